@@ -413,26 +413,192 @@ func main() {
 	if cfg.UsageStatisticsEnabled {
 		// Default to auth-dir/usage.json
 		usageFilePath := filepath.Join(cfg.AuthDir, "usage.json")
-		usageStore = usage.NewJSONStore(usageFilePath)
-		usage.SetJSONStore(usageStore)
-		
-		// Ensure store is properly closed on exit
-		defer func() {
-			if usageStore != nil {
-				if err := usageStore.Close(); err != nil {
-					log.Warnf("failed to close usage store: %v", err)
+		storeOpts := []usage.StoreOption{usage.WithSampling(cfg.UsageStore.SampleRate)}
+		if cfg.UsageStore.RecentCapacity > 0 {
+			storeOpts = append(storeOpts, usage.WithRecentCapacity(cfg.UsageStore.RecentCapacity))
+		}
+		if cfg.UsageStore.CounterRetentionDays > 0 {
+			storeOpts = append(storeOpts, usage.WithCounterRetention(time.Duration(cfg.UsageStore.CounterRetentionDays)*24*time.Hour))
+		}
+		if cfg.UsageStore.BufferErrors {
+			storeOpts = append(storeOpts, usage.WithImmediateFlushOn(nil))
+		}
+		if cfg.UsageStore.MaxBufferBytes > 0 {
+			storeOpts = append(storeOpts, usage.WithMaxBufferBytes(cfg.UsageStore.MaxBufferBytes))
+		}
+		if cfg.UsageStore.SyncEveryFlushes > 1 || cfg.UsageStore.SyncEverySeconds > 0 {
+			storeOpts = append(storeOpts, usage.WithSyncPolicy(cfg.UsageStore.SyncEveryFlushes, time.Duration(cfg.UsageStore.SyncEverySeconds)*time.Second))
+		}
+		if cfg.UsageStore.RotateMaxMB > 0 || cfg.UsageStore.RotateDaily {
+			// A negative value disables the guard; WithRotation clamps the window to 0
+			protectDays := cfg.UsageStore.RotateProtectDays
+			if protectDays == 0 {
+				protectDays = 7
+			}
+			storeOpts = append(storeOpts, usage.WithRotation(usage.RotationPolicy{
+				MaxBytes:        cfg.UsageStore.RotateMaxMB << 20,
+				Daily:           cfg.UsageStore.RotateDaily,
+				MaxTotalBytes:   cfg.UsageStore.RotateMaxTotalMB << 20,
+				ProtectedWindow: time.Duration(protectDays) * 24 * time.Hour,
+				ForcePrune:      cfg.UsageStore.RotateForcePrune,
+			}))
+			if cfg.UsageStore.RotateForcePrune && cfg.UsageStore.RotateMaxTotalMB > 0 && protectDays > 0 {
+				log.Warnf("usage-store.rotate-force-prune is set: the disk cap may delete usage segments from the last %d days", protectDays)
+			}
+		}
+		if cfg.UsageStore.MaxFollowers > 0 {
+			storeOpts = append(storeOpts, usage.WithMaxFollowers(cfg.UsageStore.MaxFollowers))
+		}
+		if cfg.UsageStore.LatenessWindowSeconds > 0 {
+			storeOpts = append(storeOpts, usage.WithLatenessWindow(time.Duration(cfg.UsageStore.LatenessWindowSeconds)*time.Second))
+		}
+		if cfg.UsageStore.KeyHash != "" || cfg.UsageStore.KeyHashLength > 0 {
+			if hasher, err := usage.NewKeyHasher(cfg.UsageStore.KeyHash, cfg.UsageStore.KeyHashLength); err != nil {
+				log.Warnf("invalid usage-store.key-hash, using sha256: %v", err)
+			} else {
+				storeOpts = append(storeOpts, usage.WithKeyHasher(hasher))
+			}
+		}
+		if len(cfg.UsageStore.Labels) > 0 {
+			// Labelled on the recording path, so the live store and OTLP see them too
+			usage.SetEnrichers(usage.StaticLabels(cfg.UsageStore.Labels))
+		}
+		if strings.EqualFold(cfg.UsageStore.LineFormat, "envelope") {
+			storeOpts = append(storeOpts,
+				usage.WithLineFormatter(usage.EnvelopeLineFormatter(cfg.UsageStore.LineFormatService, "info")),
+				usage.WithLineParser(usage.EnvelopeLineParser),
+			)
+		} else if strings.EqualFold(cfg.UsageStore.LineFormat, "binary") {
+			storeOpts = append(storeOpts, usage.WithBinaryFormat(true))
+		}
+		usage.SetTokenSanityCheck(cfg.UsageStore.SuspiciousTokenCap, cfg.UsageStore.ClampSuspicious)
+		usage.SetDropZeroTokenFailures(cfg.UsageStore.DropZeroTokenFailures)
+		usage.SetMaxFieldLength(cfg.UsageStore.MaxFieldLength)
+		if cfg.UsageStore.DisablePersistence {
+			// Keep usage in memory only; metrics cover the retention window
+			live := usage.NewLiveStore(time.Duration(cfg.UsageStore.LiveRetentionMinutes)*time.Minute, 0)
+			usage.SetLiveStore(live)
+			log.Infof("usage persistence disabled, keeping the last %s of usage in memory", live.Retention())
+		} else {
+			// Rollups are only generated for the shared store, not per-tenant files
+			mainStoreOpts := append([]usage.StoreOption(nil), storeOpts...)
+			if cfg.UsageStore.RollupIntervalMinutes > 0 {
+				mainStoreOpts = append(mainStoreOpts, usage.WithRollupInterval(time.Duration(cfg.UsageStore.RollupIntervalMinutes)*time.Minute))
+			}
+			if cfg.UsageStore.SelfCheckIntervalMinutes > 0 {
+				mainStoreOpts = append(mainStoreOpts, usage.WithSelfCheck(time.Duration(cfg.UsageStore.SelfCheckIntervalMinutes)*time.Minute))
+			}
+			if archiveCfg := cfg.UsageStore.Archive; archiveCfg.Endpoint != "" && archiveCfg.Bucket != "" {
+				uploader, err := usage.NewS3SegmentUploader(usage.S3UploaderConfig{
+					Endpoint:  archiveCfg.Endpoint,
+					Bucket:    archiveCfg.Bucket,
+					AccessKey: archiveCfg.AccessKey,
+					SecretKey: archiveCfg.SecretKey,
+					Region:    archiveCfg.Region,
+					Prefix:    archiveCfg.Prefix,
+					UseSSL:    archiveCfg.UseSSL,
+					PathStyle: archiveCfg.PathStyle,
+				})
+				if err != nil {
+					log.Warnf("usage segment archiving disabled: %v", err)
 				} else {
-					log.Debug("usage store closed successfully")
+					if cfg.UsageStore.RotateMaxMB <= 0 && !cfg.UsageStore.RotateDaily {
+						log.Warnf("usage-store.archive is set but rotation is disabled; no segments will be uploaded")
+					}
+					mainStoreOpts = append(mainStoreOpts, usage.WithArchive(usage.ArchivePolicy{
+						Uploader:    uploader,
+						Compress:    archiveCfg.Compress,
+						DeleteLocal: archiveCfg.DeleteLocal,
+						MaxAttempts: archiveCfg.MaxAttempts,
+					}))
 				}
 			}
-		}()
-		
-		// Load historical events on startup
-		if events, err := usageStore.Load(); err != nil {
-			log.Warnf("failed to load historical usage events: %v", err)
-		} else if len(events) > 0 {
-			log.Infof("loaded %d historical usage events from %s", len(events), usageFilePath)
+			usageStore = usage.NewJSONStore(usageFilePath, mainStoreOpts...)
+			usage.SetJSONStore(usageStore)
+
+			// Ensure store is properly closed on exit, without hanging on a stuck disk
+			closeTimeout := time.Duration(cfg.UsageStore.CloseTimeoutSeconds) * time.Second
+			if closeTimeout <= 0 {
+				closeTimeout = 10 * time.Second
+			}
+			defer func() {
+				if usageStore != nil {
+					if err := usageStore.CloseWithTimeout(closeTimeout); err != nil {
+						log.Warnf("failed to close usage store: %v", err)
+					} else {
+						log.Debug("usage store closed successfully")
+					}
+				}
+			}()
+
+			// Rebuild running totals from historical events on startup
+			if err := usageStore.RebuildTotals(); err != nil {
+				log.Warnf("failed to load historical usage events: %v", err)
+			} else if totals := usageStore.Totals(); totals.Requests > 0 {
+				log.Infof("loaded %d historical usage events from %s", totals.Requests, usageFilePath)
+			}
+		}
+
+		// Optionally keep a separate usage file per tenant
+		if tenantsCfg := cfg.UsageStore.Tenants; tenantsCfg.Enable && !cfg.UsageStore.DisablePersistence {
+			manager := usage.NewStoreManager(
+				filepath.Join(cfg.AuthDir, "usage-tenants"),
+				tenantsCfg.MaxOpen,
+				time.Duration(tenantsCfg.IdleTimeoutSeconds)*time.Second,
+				storeOpts...,
+			)
+			usage.SetStoreManager(manager, tenantsCfg.Header)
+			defer func() {
+				if err := manager.Close(); err != nil {
+					log.Warnf("failed to close tenant usage stores: %v", err)
+				}
+			}()
+		}
+
+		// Optionally mirror usage events to an OpenTelemetry collector
+		if otelCfg := cfg.UsageStore.OTEL; otelCfg.Enable && otelCfg.Endpoint != "" {
+			exporter := usage.NewOTELExporter(usage.OTELExporterConfig{
+				Endpoint:    otelCfg.Endpoint,
+				Headers:     otelCfg.Headers,
+				ServiceName: otelCfg.ServiceName,
+			})
+			usage.SetOTELExporter(exporter)
+			defer exporter.Close()
+			log.Infof("exporting usage events to otel collector at %s", otelCfg.Endpoint)
 		}
+
+		// Optionally allow replaying stored events into a secondary sink
+		switch replayCfg := cfg.UsageStore.Replay; replayCfg.Target {
+		case "":
+		case "otel":
+			if exporter := usage.GetOTELExporter(); exporter != nil {
+				usage.SetReplaySink(&usage.ReplaySink{Name: "otel", Write: exporter.ExportWait})
+			} else {
+				log.Warn("usage replay target otel requires usage-store.otel to be enabled")
+			}
+		case "file":
+			replayPath := replayCfg.Path
+			if replayPath != "" && !filepath.IsAbs(replayPath) {
+				replayPath = filepath.Join(cfg.AuthDir, replayPath)
+			}
+			if replayPath == "" || filepath.Clean(replayPath) == filepath.Clean(usageFilePath) {
+				// Replaying into the primary store would record every event twice
+				log.Warn("usage replay target file requires a path other than usage.json")
+				break
+			}
+			replayStore := usage.NewJSONStore(replayPath, usage.WithPeriodicFlush(false))
+			usage.SetReplaySink(&usage.ReplaySink{Name: "file:" + replayPath, Write: replayStore.Write, Flush: replayStore.Flush})
+			defer func() {
+				if err := replayStore.Close(); err != nil {
+					log.Warnf("failed to close usage replay store: %v", err)
+				}
+			}()
+		default:
+			log.Warnf("unknown usage replay target %q, expected otel or file", replayCfg.Target)
+		}
+	}
+	if cfg.UsageStore.Expvar {
+		usage.PublishExpvar()
 	}
 	managementasset.SetCurrentConfig(cfg)
 
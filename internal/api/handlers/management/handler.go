@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -41,6 +42,12 @@ type Handler struct {
 	allowRemoteOverride bool
 	envSecret           string
 	logDir              string
+	// replayRunning guards against concurrent usage replays.
+	replayRunning atomic.Bool
+	// warmCache holds the /qs/metrics responses of the warmer; see StartQSWarmer.
+	warmCache qsWarmCache
+	// budgetState holds the budget alert levels reached; see StartQSBudgets.
+	budgetState qsBudgetState
 }
 
 // NewHandler creates a new management handler instance.
@@ -209,6 +216,12 @@ func (h *Handler) Middleware() gin.HandlerFunc {
 			return
 		}
 
+		// Shared dashboard keys may only read aggregated metrics, with model names redacted
+		if h.authorizeQSSharedKey(c, provided) {
+			c.Next()
+			return
+		}
+
 		if secretHash == "" || bcrypt.CompareHashAndPassword([]byte(secretHash), []byte(provided)) != nil {
 			if !localClient {
 				fail()
@@ -0,0 +1,216 @@
+package management
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Defaults of usage-store.budgets.
+const (
+	qsDefaultBudgetInterval = 5 * time.Minute
+	qsDefaultBudgetWarnAt   = 0.8
+)
+
+// qsBudgetWebhookTimeout bounds one webhook delivery, so an unreachable
+// endpoint cannot stall the evaluator.
+const qsBudgetWebhookTimeout = 10 * time.Second
+
+// Budget alert levels, in increasing order of severity.
+const (
+	qsBudgetWarning  = "warning"
+	qsBudgetExceeded = "exceeded"
+)
+
+// BudgetAlert reports a model whose month-to-date cost reached a threshold
+// of its monthly budget; it is logged and sent to the budget webhook.
+type BudgetAlert struct {
+	Model string `json:"model"`
+	// Level is "warning" once the spend reaches warn-at of the budget and
+	// "exceeded" once it is over the budget.
+	Level string `json:"level"`
+	// Month is the UTC calendar month, e.g. "2025-11".
+	Month     string  `json:"month"`
+	SpendUSD  float64 `json:"spend_usd"`
+	BudgetUSD float64 `json:"budget_usd"`
+	// Share is the spend over the budget.
+	Share float64   `json:"share"`
+	At    time.Time `json:"at"`
+}
+
+// qsBudgetState remembers the level each model last alerted at in month,
+// so every level fires once per month.
+type qsBudgetState struct {
+	mu     sync.Mutex
+	month  string
+	levels map[string]string
+}
+
+// raise records level for model in month and reports whether it is new.
+// A model that fell back below a level, e.g. after its budget was raised,
+// alerts again when it crosses it.
+func (s *qsBudgetState) raise(month, model, level string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.month != month || s.levels == nil {
+		s.month = month
+		s.levels = make(map[string]string)
+	}
+	previous := s.levels[model]
+	if level == "" {
+		delete(s.levels, model)
+	} else {
+		s.levels[model] = level
+	}
+	return level != "" && level != previous && previous != qsBudgetExceeded
+}
+
+// qsBudgetInterval returns how often budgets are evaluated.
+func (h *Handler) qsBudgetInterval() time.Duration {
+	if h.cfg != nil && h.cfg.UsageStore.Budgets.IntervalSeconds > 0 {
+		return time.Duration(h.cfg.UsageStore.Budgets.IntervalSeconds) * time.Second
+	}
+	return qsDefaultBudgetInterval
+}
+
+// qsBudgetWarnAt returns the fraction of a budget that raises a warning,
+// falling back to the default with a warning when it is not below 1.
+func (h *Handler) qsBudgetWarnAt() float64 {
+	if h.cfg == nil || h.cfg.UsageStore.Budgets.WarnAt == 0 {
+		return qsDefaultBudgetWarnAt
+	}
+	warnAt := h.cfg.UsageStore.Budgets.WarnAt
+	if warnAt < 0 || warnAt >= 1 {
+		log.Warnf("ignoring usage-store.budgets.warn-at %v: expected a fraction between 0 and 1", warnAt)
+		return qsDefaultBudgetWarnAt
+	}
+	return warnAt
+}
+
+// StartQSBudgets starts the background evaluator of usage-store.budgets,
+// which checks the month-to-date cost of every budgeted model each interval.
+// Budgets and interval are re-read on every run, so config reloads apply
+// without a restart.
+//
+// Returns:
+//   - func(): Stops the evaluator; safe to call more than once
+func (h *Handler) StartQSBudgets() func() {
+	done := make(chan struct{})
+	go func() {
+		for {
+			h.evaluateQSBudgets(time.Now())
+			timer := time.NewTimer(h.qsBudgetInterval())
+			select {
+			case <-timer.C:
+			case <-done:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// evaluateQSBudgets estimates the cost of each budgeted model from the start
+// of the UTC month to now, alerts for every level newly reached and returns
+// those alerts.
+func (h *Handler) evaluateQSBudgets(now time.Time) []BudgetAlert {
+	if h.cfg == nil || len(h.cfg.UsageStore.Budgets.Models) == 0 {
+		return nil
+	}
+	store := h.qsStore()
+	if store == nil {
+		return nil
+	}
+	monthStart, _, _ := parseQSReportMonth("", now)
+	month := monthStart.Format("2006-01")
+	response, err := aggregateQSStoreMetrics(store, h.qsDefaultMetricsQuery(monthStart, now))
+	if err != nil {
+		log.Warnf("failed to evaluate usage budgets: %v", err)
+		return nil
+	}
+	spend := make(map[string]float64, len(response.ByModel))
+	for _, m := range response.ByModel {
+		spend[m.Model] = m.CostUSD
+	}
+
+	budgets := h.cfg.UsageStore.Budgets.Models
+	warnAt := h.qsBudgetWarnAt()
+	decimals := h.qsCostDecimals()
+	var alerts []BudgetAlert
+	for _, model := range slices.Sorted(maps.Keys(budgets)) {
+		budget := budgets[model]
+		if budget <= 0 {
+			log.Warnf("ignoring usage-store.budgets for model %q: expected a positive budget, got %v", model, budget)
+			continue
+		}
+		share := spend[model] / budget
+		level := ""
+		switch {
+		case share > 1:
+			level = qsBudgetExceeded
+		case share >= warnAt:
+			level = qsBudgetWarning
+		}
+		if !h.budgetState.raise(month, model, level) {
+			continue
+		}
+		alerts = append(alerts, BudgetAlert{
+			Model:     model,
+			Level:     level,
+			Month:     month,
+			SpendUSD:  roundQSCost(spend[model], decimals),
+			BudgetUSD: roundQSCost(budget, decimals),
+			Share:     share,
+			At:        now,
+		})
+	}
+	for _, alert := range alerts {
+		h.sendQSBudgetAlert(alert)
+	}
+	return alerts
+}
+
+// sendQSBudgetAlert logs alert and POSTs it to the configured webhook, if
+// any; delivery failures are logged and not retried.
+func (h *Handler) sendQSBudgetAlert(alert BudgetAlert) {
+	verb := "reached"
+	if alert.Level == qsBudgetExceeded {
+		verb = "exceeded"
+	}
+	log.Warnf("usage budget %s for model %s: $%.2f spent in %s, %.0f%% of the $%.2f budget", verb, alert.Model, alert.SpendUSD, alert.Month, alert.Share*100, alert.BudgetUSD)
+
+	url := h.cfg.UsageStore.Budgets.WebhookURL
+	if url == "" {
+		return
+	}
+	if err := postQSBudgetAlert(url, alert); err != nil {
+		log.Warnf("failed to send usage budget alert for model %s: %v", alert.Model, err)
+	}
+}
+
+// postQSBudgetAlert sends alert as JSON to url.
+func postQSBudgetAlert(url string, alert BudgetAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: qsBudgetWebhookTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+func TestEvaluateQSBudgets_AlertsOncePerLevel(t *testing.T) {
+	var mu sync.Mutex
+	var received []BudgetAlert
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert BudgetAlert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("decode webhook body: %v", err)
+		}
+		mu.Lock()
+		received = append(received, alert)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	store := usage.NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), usage.WithPeriodicFlush(false))
+	defer func() { _ = store.Close() }()
+	now := time.Date(2025, 11, 20, 12, 0, 0, 0, time.UTC)
+	write := func(timestamp time.Time, model string, promptTokens int64) {
+		event := usage.UsageEvent{Timestamp: timestamp, Model: model, PromptTokens: promptTokens, TotalTokens: promptTokens}
+		if err := store.Write(event); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	// $1 per million prompt tokens: gpt-4o spends $85 of 100, o1 $120 of 100
+	write(now.Add(-time.Hour), "gpt-4o", 85_000_000)
+	write(now.Add(-time.Hour), "o1", 120_000_000)
+	write(now.AddDate(0, -1, 0), "gpt-4o", 500_000_000)
+	write(now.Add(-time.Hour), "cheap", 1_000_000)
+
+	h := &Handler{cfg: &config.Config{}, jsonStore: store}
+	h.cfg.UsageStore.Pricing = map[string]config.UsageModelPrice{
+		"gpt-4o": {Input: 1},
+		"o1":     {Input: 1},
+		"cheap":  {Input: 1},
+	}
+	h.cfg.UsageStore.Budgets = config.UsageBudgetsConfig{
+		Models:     map[string]float64{"gpt-4o": 100.123456, "o1": 100, "cheap": 100, "broken": -1},
+		WebhookURL: webhook.URL,
+	}
+
+	alerts := h.evaluateQSBudgets(now)
+	if len(alerts) != 2 || alerts[0].Model != "gpt-4o" || alerts[0].Level != qsBudgetWarning || alerts[1].Model != "o1" || alerts[1].Level != qsBudgetExceeded {
+		t.Fatalf("want a gpt-4o warning and an o1 alert, got %+v", alerts)
+	}
+	// Amounts are rounded to cost-decimals like response costs
+	if alerts[0].SpendUSD != 85 || alerts[0].BudgetUSD != 100.1235 || alerts[0].Month != "2025-11" {
+		t.Fatalf("unexpected gpt-4o alert %+v", alerts[0])
+	}
+	mu.Lock()
+	if len(received) != 2 || received[1].Model != "o1" || received[1].SpendUSD != 120 {
+		t.Fatalf("want both alerts delivered, got %+v", received)
+	}
+	mu.Unlock()
+
+	// Levels already reached do not alert again; crossing the next one does
+	if alerts := h.evaluateQSBudgets(now.Add(time.Minute)); len(alerts) != 0 {
+		t.Fatalf("want no repeated alerts, got %+v", alerts)
+	}
+	write(now.Add(-time.Minute), "gpt-4o", 20_000_000)
+	alerts = h.evaluateQSBudgets(now.Add(2 * time.Minute))
+	if len(alerts) != 1 || alerts[0].Model != "gpt-4o" || alerts[0].Level != qsBudgetExceeded {
+		t.Fatalf("want gpt-4o exceeded, got %+v", alerts)
+	}
+
+	// A new month starts over
+	if alerts := h.evaluateQSBudgets(time.Date(2025, 12, 1, 1, 0, 0, 0, time.UTC)); len(alerts) != 0 {
+		t.Fatalf("want no alerts at the start of a month, got %+v", alerts)
+	}
+}
@@ -0,0 +1,41 @@
+package management
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PostQSFlush forces buffered usage events to disk, e.g. before copying the
+// store file for a backup, without waiting for the periodic flush.
+// POST /v0/management/qs/flush?tenant=<key>
+func (h *Handler) PostQSFlush(c *gin.Context) {
+	store, ok := h.qsStoreForRequest(c)
+	if !ok {
+		return
+	}
+	if store == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "usage store is not configured"})
+		return
+	}
+	flushed, err := store.FlushCount()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"flushed": 0, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"flushed": flushed})
+}
+
+// GetQSBuffer reports how many usage events are buffered in memory and not yet on disk.
+// GET /v0/management/qs/buffer?tenant=<key>
+func (h *Handler) GetQSBuffer(c *gin.Context) {
+	store, ok := h.qsStoreForRequest(c)
+	if !ok {
+		return
+	}
+	if store == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "usage store is not configured"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"buffered": store.Len()})
+}
@@ -0,0 +1,171 @@
+package management
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// qsDefaultMaxGroups is the number of distinct models, and of group_by rows,
+// one metrics aggregation tracks when usage-store.max-groups is unset.
+const qsDefaultMaxGroups = 1000
+
+// qsOtherGroup names the by_model entry, and the group_by dimension values,
+// that collect the events beyond the distinct-group cap.
+const qsOtherGroup = "(other)"
+
+// qsGroupsCappedInterval is the minimum time between two warnings about the
+// cap, so a dashboard polling a high-cardinality range does not flood the log.
+const qsGroupsCappedInterval = time.Minute
+
+// qsGroupsCappedWarnedAt holds the Unix time of the last cap warning.
+var qsGroupsCappedWarnedAt atomic.Int64
+
+// qsMaxGroups returns the configured cap on distinct models and group_by rows.
+func (h *Handler) qsMaxGroups() int {
+	if h.cfg != nil && h.cfg.UsageStore.MaxGroups > 0 {
+		return h.cfg.UsageStore.MaxGroups
+	}
+	return qsDefaultMaxGroups
+}
+
+// qsOtherGroupKey returns the group_by key collecting the rows beyond the cap:
+// every grouped string dimension reads qsOtherGroup. With group_by=model it is
+// the key of the qsOtherGroup model, so both overflows share one row.
+func qsOtherGroupKey(dims []string) qsGroupKey {
+	var key qsGroupKey
+	for _, dim := range dims {
+		switch dim {
+		case "model":
+			key.model = qsOtherGroup
+		case "family":
+			key.family = qsOtherGroup
+		case "provider":
+			key.provider = qsOtherGroup
+		case "status":
+		case "api_key_hash":
+			key.apiKeyHash = qsOtherGroup
+		default:
+			key.labels += qsLabelSeparator + qsOtherGroup
+		}
+	}
+	return key
+}
+
+// warnQSGroupsCapped logs that an aggregation hit the cap, at most once per
+// qsGroupsCappedInterval; a cardinality explosion usually means a client is
+// sending made-up model names.
+func warnQSGroupsCapped(maxGroups int) {
+	now := time.Now().Unix()
+	last := qsGroupsCappedWarnedAt.Load()
+	if now-last < int64(qsGroupsCappedInterval/time.Second) || !qsGroupsCappedWarnedAt.CompareAndSwap(last, now) {
+		return
+	}
+	log.Warnf("usage metrics tracked the maximum of %d distinct models or groups and counted the rest as %q; check for clients sending many distinct model names, or raise usage-store.max-groups", maxGroups, qsOtherGroup)
+}
+
+// parseQSCollapse reads the top and min_share parameters, which merge the
+// smaller models of by_model into qsOtherGroup; empty values disable them.
+func parseQSCollapse(top, minShare string) (int, float64, error) {
+	var n int
+	var share float64
+	var err error
+	if top != "" {
+		if n, err = strconv.Atoi(top); err != nil || n <= 0 {
+			return 0, 0, fmt.Errorf("invalid 'top', expected a positive integer")
+		}
+	}
+	if minShare != "" {
+		if share, err = strconv.ParseFloat(minShare, 64); err != nil || share <= 0 {
+			return 0, 0, fmt.Errorf("invalid 'min_share', expected a fraction between 0 and 1")
+		}
+	}
+	return n, share, validateQSCollapse(n, share)
+}
+
+// validateQSCollapse checks top and min_share values; zero disables each, as
+// an omitted field of the POST body.
+func validateQSCollapse(top int, minShare float64) error {
+	if top < 0 {
+		return fmt.Errorf("invalid 'top', expected a positive integer")
+	}
+	if minShare < 0 || minShare > 1 || math.IsNaN(minShare) {
+		return fmt.Errorf("invalid 'min_share', expected a fraction between 0 and 1")
+	}
+	return nil
+}
+
+// minorModels returns the models by_model merges into qsOtherGroup: those
+// ranked below query.Top by tokens and those with less than query.MinShare of
+// all tokens. With both set a model must pass both, so the stricter one wins.
+// Models left out by active_since are not ranked.
+func (a *metricsAggregate) minorModels(query metricsQuery) []string {
+	if query.Top <= 0 && query.MinShare <= 0 {
+		return nil
+	}
+	ranked := make([]*ModelMetrics, 0, len(a.modelStats))
+	for name, m := range a.modelStats {
+		if name == qsOtherGroup || (!query.ActiveSince.IsZero() && a.lastSeen[name].Before(query.ActiveSince)) {
+			continue
+		}
+		ranked = append(ranked, m)
+	}
+	slices.SortFunc(ranked, func(x, y *ModelMetrics) int {
+		return cmp.Or(cmp.Compare(y.Tokens, x.Tokens), cmp.Compare(x.Model, y.Model))
+	})
+
+	var minor []string
+	for rank, m := range ranked {
+		belowTop := query.Top > 0 && rank >= query.Top
+		belowShare := query.MinShare > 0 && float64(m.Tokens) < query.MinShare*float64(a.totalTokens)
+		if belowTop || belowShare {
+			minor = append(minor, m.Model)
+		}
+	}
+	return minor
+}
+
+// collapseModels merges the per-model sums of models into the qsOtherGroup
+// entry, which keeps their combined cost, throughput and sparkline.
+func (a *metricsAggregate) collapseModels(models []string) {
+	if len(models) == 0 {
+		return
+	}
+	other, ok := a.modelStats[qsOtherGroup]
+	if !ok {
+		other = &ModelMetrics{Model: qsOtherGroup}
+		a.modelStats[qsOtherGroup] = other
+	}
+	for _, model := range models {
+		m := a.modelStats[model]
+		other.Tokens += m.Tokens
+		other.Requests += m.Requests
+		other.CostUSD += m.CostUSD
+		delete(a.modelStats, model)
+
+		if throughput, ok := a.modelThroughput[model]; ok {
+			if existing, ok := a.modelThroughput[qsOtherGroup]; ok {
+				existing.merge(throughput)
+			} else {
+				a.modelThroughput[qsOtherGroup] = throughput
+			}
+			delete(a.modelThroughput, model)
+		}
+		if acc, ok := a.sparklines[model]; ok {
+			if existing, ok := a.sparklines[qsOtherGroup]; ok {
+				existing.merge(acc)
+			} else {
+				a.sparklines[qsOtherGroup] = acc
+			}
+			delete(a.sparklines, model)
+		}
+		a.markSeen(qsOtherGroup, a.lastSeen[model])
+		delete(a.lastSeen, model)
+	}
+}
@@ -0,0 +1,235 @@
+package management
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+// qsCombinedBatchSize is how many events a combined aggregation reads from a
+// file before folding them into the aggregate, which bounds its memory.
+const qsCombinedBatchSize = 10_000
+
+// qsMaxCombinedStores bounds the stores one combined aggregation reads.
+const qsMaxCombinedStores = 100
+
+// AggregateOptions selects the events AggregateFiles counts and shapes its
+// response like the parameters of /qs/metrics; zero values do not filter.
+type AggregateOptions struct {
+	// From and To bound the event timestamps; a zero To is now and a zero
+	// From 24 hours before To.
+	From time.Time
+	To   time.Time
+	// Models, Providers and Statuses count only events matching one of their values.
+	Models            []string
+	Providers         []string
+	Statuses          []int
+	ExcludeSuspicious bool
+	// Interval is the timeseries bucket width; 0 uses one hour.
+	Interval time.Duration
+	// GroupBy adds a pivot over model, family (the model itself here),
+	// provider, status, api_key_hash and/or label:<name>.
+	GroupBy []string
+	// Pricing maps model names to their token prices for cost_usd.
+	Pricing map[string]config.UsageModelPrice
+	// MaxGroups caps the distinct models and group rows; 0 uses 1000.
+	MaxGroups int
+}
+
+// CombinedMetricsRequest is the JSON body of POST /qs/metrics/combined: the
+// filters of POST /qs/metrics plus the stores to combine.
+type CombinedMetricsRequest struct {
+	MetricsQueryRequest
+	// Stores are "main", "tenant:<key>" or "segment:<file name>" of a rotated
+	// segment of the main store, e.g. "segment:usage.json.2025-11-25". "main"
+	// reads every segment itself and cannot be listed with segments.
+	Stores []string `json:"stores"`
+}
+
+// AggregateFiles aggregates the usage store files at paths into one metrics
+// response, e.g. several per-day segments or tenant files. Each file is
+// opened read-only and streamed through Iterate in batches, together with
+// its rotated segments, so the events are never all in memory at once;
+// gzipped files are decompressed on the fly. A path that is a segment of
+// another listed file is read once, with that file. Files sampled at the
+// same rate are scaled up as one store would be; mixing sample rates is an
+// error.
+//
+// Parameters:
+//   - paths: The store files to combine
+//   - opts: Filters and shape of the response
+//
+// Returns:
+//   - MetricsResponse: The combined metrics
+//   - error: An error if a file cannot be read or the sample rates differ
+func AggregateFiles(paths []string, opts AggregateOptions) (MetricsResponse, error) {
+	groupBy, err := validateQSGroupBy(opts.GroupBy)
+	if err != nil {
+		return MetricsResponse{}, err
+	}
+	if opts.To.IsZero() {
+		opts.To = time.Now()
+	}
+	if opts.From.IsZero() {
+		opts.From = opts.To.Add(-24 * time.Hour)
+	}
+
+	stores := make([]*usage.JSONStore, 0, len(paths))
+	covered := make(map[string]bool)
+	for _, path := range paths {
+		store := usage.NewReadOnlyStore(path)
+		defer func() { _ = store.Close() }()
+		stores = append(stores, store)
+		segments, err := store.Segments()
+		if err != nil {
+			return MetricsResponse{}, err
+		}
+		for _, segment := range segments {
+			covered[filepath.Clean(segment)] = true
+		}
+	}
+	stores = slices.DeleteFunc(stores, func(store *usage.JSONStore) bool {
+		return covered[filepath.Clean(store.Settings().Path)]
+	})
+	return aggregateQSStores(stores, metricsQuery{
+		From:              opts.From,
+		To:                opts.To,
+		Models:            opts.Models,
+		Providers:         opts.Providers,
+		Statuses:          opts.Statuses,
+		ExcludeSuspicious: opts.ExcludeSuspicious,
+		Interval:          opts.Interval,
+		GroupBy:           groupBy,
+		Pricing:           opts.Pricing,
+		Sort:              "tokens",
+		MaxGroups:         opts.MaxGroups,
+	})
+}
+
+// aggregateQSStores streams the flushed events of each store into one
+// aggregate. Rollups and exact counters are per store, so only raw events
+// are read; events still buffered are not included.
+func aggregateQSStores(stores []*usage.JSONStore, query metricsQuery) (MetricsResponse, error) {
+	interval, sparklineStart := qsAggregationBuckets(query)
+	agg := newMetricsAggregate(query)
+	for i, store := range stores {
+		if rate := store.SampleRate(); i == 0 {
+			query.SampleRate = rate
+		} else if rate != query.SampleRate {
+			return MetricsResponse{}, fmt.Errorf("stores are sampled at different rates (%v and %v) and cannot be combined", query.SampleRate, rate)
+		}
+
+		batch := make([]usage.UsageEvent, 0, qsCombinedBatchSize)
+		err := store.Iterate(func(event usage.UsageEvent) bool {
+			batch = append(batch, event)
+			if len(batch) == qsCombinedBatchSize {
+				agg.addEvents(batch, query, interval, sparklineStart)
+				batch = batch[:0]
+			}
+			return true
+		})
+		if err != nil {
+			return MetricsResponse{}, err
+		}
+		agg.addEvents(batch, query, interval, sparklineStart)
+	}
+	return agg.response(query, interval, sparklineStart), nil
+}
+
+// PostQSCombinedMetrics aggregates several usage stores into one /qs/metrics
+// response, e.g. all tenants or a week of daily segments.
+// POST /v0/management/qs/metrics/combined
+//
+// The body takes the fields of POST /qs/metrics plus 'stores', each "main",
+// "tenant:<key>" or "segment:<file name>" of a rotated segment of the main
+// store. "main" already reads every segment, so it cannot be combined with
+// segment stores. Stores are read one after another in batches; only
+// flushed events are counted, and rollups are not used.
+func (h *Handler) PostQSCombinedMetrics(c *gin.Context) {
+	var body CombinedMetricsRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	if len(body.Stores) == 0 || len(body.Stores) > qsMaxCombinedStores {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid 'stores', expected 1 to %d store identifiers", qsMaxCombinedStores)})
+		return
+	}
+	if unique := slices.Compact(slices.Sorted(slices.Values(body.Stores))); len(unique) != len(body.Stores) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'stores', a store is listed twice"})
+		return
+	}
+	if slices.Contains(body.Stores, "main") && slices.ContainsFunc(body.Stores, func(id string) bool { return strings.HasPrefix(id, "segment:") }) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'stores', main already includes its rotated segments"})
+		return
+	}
+	query, ok := h.parseQSMetricsRequest(c, body.MetricsQueryRequest)
+	if !ok {
+		return
+	}
+	query = h.completeQSMetricsQuery(query)
+
+	stores := make([]*usage.JSONStore, 0, len(body.Stores))
+	for _, id := range body.Stores {
+		store, closeStore, err := h.qsCombinedStore(id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		defer closeStore()
+		stores = append(stores, store)
+	}
+	response, err := aggregateQSStores(stores, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.writeQSMetricsJSON(c, query, response)
+}
+
+// qsCombinedStore resolves a store identifier of /qs/metrics/combined. The
+// returned func closes a store opened only for the request.
+func (h *Handler) qsCombinedStore(id string) (*usage.JSONStore, func(), error) {
+	noop := func() {}
+	kind, name, _ := strings.Cut(id, ":")
+	switch kind {
+	case "main":
+		if store := h.qsStore(); store != nil && name == "" {
+			return store, noop, nil
+		}
+	case "tenant":
+		manager := usage.GetStoreManager()
+		if manager == nil {
+			return nil, nil, fmt.Errorf("per-tenant usage stores are not enabled")
+		}
+		store, err := manager.Store(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid tenant %q: %v", name, err)
+		}
+		return store, noop, nil
+	case "segment":
+		main := h.qsStore()
+		if main == nil {
+			break
+		}
+		segments, err := main.Segments()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, segment := range segments {
+			if filepath.Base(segment) == name {
+				store := usage.NewReadOnlyStore(segment)
+				return store, func() { _ = store.Close() }, nil
+			}
+		}
+		return nil, nil, fmt.Errorf("unknown segment %q", name)
+	}
+	return nil, nil, fmt.Errorf("invalid store %q, expected main, tenant:<key> or segment:<file name>", id)
+}
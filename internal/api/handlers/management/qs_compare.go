@@ -0,0 +1,174 @@
+package management
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+// CompareResponse holds the aggregates of two time windows and their differences.
+type CompareResponse struct {
+	A CompareWindow `json:"a"`
+	B CompareWindow `json:"b"`
+	// Delta is window A minus window B.
+	Delta   CompareDelta `json:"delta"`
+	ByModel []ModelDelta `json:"by_model"`
+}
+
+// CompareWindow is the aggregate of one compared window.
+type CompareWindow struct {
+	From    time.Time      `json:"from"`
+	To      time.Time      `json:"to"`
+	Totals  MetricsTotals  `json:"totals"`
+	ByModel []ModelMetrics `json:"by_model"`
+	// Estimated is set when the window's numbers were scaled up from a sampled store.
+	Estimated bool `json:"estimated,omitempty"`
+}
+
+// CompareDelta is the difference A - B of the two windows' totals. The change
+// percentages are relative to B and omitted when B is zero.
+type CompareDelta struct {
+	Tokens            int64    `json:"tokens"`
+	Requests          int64    `json:"requests"`
+	TokensChangePct   *float64 `json:"tokens_change_pct,omitempty"`
+	RequestsChangePct *float64 `json:"requests_change_pct,omitempty"`
+}
+
+// ModelDelta compares one model across the two windows.
+type ModelDelta struct {
+	Model     string `json:"model"`
+	TokensA   int64  `json:"tokens_a"`
+	TokensB   int64  `json:"tokens_b"`
+	RequestsA int64  `json:"requests_a"`
+	RequestsB int64  `json:"requests_b"`
+	CompareDelta
+}
+
+// GetQSCompare aggregates two arbitrary windows and reports their differences,
+// e.g. this Monday against last Monday or before and after a release.
+// GET /v0/management/qs/compare?a_from=...&a_to=...&b_from=...&b_to=...&model=...&tenant=...
+//
+// All four bounds are required and accept the same formats as from/to on
+// /qs/metrics. Deltas are A - B.
+func (h *Handler) GetQSCompare(c *gin.Context) {
+	for _, param := range []string{"a_from", "a_to", "b_from", "b_to"} {
+		if c.Query(param) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("missing '%s'", param)})
+			return
+		}
+	}
+	aFrom, aTo, ok := h.parseQSNamedTimeRange(c, "a_from", "a_to")
+	if !ok {
+		return
+	}
+	bFrom, bTo, ok := h.parseQSNamedTimeRange(c, "b_from", "b_to")
+	if !ok {
+		return
+	}
+	store, ok := h.qsStoreForRequest(c)
+	if !ok {
+		return
+	}
+
+	model := c.Query("model")
+	workers := h.qsAggregationWorkers()
+	unknownModel := h.qsUnknownModelLabel()
+	maxGroups := h.qsMaxGroups()
+	a, err := aggregateQSCompareWindow(store, metricsQuery{From: aFrom, To: aTo, Model: model, Workers: workers, UnknownModel: unknownModel, MaxGroups: maxGroups})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load usage events"})
+		return
+	}
+	b, err := aggregateQSCompareWindow(store, metricsQuery{From: bFrom, To: bTo, Model: model, Workers: workers, UnknownModel: unknownModel, MaxGroups: maxGroups})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load usage events"})
+		return
+	}
+
+	writeQSJSON(c, http.StatusOK, CompareResponse{
+		A:       a,
+		B:       b,
+		Delta:   compareCounts(a.Totals.Tokens, b.Totals.Tokens, a.Totals.Requests, b.Totals.Requests),
+		ByModel: compareModels(a.ByModel, b.ByModel),
+	})
+}
+
+// aggregateQSCompareWindow aggregates one window the way /qs/metrics does.
+func aggregateQSCompareWindow(store *usage.JSONStore, query metricsQuery) (CompareWindow, error) {
+	window := CompareWindow{From: query.From, To: query.To, ByModel: []ModelMetrics{}}
+	if store == nil {
+		return window, nil
+	}
+	response, err := aggregateQSStoreMetrics(store, query)
+	if err != nil {
+		return window, err
+	}
+	window.Totals = response.Totals
+	window.ByModel = response.ByModel
+	window.Estimated = response.Estimated
+	return window, nil
+}
+
+// compareModels pairs up the models of both windows, largest absolute token change first.
+func compareModels(a, b []ModelMetrics) []ModelDelta {
+	byName := make(map[string]*ModelDelta, len(a)+len(b))
+	get := func(model string) *ModelDelta {
+		d, ok := byName[model]
+		if !ok {
+			d = &ModelDelta{Model: model}
+			byName[model] = d
+		}
+		return d
+	}
+	for _, m := range a {
+		d := get(m.Model)
+		d.TokensA, d.RequestsA = m.Tokens, m.Requests
+	}
+	for _, m := range b {
+		d := get(m.Model)
+		d.TokensB, d.RequestsB = m.Tokens, m.Requests
+	}
+
+	deltas := make([]ModelDelta, 0, len(byName))
+	for _, d := range byName {
+		d.CompareDelta = compareCounts(d.TokensA, d.TokensB, d.RequestsA, d.RequestsB)
+		deltas = append(deltas, *d)
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		di, dj := abs64(deltas[i].Tokens), abs64(deltas[j].Tokens)
+		if di != dj {
+			return di > dj
+		}
+		return deltas[i].Model < deltas[j].Model
+	})
+	return deltas
+}
+
+func compareCounts(tokensA, tokensB, requestsA, requestsB int64) CompareDelta {
+	return CompareDelta{
+		Tokens:            tokensA - tokensB,
+		Requests:          requestsA - requestsB,
+		TokensChangePct:   changePct(tokensA, tokensB),
+		RequestsChangePct: changePct(requestsA, requestsB),
+	}
+}
+
+// changePct returns the change from b to a in percent of b, or nil when b is zero.
+func changePct(a, b int64) *float64 {
+	if b == 0 {
+		return nil
+	}
+	pct := float64(a-b) / float64(b) * 100
+	return &pct
+}
+
+func abs64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,81 @@
+package management
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+// qsRedacted replaces secrets in the configuration served by /qs/config.
+const qsRedacted = "[redacted]"
+
+// QSConfigResponse is the usage store configuration in effect.
+type QSConfigResponse struct {
+	// Store holds the effective settings of the store serving the request;
+	// it is null when usage is not persisted.
+	Store *usage.StoreSettings `json:"store"`
+	// Config is the usage-store section of the loaded configuration, with
+	// keys, credentials and webhook paths redacted.
+	Config config.UsageStoreConfig `json:"config"`
+}
+
+// GetQSConfig reports the usage store settings that took effect, for
+// debugging which options a deployment actually runs with.
+// GET /v0/management/qs/config?tenant=<key>
+//
+// 'store' is read from the running store after defaults and validation, so it
+// shows e.g. the rotation policy and flush interval in use; 'config' is the
+// configured usage-store section. Shared dashboard keys are redacted, as are
+// the path and query of webhook and collector URLs, which often carry tokens;
+// archive credentials and OTLP headers are never included.
+func (h *Handler) GetQSConfig(c *gin.Context) {
+	store, ok := h.qsStoreForRequest(c)
+	if !ok {
+		return
+	}
+	response := QSConfigResponse{}
+	if store != nil {
+		settings := store.Settings()
+		response.Store = &settings
+	}
+	if h.cfg != nil {
+		response.Config = redactQSConfig(h.cfg.UsageStore)
+	}
+	writeQSJSON(c, http.StatusOK, response)
+}
+
+// redactQSConfig returns a copy of cfg without its secrets; cfg itself is
+// left unchanged.
+func redactQSConfig(cfg config.UsageStoreConfig) config.UsageStoreConfig {
+	if len(cfg.ModelRedaction.SharedKeys) > 0 {
+		keys := slices.Clone(cfg.ModelRedaction.SharedKeys)
+		for i := range keys {
+			keys[i] = qsRedacted
+		}
+		cfg.ModelRedaction.SharedKeys = keys
+	}
+	cfg.Budgets.WebhookURL = redactQSURL(cfg.Budgets.WebhookURL)
+	cfg.OTEL.Endpoint = redactQSURL(cfg.OTEL.Endpoint)
+	return cfg
+}
+
+// redactQSURL keeps the scheme and host of a URL and redacts its user
+// information, path and query; a URL that does not parse is redacted whole.
+func redactQSURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return qsRedacted
+	}
+	redacted := &url.URL{Scheme: u.Scheme, Host: u.Host}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.User != nil {
+		return redacted.String() + "/" + qsRedacted
+	}
+	return redacted.String()
+}
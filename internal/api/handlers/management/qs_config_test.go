@@ -0,0 +1,50 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+func TestGetQSConfig_RedactsSecrets(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := usage.NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), usage.WithPeriodicFlush(false),
+		usage.WithRotation(usage.RotationPolicy{MaxBytes: 1 << 20, ProtectedWindow: -time.Hour}))
+	defer func() { _ = store.Close() }()
+	h := &Handler{cfg: &config.Config{}, jsonStore: store}
+	h.cfg.UsageStore.ModelRedaction.SharedKeys = []string{"dashboard-secret"}
+	h.cfg.UsageStore.Budgets.WebhookURL = "https://hooks.example.com/services/T000/B000/hook-secret"
+	h.cfg.UsageStore.OTEL.Endpoint = "http://collector:4318"
+	h.cfg.UsageStore.Archive.SecretKey = "s3-secret"
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("GET", "/v0/management/qs/config", nil)
+	h.GetQSConfig(c)
+	body := recorder.Body.String()
+	for _, secret := range []string{"dashboard-secret", "hook-secret", "s3-secret"} {
+		if strings.Contains(body, secret) {
+			t.Fatalf("want %q redacted, got %s", secret, body)
+		}
+	}
+	var response QSConfigResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if response.Store == nil || response.Store.Rotation.MaxBytes != 1<<20 || response.Store.Rotation.ProtectedWindowSeconds != 0 || response.Store.FlushIntervalSeconds != 0 {
+		t.Fatalf("want the effective store settings, got %+v", response.Store)
+	}
+	if response.Config.Budgets.WebhookURL != "https://hooks.example.com/[redacted]" || response.Config.OTEL.Endpoint != "http://collector:4318" {
+		t.Fatalf("unexpected redacted URLs %+v", response.Config)
+	}
+	if h.cfg.UsageStore.ModelRedaction.SharedKeys[0] != "dashboard-secret" {
+		t.Fatal("want the loaded configuration left unchanged")
+	}
+}
@@ -0,0 +1,130 @@
+package management
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QSRoutePrefix is the path prefix of the /qs management endpoints.
+const QSRoutePrefix = "/v0/management/qs/"
+
+// qsCORSDefaultMaxAge is how long browsers may cache a preflight response,
+// in seconds, when usage-store.cors.max-age-seconds is unset.
+const qsCORSDefaultMaxAge = 600
+
+var (
+	// qsCORSDefaultMethods are allowed when usage-store.cors.allowed-methods is empty.
+	qsCORSDefaultMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	// qsCORSAllowedHeaders are the request headers cross-origin callers may send:
+	// the management key in either form, and the JSON body of POST /qs/metrics.
+	qsCORSAllowedHeaders = []string{"X-Management-Key", "Authorization", "Content-Type"}
+	// qsCORSExposedHeaders are the response headers the endpoints set for callers.
+	qsCORSExposedHeaders = []string{"Content-Disposition", "X-Row-Count", "X-Sample-Rate"}
+)
+
+// QSCORSMiddleware applies usage-store.cors to requests under QSRoutePrefix
+// and passes every other request through untouched. It must run on the
+// engine rather than the management group: preflight OPTIONS requests match
+// no route, so group middleware never sees them.
+//
+// Without allowed origins the endpoints stay same-origin: no CORS headers are
+// sent and preflights are refused. Otherwise requests from an allowed origin
+// get Access-Control-Allow-Origin, and a preflight is answered with 204 when
+// its method and headers are allowed, or 403 when they are not, without
+// reaching the management key check. Credentials (cookies) are never allowed;
+// callers authenticate with X-Management-Key or Authorization as usual.
+func (h *Handler) QSCORSMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.HasPrefix(c.Request.URL.Path, QSRoutePrefix) {
+			c.Next()
+			return
+		}
+		origin := c.GetHeader("Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if origin == "" {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		allowOrigin, ok := h.qsCORSAllowOrigin(origin)
+		if !ok {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		c.Header("Access-Control-Allow-Origin", allowOrigin)
+		if !preflight {
+			c.Header("Access-Control-Expose-Headers", strings.Join(qsCORSExposedHeaders, ", "))
+			c.Next()
+			return
+		}
+
+		methods := h.qsCORSMethods()
+		if !slices.Contains(methods, strings.ToUpper(c.GetHeader("Access-Control-Request-Method"))) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		for _, header := range strings.Split(c.GetHeader("Access-Control-Request-Headers"), ",") {
+			header = strings.TrimSpace(header)
+			if header != "" && !slices.ContainsFunc(qsCORSAllowedHeaders, func(allowed string) bool {
+				return strings.EqualFold(allowed, header)
+			}) {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+		}
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+		c.Header("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		c.Header("Access-Control-Allow-Headers", strings.Join(qsCORSAllowedHeaders, ", "))
+		c.Header("Access-Control-Max-Age", strconv.Itoa(h.qsCORSMaxAge()))
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+// qsCORSAllowOrigin returns the Access-Control-Allow-Origin value for origin,
+// and false when the origin is not allowed.
+func (h *Handler) qsCORSAllowOrigin(origin string) (string, bool) {
+	if h.cfg == nil {
+		return "", false
+	}
+	for _, allowed := range h.cfg.UsageStore.CORS.AllowedOrigins {
+		allowed = strings.TrimSuffix(strings.TrimSpace(allowed), "/")
+		if allowed == "*" {
+			return "*", true
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// qsCORSMethods returns the upper-cased methods cross-origin callers may use.
+func (h *Handler) qsCORSMethods() []string {
+	if h.cfg == nil || len(h.cfg.UsageStore.CORS.AllowedMethods) == 0 {
+		return qsCORSDefaultMethods
+	}
+	methods := make([]string, 0, len(h.cfg.UsageStore.CORS.AllowedMethods))
+	for _, method := range h.cfg.UsageStore.CORS.AllowedMethods {
+		if method = strings.ToUpper(strings.TrimSpace(method)); method != "" {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+func (h *Handler) qsCORSMaxAge() int {
+	if h.cfg != nil && h.cfg.UsageStore.CORS.MaxAgeSeconds > 0 {
+		return h.cfg.UsageStore.CORS.MaxAgeSeconds
+	}
+	return qsCORSDefaultMaxAge
+}
@@ -0,0 +1,83 @@
+package management
+
+import (
+	"math"
+	"slices"
+)
+
+// qsDefaultCostDecimals is the precision of cost_usd figures unless
+// usage-store.cost-decimals sets another.
+const qsDefaultCostDecimals = 4
+
+// qsCostDecimals returns the configured number of decimal places of cost_usd
+// figures; a negative value keeps full precision.
+func (h *Handler) qsCostDecimals() int {
+	if h.cfg != nil && h.cfg.UsageStore.CostDecimals != 0 {
+		return h.cfg.UsageStore.CostDecimals
+	}
+	return qsDefaultCostDecimals
+}
+
+// roundQSCost rounds a cost to decimals places, or returns it unchanged when
+// decimals is negative. Costs are summed unrounded and rounded only here, as
+// they are written, so rounding errors never accumulate.
+func roundQSCost(cost float64, decimals int) float64 {
+	if decimals < 0 {
+		return cost
+	}
+	scale := math.Pow10(decimals)
+	return math.Round(cost*scale) / scale
+}
+
+// qsMicroUSD returns a cost in whole micro-dollars.
+func qsMicroUSD(cost float64) int64 {
+	return int64(math.Round(cost * 1e6))
+}
+
+// roundQSModelCosts returns a copy of byModel with each cost rounded for the
+// response and its micro-dollars set; byModel itself, which may be cached, is
+// left unchanged.
+func roundQSModelCosts(byModel []ModelMetrics, decimals int) []ModelMetrics {
+	byModel = slices.Clone(byModel)
+	for i := range byModel {
+		byModel[i].CostMicroUSD = qsMicroUSD(byModel[i].CostUSD)
+		byModel[i].CostUSD = roundQSCost(byModel[i].CostUSD, decimals)
+	}
+	return byModel
+}
+
+// roundQSMetricsCosts rounds the cache savings, per-model costs and
+// per-request bucket costs of a metrics response for writing. Slices and
+// pointers are copied first, since the response may share them with the
+// metrics cache.
+func roundQSMetricsCosts(response *MetricsResponse, decimals int) {
+	response.Totals.CacheSavingsUSD = roundQSCost(response.Totals.CacheSavingsUSD, decimals)
+	response.ByModel = roundQSModelCosts(response.ByModel, decimals)
+	response.Timeseries = slices.Clone(response.Timeseries)
+	for i := range response.Timeseries {
+		if cost := response.Timeseries[i].CostPerRequest; cost != nil {
+			rounded := roundQSCost(*cost, decimals)
+			response.Timeseries[i].CostPerRequest = &rounded
+		}
+	}
+}
+
+// roundQSReportCosts rounds the costs of a report for the response. The
+// micro-dollars of each key and of the total are the sums of those of their
+// models, so they add up exactly.
+func roundQSReportCosts(report *UsageReportResponse, decimals int) {
+	report.Total.CostMicroUSD = 0
+	for i := range report.Keys {
+		key := &report.Keys[i]
+		key.CostMicroUSD = 0
+		for j := range key.ByModel {
+			model := &key.ByModel[j]
+			model.CostMicroUSD = qsMicroUSD(model.CostUSD)
+			model.CostUSD = roundQSCost(model.CostUSD, decimals)
+			key.CostMicroUSD += model.CostMicroUSD
+		}
+		key.CostUSD = roundQSCost(key.CostUSD, decimals)
+		report.Total.CostMicroUSD += key.CostMicroUSD
+	}
+	report.Total.CostUSD = roundQSCost(report.Total.CostUSD, decimals)
+}
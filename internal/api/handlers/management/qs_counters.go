@@ -0,0 +1,29 @@
+package management
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PostQSCountersReset zeroes a store's running counters and starts a new
+// baseline, so /qs/summary's all_time and /qs/health report usage since the
+// reset. It deletes nothing: usage.json, rollups and every event-based
+// endpoint are unaffected.
+// POST /v0/management/qs/counters/reset?tenant=<key>
+func (h *Handler) PostQSCountersReset(c *gin.Context) {
+	store, ok := h.qsStoreForRequest(c)
+	if !ok {
+		return
+	}
+	if store == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "usage store is not configured"})
+		return
+	}
+	since, err := store.ResetTotals()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"reset": true, "since": since})
+}
@@ -0,0 +1,116 @@
+package management
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+// CycleCounts are the counts of one slot of a repeating cycle, such as an
+// hour of the day, summed over every time it occurs in the range.
+type CycleCounts struct {
+	Tokens   int64 `json:"tokens"`
+	Requests int64 `json:"requests"`
+	// Days is how often the slot falls within the range, counting partial
+	// first and last days; an hour repeated when the clocks fall back counts
+	// twice. AvgTokens and AvgRequests divide by it and are 0 when it is 0.
+	Days        int     `json:"days"`
+	AvgTokens   float64 `json:"avg_tokens"`
+	AvgRequests float64 `json:"avg_requests"`
+}
+
+// qsCycleRequest parses the range, tz and filters shared by the hour-of-day
+// and day-of-week endpoints and loads the matching events. It writes the
+// error response itself when ok is false.
+func (h *Handler) qsCycleRequest(c *gin.Context) (events []usage.UsageEvent, query metricsQuery, loc *time.Location, ok bool) {
+	fromTime, toTime, ok := h.parseQSTimeRange(c)
+	if !ok {
+		return nil, query, nil, false
+	}
+	loc = time.UTC
+	if tz := c.Query("tz"); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'tz', expected an IANA time zone such as Europe/Berlin"})
+			return nil, query, nil, false
+		}
+	}
+	query = metricsQuery{
+		From:              fromTime,
+		To:                toTime,
+		Model:             c.Query("model"),
+		ExcludeSuspicious: c.Query("exclude_suspicious") == "true",
+		RedactModel:       h.qsModelRedactor(c),
+		RawOnly:           true,
+		UnknownModel:      h.qsUnknownModelLabel(),
+	}
+
+	store, ok := h.qsStoreForRequest(c)
+	if !ok {
+		return nil, query, nil, false
+	}
+	if store != nil {
+		var err error
+		events, err = loadQSMetricsEvents(store, &query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load usage events"})
+			return nil, query, nil, false
+		}
+		query.SampleRate = store.SampleRate()
+	} else if live := usage.GetLiveStore(); live != nil && c.Query("tenant") == "" {
+		events = live.Since(query.From)
+	}
+	return events, query, loc, true
+}
+
+// foldQSCycle sums the events matching the query into n slots and averages
+// each slot over its occurrences in the range, scaling sampled counts up.
+// first is the start of the period holding query.From, next advances one
+// period and slot maps a time to its index; periods are stepped from first
+// so both calendar days and DST transitions are honoured.
+func foldQSCycle(events []usage.UsageEvent, query metricsQuery, n int, first time.Time, next func(time.Time) time.Time, slot func(time.Time) int) ([]CycleCounts, MetricsTotals) {
+	counts := make([]CycleCounts, n)
+	var totals MetricsTotals
+	for start := first; !start.After(query.To); start = next(start) {
+		if next(start).After(query.From) {
+			counts[slot(start)].Days++
+		}
+	}
+
+	for _, event := range events {
+		if event.IsMarker() || event.Timestamp.Before(query.From) || event.Timestamp.After(query.To) {
+			continue
+		}
+		if query.Model != "" && query.modelName(event.Model) != query.Model {
+			continue
+		}
+		if query.ExcludeSuspicious && event.Suspicious {
+			continue
+		}
+		count := &counts[slot(event.Timestamp)]
+		count.Tokens += event.TotalTokens
+		count.Requests++
+		totals.Tokens += event.TotalTokens
+		totals.Requests++
+	}
+
+	if query.SampleRate > 0 && query.SampleRate < 1 {
+		scale := func(v int64) int64 { return int64(math.Round(float64(v) / query.SampleRate)) }
+		totals.Tokens = scale(totals.Tokens)
+		totals.Requests = scale(totals.Requests)
+		for i := range counts {
+			counts[i].Tokens = scale(counts[i].Tokens)
+			counts[i].Requests = scale(counts[i].Requests)
+		}
+	}
+	for i := range counts {
+		if count := &counts[i]; count.Days > 0 {
+			count.AvgTokens = float64(count.Tokens) / float64(count.Days)
+			count.AvgRequests = float64(count.Requests) / float64(count.Days)
+		}
+	}
+	return counts, totals
+}
@@ -0,0 +1,83 @@
+package management
+
+import (
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+func TestAggregateHourOfDay_FoldsDaysInTimezone(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+	// Three whole days in Berlin, spanning the fall-back on 2025-10-26
+	query := metricsQuery{
+		From: time.Date(2025, 10, 25, 0, 0, 0, 0, berlin),
+		To:   time.Date(2025, 10, 27, 23, 59, 59, 0, berlin),
+	}
+	events := []usage.UsageEvent{
+		// 09:00 UTC is 11:00 Berlin in summer time and 10:00 after the change
+		{Timestamp: time.Date(2025, 10, 25, 9, 0, 0, 0, time.UTC), Model: "m", TotalTokens: 10},
+		{Timestamp: time.Date(2025, 10, 27, 9, 0, 0, 0, time.UTC), Model: "m", TotalTokens: 20},
+		{Timestamp: time.Date(2025, 10, 27, 9, 30, 0, 0, time.UTC), Model: "m", TotalTokens: 40},
+		// Before the range
+		{Timestamp: time.Date(2025, 10, 24, 9, 0, 0, 0, time.UTC), Model: "m", TotalTokens: 80},
+	}
+
+	response := aggregateHourOfDay(events, query, berlin)
+	if len(response.Hours) != 24 || response.Timezone != "Europe/Berlin" {
+		t.Fatalf("want 24 Berlin hours, got %d in %s", len(response.Hours), response.Timezone)
+	}
+	if h := response.Hours[11]; h.Requests != 1 || h.Tokens != 10 || h.Days != 3 {
+		t.Fatalf("unexpected 11:00: %+v", h)
+	}
+	if h := response.Hours[10]; h.Requests != 2 || h.Tokens != 60 || h.AvgTokens != 20 || h.AvgRequests != 2.0/3 {
+		t.Fatalf("unexpected 10:00: %+v", h)
+	}
+	// 02:00-03:00 happens twice on the day the clocks fall back
+	if h := response.Hours[2]; h.Days != 4 || h.Requests != 0 || h.AvgRequests != 0 {
+		t.Fatalf("unexpected 02:00: %+v", h)
+	}
+	if response.Totals.Requests != 3 || response.Totals.Tokens != 70 {
+		t.Fatalf("unexpected totals: %+v", response.Totals)
+	}
+}
+
+func TestAggregateDayOfWeek_ZeroFillsMondayFirst(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+	// Monday 2025-11-24 to Sunday 2025-12-07 in Tokyo: two of every day
+	query := metricsQuery{
+		From: time.Date(2025, 11, 24, 0, 0, 0, 0, tokyo),
+		To:   time.Date(2025, 12, 7, 23, 59, 59, 0, tokyo),
+	}
+	events := []usage.UsageEvent{
+		// Sunday 2025-11-23 20:00 UTC is already Monday 05:00 in Tokyo
+		{Timestamp: time.Date(2025, 11, 23, 20, 0, 0, 0, time.UTC), Model: "m", TotalTokens: 10},
+		{Timestamp: time.Date(2025, 12, 1, 3, 0, 0, 0, time.UTC), Model: "m", TotalTokens: 30},
+		// Saturday in Tokyo
+		{Timestamp: time.Date(2025, 11, 29, 12, 0, 0, 0, time.UTC), Model: "m", TotalTokens: 5},
+		{Timestamp: time.Date(2025, 11, 29, 0, 0, 0, 0, time.UTC), Kind: usage.EventKindPing},
+	}
+
+	response := aggregateDayOfWeek(events, query, tokyo)
+	if len(response.Days) != 7 || response.Days[0].Day != "Monday" || response.Days[6].Day != "Sunday" || response.Days[6].Weekday != 7 {
+		t.Fatalf("want Monday to Sunday, got %+v", response.Days)
+	}
+	if d := response.Days[0]; d.Requests != 2 || d.Tokens != 40 || d.Days != 2 || d.AvgTokens != 20 {
+		t.Fatalf("unexpected Monday: %+v", d)
+	}
+	if d := response.Days[5]; d.Requests != 1 || d.Tokens != 5 {
+		t.Fatalf("unexpected Saturday: %+v", d)
+	}
+	if d := response.Days[2]; d.Requests != 0 || d.Days != 2 || d.AvgRequests != 0 {
+		t.Fatalf("want zero-filled Wednesday, got %+v", d)
+	}
+	if response.Totals.Requests != 3 || response.Totals.Tokens != 45 {
+		t.Fatalf("unexpected totals: %+v", response.Totals)
+	}
+}
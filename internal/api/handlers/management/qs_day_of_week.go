@@ -0,0 +1,68 @@
+package management
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+// DayOfWeekResponse is usage folded onto the 7 days of the week.
+type DayOfWeekResponse struct {
+	// Timezone is the zone days are read in.
+	Timezone   string            `json:"timezone"`
+	Totals     MetricsTotals     `json:"totals"`
+	Days       []DayOfWeekBucket `json:"days"`
+	Estimated  bool              `json:"estimated,omitempty"`
+	SampleRate float64           `json:"sample_rate,omitempty"`
+}
+
+// DayOfWeekBucket sums one day of the week over every week of the range.
+type DayOfWeekBucket struct {
+	// Weekday is the ISO 8601 day number, 1 for Monday to 7 for Sunday.
+	Weekday int `json:"weekday"`
+	// Day is the English day name, e.g. "Monday".
+	Day string `json:"day"`
+	CycleCounts
+}
+
+// GetQSDayOfWeekMetrics returns usage by day of the week across the range,
+// for comparing weekdays with weekends and picking maintenance windows.
+// GET /v0/management/qs/metrics/day-of-week?from=...&to=...&tz=Europe/Berlin&model=...&tenant=...
+//
+// Each event counts toward its local day in tz (default UTC); all 7 days are
+// returned Monday first, zero-filled, with sums and per-day averages.
+func (h *Handler) GetQSDayOfWeekMetrics(c *gin.Context) {
+	events, query, loc, ok := h.qsCycleRequest(c)
+	if !ok {
+		return
+	}
+	writeQSJSON(c, http.StatusOK, aggregateDayOfWeek(events, query, loc))
+}
+
+// aggregateDayOfWeek sums the events matching the query by their day of the
+// week in loc and averages each day over its occurrences in the range. Days
+// are stepped by calendar date, so one spanning a DST change still counts once.
+func aggregateDayOfWeek(events []usage.UsageEvent, query metricsQuery, loc *time.Location) DayOfWeekResponse {
+	local := query.From.In(loc)
+	first := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	counts, totals := foldQSCycle(events, query, 7, first,
+		func(t time.Time) time.Time { return t.AddDate(0, 0, 1) },
+		func(t time.Time) int { return (int(t.In(loc).Weekday()) + 6) % 7 },
+	)
+
+	response := DayOfWeekResponse{Timezone: loc.String(), Totals: totals, Days: make([]DayOfWeekBucket, 7)}
+	for i := range response.Days {
+		response.Days[i] = DayOfWeekBucket{
+			Weekday:     i + 1,
+			Day:         time.Weekday((i + 1) % 7).String(),
+			CycleCounts: counts[i],
+		}
+	}
+	if query.SampleRate > 0 && query.SampleRate < 1 {
+		response.Estimated = true
+		response.SampleRate = query.SampleRate
+	}
+	return response
+}
@@ -0,0 +1,116 @@
+package management
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+// GetQSDownload streams the raw store files for backups.
+// GET /v0/management/qs/download?format=jsonl|tar.gz&segments=true&tenant=...
+//
+// The buffer is flushed and the files captured under the store lock, so the
+// download is internally consistent: it holds every event recorded before the
+// request and no partial line, while writers carry on. format=jsonl (the
+// default) concatenates the files, rotated segments (with segments=true)
+// oldest first and then the live file, into one JSON Lines stream; tar.gz
+// bundles them unchanged, which also works for binary-format files.
+func (h *Handler) GetQSDownload(c *gin.Context) {
+	format := c.DefaultQuery("format", "jsonl")
+	if format != "jsonl" && format != "tar.gz" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'format', expected jsonl or tar.gz"})
+		return
+	}
+	store, ok := h.qsStoreForRequest(c)
+	if !ok {
+		return
+	}
+	if store == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "usage store is not configured"})
+		return
+	}
+
+	snapshot, err := store.Snapshot(c.Query("segments") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to snapshot usage store: " + err.Error()})
+		return
+	}
+	defer func() { _ = snapshot.Close() }()
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	if format == "tar.gz" {
+		c.Header("Content-Type", "application/gzip")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"usage-store-%s.tar.gz\"", stamp))
+		c.Status(http.StatusOK)
+		if err := writeQSSnapshotTarGz(c.Writer, snapshot); err != nil {
+			// Headers are already sent; abort so the client sees a truncated archive
+			_ = c.Error(err)
+			c.Abort()
+		}
+		return
+	}
+
+	for _, file := range snapshot.Files {
+		if file.Binary {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s uses the binary format and cannot be concatenated as JSON Lines; use format=tar.gz", file.Name)})
+			return
+		}
+	}
+	// A file cut off mid-line by a crash gets a newline so the next one starts cleanly
+	missingNewline := make([]bool, len(snapshot.Files))
+	length := snapshot.Size()
+	for i, file := range snapshot.Files {
+		last := make([]byte, 1)
+		if file.Size > 0 {
+			if _, err := file.Reader().ReadAt(last, file.Size-1); err == nil && last[0] != '\n' {
+				missingNewline[i] = true
+				length++
+			}
+		}
+	}
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"usage-store-%s.jsonl\"", stamp))
+	c.Header("Content-Length", strconv.FormatInt(length, 10))
+	c.Status(http.StatusOK)
+	for i, file := range snapshot.Files {
+		if _, err := io.Copy(c.Writer, file.Reader()); err != nil {
+			_ = c.Error(err)
+			c.Abort()
+			return
+		}
+		if missingNewline[i] {
+			_, _ = c.Writer.Write([]byte{'\n'})
+		}
+	}
+}
+
+// writeQSSnapshotTarGz writes the snapshot's files as a gzip-compressed tar archive.
+func writeQSSnapshotTarGz(w io.Writer, snapshot *usage.Snapshot) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, file := range snapshot.Files {
+		header := &tar.Header{
+			Name:    file.Name,
+			Mode:    0o600,
+			Size:    file.Size,
+			ModTime: file.ModTime,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, file.Reader()); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
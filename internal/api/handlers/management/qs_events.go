@@ -0,0 +1,210 @@
+package management
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+const (
+	qsEventsDefaultLimit = 100
+	qsEventsMaxLimit     = 1000
+)
+
+// EventsPageResponse is one page of the raw events listing.
+type EventsPageResponse struct {
+	Events []usage.UsageEvent `json:"events"`
+	// NextCursor continues in the listing's order; PrevCursor pages back.
+	// A cursor is omitted when there is nothing in that direction, except
+	// that the cursor towards newer events is always returned because new
+	// events may still be appended.
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	// SampleRate is set when the store is sampled: the events are only this
+	// fraction of all requests.
+	SampleRate float64 `json:"sample_rate,omitempty"`
+}
+
+// qsEventsCursor marks a position next to a reference event in the store
+// file: just after it (After) or just before it. The byte offset keeps pages
+// stable while events are appended; the file generation and the reference
+// timestamp detect a file that was rotated or truncated since the cursor was
+// issued.
+type qsEventsCursor struct {
+	Offset     int64  `json:"o"`
+	Timestamp  int64  `json:"t"`
+	After      bool   `json:"a,omitempty"`
+	Generation string `json:"g,omitempty"`
+}
+
+func (cur qsEventsCursor) encode() string {
+	data, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeQSEventsCursor(raw string) (qsEventsCursor, error) {
+	var cur qsEventsCursor
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return cur, err
+	}
+	err = json.Unmarshal(data, &cur)
+	return cur, err
+}
+
+// cursorAfter and cursorBefore build the cursors on either side of an event.
+func cursorAfter(e usage.PositionedEvent) string {
+	return qsEventsCursor{Offset: e.End, Timestamp: e.Event.Timestamp.UnixNano(), After: true, Generation: e.Generation}.encode()
+}
+
+func cursorBefore(e usage.PositionedEvent) string {
+	return qsEventsCursor{Offset: e.Offset, Timestamp: e.Event.Timestamp.UnixNano(), Generation: e.Generation}.encode()
+}
+
+// validQSEventsCursor reports whether the cursor's reference event is still
+// where the cursor says it is.
+func validQSEventsCursor(store *usage.JSONStore, cur qsEventsCursor) (bool, error) {
+	var ref []usage.PositionedEvent
+	var err error
+	if cur.After {
+		ref, err = store.EventsBefore(cur.Offset, 1, nil)
+	} else {
+		ref, err = store.EventsAfter(cur.Offset, 1, nil)
+	}
+	if errors.Is(err, usage.ErrInvalidOffset) {
+		return false, nil
+	}
+	if err != nil || len(ref) == 0 {
+		return false, err
+	}
+	if ref[0].Generation != cur.Generation || cur.After && ref[0].End != cur.Offset || !cur.After && ref[0].Offset != cur.Offset {
+		return false, nil
+	}
+	return ref[0].Event.Timestamp.UnixNano() == cur.Timestamp, nil
+}
+
+// GetQSEvents lists persisted events page by page with opaque cursors.
+// GET /v0/management/qs/events?order=asc|desc&limit=100&model=...&request_id_prefix=...&cursor=...
+//
+// Events are listed in file (append) order, oldest first, or newest first with
+// order=desc. Pass next_cursor or prev_cursor from a response as 'cursor' to
+// move forward or back, keeping the same order and filter parameters.
+// Cursors encode a byte offset, the file generation and the reference event's
+// timestamp, so pages do not shift as new events arrive. A cursor from before
+// the file was rotated or truncated returns 410. Events still in the write buffer are not listed.
+func (h *Handler) GetQSEvents(c *gin.Context) {
+	order := c.DefaultQuery("order", "asc")
+	if order != "asc" && order != "desc" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'order', expected asc or desc"})
+		return
+	}
+	limit := qsEventsDefaultLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'limit', expected a positive integer"})
+			return
+		}
+		limit = min(n, qsEventsMaxLimit)
+	}
+	var match func(usage.UsageEvent) bool
+	if model, prefix := c.Query("model"), c.Query("request_id_prefix"); model != "" || prefix != "" {
+		match = func(event usage.UsageEvent) bool {
+			return (model == "" || event.Model == model) && strings.HasPrefix(event.RequestID, prefix)
+		}
+	}
+	var cursor *qsEventsCursor
+	if raw := c.Query("cursor"); raw != "" {
+		cur, err := decodeQSEventsCursor(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'cursor'"})
+			return
+		}
+		cursor = &cur
+	}
+
+	response := EventsPageResponse{Events: []usage.UsageEvent{}}
+	store := h.qsStore()
+	if store == nil {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	if cursor != nil {
+		valid, err := validQSEventsCursor(store, *cursor)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read usage events"})
+			return
+		}
+		if !valid {
+			c.JSON(http.StatusGone, gin.H{"error": "cursor no longer matches the store file (rotated or truncated); start over without a cursor"})
+			return
+		}
+	}
+
+	// Read one extra event to learn whether more exist towards the file start
+	forward := cursor == nil && order == "asc" || cursor != nil && cursor.After
+	var page []usage.PositionedEvent
+	var err error
+	switch {
+	case cursor == nil && forward:
+		page, err = store.EventsAfter(0, limit, match)
+	case cursor == nil:
+		page, err = store.EventsBefore(-1, limit+1, match)
+	case forward:
+		page, err = store.EventsAfter(cursor.Offset, limit, match)
+	default:
+		page, err = store.EventsBefore(cursor.Offset, limit+1, match)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read usage events"})
+		return
+	}
+	moreBefore := !forward && len(page) > limit || forward && cursor != nil
+	if len(page) > limit {
+		page = page[:limit]
+	}
+	// EventsBefore returns newest first; put the page in display order
+	if forward == (order == "desc") {
+		for i, j := 0, len(page)-1; i < j; i, j = i+1, j-1 {
+			page[i], page[j] = page[j], page[i]
+		}
+	}
+
+	if len(page) == 0 {
+		if forward && cursor != nil {
+			// Nothing newer yet; keep polling from the same place
+			if order == "asc" {
+				response.NextCursor = c.Query("cursor")
+			} else {
+				response.PrevCursor = c.Query("cursor")
+			}
+		}
+	} else {
+		first, last := page[0], page[len(page)-1]
+		if order == "asc" {
+			response.NextCursor = cursorAfter(last)
+			if moreBefore {
+				response.PrevCursor = cursorBefore(first)
+			}
+		} else {
+			response.PrevCursor = cursorAfter(first)
+			if moreBefore {
+				response.NextCursor = cursorBefore(last)
+			}
+		}
+	}
+	for _, e := range page {
+		response.Events = append(response.Events, e.Event)
+	}
+	if rate := store.SampleRate(); rate < 1 {
+		response.SampleRate = rate
+	}
+
+	c.JSON(http.StatusOK, response)
+}
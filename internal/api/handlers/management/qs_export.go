@@ -0,0 +1,187 @@
+package management
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+// qsExportCSVHeader lists the default CSV export columns in order.
+var qsExportCSVHeader = []string{
+	"timestamp", "model", "prompt_tokens", "completion_tokens", "total_tokens",
+	"status", "request_id", "api_key_hash", "latency_ms", "upstream_request_id",
+}
+
+// qsExportCSVColumns renders each selectable CSV column from an event.
+var qsExportCSVColumns = map[string]func(event *usage.UsageEvent) string{
+	"timestamp":           func(e *usage.UsageEvent) string { return e.Timestamp.Format(time.RFC3339Nano) },
+	"model":               func(e *usage.UsageEvent) string { return e.Model },
+	"provider":            func(e *usage.UsageEvent) string { return e.Provider },
+	"prompt_tokens":       func(e *usage.UsageEvent) string { return strconv.FormatInt(e.PromptTokens, 10) },
+	"completion_tokens":   func(e *usage.UsageEvent) string { return strconv.FormatInt(e.CompletionTokens, 10) },
+	"total_tokens":        func(e *usage.UsageEvent) string { return strconv.FormatInt(e.TotalTokens, 10) },
+	"cached_tokens":       func(e *usage.UsageEvent) string { return strconv.FormatInt(e.CachedTokens, 10) },
+	"status":              func(e *usage.UsageEvent) string { return strconv.Itoa(e.Status) },
+	"request_id":          func(e *usage.UsageEvent) string { return e.RequestID },
+	"api_key_hash":        func(e *usage.UsageEvent) string { return e.APIKeyHash },
+	"latency_ms":          func(e *usage.UsageEvent) string { return strconv.FormatInt(e.LatencyMs, 10) },
+	"upstream_request_id": func(e *usage.UsageEvent) string { return e.UpstreamRequestID },
+	"suspicious":          func(e *usage.UsageEvent) string { return strconv.FormatBool(e.Suspicious) },
+}
+
+// parseQSExportColumns parses a comma-separated column list, keeping the
+// caller's order. An empty value selects the default columns.
+func parseQSExportColumns(raw string) ([]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return qsExportCSVHeader, nil
+	}
+	parts := strings.Split(raw, ",")
+	columns := make([]string, 0, len(parts))
+	for _, part := range parts {
+		name := strings.TrimSpace(part)
+		if _, ok := qsExportCSVColumns[name]; !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns = append(columns, name)
+	}
+	return columns, nil
+}
+
+// ExportQSEvents streams raw usage events as CSV or NDJSON.
+// GET|HEAD /v0/management/qs/events/export?format=csv&from=...&to=...&model=...&columns=...
+//
+// For CSV, columns selects and orders the output columns by field name;
+// unknown names are rejected with 400.
+//
+// A sampled store only persists a fraction of events; its exports carry that
+// fraction in the X-Sample-Rate header.
+//
+// The GET response is streamed with chunked encoding, so it carries no
+// Content-Length; the number of data rows is reported up front in the
+// X-Row-Count header. A HEAD request with the same parameters renders the
+// export without sending it and returns both X-Row-Count and the exact
+// Content-Length, letting clients size a progress bar before downloading.
+func (h *Handler) ExportQSEvents(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "ndjson" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid format, expected csv or ndjson"})
+		return
+	}
+	columns, err := parseQSExportColumns(c.Query("columns"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fromTime, toTime, ok := h.parseQSTimeRange(c)
+	if !ok {
+		return
+	}
+	modelFilter := c.Query("model")
+
+	var events []usage.UsageEvent
+	if store := h.qsStore(); store != nil {
+		loaded, err := store.LoadRange(fromTime, toTime)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load usage events"})
+			return
+		}
+		events = filterQSEvents(loaded, fromTime, toTime, modelFilter)
+	}
+
+	contentType := "text/csv; charset=utf-8"
+	if format == "ndjson" {
+		contentType = "application/x-ndjson"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"usage-events.%s\"", format))
+	c.Header("X-Row-Count", strconv.Itoa(len(events)))
+	setQSSampleRateHeader(c, h.qsStore())
+
+	if c.Request.Method == http.MethodHead {
+		var counter countingWriter
+		if err := writeQSExport(&counter, format, columns, events); err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Header("Content-Length", strconv.FormatInt(counter.n, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Status(http.StatusOK)
+	if err := writeQSExport(c.Writer, format, columns, events); err != nil {
+		// Headers are already sent; abort the stream so the client sees a truncated body
+		_ = c.Error(err)
+		c.Abort()
+	}
+}
+
+// writeQSExport renders events in the requested format. columns applies to CSV only.
+func writeQSExport(w io.Writer, format string, columns []string, events []usage.UsageEvent) error {
+	if format == "ndjson" {
+		encoder := json.NewEncoder(w)
+		for i := range events {
+			if err := encoder.Encode(&events[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+	record := make([]string, len(columns))
+	for i := range events {
+		for j, column := range columns {
+			record[j] = qsExportCSVColumns[column](&events[i])
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// filterQSEvents returns events inside [fromTime, toTime] matching the optional model filter.
+func filterQSEvents(events []usage.UsageEvent, fromTime, toTime time.Time, modelFilter string) []usage.UsageEvent {
+	filtered := make([]usage.UsageEvent, 0, len(events))
+	for _, event := range events {
+		if event.Timestamp.Before(fromTime) || event.Timestamp.After(toTime) {
+			continue
+		}
+		if modelFilter != "" && event.Model != modelFilter {
+			continue
+		}
+		filtered = append(filtered, event)
+	}
+	return filtered
+}
+
+// setQSSampleRateHeader marks raw event exports of a sampled store with
+// X-Sample-Rate, since they hold only that fraction of the events.
+func setQSSampleRateHeader(c *gin.Context, store *usage.JSONStore) {
+	if rate := store.SampleRate(); rate < 1 {
+		c.Header("X-Sample-Rate", strconv.FormatFloat(rate, 'g', -1, 64))
+	}
+}
+
+// countingWriter discards writes while counting bytes.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,70 @@
+package management
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+func TestWriteQSExport_SelectedColumns(t *testing.T) {
+	columns, err := parseQSExportColumns("timestamp, model,total_tokens,status")
+	if err != nil {
+		t.Fatalf("parse columns: %v", err)
+	}
+	events := []usage.UsageEvent{{
+		Timestamp:   time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC),
+		Model:       "gpt-4o",
+		TotalTokens: 42,
+		Status:      200,
+		RequestID:   "req-1",
+	}}
+
+	var buf bytes.Buffer
+	if err := writeQSExport(&buf, "csv", columns, events); err != nil {
+		t.Fatalf("write export: %v", err)
+	}
+	want := "timestamp,model,total_tokens,status\n2025-11-25T12:00:00Z,gpt-4o,42,200\n"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+
+	if _, err := parseQSExportColumns("timestamp,bogus"); err == nil {
+		t.Fatal("expected error for unknown column")
+	}
+}
+
+func TestWriteQSSnapshotTarGz_BundlesFilesUnchanged(t *testing.T) {
+	store := usage.NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), usage.WithPeriodicFlush(false), usage.WithBinaryFormat(true))
+	defer func() { _ = store.Close() }()
+	if err := store.Write(usage.UsageEvent{Timestamp: time.Now(), Model: "m", TotalTokens: 1}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	snapshot, err := store.Snapshot(false)
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	defer func() { _ = snapshot.Close() }()
+
+	var buf bytes.Buffer
+	if err := writeQSSnapshotTarGz(&buf, snapshot); err != nil {
+		t.Fatalf("write archive: %v", err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	header, err := tr.Next()
+	if err != nil || header.Name != "usage.json" || header.Size != snapshot.Files[0].Size {
+		t.Fatalf("unexpected entry %+v (%v)", header, err)
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Fatalf("want a single entry, got %v", err)
+	}
+}
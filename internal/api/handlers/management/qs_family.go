@@ -0,0 +1,59 @@
+package management
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// qsFamilyRule is a compiled usage-store.model-families entry.
+type qsFamilyRule struct {
+	family string
+	prefix string
+	match  *regexp.Regexp
+}
+
+// qsModelFamilies returns the function mapping reported model names to their
+// family under usage-store.model-families. Models matching no rule are their
+// own family. Rules with an invalid expression, or without a family or a
+// pattern, are skipped with a warning. The function caches each model's
+// family and is safe for concurrent use by aggregation workers.
+func (h *Handler) qsModelFamilies() func(string) string {
+	var rules []qsFamilyRule
+	if h.cfg != nil {
+		for _, entry := range h.cfg.UsageStore.ModelFamilies {
+			rule := qsFamilyRule{family: entry.Family, prefix: entry.Prefix}
+			if entry.Match != "" {
+				re, err := regexp.Compile(entry.Match)
+				if err != nil {
+					log.Warnf("skipping usage-store.model-families rule for %q: %v", entry.Family, err)
+					continue
+				}
+				rule.match = re
+			}
+			if rule.family == "" || (rule.prefix == "" && rule.match == nil) {
+				log.Warnf("skipping usage-store.model-families rule for %q: expected a family and a prefix or match", entry.Family)
+				continue
+			}
+			rules = append(rules, rule)
+		}
+	}
+
+	var cache sync.Map
+	return func(model string) string {
+		if family, ok := cache.Load(model); ok {
+			return family.(string)
+		}
+		family := model
+		for _, rule := range rules {
+			if rule.prefix != "" && strings.HasPrefix(model, rule.prefix) || rule.match != nil && rule.match.MatchString(model) {
+				family = rule.family
+				break
+			}
+		}
+		cache.Store(model, family)
+		return family
+	}
+}
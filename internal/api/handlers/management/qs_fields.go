@@ -0,0 +1,95 @@
+package management
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// qsMetricsFields are the top-level sections of a metrics response the
+// fields parameter selects; the remaining fields, such as bucket_seconds and
+// estimated, are always returned.
+var qsMetricsFields = []string{"totals", "by_model", "timeseries", "groups", "markers"}
+
+// parseQSFields parses a comma-separated fields list. An empty value selects
+// every section and returns nil.
+func parseQSFields(raw string) (map[string]bool, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	return validateQSFields(strings.Split(raw, ","))
+}
+
+// validateQSFields checks the names of selected response sections; an empty
+// list selects every section and returns nil.
+func validateQSFields(names []string) (map[string]bool, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	fields := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(qsMetricsFields, name) {
+			return nil, fmt.Errorf("invalid 'fields' entry %q, expected any of %s", name, strings.Join(qsMetricsFields, ", "))
+		}
+		fields[name] = true
+	}
+	return fields, nil
+}
+
+// wants reports whether the response section name is selected.
+func (q metricsQuery) wants(name string) bool {
+	return q.Fields == nil || q.Fields[name]
+}
+
+// applyQSFields drops the work behind sections the query does not select:
+// without by_model and timeseries only totals are computed, as for
+// view=totals, and without groups group_by is ignored.
+func applyQSFields(query metricsQuery) metricsQuery {
+	if query.Fields == nil {
+		return query
+	}
+	if !query.wants("groups") {
+		query.GroupBy = nil
+	}
+	if !query.wants("by_model") {
+		query.Sparklines, query.Top, query.MinShare = false, 0, 0
+	}
+	if !query.wants("timeseries") {
+		query.IncludeDelta, query.FillGaps, query.CostPerRequest = false, false, false
+	}
+	if !query.wants("by_model") && !query.wants("timeseries") && len(query.GroupBy) == 0 {
+		query.TotalsOnly = true
+	}
+	return query
+}
+
+// writeQSMetricsJSON writes a metrics response with its costs rounded and
+// only the sections the query selects.
+func (h *Handler) writeQSMetricsJSON(c *gin.Context, query metricsQuery, response MetricsResponse) {
+	roundQSMetricsCosts(&response, h.qsCostDecimals())
+	if query.Fields == nil {
+		writeQSJSON(c, http.StatusOK, response)
+		return
+	}
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encode usage metrics"})
+		return
+	}
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &sections); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encode usage metrics"})
+		return
+	}
+	for _, name := range qsMetricsFields {
+		if !query.Fields[name] {
+			delete(sections, name)
+		}
+	}
+	writeQSJSON(c, http.StatusOK, sections)
+}
@@ -0,0 +1,84 @@
+package management
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+// qsFollowKeepAlive is how often an idle follow stream sends an SSE comment,
+// keeping proxies from closing the connection.
+const qsFollowKeepAlive = 15 * time.Second
+
+// GetQSEventsFollow streams events as they are flushed to disk, like tail -f,
+// as Server-Sent Events starting from now.
+// GET /v0/management/qs/events/follow?model=...&tenant=...
+//
+// Each event is sent as a "data:" line holding the UsageEvent JSON. Events
+// reach followers when the store flushes them, so they may arrive up to the
+// flush interval late. The number of concurrent followers is bounded by
+// usage-store.max-followers (429 beyond it); a follower that falls too far
+// behind, or whose store is closed, receives a final "event: end" message.
+func (h *Handler) GetQSEventsFollow(c *gin.Context) {
+	store, ok := h.qsStoreForRequest(c)
+	if !ok {
+		return
+	}
+	if store == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "usage store is not configured"})
+		return
+	}
+	events, err := store.Follow(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, usage.ErrTooManyFollowers) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	model := c.Query("model")
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(qsFollowKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(c.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case event, open := <-events:
+			if !open {
+				_, _ = fmt.Fprint(c.Writer, "event: end\ndata: {}\n\n")
+				c.Writer.Flush()
+				return
+			}
+			if model != "" && event.Model != model {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(c.Writer, "data: %s\n\n", data); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package management
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+// HourOfDayResponse is usage folded onto the 24 hours of the day.
+type HourOfDayResponse struct {
+	// Timezone is the zone hours are read in.
+	Timezone   string            `json:"timezone"`
+	Totals     MetricsTotals     `json:"totals"`
+	Hours      []HourOfDayBucket `json:"hours"`
+	Estimated  bool              `json:"estimated,omitempty"`
+	SampleRate float64           `json:"sample_rate,omitempty"`
+}
+
+// HourOfDayBucket sums one hour of the day, e.g. 14:00-15:00, over every day
+// of the range.
+type HourOfDayBucket struct {
+	Hour int `json:"hour"`
+	CycleCounts
+}
+
+// GetQSHourOfDayMetrics returns usage by hour of the day across the range,
+// for spotting daily seasonality.
+// GET /v0/management/qs/metrics/hour-of-day?from=...&to=...&tz=Europe/Berlin&model=...&tenant=...
+//
+// Each event counts toward its local hour in tz (default UTC); all 24 hours
+// are returned, with sums and per-day averages.
+func (h *Handler) GetQSHourOfDayMetrics(c *gin.Context) {
+	events, query, loc, ok := h.qsCycleRequest(c)
+	if !ok {
+		return
+	}
+	writeQSJSON(c, http.StatusOK, aggregateHourOfDay(events, query, loc))
+}
+
+// aggregateHourOfDay sums the events matching the query by their hour of the
+// day in loc and averages each hour over the days of the range.
+func aggregateHourOfDay(events []usage.UsageEvent, query metricsQuery, loc *time.Location) HourOfDayResponse {
+	local := query.From.In(loc)
+	first := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), 0, 0, 0, loc)
+	counts, totals := foldQSCycle(events, query, 24, first,
+		func(t time.Time) time.Time { return t.Add(time.Hour) },
+		func(t time.Time) int { return t.In(loc).Hour() },
+	)
+
+	response := HourOfDayResponse{Timezone: loc.String(), Totals: totals, Hours: make([]HourOfDayBucket, 24)}
+	for hour := range response.Hours {
+		response.Hours[hour] = HourOfDayBucket{Hour: hour, CycleCounts: counts[hour]}
+	}
+	if query.SampleRate > 0 && query.SampleRate < 1 {
+		response.Estimated = true
+		response.SampleRate = query.SampleRate
+	}
+	return response
+}
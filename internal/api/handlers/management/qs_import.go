@@ -0,0 +1,39 @@
+package management
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PostQSImport writes the usage events of a JSON lines body into the store,
+// e.g. to seed a fresh instance from /qs/events/export?format=ndjson or merge
+// another node's usage.json.
+// POST /v0/management/qs/import?dedupe=true&tenant=<key>
+//
+// The body is parsed as it streams in, gzipped or not. Invalid lines are skipped and counted;
+// with dedupe=true events the store already holds are left out. The response
+// carries the imported, skipped and deduped counts, also when the import
+// stops on an error.
+func (h *Handler) PostQSImport(c *gin.Context) {
+	store, ok := h.qsStoreForRequest(c)
+	if !ok {
+		return
+	}
+	if store == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "usage store is not configured"})
+		return
+	}
+	result, err := store.Import(c.Request.Body, c.Query("dedupe") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"imported": result.Imported,
+			"skipped":  result.Skipped,
+			"deduped":  result.Deduped,
+			"errors":   result.Errors,
+			"error":    err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,204 @@
+package management
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+// KeyTimeseriesResponse is the usage timeseries of a single hashed API key.
+type KeyTimeseriesResponse struct {
+	APIKeyHash string             `json:"api_key_hash"`
+	Interval   string             `json:"interval"`
+	Totals     MetricsTotals      `json:"totals"`
+	Timeseries []TimeseriesBucket `json:"timeseries"`
+	Estimated  bool               `json:"estimated,omitempty"`
+}
+
+// qsIntervals maps the supported 'interval' query values to bucket widths.
+var qsIntervals = map[string]time.Duration{
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+}
+
+// qsBucketSteps are the bucket widths a 'buckets' target snaps to, narrowest first.
+var qsBucketSteps = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+}
+
+// qsMaxBucketTarget caps the 'buckets' query parameter.
+const qsMaxBucketTarget = 1000
+
+// qsIntervalForBuckets picks the narrowest bucket step that splits span into
+// at most target buckets, or the widest step when none does.
+func qsIntervalForBuckets(span time.Duration, target int) time.Duration {
+	if target <= 0 {
+		target = 1
+	}
+	raw := span / time.Duration(target)
+	for _, step := range qsBucketSteps {
+		if step >= raw {
+			return step
+		}
+	}
+	return qsBucketSteps[len(qsBucketSteps)-1]
+}
+
+// qsIntervalName names a bucket width, using the 'interval' names where they exist.
+func qsIntervalName(interval time.Duration) string {
+	for name, d := range qsIntervals {
+		if d == interval {
+			return name
+		}
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(interval.String(), "0s"), "0m")
+}
+
+// parseQSBuckets reads the optional 'buckets' query parameter and returns the
+// snapped bucket width for the range, or zero when it is absent.
+// On invalid input it writes a 400 response and returns ok=false.
+func parseQSBuckets(c *gin.Context, fromTime, toTime time.Time) (time.Duration, bool) {
+	bucketsStr := c.Query("buckets")
+	if bucketsStr == "" {
+		return 0, true
+	}
+	target, err := strconv.Atoi(bucketsStr)
+	if err != nil || target <= 0 || target > qsMaxBucketTarget {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid 'buckets', expected an integer between 1 and %d", qsMaxBucketTarget)})
+		return 0, false
+	}
+	return qsIntervalForBuckets(toTime.Sub(fromTime), target), true
+}
+
+// qsMaxFilledBuckets bounds the buckets of a gap-filled timeseries, so a long
+// range at a narrow interval cannot produce a response of millions of zeros.
+const qsMaxFilledBuckets = 10_000
+
+// parseQSNonzeroOnly reads the nonzero_only option: "" or "true" list only
+// buckets with activity, "false" asks for the gaps to be zero-filled. It
+// returns whether to fill them. On invalid input, or when filling the range
+// at interval would exceed qsMaxFilledBuckets, it writes a 400 response and
+// returns ok=false.
+func parseQSNonzeroOnly(c *gin.Context, value string, fromTime, toTime time.Time, interval time.Duration) (fillGaps bool, ok bool) {
+	switch value {
+	case "", "true":
+		return false, true
+	case "false":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'nonzero_only', expected true or false"})
+		return false, false
+	}
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	if buckets := toTime.Sub(fromTime.Truncate(interval))/interval + 1; buckets > qsMaxFilledBuckets {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("nonzero_only=false would return %d buckets, more than %d; use a wider interval or buckets=N", buckets, qsMaxFilledBuckets)})
+		return false, false
+	}
+	return true, true
+}
+
+// fillTimeseriesGaps returns the sorted timeseries with a zero bucket for
+// every interval of [from, to] without activity. Days served from rollups
+// keep their single daily bucket, zero when the day had none, instead of
+// being split into intervals.
+func fillTimeseriesGaps(timeseries []TimeseriesBucket, from, to time.Time, interval time.Duration, rollups []usage.Rollup) []TimeseriesBucket {
+	filled := make([]TimeseriesBucket, 0, len(timeseries))
+	next := 0
+	for bucket := from.Truncate(interval); !bucket.After(to); bucket = bucket.Add(interval) {
+		inRollup := slices.ContainsFunc(rollups, func(r usage.Rollup) bool {
+			return bucket.After(r.Start) && bucket.Before(r.Start.AddDate(0, 0, 1))
+		})
+		for next < len(timeseries) && timeseries[next].BucketStart.Before(bucket) {
+			filled = append(filled, timeseries[next])
+			next++
+		}
+		if next < len(timeseries) && timeseries[next].BucketStart.Equal(bucket) {
+			filled = append(filled, timeseries[next])
+			next++
+		} else if !inRollup {
+			filled = append(filled, TimeseriesBucket{BucketStart: bucket})
+		}
+	}
+	return append(filled, timeseries[next:]...)
+}
+
+// GetQSKeyTimeseries returns the usage timeseries for one API key hash, to spot
+// sudden changes in a tenant's usage pattern.
+// GET /v0/management/qs/metrics/by-key-timeseries?api_key_hash=...&interval=hour&from=...&to=...
+//
+// buckets=N may be given instead of interval to get roughly N buckets across the range.
+// include_delta=true adds each bucket's change from the previous one, and
+// nonzero_only=false zero-fills the buckets without activity.
+func (h *Handler) GetQSKeyTimeseries(c *gin.Context) {
+	keyHash := c.Query("api_key_hash")
+	if keyHash == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing 'api_key_hash'"})
+		return
+	}
+	intervalName := c.DefaultQuery("interval", "hour")
+	interval, ok := qsIntervals[intervalName]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'interval', expected minute, hour or day"})
+		return
+	}
+	fromTime, toTime, ok := h.parseQSTimeRange(c)
+	if !ok {
+		return
+	}
+	if _, set := c.GetQuery("buckets"); set {
+		if interval, ok = parseQSBuckets(c, fromTime, toTime); !ok {
+			return
+		}
+		intervalName = qsIntervalName(interval)
+	}
+	fillGaps, ok := parseQSNonzeroOnly(c, c.Query("nonzero_only"), fromTime, toTime, interval)
+	if !ok {
+		return
+	}
+
+	response := KeyTimeseriesResponse{
+		APIKeyHash: keyHash,
+		Interval:   intervalName,
+		Timeseries: []TimeseriesBucket{},
+	}
+	store := h.qsStore()
+	if store == nil {
+		writeQSJSON(c, http.StatusOK, response)
+		return
+	}
+
+	events, err := store.LoadRange(fromTime, toTime)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load usage events"})
+		return
+	}
+
+	metrics := aggregateMetrics(events, metricsQuery{
+		From:         fromTime,
+		To:           toTime,
+		APIKeyHash:   keyHash,
+		Interval:     interval,
+		SampleRate:   store.SampleRate(),
+		Workers:      h.qsAggregationWorkers(),
+		IncludeDelta: c.Query("include_delta") == "true",
+		FillGaps:     fillGaps,
+	})
+	response.Totals = metrics.Totals
+	response.Timeseries = metrics.Timeseries
+	response.Estimated = metrics.Estimated
+
+	writeQSJSON(c, http.StatusOK, response)
+}
@@ -0,0 +1,191 @@
+package management
+
+import (
+	"cmp"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+// LatencyResponse holds latency percentiles overall and per provider or model.
+type LatencyResponse struct {
+	GroupBy string    `json:"group_by"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	// Overall covers every event with a recorded latency in the range.
+	Overall LatencyStats `json:"overall"`
+	// Groups is sorted by p99 descending, slowest first.
+	Groups []LatencyStats `json:"groups"`
+}
+
+// LatencyStats are the latency percentiles of a set of requests. Percentiles
+// are estimates within qsLatencyRelativeError of the true value; MaxMs is exact.
+type LatencyStats struct {
+	// Key is the provider or model name; empty for Overall.
+	Key      string  `json:"key,omitempty"`
+	Requests int64   `json:"requests"`
+	P50Ms    float64 `json:"p50_ms"`
+	P90Ms    float64 `json:"p90_ms"`
+	P99Ms    float64 `json:"p99_ms"`
+	MaxMs    int64   `json:"max_ms"`
+}
+
+// qsLatencyRelativeError bounds the relative error of latency percentiles.
+const qsLatencyRelativeError = 0.01
+
+// latencySketch estimates quantiles of positive values in bounded memory by
+// counting them in logarithmic buckets, each spanning a factor of gamma.
+// Every value in a bucket is within the relative error of its midpoint, so
+// a sketch spanning 1ms to an hour needs under 800 buckets however many
+// values it sees.
+type latencySketch struct {
+	gamma   float64
+	buckets map[int]int64
+	count   int64
+	max     int64
+}
+
+func newLatencySketch() *latencySketch {
+	return &latencySketch{
+		gamma:   (1 + qsLatencyRelativeError) / (1 - qsLatencyRelativeError),
+		buckets: make(map[int]int64),
+	}
+}
+
+func (s *latencySketch) add(ms int64) {
+	if ms <= 0 {
+		return
+	}
+	s.buckets[int(math.Ceil(math.Log(float64(ms))/math.Log(s.gamma)))]++
+	s.count++
+	s.max = max(s.max, ms)
+}
+
+// quantile returns the estimated q-quantile, 0 <= q <= 1, or 0 when empty.
+func (s *latencySketch) quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	keys := make([]int, 0, len(s.buckets))
+	for key := range s.buckets {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	rank := int64(math.Ceil(q * float64(s.count)))
+	var seen int64
+	for _, key := range keys {
+		seen += s.buckets[key]
+		if seen >= rank {
+			// Midpoint of (gamma^(key-1), gamma^key], capped by the exact max
+			estimate := 2 * math.Pow(s.gamma, float64(key)) / (s.gamma + 1)
+			return math.Min(estimate, float64(s.max))
+		}
+	}
+	return float64(s.max)
+}
+
+func (s *latencySketch) stats(key string) LatencyStats {
+	round := func(v float64) float64 { return math.Round(v*10) / 10 }
+	return LatencyStats{
+		Key:      key,
+		Requests: s.count,
+		P50Ms:    round(s.quantile(0.5)),
+		P90Ms:    round(s.quantile(0.9)),
+		P99Ms:    round(s.quantile(0.99)),
+		MaxMs:    s.max,
+	}
+}
+
+// GetQSLatency returns p50/p90/p99/max request latency per provider or model.
+// GET /v0/management/qs/latency?from=...&to=...&group_by=provider|model&model=...&exclude_suspicious=true&tenant=...
+//
+// Only events that recorded a latency count. group_by defaults to provider;
+// events without a provider are grouped as "unknown".
+func (h *Handler) GetQSLatency(c *gin.Context) {
+	groupBy := c.DefaultQuery("group_by", "provider")
+	if groupBy != "provider" && groupBy != "model" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'group_by', expected provider or model"})
+		return
+	}
+	fromTime, toTime, ok := h.parseQSTimeRange(c)
+	if !ok {
+		return
+	}
+	query := metricsQuery{
+		From:              fromTime,
+		To:                toTime,
+		Model:             c.Query("model"),
+		ExcludeSuspicious: c.Query("exclude_suspicious") == "true",
+		RedactModel:       h.qsModelRedactor(c),
+		RawOnly:           true,
+		UnknownModel:      h.qsUnknownModelLabel(),
+	}
+
+	store, ok := h.qsStoreForRequest(c)
+	if !ok {
+		return
+	}
+	var events []usage.UsageEvent
+	if store != nil {
+		var err error
+		events, err = loadQSMetricsEvents(store, &query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load usage events"})
+			return
+		}
+	} else if live := usage.GetLiveStore(); live != nil && c.Query("tenant") == "" {
+		events = live.Since(query.From)
+	}
+
+	writeQSJSON(c, http.StatusOK, aggregateLatency(events, query, groupBy))
+}
+
+// aggregateLatency sketches the latency of the events matching the query,
+// overall and per provider or model.
+func aggregateLatency(events []usage.UsageEvent, query metricsQuery, groupBy string) LatencyResponse {
+	overall := newLatencySketch()
+	sketches := make(map[string]*latencySketch)
+	for _, event := range events {
+		if event.LatencyMs <= 0 || event.Timestamp.Before(query.From) || event.Timestamp.After(query.To) {
+			continue
+		}
+		model := query.modelName(event.Model)
+		if query.Model != "" && model != query.Model {
+			continue
+		}
+		if query.ExcludeSuspicious && event.Suspicious {
+			continue
+		}
+		key := model
+		if groupBy == "provider" {
+			key = cmp.Or(event.Provider, "unknown")
+		}
+		sketch, ok := sketches[key]
+		if !ok {
+			sketch = newLatencySketch()
+			sketches[key] = sketch
+		}
+		sketch.add(event.LatencyMs)
+		overall.add(event.LatencyMs)
+	}
+
+	response := LatencyResponse{
+		GroupBy: groupBy,
+		From:    query.From,
+		To:      query.To,
+		Overall: overall.stats(""),
+		Groups:  make([]LatencyStats, 0, len(sketches)),
+	}
+	for key, sketch := range sketches {
+		response.Groups = append(response.Groups, sketch.stats(key))
+	}
+	slices.SortFunc(response.Groups, func(a, b LatencyStats) int {
+		return cmp.Or(cmp.Compare(b.P99Ms, a.P99Ms), cmp.Compare(a.Key, b.Key))
+	})
+	return response
+}
@@ -0,0 +1,68 @@
+package management
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+func TestLatencySketch_QuantilesWithinRelativeError(t *testing.T) {
+	sketch := newLatencySketch()
+	for ms := int64(1); ms <= 10000; ms++ {
+		sketch.add(ms)
+	}
+	sketch.add(0) // no latency recorded
+
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		want := q * 10000
+		if got := sketch.quantile(q); math.Abs(got-want)/want > qsLatencyRelativeError {
+			t.Fatalf("p%v: want about %v, got %v", q*100, want, got)
+		}
+	}
+	if sketch.count != 10000 || sketch.max != 10000 {
+		t.Fatalf("want 10000 values up to 10000, got %d up to %d", sketch.count, sketch.max)
+	}
+	if len(sketch.buckets) > 500 {
+		t.Fatalf("want memory bounded by the latency spread, got %d buckets", len(sketch.buckets))
+	}
+}
+
+func TestAggregateLatency_GroupsByProviderOrModel(t *testing.T) {
+	now := time.Now()
+	var events []usage.UsageEvent
+	for i := int64(1); i <= 100; i++ {
+		events = append(events,
+			usage.UsageEvent{Timestamp: now, Provider: "fast", Model: "a", LatencyMs: i},
+			usage.UsageEvent{Timestamp: now, Provider: "slow", Model: "b", LatencyMs: 100 * i},
+		)
+	}
+	events = append(events,
+		usage.UsageEvent{Timestamp: now, Model: "a", LatencyMs: 50},
+		usage.UsageEvent{Timestamp: now, Provider: "fast", Model: "a"},
+		usage.UsageEvent{Timestamp: now.Add(-2 * time.Hour), Provider: "fast", Model: "a", LatencyMs: 99999},
+	)
+	query := metricsQuery{From: now.Add(-time.Hour), To: now}
+
+	response := aggregateLatency(events, query, "provider")
+	if len(response.Groups) != 3 || response.Overall.Requests != 201 {
+		t.Fatalf("unexpected response %+v", response)
+	}
+	slow := response.Groups[0]
+	if slow.Key != "slow" || slow.Requests != 100 || slow.MaxMs != 10000 {
+		t.Fatalf("want the slow provider first, got %+v", slow)
+	}
+	if math.Abs(slow.P50Ms-5000) > 5000*qsLatencyRelativeError {
+		t.Fatalf("want p50 about 5000ms, got %v", slow.P50Ms)
+	}
+	if response.Groups[2].Key != "unknown" {
+		t.Fatalf("want events without a provider grouped as unknown, got %+v", response.Groups)
+	}
+
+	query.Model = "a"
+	response = aggregateLatency(events, query, "model")
+	if len(response.Groups) != 1 || response.Groups[0].Key != "a" || response.Groups[0].Requests != 101 {
+		t.Fatalf("unexpected model groups %+v", response.Groups)
+	}
+}
@@ -3,31 +3,117 @@
 package management
 
 import (
+	"cmp"
+	"fmt"
+	"math"
 	"net/http"
+	"runtime"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
 	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
 )
 
-// GetQSHealth returns a simple health check for QuantumSpring metrics endpoints.
+// GetQSHealth returns a simple health check for QuantumSpring metrics endpoints,
+// including the store's all-time event count (since the last counter reset,
+// if any) and on-disk size when one is configured and the latest background
+// integrity check when self-checks are enabled. token_adjustments counts the
+// events recorded with clamped or dropped token counts.
 // GET /v0/management/qs/health
 func (h *Handler) GetQSHealth(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"ok": true})
+	response := gin.H{"ok": true, "token_adjustments": usage.CurrentTokenAdjustments()}
+	if store := h.qsStore(); store != nil {
+		totals := store.Totals()
+		response["total_requests"] = totals.Requests
+		response["total_tokens"] = totals.Tokens
+		if !totals.Since.IsZero() {
+			response["totals_since"] = totals.Since
+		}
+		if bytes, err := store.DiskUsage(); err == nil {
+			response["disk_bytes"] = bytes
+		}
+		if check, ok := store.SelfCheck(); ok {
+			response["self_check"] = check
+		}
+	} else if live := usage.GetLiveStore(); live != nil {
+		totals := live.Totals()
+		response["total_requests"] = totals.Requests
+		response["total_tokens"] = totals.Tokens
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // MetricsResponse represents the aggregated metrics response.
 type MetricsResponse struct {
-	Totals     MetricsTotals     `json:"totals"`
-	ByModel    []ModelMetrics    `json:"by_model"`
+	Totals     MetricsTotals      `json:"totals"`
+	ByModel    []ModelMetrics     `json:"by_model"`
 	Timeseries []TimeseriesBucket `json:"timeseries"`
+	// Estimated is set when the store is sampled and counts were scaled up by 1/SampleRate.
+	Estimated  bool    `json:"estimated,omitempty"`
+	SampleRate float64 `json:"sample_rate,omitempty"`
+	Note       string  `json:"note,omitempty"`
+	// Precision says, for sampled stores, which parts are exact and which are
+	// extrapolated from the sample.
+	Precision *MetricsPrecision `json:"precision,omitempty"`
+	// Groups is the pivot requested with group_by: one row per combination of
+	// the grouped dimensions, largest token count first.
+	Groups []GroupMetrics `json:"groups,omitempty"`
+	// BucketSeconds is the width of the timeseries buckets.
+	BucketSeconds int64 `json:"bucket_seconds"`
+	// RollupDays is the number of days served from daily rollups; their
+	// timeseries buckets span a whole day regardless of BucketSeconds.
+	RollupDays int `json:"rollup_days,omitempty"`
+	// InactiveModels is the number of models left out of ByModel by active_since.
+	InactiveModels int `json:"inactive_models,omitempty"`
+	// GroupsCapped is set when ByModel or Groups reached the distinct-group
+	// cap and later models or rows were counted under qsOtherGroup.
+	GroupsCapped bool `json:"groups_capped,omitempty"`
+	// OtherModels is the number of models top or min_share merged into the
+	// qsOtherGroup entry of ByModel.
+	OtherModels int `json:"other_models,omitempty"`
+	// Markers counts the marker events, such as health pings, that match the
+	// query's filters but not its kind, per kind; without a kind filter these
+	// are every marker, which no other field includes.
+	Markers map[string]int64 `json:"markers,omitempty"`
+	// CachedAt is set when the response was precomputed by the warmer
+	// (usage-store.warm) at that time; From and To are as of then.
+	CachedAt *time.Time `json:"cached_at,omitempty"`
+}
+
+// Precision values of MetricsPrecision.
+const (
+	qsPrecisionExact   = "exact"
+	qsPrecisionSampled = "sampled"
+)
+
+// MetricsPrecision marks each part of a sampled metrics response as "exact"
+// (counted for every event) or "sampled" (scaled up from persisted events).
+type MetricsPrecision struct {
+	Totals     string `json:"totals"`
+	ByModel    string `json:"by_model"`
+	Timeseries string `json:"timeseries"`
 }
 
 // MetricsTotals represents overall aggregated metrics.
 type MetricsTotals struct {
 	Tokens   int64 `json:"tokens"`
 	Requests int64 `json:"requests"`
+	// TokensPerSecond is the completion token throughput: completion tokens
+	// divided by latency, summed over requests that report both.
+	TokensPerSecond float64 `json:"tokens_per_second,omitempty"`
+	// CachedTokens are prompt tokens served from provider prompt caches, and
+	// CacheHitRatio their share of all prompt tokens.
+	CachedTokens  int64   `json:"cached_tokens,omitempty"`
+	CacheHitRatio float64 `json:"cache_hit_ratio,omitempty"`
+	// CacheSavingsUSD sums, per model, cached tokens times the difference
+	// between the full and the cached input price from usage-store.pricing.
+	CacheSavingsUSD float64 `json:"cache_savings_usd,omitempty"`
 }
 
 // ModelMetrics represents metrics aggregated by model.
@@ -35,44 +121,884 @@ type ModelMetrics struct {
 	Model    string `json:"model"`
 	Tokens   int64  `json:"tokens"`
 	Requests int64  `json:"requests"`
+	// TokensPerSecond is the model's completion token throughput, as in MetricsTotals.
+	TokensPerSecond float64 `json:"tokens_per_second,omitempty"`
+	// CostUSD is the model's estimated cost from usage-store.pricing, rounded
+	// to usage-store.cost-decimals; omitted when unpriced.
+	CostUSD float64 `json:"cost_usd,omitempty"`
+	// CostMicroUSD is the unrounded cost in whole micro-dollars.
+	CostMicroUSD int64 `json:"cost_micro_usd,omitempty"`
+	// Sparkline holds the model's last qsSparklineBuckets hourly buckets, oldest first.
+	// It is only populated when the request sets sparklines=true.
+	Sparkline []SparklinePoint `json:"sparkline,omitempty"`
+}
+
+// GroupMetrics is one row of a group_by pivot. Only the grouped dimensions
+// are set; events without a provider are grouped as "unknown" and events
+// without a grouped label as qsNoLabel.
+type GroupMetrics struct {
+	Model string `json:"model,omitempty"`
+	// Family is the model's family under usage-store.model-families.
+	Family     string `json:"family,omitempty"`
+	Provider   string `json:"provider,omitempty"`
+	Status     *int   `json:"status,omitempty"`
+	APIKeyHash string `json:"api_key_hash,omitempty"`
+	// Labels holds the value of each label:<name> dimension, keyed by name.
+	Labels   map[string]string `json:"labels,omitempty"`
+	Tokens   int64             `json:"tokens"`
+	Requests int64             `json:"requests"`
 }
 
+// qsGroupDimensions are the valid group_by dimension names, besides
+// label:<name> for an event label.
+var qsGroupDimensions = []string{"model", "family", "provider", "status", "api_key_hash"}
+
+// qsLabelDimension prefixes group_by dimensions naming an event label.
+const qsLabelDimension = "label:"
+
+// qsNoLabel is the group of events that do not carry a grouped label.
+const qsNoLabel = "(none)"
+
+// validQSLabelKey reports whether a label key is 1 to 64 ASCII letters,
+// digits, '_', '-' or '.'.
+func validQSLabelKey(key string) bool {
+	if key == "" || len(key) > 64 {
+		return false
+	}
+	for _, r := range key {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_' || r == '-' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseQSGroupBy parses a comma-separated group_by list, rejecting unknown
+// dimensions and dropping duplicates. An empty value disables grouping.
+func parseQSGroupBy(raw string) ([]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	return validateQSGroupBy(strings.Split(raw, ","))
+}
+
+// validateQSGroupBy checks and de-duplicates group_by dimension names.
+func validateQSGroupBy(dims []string) ([]string, error) {
+	var out []string
+	for _, dim := range dims {
+		dim = strings.TrimSpace(dim)
+		if label, ok := strings.CutPrefix(dim, qsLabelDimension); ok {
+			if !validQSLabelKey(label) {
+				return nil, fmt.Errorf("invalid 'group_by' label key %q, expected 1 to 64 letters, digits, '_', '-' or '.'", label)
+			}
+		} else if !slices.Contains(qsGroupDimensions, dim) {
+			return nil, fmt.Errorf("invalid 'group_by' dimension %q, expected %s or label:<name>", dim, strings.Join(qsGroupDimensions, ", "))
+		}
+		if !slices.Contains(out, dim) {
+			out = append(out, dim)
+		}
+	}
+	return out, nil
+}
+
+// qsGroupKey identifies one group_by row; ungrouped dimensions stay zero.
+type qsGroupKey struct {
+	model      string
+	family     string
+	provider   string
+	status     int
+	apiKeyHash string
+	// labels holds the values of the label dimensions in group_by order,
+	// each prefixed with qsLabelSeparator, keeping the key comparable.
+	labels string
+}
+
+// qsLabelSeparator separates label values in qsGroupKey.labels.
+const qsLabelSeparator = "\x00"
+
+// newQSGroupKey builds the group key of an event under the given dimensions;
+// family maps the model to its family, or is nil to use the model itself.
+func newQSGroupKey(dims []string, model string, family func(string) string, event usage.UsageEvent) qsGroupKey {
+	var key qsGroupKey
+	for _, dim := range dims {
+		switch dim {
+		case "model":
+			key.model = model
+		case "family":
+			key.family = model
+			if family != nil {
+				key.family = family(model)
+			}
+		case "provider":
+			key.provider = event.Provider
+			if key.provider == "" {
+				key.provider = "unknown"
+			}
+		case "status":
+			key.status = event.Status
+		case "api_key_hash":
+			key.apiKeyHash = event.APIKeyHash
+		default:
+			label, _ := strings.CutPrefix(dim, qsLabelDimension)
+			value, ok := event.Labels[label]
+			if !ok {
+				value = qsNoLabel
+			}
+			key.labels += qsLabelSeparator + value
+		}
+	}
+	return key
+}
+
+// SparklinePoint is one hourly bucket of a per-model sparkline.
+type SparklinePoint struct {
+	BucketStart  time.Time `json:"bucket_start"`
+	Requests     int64     `json:"requests"`
+	Tokens       int64     `json:"tokens"`
+	AvgLatencyMs int64     `json:"avg_latency_ms"`
+}
+
+// qsSparklineBuckets is the number of hourly buckets in each per-model sparkline.
+const qsSparklineBuckets = 24
+
 // TimeseriesBucket represents metrics for a specific time bucket.
 type TimeseriesBucket struct {
 	BucketStart time.Time `json:"bucket_start"`
 	Tokens      int64     `json:"tokens"`
 	Requests    int64     `json:"requests"`
+	// TokensDelta and RequestsDelta are the change from the previous bucket
+	// in the series (zero for the first), set only with include_delta=true.
+	TokensDelta   *int64 `json:"tokens_delta,omitempty"`
+	RequestsDelta *int64 `json:"requests_delta,omitempty"`
+	// CostPerRequest is the bucket's estimated cost divided by its requests,
+	// set only with cost_per_request=true and omitted for buckets without
+	// requests. Unpriced models add requests but no cost.
+	CostPerRequest *float64 `json:"cost_per_request,omitempty"`
+}
+
+// metricsQuery holds the parameters of a metrics aggregation.
+type metricsQuery struct {
+	From  time.Time
+	To    time.Time
+	Model string
+	// SampleRate below 1 scales every count by 1/SampleRate and marks the response as estimated.
+	SampleRate float64
+	// Sparklines adds a short hourly series to each by_model entry.
+	Sparklines bool
+	// APIKeyHash restricts aggregation to a single hashed API key when set.
+	APIKeyHash string
+	// Interval is the timeseries bucket width; zero means one hour.
+	Interval time.Duration
+	// ExcludeSuspicious skips events flagged as exceeding the token sanity cap.
+	ExcludeSuspicious bool
+	// RequestIDPrefix restricts aggregation to events whose RequestID starts with it.
+	RequestIDPrefix string
+	// Models, Providers and Statuses, when non-empty, restrict aggregation to
+	// events matching one of their values; Models compares reported names.
+	Models    []string
+	Providers []string
+	Statuses  []int
+	// Workers is the maximum number of goroutines aggregating events; 0 or 1
+	// aggregates sequentially.
+	Workers int
+	// Rollups are daily summaries covering whole days of the range whose raw
+	// events were not loaded; each contributes one daily timeseries bucket.
+	Rollups []usage.Rollup
+	// RedactModel, when set, maps each model name to the name reported (and
+	// matched against Model), hiding names the caller may not see.
+	RedactModel func(string) string
+	// RawOnly loads every event instead of using daily rollups, for breakdowns
+	// that need each event's own timestamp.
+	RawOnly bool
+	// Pricing maps model names to their token prices, for costs and cache savings.
+	Pricing map[string]config.UsageModelPrice
+	// Sort orders by_model by "tokens" (the default), "cost" or "requests",
+	// largest first unless Ascending is set.
+	Sort      string
+	Ascending bool
+	// GroupBy lists the dimensions of the Groups pivot; empty disables it.
+	GroupBy []string
+	// Family maps reported model names to their family for group_by=family.
+	Family func(string) string
+	// UnknownModel names events without a model; empty uses qsDefaultUnknownModel.
+	UnknownModel string
+	// ActiveSince, when set, limits ByModel to models with an event at or
+	// after it; totals and timeseries still count every model.
+	ActiveSince time.Time
+	// IncludeDelta adds the change from the previous bucket to each timeseries bucket.
+	IncludeDelta bool
+	// TotalsOnly computes only the totals, skipping every breakdown.
+	TotalsOnly bool
+	// FillGaps adds a zero bucket for every interval of the range without
+	// activity, so the timeseries is continuous.
+	FillGaps bool
+	// MaxGroups caps the distinct models and group_by rows tracked; 0 uses
+	// qsDefaultMaxGroups.
+	MaxGroups int
+	// Top keeps the Top models with the most tokens in ByModel and MinShare
+	// those with at least that fraction of all tokens; the rest are merged
+	// into qsOtherGroup. Zero disables each.
+	Top      int
+	MinShare float64
+	// Kind selects the events aggregated by kind: "" or "request" for proxied
+	// requests, "all" for every event, or one marker kind such as "ping".
+	Kind string
+	// BucketBy places events in timeseries and sparkline buckets by their
+	// start time ("" or "started") or their completion time ("completed").
+	// The range itself always filters on the start time.
+	BucketBy string
+	// Fields selects the top-level response sections returned; nil returns
+	// all of them.
+	Fields map[string]bool
+	// CostPerRequest adds each timeseries bucket's average cost per request.
+	CostPerRequest bool
+}
+
+// groupsOnlyByModel reports whether the pivot, if any, can be built from
+// per-model rollups: it groups by nothing but the model and its family.
+func (q metricsQuery) groupsOnlyByModel() bool {
+	for _, dim := range q.GroupBy {
+		if dim != "model" && dim != "family" {
+			return false
+		}
+	}
+	return true
+}
+
+// bucketTime returns the time an event is bucketed by under BucketBy.
+func (q metricsQuery) bucketTime(event usage.UsageEvent) time.Time {
+	if q.BucketBy == qsBucketByCompleted {
+		return event.CompletionTime()
+	}
+	return event.Timestamp
+}
+
+// qsBucketByCompleted is the 'bucket_by' value bucketing events by completion time.
+const qsBucketByCompleted = "completed"
+
+// qsKindAll is the 'kind' value aggregating requests and markers alike.
+const qsKindAll = "all"
+
+// matchesKind reports whether an event passes the kind filter.
+func (q metricsQuery) matchesKind(event usage.UsageEvent) bool {
+	switch q.Kind {
+	case "", usage.EventKindRequest:
+		return !event.IsMarker()
+	case qsKindAll:
+		return true
+	}
+	return event.Kind == q.Kind
+}
+
+// matchesModel reports whether a reported model name passes the model filters.
+func (q metricsQuery) matchesModel(model string) bool {
+	return (q.Model == "" || model == q.Model) && (len(q.Models) == 0 || slices.Contains(q.Models, model))
+}
+
+// qsDefaultUnknownModel is the model name reported for events without one.
+const qsDefaultUnknownModel = "(unknown)"
+
+// modelName returns the model name reported for an event's model. Events
+// without a model are reported as the unknown-model label, which reveals
+// nothing and so is never redacted.
+func (q metricsQuery) modelName(model string) string {
+	if model == "" {
+		return cmp.Or(q.UnknownModel, qsDefaultUnknownModel)
+	}
+	if q.RedactModel == nil {
+		return model
+	}
+	return q.RedactModel(model)
 }
 
 // GetQSMetrics returns aggregated usage metrics with optional filtering.
-// GET /v0/management/qs/metrics?from=2025-11-25T00:00:00Z&to=2025-11-26T00:00:00Z&model=gpt-4
+// GET /v0/management/qs/metrics?from=2025-11-25T00:00:00Z&to=2025-11-26T00:00:00Z&model=gpt-4&sparklines=true&exclude_suspicious=true
+//
+// window=24h (days as "7d" or a Go duration) asks for a range ending now
+// instead of from and to. A request with nothing but a window, or no range,
+// is served from memory when usage-store.warm precomputes that window.
+// With tenant=<key> the metrics come from that tenant's own store instead of the shared one.
+// request_id_prefix=<p> only counts events whose request ID starts with p,
+// e.g. the requests of one batch job. group_by=model,provider,status adds a
+// flat pivot of the listed dimensions as 'groups'. sort=cost|tokens|requests
+// and order=asc|desc order by_model, largest token count first by default.
+// active_since=<time> drops models without events since then from by_model.
+// include_delta=true adds each timeseries bucket's change from the previous one.
+// cost_per_request=true adds each bucket's estimated cost per request.
+// view=totals computes only totals, leaving by_model and timeseries empty.
+// fields=totals,timeseries returns only the listed sections of totals,
+// by_model, timeseries, groups and markers, and skips computing the others.
+// buckets=N sizes timeseries buckets (1m, 5m, 15m, 1h, 6h or 1d) so the range
+// yields roughly N of them instead of hourly ones. Only buckets with activity
+// are listed unless nonzero_only=false, which zero-fills the gaps.
+// top=N and min_share=<fraction> merge the models outside the N largest by
+// tokens, or below that share of all tokens, into one "(other)" entry of
+// by_model; with both, a model must pass both to keep its own entry.
+// kind=request (the default) aggregates proxied requests and counts marker
+// events such as health pings under markers; kind=ping aggregates only those
+// markers and kind=all every event.
+// bucket_by=completed places events in timeseries buckets by when their
+// response finished instead of when they were received.
+//
+// The response is gzip-compressed when the client sends Accept-Encoding: gzip,
+// and indented when pretty=true is set. Callers using a shared dashboard key
+// see models outside the redaction allow-list as "(internal)".
 func (h *Handler) GetQSMetrics(c *gin.Context) {
-	// Parse query parameters
-	fromStr := c.Query("from")
-	toStr := c.Query("to")
-	modelFilter := c.Query("model")
+	if response, ok := h.qsWarmMetrics(c); ok {
+		h.writeQSMetricsJSON(c, metricsQuery{}, response)
+		return
+	}
+	fromTime, toTime, ok := h.parseQSWindowRange(c)
+	if !ok {
+		return
+	}
+	interval, ok := parseQSBuckets(c, fromTime, toTime)
+	if !ok {
+		return
+	}
+	groupBy, err := parseQSGroupBy(c.Query("group_by"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sortBy, ascending, err := parseQSModelSort(c.Query("sort"), c.Query("order"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	activeSince, ok := parseQSActiveSince(c, c.Query("active_since"))
+	if !ok {
+		return
+	}
+	totalsOnly, err := parseQSView(c.Query("view"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fields, err := parseQSFields(c.Query("fields"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fillGaps, ok := parseQSNonzeroOnly(c, c.Query("nonzero_only"), fromTime, toTime, interval)
+	if !ok {
+		return
+	}
+	top, minShare, err := parseQSCollapse(c.Query("top"), c.Query("min_share"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	kind, err := parseQSKind(c.Query("kind"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	bucketBy, err := parseQSBucketBy(c.Query("bucket_by"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query := metricsQuery{
+		From:              fromTime,
+		To:                toTime,
+		Model:             c.Query("model"),
+		Sparklines:        c.Query("sparklines") == "true",
+		ExcludeSuspicious: c.Query("exclude_suspicious") == "true",
+		RequestIDPrefix:   c.Query("request_id_prefix"),
+		Interval:          interval,
+		Workers:           h.qsAggregationWorkers(),
+		RedactModel:       h.qsModelRedactor(c),
+		Pricing:           h.qsPricing(),
+		GroupBy:           groupBy,
+		UnknownModel:      h.qsUnknownModelLabel(),
+		Sort:              sortBy,
+		Ascending:         ascending,
+		ActiveSince:       activeSince,
+		IncludeDelta:      c.Query("include_delta") == "true",
+		CostPerRequest:    c.Query("cost_per_request") == "true",
+		TotalsOnly:        totalsOnly,
+		FillGaps:          fillGaps,
+		Top:               top,
+		MinShare:          minShare,
+		Kind:              kind,
+		BucketBy:          bucketBy,
+		Fields:            fields,
+	}
+	h.serveQSMetrics(c, query)
+}
+
+// MetricsQueryRequest is the JSON body of POST /qs/metrics. Every list
+// filter matches events with any of its values; empty lists do not filter.
+type MetricsQueryRequest struct {
+	// From and To accept the same formats as the GET parameters and default to the last 24 hours.
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	Models    []string `json:"models"`
+	Providers []string `json:"providers"`
+	Statuses  []int    `json:"statuses"`
+	// Interval is minute, hour or day; Buckets instead asks for roughly that many buckets.
+	Interval          string `json:"interval"`
+	Buckets           int    `json:"buckets"`
+	RequestIDPrefix   string `json:"request_id_prefix"`
+	Sparklines        bool   `json:"sparklines"`
+	ExcludeSuspicious bool   `json:"exclude_suspicious"`
+	// GroupBy requests a pivot over model, family, provider, status, api_key_hash and/or label:<name>.
+	GroupBy []string `json:"group_by"`
+	// Sort and Order take the same values as the GET parameters.
+	Sort  string `json:"sort"`
+	Order string `json:"order"`
+	// ActiveSince accepts the same formats as From.
+	ActiveSince    string `json:"active_since"`
+	IncludeDelta   bool   `json:"include_delta"`
+	CostPerRequest bool   `json:"cost_per_request"`
+	// View is "full" (the default) or "totals".
+	View string `json:"view"`
+	// NonzeroOnly set to false zero-fills timeseries buckets without activity.
+	NonzeroOnly *bool `json:"nonzero_only"`
+	// Top and MinShare take the same values as the GET parameters.
+	Top      int     `json:"top"`
+	MinShare float64 `json:"min_share"`
+	// Kind and BucketBy take the same values as the GET parameters.
+	Kind     string `json:"kind"`
+	BucketBy string `json:"bucket_by"`
+	// Fields lists the response sections to return, as the GET parameter.
+	Fields []string `json:"fields"`
+}
+
+// PostQSMetrics runs the /qs/metrics aggregation with filters from a JSON
+// body, for filter sets too large for a query string.
+// POST /v0/management/qs/metrics?tenant=...
+//
+// The response is the same as for GET; tenant and pretty stay query parameters.
+func (h *Handler) PostQSMetrics(c *gin.Context) {
+	var body MetricsQueryRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	query, ok := h.parseQSMetricsRequest(c, body)
+	if !ok {
+		return
+	}
+	h.serveQSMetrics(c, query)
+}
+
+// parseQSMetricsRequest validates a MetricsQueryRequest body into a metrics
+// query. On invalid input it writes a 400 response and returns ok=false.
+func (h *Handler) parseQSMetricsRequest(c *gin.Context, body MetricsQueryRequest) (metricsQuery, bool) {
+	fromTime, toTime, ok := h.resolveQSTimeRange(c, "from", body.From, "to", body.To)
+	if !ok {
+		return metricsQuery{}, false
+	}
+	groupBy, err := validateQSGroupBy(body.GroupBy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return metricsQuery{}, false
+	}
+	sortBy, ascending, err := parseQSModelSort(body.Sort, body.Order)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return metricsQuery{}, false
+	}
+	activeSince, ok := parseQSActiveSince(c, body.ActiveSince)
+	if !ok {
+		return metricsQuery{}, false
+	}
+	totalsOnly, err := parseQSView(body.View)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return metricsQuery{}, false
+	}
+	fields, err := validateQSFields(body.Fields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return metricsQuery{}, false
+	}
+	var interval time.Duration
+	switch {
+	case body.Buckets < 0 || body.Buckets > qsMaxBucketTarget:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid 'buckets', expected an integer between 1 and %d", qsMaxBucketTarget)})
+		return metricsQuery{}, false
+	case body.Buckets > 0:
+		interval = qsIntervalForBuckets(toTime.Sub(fromTime), body.Buckets)
+	case body.Interval != "":
+		if interval, ok = qsIntervals[body.Interval]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'interval', expected minute, hour or day"})
+			return metricsQuery{}, false
+		}
+	}
+	var nonzeroOnly string
+	if body.NonzeroOnly != nil {
+		nonzeroOnly = strconv.FormatBool(*body.NonzeroOnly)
+	}
+	fillGaps, ok := parseQSNonzeroOnly(c, nonzeroOnly, fromTime, toTime, interval)
+	if !ok {
+		return metricsQuery{}, false
+	}
+	if err := validateQSCollapse(body.Top, body.MinShare); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return metricsQuery{}, false
+	}
+	kind, err := parseQSKind(body.Kind)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return metricsQuery{}, false
+	}
+	bucketBy, err := parseQSBucketBy(body.BucketBy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return metricsQuery{}, false
+	}
+
+	return metricsQuery{
+		From:              fromTime,
+		To:                toTime,
+		Models:            body.Models,
+		Providers:         body.Providers,
+		Statuses:          body.Statuses,
+		RequestIDPrefix:   body.RequestIDPrefix,
+		Sparklines:        body.Sparklines,
+		ExcludeSuspicious: body.ExcludeSuspicious,
+		Interval:          interval,
+		Workers:           h.qsAggregationWorkers(),
+		RedactModel:       h.qsModelRedactor(c),
+		Pricing:           h.qsPricing(),
+		GroupBy:           groupBy,
+		UnknownModel:      h.qsUnknownModelLabel(),
+		Sort:              sortBy,
+		Ascending:         ascending,
+		ActiveSince:       activeSince,
+		IncludeDelta:      body.IncludeDelta,
+		CostPerRequest:    body.CostPerRequest,
+		TotalsOnly:        totalsOnly,
+		FillGaps:          fillGaps,
+		Top:               body.Top,
+		MinShare:          body.MinShare,
+		Kind:              kind,
+		BucketBy:          bucketBy,
+		Fields:            fields,
+	}, true
+}
+
+// serveQSMetrics aggregates a metrics query from the request's store, or the
+// live store without persistence, and writes the response.
+func (h *Handler) serveQSMetrics(c *gin.Context, query metricsQuery) {
+	query = h.completeQSMetricsQuery(query)
+	// Load events from JSON store
+	store, ok := h.qsStoreForRequest(c)
+	if !ok {
+		return
+	}
+	if store == nil {
+		// Without persistence, serve what the in-memory live store still retains
+		if live := usage.GetLiveStore(); live != nil && c.Query("tenant") == "" {
+			response := aggregateMetrics(live.Since(query.From), query)
+			if coveredSince := live.CoveredSince(); query.From.Before(coveredSince) {
+				response.Note = fmt.Sprintf("usage is kept in memory only; events before %s are not available", coveredSince.UTC().Format(time.RFC3339))
+			}
+			h.writeQSMetricsJSON(c, query, response)
+			return
+		}
+		// No store configured, return empty metrics
+		c.JSON(http.StatusOK, MetricsResponse{
+			Totals:     MetricsTotals{},
+			ByModel:    []ModelMetrics{},
+			Timeseries: []TimeseriesBucket{},
+		})
+		return
+	}
+
+	response, err := aggregateQSStoreMetrics(store, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load usage events"})
+		return
+	}
+
+	h.writeQSMetricsJSON(c, query, response)
+}
+
+// completeQSMetricsQuery drops the breakdowns of a totals-only query and
+// fills in the configured model families and group cap.
+func (h *Handler) completeQSMetricsQuery(query metricsQuery) metricsQuery {
+	query = applyQSFields(query)
+	if query.TotalsOnly {
+		// Breakdown options would only keep the rollups from being used
+		query.GroupBy, query.Sparklines, query.IncludeDelta, query.FillGaps, query.CostPerRequest = nil, false, false, false, false
+	}
+	if slices.Contains(query.GroupBy, "family") {
+		query.Family = h.qsModelFamilies()
+	}
+	query.MaxGroups = h.qsMaxGroups()
+	return query
+}
+
+// aggregateQSStoreMetrics loads the events a query needs from the store and aggregates them.
+func aggregateQSStoreMetrics(store *usage.JSONStore, query metricsQuery) (MetricsResponse, error) {
+	events, err := loadQSMetricsEvents(store, &query)
+	if err != nil {
+		return MetricsResponse{}, err
+	}
+
+	// Filter and aggregate events
+	query.SampleRate = store.SampleRate()
+	response := aggregateMetrics(events, query)
+	applyQSExactTotals(store, query, &response)
+	return response, nil
+}
+
+// applyQSExactTotals replaces the scaled-up totals of a sampled store with
+// its exact counters when they cover the query. Filtered queries keep the
+// estimate, since the counters are not broken down by model or key.
+func applyQSExactTotals(store *usage.JSONStore, query metricsQuery, response *MetricsResponse) {
+	if !response.Estimated || query.Kind != "" || query.Model != "" || len(query.Models) > 0 || len(query.Providers) > 0 || len(query.Statuses) > 0 ||
+		query.APIKeyHash != "" || query.ExcludeSuspicious || query.RequestIDPrefix != "" {
+		return
+	}
+	counts, ok := store.ExactCountsBetween(query.From, query.To)
+	if !ok {
+		return
+	}
+	response.Totals.Requests = counts.Requests
+	response.Totals.Tokens = counts.Tokens
+	response.Precision.Totals = qsPrecisionExact
+	response.Note = fmt.Sprintf("totals are exact; by_model and timeseries are estimated from a %.4g%% sample of events", query.SampleRate*100)
+}
+
+// qsRollupMinRange is the shortest query range that considers daily rollups.
+const qsRollupMinRange = 48 * time.Hour
+
+// loadQSMetricsEvents loads the raw events needed for a metrics query.
+//
+// Recent windows come from the store's in-memory cache, which also holds
+// events not yet flushed. Otherwise events are read from disk and the store's
+// unflushed buffer is merged in so the latest requests show up immediately.
+func loadQSMetricsEvents(store *usage.JSONStore, query *metricsQuery) ([]usage.UsageEvent, error) {
+	if events, ok := store.RecentSince(query.From); ok {
+		return events, nil
+	}
+
+	// Snapshot the buffer before reading the file: a flush racing in between
+	// then duplicates events, which the merge removes, instead of losing them
+	buffered := store.BufferedEvents()
+	events, err := loadQSMetricsDiskEvents(store, query)
+	if err != nil {
+		return nil, err
+	}
+	return mergeQSBufferedEvents(events, buffered, *query), nil
+}
+
+// qsEventKey identifies an event well enough to recognise a buffered event
+// that has since been flushed to disk.
+type qsEventKey struct {
+	timestamp  int64
+	model      string
+	requestID  string
+	apiKeyHash string
+	tokens     int64
+}
+
+func newQSEventKey(event usage.UsageEvent) qsEventKey {
+	return qsEventKey{
+		timestamp:  event.Timestamp.UnixNano(),
+		model:      event.Model,
+		requestID:  event.RequestID,
+		apiKeyHash: event.APIKeyHash,
+		tokens:     event.TotalTokens,
+	}
+}
+
+// mergeQSBufferedEvents appends buffered events inside the query range that
+// are not already among the events read from disk or covered by its rollups.
+func mergeQSBufferedEvents(events, buffered []usage.UsageEvent, query metricsQuery) []usage.UsageEvent {
+	if len(buffered) == 0 {
+		return events
+	}
+
+	pending := make(map[qsEventKey]int, len(buffered))
+	for _, event := range buffered {
+		pending[newQSEventKey(event)]++
+	}
+	for _, event := range events {
+		key := newQSEventKey(event)
+		if pending[key] > 0 {
+			pending[key]--
+		}
+	}
 
+	var rolledFrom, rolledUntil time.Time
+	if len(query.Rollups) > 0 {
+		rolledFrom = query.Rollups[0].Start
+		rolledUntil = query.Rollups[len(query.Rollups)-1].Start.Add(24 * time.Hour)
+	}
+	for _, event := range buffered {
+		key := newQSEventKey(event)
+		if pending[key] == 0 {
+			continue
+		}
+		pending[key]--
+		if event.Timestamp.Before(query.From) || event.Timestamp.After(query.To) {
+			continue
+		}
+		if !event.Timestamp.Before(rolledFrom) && event.Timestamp.Before(rolledUntil) {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// loadQSMetricsDiskEvents reads the events for a metrics query from the store file.
+//
+// Long ranges use the daily rollups for whole days they cover (setting
+// query.Rollups) and only load raw events for the partial day at the start and
+// for the tail after the rollups, which is read from the rollups' recorded file
+// offset unless part of it was rotated into a segment. Everything else falls
+// back to a range scan, which covers rotated segments too.
+func loadQSMetricsDiskEvents(store *usage.JSONStore, query *metricsQuery) ([]usage.UsageEvent, error) {
+	// Rollups only carry per-model daily totals, so other filters and sparklines need raw events
+	useRollups := !query.RawOnly && query.Kind == "" && query.BucketBy == "" && query.APIKeyHash == "" && query.RequestIDPrefix == "" && len(query.Providers) == 0 && len(query.Statuses) == 0 &&
+		query.groupsOnlyByModel() && !query.ExcludeSuspicious && !query.Sparklines && query.To.Sub(query.From) >= qsRollupMinRange
+	if !useRollups {
+		return store.LoadRange(query.From, query.To)
+	}
+	set, ok := store.Rollups()
+	if !ok {
+		return store.LoadRange(query.From, query.To)
+	}
+
+	const day = 24 * time.Hour
+	rolledFrom := query.From.UTC().Truncate(day)
+	if rolledFrom.Before(query.From) {
+		rolledFrom = rolledFrom.Add(day)
+	}
+	rolledUntil := query.To.UTC().Truncate(day)
+	if set.CoveredUntil.Before(rolledUntil) {
+		rolledUntil = set.CoveredUntil
+	}
+	if rolledUntil.Sub(rolledFrom) < day {
+		return store.LoadRange(query.From, query.To)
+	}
+
+	var events []usage.UsageEvent
+	if query.From.Before(rolledFrom) {
+		head, err := store.LoadRange(query.From, rolledFrom)
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range head {
+			if event.Timestamp.Before(rolledFrom) {
+				events = append(events, event)
+			}
+		}
+	}
+
+	var tail []usage.UsageEvent
+	if rolledUntil.Equal(set.CoveredUntil) && set.TailOffset >= 0 {
+		page, err := store.ReadFromGeneration(set.Generation, set.TailOffset, 0)
+		if err != nil {
+			return nil, err
+		}
+		if !page.Reset {
+			tail = page.Events
+		}
+	}
+	if tail == nil {
+		var err error
+		tail, err = store.LoadRange(rolledUntil, query.To)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, event := range tail {
+		if !event.Timestamp.Before(rolledUntil) && !event.Timestamp.After(query.To) {
+			events = append(events, event)
+		}
+	}
+
+	for _, rollup := range set.Daily {
+		if !rollup.Start.Before(rolledFrom) && rollup.Start.Before(rolledUntil) {
+			query.Rollups = append(query.Rollups, rollup)
+		}
+	}
+	return events, nil
+}
+
+// qsDefaultMetricsQuery returns the query of a /qs/metrics request without
+// parameters other than its range.
+func (h *Handler) qsDefaultMetricsQuery(from, to time.Time) metricsQuery {
+	return metricsQuery{
+		From:         from,
+		To:           to,
+		Workers:      h.qsAggregationWorkers(),
+		Pricing:      h.qsPricing(),
+		UnknownModel: h.qsUnknownModelLabel(),
+		Sort:         "tokens",
+		MaxGroups:    h.qsMaxGroups(),
+	}
+}
+
+// parseQSWindowRange is parseQSTimeRange that also accepts 'window', a range
+// ending now given in days ("7d") or as a Go duration ("1h"), instead of
+// 'from' and 'to'. On invalid input it writes a 400 response.
+func (h *Handler) parseQSWindowRange(c *gin.Context) (fromTime, toTime time.Time, ok bool) {
+	value := c.Query("window")
+	if value == "" {
+		return h.parseQSTimeRange(c)
+	}
+	if c.Query("from") != "" || c.Query("to") != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "'window' cannot be combined with 'from' or 'to'"})
+		return fromTime, toTime, false
+	}
+	window, valid := parseQSWindow(value)
+	if !valid || window <= 0 || window > time.Duration(h.qsMaxLookbackDays())*24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'window', expected a positive duration within the maximum lookback (e.g. 24h or 7d)"})
+		return fromTime, toTime, false
+	}
+	toTime = time.Now()
+	return toTime.Add(-window), toTime, true
+}
+
+// qsDefaultWindow is the range of queries without 'from': the last 24 hours.
+const qsDefaultWindow = 24 * time.Hour
+
+// parseQSTimeRange reads the 'from' and 'to' query parameters, defaulting to the last 24 hours.
+// Ranges reaching further back than the configured maximum lookback, or ending
+// in the far future, are rejected to keep scans bounded.
+// On invalid input it writes a 400 response and returns ok=false.
+func (h *Handler) parseQSTimeRange(c *gin.Context) (fromTime, toTime time.Time, ok bool) {
+	return h.parseQSNamedTimeRange(c, "from", "to")
+}
+
+// parseQSNamedTimeRange is parseQSTimeRange for the given parameter names.
+func (h *Handler) parseQSNamedTimeRange(c *gin.Context, fromParam, toParam string) (fromTime, toTime time.Time, ok bool) {
+	return h.resolveQSTimeRange(c, fromParam, c.Query(fromParam), toParam, c.Query(toParam))
+}
+
+// resolveQSTimeRange parses and validates a time range given as strings,
+// naming fromParam and toParam in error messages. Empty values default to
+// the last 24 hours.
+func (h *Handler) resolveQSTimeRange(c *gin.Context, fromParam, fromStr, toParam, toStr string) (fromTime, toTime time.Time, ok bool) {
 	// Default time range: last 24 hours
 	now := time.Now()
-	var fromTime, toTime time.Time
-	
+
 	if fromStr != "" {
 		var err error
-		fromTime, err = time.Parse(time.RFC3339, fromStr)
+		fromTime, err = parseQSTimestamp(fromStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'from' timestamp format, expected RFC3339"})
-			return
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid '%s' timestamp format, expected RFC3339 or Unix epoch seconds/milliseconds", fromParam)})
+			return fromTime, toTime, false
 		}
 	} else {
-		fromTime = now.Add(-24 * time.Hour)
+		fromTime = now.Add(-qsDefaultWindow)
 	}
 
 	if toStr != "" {
 		var err error
-		toTime, err = time.Parse(time.RFC3339, toStr)
+		toTime, err = parseQSTimestamp(toStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'to' timestamp format, expected RFC3339"})
-			return
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid '%s' timestamp format, expected RFC3339 or Unix epoch seconds/milliseconds", toParam)})
+			return fromTime, toTime, false
 		}
 	} else {
 		toTime = now
@@ -80,36 +1006,242 @@ func (h *Handler) GetQSMetrics(c *gin.Context) {
 
 	// Validate time range
 	if toTime.Before(fromTime) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "'to' must be after 'from'"})
-		return
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("'%s' must be after '%s'", toParam, fromParam)})
+		return fromTime, toTime, false
 	}
 
-	// Load events from JSON store
-	store := h.jsonStore
-	if store == nil {
-		store = usage.GetJSONStore()
+	if toTime.After(now.Add(qsMaxFutureSkew)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("'%s' must not be more than %s in the future", toParam, qsMaxFutureSkew)})
+		return fromTime, toTime, false
+	}
+
+	lookbackDays := h.qsMaxLookbackDays()
+	if earliest := now.AddDate(0, 0, -lookbackDays); fromTime.Before(earliest) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("'%s' exceeds the maximum lookback of %d days (earliest allowed: %s)", fromParam, lookbackDays, earliest.UTC().Format(time.RFC3339))})
+		return fromTime, toTime, false
+	}
+
+	return fromTime, toTime, true
+}
+
+// qsEpochMillisThreshold separates epoch seconds from milliseconds: 1e12 seconds
+// is tens of thousands of years away, while 1e12 milliseconds is in 2001.
+const qsEpochMillisThreshold = 1_000_000_000_000
+
+// parseQSView parses the view parameter: "full" (the default) or "totals",
+// which reports whether only totals are wanted.
+func parseQSView(value string) (totalsOnly bool, err error) {
+	switch value {
+	case "", "full":
+		return false, nil
+	case "totals":
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid 'view', expected full or totals")
+}
+
+// parseQSKind parses the kind parameter: "request" (the default, returned as
+// ""), "all", or a marker kind such as "ping".
+func parseQSKind(value string) (string, error) {
+	switch value {
+	case "", usage.EventKindRequest:
+		return "", nil
+	case qsKindAll:
+		return value, nil
+	}
+	if !validQSLabelKey(value) {
+		return "", fmt.Errorf("invalid 'kind', expected request, all or a marker kind of 1 to 64 letters, digits, '_', '-' or '.'")
+	}
+	return value, nil
+}
+
+// parseQSBucketBy parses the bucket_by parameter: "started" (the default,
+// returned as "") or "completed".
+func parseQSBucketBy(value string) (string, error) {
+	switch value {
+	case "", "started":
+		return "", nil
+	case qsBucketByCompleted:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid 'bucket_by', expected started or completed")
+}
+
+// parseQSActiveSince parses the optional active_since cutoff; zero means unset.
+// On invalid input it writes a 400 response and returns ok=false.
+func parseQSActiveSince(c *gin.Context, value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, true
+	}
+	activeSince, err := parseQSTimestamp(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'active_since' timestamp format, expected RFC3339 or Unix epoch seconds/milliseconds"})
+		return time.Time{}, false
 	}
+	return activeSince, true
+}
+
+// parseQSTimestamp parses an RFC3339 timestamp or a Unix epoch in seconds or
+// milliseconds, telling the two apart by magnitude.
+func parseQSTimestamp(value string) (time.Time, error) {
+	if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if epoch >= qsEpochMillisThreshold || epoch <= -qsEpochMillisThreshold {
+			return time.UnixMilli(epoch), nil
+		}
+		return time.Unix(epoch, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// qsMaxFutureSkew bounds how far in the future a query's 'to' may be, allowing for clock skew.
+const qsMaxFutureSkew = 24 * time.Hour
+
+// qsDefaultMaxLookbackDays applies when usage-store.max-lookback-days is unset.
+const qsDefaultMaxLookbackDays = 90
+
+// qsMaxLookbackDays returns the configured maximum query lookback in days.
+func (h *Handler) qsMaxLookbackDays() int {
+	if h.cfg != nil && h.cfg.UsageStore.MaxLookbackDays > 0 {
+		return h.cfg.UsageStore.MaxLookbackDays
+	}
+	return qsDefaultMaxLookbackDays
+}
+
+// qsAggregationWorkers returns how many goroutines may aggregate one metrics query.
+func (h *Handler) qsAggregationWorkers() int {
+	if h.cfg != nil && h.cfg.UsageStore.AggregationWorkers > 0 {
+		return h.cfg.UsageStore.AggregationWorkers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// qsPricing returns the configured per-model token prices.
+func (h *Handler) qsPricing() map[string]config.UsageModelPrice {
+	if h.cfg == nil {
+		return nil
+	}
+	return h.cfg.UsageStore.Pricing
+}
 
+// qsModelSorts are the valid 'sort' values for by_model.
+var qsModelSorts = []string{"tokens", "cost", "requests"}
+
+// parseQSModelSort validates the by_model sort key and order, defaulting to
+// tokens, descending.
+func parseQSModelSort(sortBy, order string) (string, bool, error) {
+	if sortBy == "" {
+		sortBy = "tokens"
+	} else if !slices.Contains(qsModelSorts, sortBy) {
+		return "", false, fmt.Errorf("invalid 'sort' %q, expected one of %s", sortBy, strings.Join(qsModelSorts, ", "))
+	}
+	switch order {
+	case "", "desc":
+		return sortBy, false, nil
+	case "asc":
+		return sortBy, true, nil
+	}
+	return "", false, fmt.Errorf("invalid 'order' %q, expected asc or desc", order)
+}
+
+// sortQSModels orders by_model entries by the query's sort key, then by name
+// so ties are stable. The qsOtherGroup entry, if any, always comes last.
+func sortQSModels(byModel []ModelMetrics, sortBy string, ascending bool) {
+	key := func(m ModelMetrics) float64 {
+		switch sortBy {
+		case "cost":
+			return m.CostUSD
+		case "requests":
+			return float64(m.Requests)
+		}
+		return float64(m.Tokens)
+	}
+	slices.SortFunc(byModel, func(a, b ModelMetrics) int {
+		if (a.Model == qsOtherGroup) != (b.Model == qsOtherGroup) {
+			if a.Model == qsOtherGroup {
+				return 1
+			}
+			return -1
+		}
+		order := cmp.Compare(key(b), key(a))
+		if ascending {
+			order = -order
+		}
+		return cmp.Or(order, cmp.Compare(a.Model, b.Model))
+	})
+}
+
+// qsUnknownModelLabel returns the configured name for events without a model.
+func (h *Handler) qsUnknownModelLabel() string {
+	if h.cfg == nil {
+		return ""
+	}
+	return h.cfg.UsageStore.UnknownModelLabel
+}
+
+// qsStore returns the JSON store backing the metrics endpoints, or nil if none is configured.
+func (h *Handler) qsStore() *usage.JSONStore {
+	if h.jsonStore != nil {
+		return h.jsonStore
+	}
+	return usage.GetJSONStore()
+}
+
+// qsStoreForRequest resolves the store selected by the optional 'tenant' query
+// parameter, falling back to the shared store. On error it writes a 400
+// response and returns ok=false.
+func (h *Handler) qsStoreForRequest(c *gin.Context) (store *usage.JSONStore, ok bool) {
+	tenant := c.Query("tenant")
+	if tenant == "" {
+		return h.qsStore(), true
+	}
+	manager := usage.GetStoreManager()
+	if manager == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "per-tenant usage stores are not enabled"})
+		return nil, false
+	}
+	store, err := manager.Store(tenant)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid tenant: %v", err)})
+		return nil, false
+	}
+	return store, true
+}
+
+// GetQSValidate scans the usage store file and reports integrity diagnostics:
+// line counts, corrupt lines with line numbers, the covered time span and
+// whether timestamps are monotonic. Useful after a crash or manual edit.
+// GET /v0/management/qs/validate?tenant=...
+func (h *Handler) GetQSValidate(c *gin.Context) {
+	store, ok := h.qsStoreForRequest(c)
+	if !ok {
+		return
+	}
 	if store == nil {
-		// No store configured, return empty metrics
-		c.JSON(http.StatusOK, MetricsResponse{
-			Totals:     MetricsTotals{},
-			ByModel:    []ModelMetrics{},
-			Timeseries: []TimeseriesBucket{},
-		})
+		c.JSON(http.StatusNotFound, gin.H{"error": "usage store is not configured"})
 		return
 	}
-
-	events, err := store.Load()
+	report, err := store.Validate()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load usage events"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to validate usage store: %v", err), "report": report})
 		return
 	}
+	writeQSJSON(c, http.StatusOK, report)
+}
 
-	// Filter and aggregate events
-	response := aggregateMetrics(events, fromTime, toTime, modelFilter)
-
-	c.JSON(http.StatusOK, response)
+// GetQSTenants lists the tenants that have a per-tenant usage store.
+// GET /v0/management/qs/tenants
+func (h *Handler) GetQSTenants(c *gin.Context) {
+	manager := usage.GetStoreManager()
+	if manager == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false, "tenants": []string{}})
+		return
+	}
+	tenants, err := manager.Tenants()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list tenant stores"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": true, "tenants": tenants})
 }
 
 // GetQSMetricsUI serves an HTML dashboard for visualizing usage metrics.
@@ -118,82 +1250,643 @@ func (h *Handler) GetQSMetricsUI(c *gin.Context) {
 	c.File("static/metrics-dashboard.html")
 }
 
+// qsMinEventsPerWorker keeps small aggregations on a single goroutine, where
+// splitting costs more than it saves.
+const qsMinEventsPerWorker = 50_000
+
 // aggregateMetrics processes events and returns aggregated metrics.
-func aggregateMetrics(events []usage.UsageEvent, fromTime, toTime time.Time, modelFilter string) MetricsResponse {
-	var totalTokens int64
-	var totalRequests int64
-	modelStats := make(map[string]*ModelMetrics)
-	
-	// Timeseries buckets by hour
-	hourlyStats := make(map[time.Time]*TimeseriesBucket)
+// Large event slices are split into chunks aggregated by up to query.Workers
+// goroutines, whose partial results are merged; the output is the same as a
+// sequential pass.
+func aggregateMetrics(events []usage.UsageEvent, query metricsQuery) MetricsResponse {
+	interval, sparklineStart := qsAggregationBuckets(query)
+	agg := newMetricsAggregate(query)
+
+	// Fold in pre-aggregated days first
+	for _, rollup := range query.Rollups {
+		// Rollups are only used without a kind filter, and markers have no model
+		if query.matchesModel(query.modelName("")) {
+			for kind, n := range rollup.Markers {
+				agg.addMarkers(kind, n)
+			}
+		}
+		for name, totals := range rollup.ByModel {
+			model := query.modelName(name)
+			if !query.matchesModel(model) {
+				continue
+			}
+			if query.TotalsOnly {
+				agg.totalTokens += totals.Tokens
+				agg.totalRequests += totals.Requests
+				price := query.Pricing[name]
+				agg.addCache(totals.PromptTokens, totals.CachedTokens, price)
+				continue
+			}
+			model = agg.trackedModel(model)
+			agg.addCounts(model, rollup.Start, totals.Tokens, totals.Requests)
+			// A rolled-up day only says the model was used sometime that day
+			agg.markSeen(model, rollup.Start.AddDate(0, 0, 1))
+			if len(query.GroupBy) > 0 {
+				agg.addGroup(newQSGroupKey(query.GroupBy, model, query.Family, usage.UsageEvent{}), totals.Tokens, totals.Requests)
+			}
+			if price, ok := query.Pricing[name]; ok {
+				cost := qsTokenCost(totals.PromptTokens, totals.CachedTokens, totals.CompletionTokens, price)
+				agg.addCache(totals.PromptTokens, totals.CachedTokens, price)
+				agg.modelStats[model].CostUSD += cost
+				if query.CostPerRequest {
+					agg.addBucketCost(rollup.Start, cost)
+				}
+			} else {
+				agg.addCache(totals.PromptTokens, totals.CachedTokens, config.UsageModelPrice{})
+			}
+		}
+	}
+
+	workers := min(query.Workers, len(events)/qsMinEventsPerWorker)
+	if workers <= 1 {
+		agg.addEvents(events, query, interval, sparklineStart)
+	} else {
+		partials := make([]*metricsAggregate, workers)
+		chunkSize := (len(events) + workers - 1) / workers
+		var wg sync.WaitGroup
+		for i := range partials {
+			chunk := events[min(i*chunkSize, len(events)):min((i+1)*chunkSize, len(events))]
+			partials[i] = newMetricsAggregate(query)
+			// Partials keep every model and group; merge applies the cap in
+			// first-seen order, as a sequential pass would
+			partials[i].maxGroups = math.MaxInt
+			wg.Add(1)
+			go func(partial *metricsAggregate) {
+				defer wg.Done()
+				partial.addEvents(chunk, query, interval, sparklineStart)
+			}(partials[i])
+		}
+		wg.Wait()
+		for _, partial := range partials {
+			agg.merge(partial)
+		}
+	}
+	return agg.response(query, interval, sparklineStart)
+}
+
+// qsAggregationBuckets returns the timeseries interval of a query, hourly
+// unless another was requested, and the start of the per-model sparklines,
+// which cover the last qsSparklineBuckets hours of the range.
+func qsAggregationBuckets(query metricsQuery) (interval time.Duration, sparklineStart time.Time) {
+	interval = query.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	sparklineEnd := query.To.Truncate(time.Hour)
+	return interval, sparklineEnd.Add(-(qsSparklineBuckets - 1) * time.Hour)
+}
+
+// response turns the aggregate into the metrics response of query, merging
+// minor models, sorting and scaling up sampled counts.
+func (a *metricsAggregate) response(query metricsQuery, interval time.Duration, sparklineStart time.Time) MetricsResponse {
+	minor := a.minorModels(query)
+	a.collapseModels(minor)
+
+	// Convert maps to slices for response
+	byModel := make([]ModelMetrics, 0, len(a.modelStats))
+	inactive := 0
+	for _, m := range a.modelStats {
+		if !query.ActiveSince.IsZero() && a.lastSeen[m.Model].Before(query.ActiveSince) {
+			inactive++
+			continue
+		}
+		m.TokensPerSecond = a.modelThroughput[m.Model].tokensPerSecond()
+		if query.Sparklines {
+			m.Sparkline = a.sparklines[m.Model].points(sparklineStart)
+		}
+		byModel = append(byModel, *m)
+	}
+
+	sortQSModels(byModel, query.Sort, query.Ascending)
+
+	timeseries := make([]TimeseriesBucket, 0, len(a.bucketStats))
+	for _, bucket := range a.bucketStats {
+		if query.CostPerRequest && bucket.Requests > 0 {
+			// The ratio is unchanged by scaling a sampled store up
+			costPerRequest := a.bucketCosts[bucket.BucketStart] / float64(bucket.Requests)
+			bucket.CostPerRequest = &costPerRequest
+		}
+		timeseries = append(timeseries, *bucket)
+	}
+
+	// Sort timeseries by timestamp ascending
+	sort.Slice(timeseries, func(i, j int) bool {
+		return timeseries[i].BucketStart.Before(timeseries[j].BucketStart)
+	})
+
+	response := MetricsResponse{
+		Groups: a.groupRows(query.GroupBy),
+		Totals: MetricsTotals{
+			Tokens:          a.totalTokens,
+			Requests:        a.totalRequests,
+			TokensPerSecond: a.totalThroughput.tokensPerSecond(),
+			CachedTokens:    a.cachedTokens,
+			CacheHitRatio:   a.cacheHitRatio(),
+			CacheSavingsUSD: a.cacheSavings,
+		},
+		ByModel:        byModel,
+		Timeseries:     timeseries,
+		RollupDays:     len(query.Rollups),
+		BucketSeconds:  int64(interval / time.Second),
+		InactiveModels: inactive,
+		GroupsCapped:   a.groupsCapped,
+		OtherModels:    len(minor),
+		Markers:        a.markers,
+	}
+	if a.groupsCapped {
+		warnQSGroupsCapped(a.maxGroups)
+	}
+	if query.SampleRate > 0 && query.SampleRate < 1 {
+		scaleMetrics(&response, query.SampleRate)
+	}
+	if query.FillGaps {
+		response.Timeseries = fillTimeseriesGaps(response.Timeseries, query.From, query.To, interval, query.Rollups)
+	}
+	if query.IncludeDelta {
+		addTimeseriesDeltas(response.Timeseries)
+	}
+	return response
+}
+
+// addTimeseriesDeltas sets each bucket's change from the bucket before it in
+// the sorted series; the first bucket's deltas are zero.
+func addTimeseriesDeltas(timeseries []TimeseriesBucket) {
+	var prevTokens, prevRequests int64
+	for i := range timeseries {
+		bucket := &timeseries[i]
+		if i == 0 {
+			prevTokens, prevRequests = bucket.Tokens, bucket.Requests
+		}
+		tokensDelta, requestsDelta := bucket.Tokens-prevTokens, bucket.Requests-prevRequests
+		bucket.TokensDelta, bucket.RequestsDelta = &tokensDelta, &requestsDelta
+		prevTokens, prevRequests = bucket.Tokens, bucket.Requests
+	}
+}
+
+// groupRows returns the group_by pivot rows, largest token count first.
+func (a *metricsAggregate) groupRows(dims []string) []GroupMetrics {
+	if len(dims) == 0 {
+		return nil
+	}
+	keys := make([]qsGroupKey, 0, len(a.groups))
+	for key := range a.groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		gi, gj := a.groups[keys[i]], a.groups[keys[j]]
+		if gi.Tokens != gj.Tokens {
+			return gi.Tokens > gj.Tokens
+		}
+		ki, kj := keys[i], keys[j]
+		return cmp.Or(
+			cmp.Compare(ki.model, kj.model),
+			cmp.Compare(ki.family, kj.family),
+			cmp.Compare(ki.provider, kj.provider),
+			cmp.Compare(ki.status, kj.status),
+			cmp.Compare(ki.apiKeyHash, kj.apiKeyHash),
+			cmp.Compare(ki.labels, kj.labels),
+		) < 0
+	})
+
+	rows := make([]GroupMetrics, len(keys))
+	for i, key := range keys {
+		rows[i] = *a.groups[key]
+		labels := strings.Split(key.labels, qsLabelSeparator)[1:]
+		for _, dim := range dims {
+			switch dim {
+			case "model":
+				rows[i].Model = key.model
+			case "family":
+				rows[i].Family = key.family
+			case "provider":
+				rows[i].Provider = key.provider
+			case "status":
+				if a.groupsCapped && key == a.otherGroup {
+					continue
+				}
+				status := key.status
+				rows[i].Status = &status
+			case "api_key_hash":
+				rows[i].APIKeyHash = key.apiKeyHash
+			default:
+				if rows[i].Labels == nil {
+					rows[i].Labels = make(map[string]string)
+				}
+				label, _ := strings.CutPrefix(dim, qsLabelDimension)
+				rows[i].Labels[label] = labels[0]
+				labels = labels[1:]
+			}
+		}
+	}
+	return rows
+}
+
+// metricsAggregate holds the running sums of a (partial) metrics aggregation.
+type metricsAggregate struct {
+	totalTokens     int64
+	totalRequests   int64
+	totalThroughput throughputAccumulator
+	modelStats      map[string]*ModelMetrics
+	modelThroughput map[string]*throughputAccumulator
+	bucketStats     map[time.Time]*TimeseriesBucket
+	sparklines      map[string]*sparklineAccumulator
+	promptTokens    int64
+	cachedTokens    int64
+	cacheSavings    float64
+	groups          map[qsGroupKey]*GroupMetrics
+	lastSeen        map[string]time.Time
+	// maxGroups caps the keys of modelStats and groups, not counting the
+	// qsOtherGroup entries collecting the rest; groupsCapped records that
+	// the cap was reached.
+	maxGroups  int
+	otherGroup qsGroupKey
+	// modelOrder and groupOrder list the keys of modelStats and groups in
+	// the order they were first seen, so merge caps them deterministically.
+	modelOrder   []string
+	groupOrder   []qsGroupKey
+	groupsCapped bool
+	markers      map[string]int64
+	// skipTimeseries leaves bucketStats empty when the timeseries is not returned.
+	skipTimeseries bool
+	// bucketCosts sums the estimated cost of each timeseries bucket for
+	// cost_per_request.
+	bucketCosts map[time.Time]float64
+}
+
+func newMetricsAggregate(query metricsQuery) *metricsAggregate {
+	return &metricsAggregate{
+		modelStats:      make(map[string]*ModelMetrics),
+		modelThroughput: make(map[string]*throughputAccumulator),
+		bucketStats:     make(map[time.Time]*TimeseriesBucket),
+		bucketCosts:     make(map[time.Time]float64),
+		sparklines:      make(map[string]*sparklineAccumulator),
+		groups:          make(map[qsGroupKey]*GroupMetrics),
+		lastSeen:        make(map[string]time.Time),
+		maxGroups:       cmp.Or(query.MaxGroups, qsDefaultMaxGroups),
+		otherGroup:      qsOtherGroupKey(query.GroupBy),
+		skipTimeseries:  !query.wants("timeseries"),
+	}
+}
+
+// addMarkers counts n marker events of kind left out by the kind filter.
+func (a *metricsAggregate) addMarkers(kind string, n int64) {
+	if a.markers == nil {
+		a.markers = make(map[string]int64)
+	}
+	a.markers[kind] += n
+}
+
+// trackedModel returns the name to aggregate model under: the model itself,
+// or qsOtherGroup once maxGroups other models are tracked.
+func (a *metricsAggregate) trackedModel(model string) string {
+	tracked := len(a.modelStats)
+	if _, exists := a.modelStats[qsOtherGroup]; exists {
+		tracked--
+	}
+	if _, exists := a.modelStats[model]; exists || model == qsOtherGroup || tracked < a.maxGroups {
+		return model
+	}
+	a.groupsCapped = true
+	return qsOtherGroup
+}
+
+// throughputAccumulator sums completion tokens and latency of requests that
+// report both, so throughput is weighted by request duration rather than
+// averaging per-request rates.
+type throughputAccumulator struct {
+	completionTokens int64
+	latencyMs        int64
+}
+
+func (t *throughputAccumulator) add(event usage.UsageEvent) {
+	if event.LatencyMs <= 0 || event.CompletionTokens <= 0 {
+		return
+	}
+	t.completionTokens += event.CompletionTokens
+	t.latencyMs += event.LatencyMs
+}
+
+func (t *throughputAccumulator) merge(other *throughputAccumulator) {
+	t.completionTokens += other.completionTokens
+	t.latencyMs += other.latencyMs
+}
+
+// tokensPerSecond returns the throughput, or 0 when no request reported latency.
+func (t *throughputAccumulator) tokensPerSecond() float64 {
+	if t == nil || t.latencyMs <= 0 {
+		return 0
+	}
+	return float64(t.completionTokens) / (float64(t.latencyMs) / 1000)
+}
+
+// addCounts adds tokens and requests to the totals, the model and the bucket.
+func (a *metricsAggregate) addCounts(model string, bucket time.Time, tokens, requests int64) {
+	a.totalTokens += tokens
+	a.totalRequests += requests
+
+	// Aggregate by model
+	if _, exists := a.modelStats[model]; !exists {
+		a.modelStats[model] = &ModelMetrics{Model: model}
+		a.modelOrder = append(a.modelOrder, model)
+	}
+	a.modelStats[model].Tokens += tokens
+	a.modelStats[model].Requests += requests
+
+	// Aggregate by time bucket
+	if a.skipTimeseries {
+		return
+	}
+	if _, exists := a.bucketStats[bucket]; !exists {
+		a.bucketStats[bucket] = &TimeseriesBucket{BucketStart: bucket}
+	}
+	a.bucketStats[bucket].Tokens += tokens
+	a.bucketStats[bucket].Requests += requests
+}
+
+// addBucketCost adds an estimated cost to a timeseries bucket.
+func (a *metricsAggregate) addBucketCost(bucket time.Time, cost float64) {
+	if !a.skipTimeseries {
+		a.bucketCosts[bucket] += cost
+	}
+}
+
+// markSeen records that model had an event at t, keeping the latest time.
+func (a *metricsAggregate) markSeen(model string, t time.Time) {
+	if t.After(a.lastSeen[model]) {
+		a.lastSeen[model] = t
+	}
+}
+
+// addGroup adds tokens and requests to a group_by row, or to the otherGroup
+// row once maxGroups other rows are tracked.
+func (a *metricsAggregate) addGroup(key qsGroupKey, tokens, requests int64) {
+	g, exists := a.groups[key]
+	if !exists && key != a.otherGroup {
+		tracked := len(a.groups)
+		if _, ok := a.groups[a.otherGroup]; ok {
+			tracked--
+		}
+		if tracked >= a.maxGroups {
+			a.groupsCapped = true
+			key = a.otherGroup
+			g, exists = a.groups[key]
+		}
+	}
+	if !exists {
+		g = &GroupMetrics{}
+		a.groups[key] = g
+		a.groupOrder = append(a.groupOrder, key)
+	}
+	g.Tokens += tokens
+	g.Requests += requests
+}
+
+// addCache adds prompt and cached tokens and the savings from the cached ones
+// at price; a zero price saves nothing.
+func (a *metricsAggregate) addCache(prompt, cached int64, price config.UsageModelPrice) {
+	a.promptTokens += prompt
+	a.cachedTokens += cached
+	a.cacheSavings += float64(cached) * (price.Input - price.CachedInput) / 1_000_000
+}
+
+// cacheHitRatio returns cached over prompt tokens, capped at 1 for providers
+// that report cache reads separately from prompt tokens.
+func (a *metricsAggregate) cacheHitRatio() float64 {
+	if a.promptTokens <= 0 || a.cachedTokens <= 0 {
+		return 0
+	}
+	return math.Min(float64(a.cachedTokens)/float64(a.promptTokens), 1)
+}
 
+// addEvents aggregates the events that match the query filters.
+func (a *metricsAggregate) addEvents(events []usage.UsageEvent, query metricsQuery, interval time.Duration, sparklineStart time.Time) {
 	for _, event := range events {
 		// Filter by time range
-		if event.Timestamp.Before(fromTime) || event.Timestamp.After(toTime) {
+		if event.Timestamp.Before(query.From) || event.Timestamp.After(query.To) {
 			continue
 		}
 
 		// Filter by model if specified
-		if modelFilter != "" && event.Model != modelFilter {
+		model := query.modelName(event.Model)
+		if !query.matchesModel(model) {
 			continue
 		}
 
-		// Aggregate totals
-		totalTokens += event.TotalTokens
-		totalRequests++
+		if len(query.Providers) > 0 && !slices.Contains(query.Providers, event.Provider) {
+			continue
+		}
+
+		if len(query.Statuses) > 0 && !slices.Contains(query.Statuses, event.Status) {
+			continue
+		}
+
+		// Filter by API key hash if specified
+		if query.APIKeyHash != "" && event.APIKeyHash != query.APIKeyHash {
+			continue
+		}
 
-		// Aggregate by model
-		if _, exists := modelStats[event.Model]; !exists {
-			modelStats[event.Model] = &ModelMetrics{
-				Model:    event.Model,
-				Tokens:   0,
-				Requests: 0,
+		if query.ExcludeSuspicious && event.Suspicious {
+			continue
+		}
+
+		if query.RequestIDPrefix != "" && !strings.HasPrefix(event.RequestID, query.RequestIDPrefix) {
+			continue
+		}
+
+		if !query.matchesKind(event) {
+			if event.IsMarker() {
+				a.addMarkers(event.Kind, 1)
 			}
+			continue
 		}
-		modelStats[event.Model].Tokens += event.TotalTokens
-		modelStats[event.Model].Requests++
 
-		// Aggregate by hour
-		hourBucket := event.Timestamp.Truncate(time.Hour)
-		if _, exists := hourlyStats[hourBucket]; !exists {
-			hourlyStats[hourBucket] = &TimeseriesBucket{
-				BucketStart: hourBucket,
-				Tokens:      0,
-				Requests:    0,
+		if query.TotalsOnly {
+			// Skip the per-model, bucket and group maps entirely
+			a.totalTokens += event.TotalTokens
+			a.totalRequests++
+			a.addCache(event.PromptTokens, event.CachedTokens, query.Pricing[event.Model])
+			a.totalThroughput.add(event)
+			continue
+		}
+
+		model = a.trackedModel(model)
+		bucket := query.bucketTime(event).Truncate(interval)
+		a.addCounts(model, bucket, event.TotalTokens, 1)
+		a.markSeen(model, event.Timestamp)
+		price, priced := query.Pricing[event.Model]
+		a.addCache(event.PromptTokens, event.CachedTokens, price)
+		if priced {
+			cost := qsEventCost(event, price)
+			a.modelStats[model].CostUSD += cost
+			if query.CostPerRequest {
+				a.addBucketCost(bucket, cost)
+			}
+		}
+		if len(query.GroupBy) > 0 {
+			a.addGroup(newQSGroupKey(query.GroupBy, model, query.Family, event), event.TotalTokens, 1)
+		}
+
+		a.totalThroughput.add(event)
+		throughput, exists := a.modelThroughput[model]
+		if !exists {
+			throughput = &throughputAccumulator{}
+			a.modelThroughput[model] = throughput
+		}
+		throughput.add(event)
+
+		hourBucket := query.bucketTime(event).Truncate(time.Hour)
+		if query.Sparklines && !hourBucket.Before(sparklineStart) {
+			acc, exists := a.sparklines[model]
+			if !exists {
+				acc = &sparklineAccumulator{}
+				a.sparklines[model] = acc
 			}
+			acc.add(int(hourBucket.Sub(sparklineStart)/time.Hour), event)
 		}
-		hourlyStats[hourBucket].Tokens += event.TotalTokens
-		hourlyStats[hourBucket].Requests++
 	}
+}
 
-	// Convert maps to slices for response
-	byModel := make([]ModelMetrics, 0, len(modelStats))
-	for _, m := range modelStats {
-		byModel = append(byModel, *m)
+// merge adds another partial aggregation into a.
+func (a *metricsAggregate) merge(other *metricsAggregate) {
+	a.totalTokens += other.totalTokens
+	a.totalRequests += other.totalRequests
+	a.totalThroughput.merge(&other.totalThroughput)
+	a.promptTokens += other.promptTokens
+	a.cachedTokens += other.cachedTokens
+	a.cacheSavings += other.cacheSavings
+	a.groupsCapped = a.groupsCapped || other.groupsCapped
+	for kind, n := range other.markers {
+		a.addMarkers(kind, n)
+	}
+	// tracked maps each of other's models to its name here, which is
+	// qsOtherGroup for models beyond the cap. Models are visited in the
+	// order other first saw them, so merging chunks in order caps the same
+	// models as a sequential pass.
+	tracked := make(map[string]string, len(other.modelStats))
+	for _, model := range other.modelOrder {
+		m := other.modelStats[model]
+		name := a.trackedModel(model)
+		tracked[model] = name
+		if existing, ok := a.modelStats[name]; ok {
+			existing.Tokens += m.Tokens
+			existing.Requests += m.Requests
+			existing.CostUSD += m.CostUSD
+		} else {
+			m.Model = name
+			a.modelStats[name] = m
+			a.modelOrder = append(a.modelOrder, name)
+		}
+	}
+	for model, throughput := range other.modelThroughput {
+		if existing, ok := a.modelThroughput[tracked[model]]; ok {
+			existing.merge(throughput)
+		} else {
+			a.modelThroughput[tracked[model]] = throughput
+		}
 	}
+	for start, bucket := range other.bucketStats {
+		if existing, ok := a.bucketStats[start]; ok {
+			existing.Tokens += bucket.Tokens
+			existing.Requests += bucket.Requests
+		} else {
+			a.bucketStats[start] = bucket
+		}
+	}
+	for start, cost := range other.bucketCosts {
+		a.bucketCosts[start] += cost
+	}
+	for _, key := range other.groupOrder {
+		g := other.groups[key]
+		a.addGroup(key, g.Tokens, g.Requests)
+	}
+	for model, t := range other.lastSeen {
+		a.markSeen(tracked[model], t)
+	}
+	for model, acc := range other.sparklines {
+		if existing, ok := a.sparklines[tracked[model]]; ok {
+			existing.merge(acc)
+		} else {
+			a.sparklines[tracked[model]] = acc
+		}
+	}
+}
 
-	// Sort by tokens descending
-	sort.Slice(byModel, func(i, j int) bool {
-		return byModel[i].Tokens > byModel[j].Tokens
-	})
+// sparklineAccumulator collects one model's hourly sparkline buckets.
+type sparklineAccumulator struct {
+	buckets      [qsSparklineBuckets]SparklinePoint
+	latencyTotal [qsSparklineBuckets]int64
+	latencyCount [qsSparklineBuckets]int64
+}
 
-	timeseries := make([]TimeseriesBucket, 0, len(hourlyStats))
-	for _, bucket := range hourlyStats {
-		timeseries = append(timeseries, *bucket)
+func (a *sparklineAccumulator) add(idx int, event usage.UsageEvent) {
+	if idx < 0 || idx >= qsSparklineBuckets {
+		return
 	}
+	a.buckets[idx].Requests++
+	a.buckets[idx].Tokens += event.TotalTokens
+	if event.LatencyMs > 0 {
+		a.latencyTotal[idx] += event.LatencyMs
+		a.latencyCount[idx]++
+	}
+}
 
-	// Sort timeseries by timestamp ascending
-	sort.Slice(timeseries, func(i, j int) bool {
-		return timeseries[i].BucketStart.Before(timeseries[j].BucketStart)
-	})
+// merge adds another accumulator's buckets into a.
+func (a *sparklineAccumulator) merge(other *sparklineAccumulator) {
+	for i := range a.buckets {
+		a.buckets[i].Requests += other.buckets[i].Requests
+		a.buckets[i].Tokens += other.buckets[i].Tokens
+		a.latencyTotal[i] += other.latencyTotal[i]
+		a.latencyCount[i] += other.latencyCount[i]
+	}
+}
 
-	return MetricsResponse{
-		Totals: MetricsTotals{
-			Tokens:   totalTokens,
-			Requests: totalRequests,
-		},
-		ByModel:    byModel,
-		Timeseries: timeseries,
+// points returns the dense series of buckets starting at start, including empty hours.
+func (a *sparklineAccumulator) points(start time.Time) []SparklinePoint {
+	points := make([]SparklinePoint, qsSparklineBuckets)
+	for i := range points {
+		if a != nil {
+			points[i] = a.buckets[i]
+			if a.latencyCount[i] > 0 {
+				points[i].AvgLatencyMs = a.latencyTotal[i] / a.latencyCount[i]
+			}
+		}
+		points[i].BucketStart = start.Add(time.Duration(i) * time.Hour)
 	}
+	return points
 }
 
+// scaleMetrics extrapolates sampled counts back to estimated totals.
+func scaleMetrics(response *MetricsResponse, sampleRate float64) {
+	scale := func(v int64) int64 { return int64(math.Round(float64(v) / sampleRate)) }
+
+	response.Totals.Tokens = scale(response.Totals.Tokens)
+	response.Totals.Requests = scale(response.Totals.Requests)
+	response.Totals.CachedTokens = scale(response.Totals.CachedTokens)
+	response.Totals.CacheSavingsUSD /= sampleRate
+	for i := range response.ByModel {
+		response.ByModel[i].Tokens = scale(response.ByModel[i].Tokens)
+		response.ByModel[i].Requests = scale(response.ByModel[i].Requests)
+		response.ByModel[i].CostUSD /= sampleRate
+		for j := range response.ByModel[i].Sparkline {
+			response.ByModel[i].Sparkline[j].Tokens = scale(response.ByModel[i].Sparkline[j].Tokens)
+			response.ByModel[i].Sparkline[j].Requests = scale(response.ByModel[i].Sparkline[j].Requests)
+		}
+	}
+	for i := range response.Groups {
+		response.Groups[i].Tokens = scale(response.Groups[i].Tokens)
+		response.Groups[i].Requests = scale(response.Groups[i].Requests)
+	}
+	for i := range response.Timeseries {
+		response.Timeseries[i].Tokens = scale(response.Timeseries[i].Tokens)
+		response.Timeseries[i].Requests = scale(response.Timeseries[i].Requests)
+	}
+	response.Estimated = true
+	response.SampleRate = sampleRate
+	response.Precision = &MetricsPrecision{Totals: qsPrecisionSampled, ByModel: qsPrecisionSampled, Timeseries: qsPrecisionSampled}
+	response.Note = fmt.Sprintf("numbers are estimated from a %.4g%% sample of events", sampleRate*100)
+}
@@ -0,0 +1,832 @@
+package management
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+// syntheticEvents returns n events spread over the week before end across a few models.
+func syntheticEvents(n int, end time.Time) []usage.UsageEvent {
+	models := []string{"gpt-4o", "claude-sonnet", "gemini-pro", "gpt-4o-mini"}
+	step := 7 * 24 * time.Hour / time.Duration(n)
+	events := make([]usage.UsageEvent, n)
+	for i := range events {
+		events[i] = usage.UsageEvent{
+			Timestamp:   end.Add(-time.Duration(n-i) * step),
+			Model:       models[i%len(models)],
+			TotalTokens: int64(100 + i%900),
+			Status:      200,
+			LatencyMs:   int64(50 + i%400),
+		}
+	}
+	return events
+}
+
+func TestAggregateMetrics_ParallelMatchesSequential(t *testing.T) {
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	events := syntheticEvents(4*qsMinEventsPerWorker, end)
+	query := metricsQuery{From: end.Add(-7 * 24 * time.Hour), To: end, Sparklines: true}
+
+	sequential := aggregateMetrics(events, query)
+	query.Workers = 4
+	parallel := aggregateMetrics(events, query)
+
+	if !reflect.DeepEqual(sequential, parallel) {
+		t.Fatalf("parallel aggregation differs from sequential:\nsequential totals %+v\nparallel totals %+v", sequential.Totals, parallel.Totals)
+	}
+}
+
+func TestAggregateMetrics_ParallelCapsModelsLikeSequential(t *testing.T) {
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	events := syntheticEvents(4*qsMinEventsPerWorker, end)
+	// 40 models, first seen spread over every chunk, against a cap of 5
+	for i := range events {
+		events[i].Model = fmt.Sprintf("m-%d", (i/(qsMinEventsPerWorker/10)+i)%40)
+	}
+	query := metricsQuery{From: end.Add(-7 * 24 * time.Hour), To: end, MaxGroups: 5, GroupBy: []string{"model"}}
+
+	sequential := aggregateMetrics(events, query)
+	if !sequential.GroupsCapped {
+		t.Fatal("want the model cap reached")
+	}
+	query.Workers = 4
+	for run := 0; run < 5; run++ {
+		parallel := aggregateMetrics(events, query)
+		if !reflect.DeepEqual(sequential.ByModel, parallel.ByModel) || !reflect.DeepEqual(sequential.Groups, parallel.Groups) {
+			t.Fatalf("run %d: parallel models differ from sequential:\nsequential %+v\nparallel %+v", run, sequential.ByModel, parallel.ByModel)
+		}
+	}
+}
+
+func TestAggregateMetrics_RedactsModels(t *testing.T) {
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	events := syntheticEvents(400, end)
+	h := &Handler{cfg: &config.Config{}}
+	h.cfg.UsageStore.ModelRedaction.Allow = []string{"gpt-4o"}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(qsRedactModelsKey, true)
+	query := metricsQuery{From: end.Add(-7 * 24 * time.Hour), To: end, RedactModel: h.qsModelRedactor(c)}
+
+	response := aggregateMetrics(events, query)
+	if len(response.ByModel) != 2 || response.ByModel[0].Model != qsRedactedModel || response.ByModel[1].Model != "gpt-4o" {
+		t.Fatalf("want (internal) and gpt-4o, got %+v", response.ByModel)
+	}
+	if response.ByModel[0].Requests != 300 || response.Totals.Requests != 400 {
+		t.Fatalf("hidden models not merged: %+v totals %+v", response.ByModel[0], response.Totals)
+	}
+
+	// Filtering by a hidden name must not reveal its usage
+	query.Model = "claude-sonnet"
+	if filtered := aggregateMetrics(events, query); filtered.Totals.Requests != 0 {
+		t.Fatalf("filter by hidden model returned %d requests", filtered.Totals.Requests)
+	}
+}
+
+func TestAggregateMetrics_RequestIDPrefix(t *testing.T) {
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	events := syntheticEvents(100, end)
+	for i := range events {
+		if i%4 == 0 {
+			events[i].RequestID = fmt.Sprintf("batch-42-%d", i)
+		} else {
+			events[i].RequestID = fmt.Sprintf("req-%d", i)
+		}
+	}
+	query := metricsQuery{From: end.Add(-7 * 24 * time.Hour), To: end, RequestIDPrefix: "batch-42-"}
+
+	response := aggregateMetrics(events, query)
+	if response.Totals.Requests != 25 || len(response.ByModel) != 1 || response.ByModel[0].Model != "gpt-4o" {
+		t.Fatalf("want 25 gpt-4o batch requests, got totals %+v by_model %+v", response.Totals, response.ByModel)
+	}
+}
+
+func TestAggregateMetrics_ListFilters(t *testing.T) {
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	events := syntheticEvents(400, end)
+	for i := range events {
+		events[i].Provider = []string{"openai", "anthropic"}[i%2]
+		if i%5 == 0 {
+			events[i].Status = 429
+		}
+	}
+	query := metricsQuery{
+		From:      end.Add(-7 * 24 * time.Hour),
+		To:        end,
+		Models:    []string{"gpt-4o", "claude-sonnet"},
+		Providers: []string{"openai"},
+		Statuses:  []int{429},
+	}
+
+	// gpt-4o is every 4th event (all openai), claude-sonnet never openai; every 5th is a 429
+	response := aggregateMetrics(events, query)
+	if response.Totals.Requests != 20 || len(response.ByModel) != 1 || response.ByModel[0].Model != "gpt-4o" {
+		t.Fatalf("want 20 gpt-4o 429s, got totals %+v by_model %+v", response.Totals, response.ByModel)
+	}
+}
+
+func TestAggregateMetrics_UnknownModelLabel(t *testing.T) {
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	events := syntheticEvents(100, end)
+	for i := range events {
+		if i%5 == 0 {
+			events[i].Model = ""
+		}
+	}
+	query := metricsQuery{From: end.Add(-7 * 24 * time.Hour), To: end, UnknownModel: "n/a"}
+
+	response := aggregateMetrics(events, query)
+	if response.Totals.Requests != 100 {
+		t.Fatalf("want every event in totals, got %d", response.Totals.Requests)
+	}
+	var found bool
+	for _, model := range response.ByModel {
+		if model.Model == "" {
+			t.Fatal("want no empty model name in by_model")
+		}
+		if model.Model == "n/a" {
+			found = model.Requests == 20
+		}
+	}
+	if !found {
+		t.Fatalf("want 20 requests under the unknown label, got %+v", response.ByModel)
+	}
+
+	query.Model = "n/a"
+	if filtered := aggregateMetrics(events, query); filtered.Totals.Requests != 20 {
+		t.Fatalf("want the label to filter model-less events, got %d", filtered.Totals.Requests)
+	}
+	if got := (metricsQuery{}).modelName(""); got != qsDefaultUnknownModel {
+		t.Fatalf("want default label %q, got %q", qsDefaultUnknownModel, got)
+	}
+}
+
+func TestAggregateMetrics_GroupBy(t *testing.T) {
+	if _, err := parseQSGroupBy("model,region"); err == nil {
+		t.Fatal("want an error for an unknown dimension")
+	}
+	groupBy, err := parseQSGroupBy("provider, status,provider")
+	if err != nil || !reflect.DeepEqual(groupBy, []string{"provider", "status"}) {
+		t.Fatalf("want [provider status], got %v (%v)", groupBy, err)
+	}
+
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	events := syntheticEvents(400, end)
+	for i := range events {
+		if i%2 == 0 {
+			events[i].Provider = "openai"
+		}
+		if i%4 == 0 {
+			events[i].Status = 500
+		}
+	}
+	query := metricsQuery{From: end.Add(-7 * 24 * time.Hour), To: end, GroupBy: groupBy, Workers: 4}
+
+	response := aggregateMetrics(events, query)
+	if len(response.Groups) != 3 {
+		t.Fatalf("want 3 provider/status rows, got %+v", response.Groups)
+	}
+	var requests int64
+	for _, row := range response.Groups {
+		if row.Model != "" || row.Status == nil || row.Provider == "" {
+			t.Fatalf("unexpected row dimensions %+v", row)
+		}
+		if row.Provider == "unknown" && *row.Status != 200 {
+			t.Fatalf("odd events are unknown-provider 200s, got %+v", row)
+		}
+		requests += row.Requests
+	}
+	if requests != response.Totals.Requests {
+		t.Fatalf("rows sum to %d requests, totals have %d", requests, response.Totals.Requests)
+	}
+}
+
+func TestAggregateMetrics_GroupByFamily(t *testing.T) {
+	h := &Handler{cfg: &config.Config{UsageStore: config.UsageStoreConfig{ModelFamilies: []config.UsageModelFamily{
+		{Family: "gpt-4 family", Prefix: "gpt-4"},
+		{Family: "broken", Match: "("},
+		{Family: "claude-3 family", Match: `^claude-3(\.\d)?-`},
+	}}}}
+	family := h.qsModelFamilies()
+	for model, want := range map[string]string{
+		"gpt-4o":            "gpt-4 family",
+		"gpt-4-turbo":       "gpt-4 family",
+		"claude-3-opus":     "claude-3 family",
+		"claude-3.5-sonnet": "claude-3 family",
+		"gemini-pro":        "gemini-pro",
+	} {
+		if got := family(model); got != want {
+			t.Fatalf("%s: want family %q, got %q", model, want, got)
+		}
+	}
+
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	day := time.Date(2025, 11, 23, 0, 0, 0, 0, time.UTC)
+	events := []usage.UsageEvent{
+		{Timestamp: end.Add(-time.Hour), Model: "gpt-4o", TotalTokens: 10},
+		{Timestamp: end.Add(-time.Hour), Model: "gpt-4-turbo", TotalTokens: 20},
+		{Timestamp: end.Add(-time.Hour), Model: "claude-3-opus", TotalTokens: 5},
+	}
+	query := metricsQuery{
+		From:    day,
+		To:      end,
+		GroupBy: []string{"family"},
+		Family:  family,
+		Rollups: []usage.Rollup{{Period: "day", Start: day, ByModel: map[string]usage.ModelTotals{"gpt-4o": {Tokens: 100, Requests: 4}}}},
+	}
+	if !query.groupsOnlyByModel() {
+		t.Fatal("want family groups served from rollups")
+	}
+	groups := aggregateMetrics(events, query).Groups
+	want := []GroupMetrics{
+		{Family: "gpt-4 family", Tokens: 130, Requests: 6},
+		{Family: "claude-3 family", Tokens: 5, Requests: 1},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Fatalf("want %+v, got %+v", want, groups)
+	}
+}
+
+func TestAggregateMetrics_CapsDistinctGroups(t *testing.T) {
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	events := syntheticEvents(2*qsMinEventsPerWorker, end)
+	var totalTokens int64
+	for i := range events {
+		events[i].Model = fmt.Sprintf("model-%d", i%50)
+		totalTokens += events[i].TotalTokens
+	}
+
+	for _, workers := range []int{1, 2} {
+		query := metricsQuery{
+			From:      end.Add(-7 * 24 * time.Hour),
+			To:        end,
+			GroupBy:   []string{"model", "status"},
+			MaxGroups: 10,
+			Workers:   workers,
+		}
+		response := aggregateMetrics(events, query)
+		if !response.GroupsCapped || response.Totals.Tokens != totalTokens {
+			t.Fatalf("workers=%d: want capped groups and full totals, got %+v", workers, response.Totals)
+		}
+		if len(response.ByModel) != 11 || len(response.Groups) != 11 {
+			t.Fatalf("workers=%d: want 10 models and groups plus (other), got %d and %d", workers, len(response.ByModel), len(response.Groups))
+		}
+		var byModelTokens, groupTokens int64
+		for _, m := range response.ByModel {
+			byModelTokens += m.Tokens
+		}
+		for _, g := range response.Groups {
+			groupTokens += g.Tokens
+			if g.Model == qsOtherGroup && g.Status != nil {
+				t.Fatalf("workers=%d: want no status on the (other) row, got %+v", workers, g)
+			}
+		}
+		if byModelTokens != totalTokens || groupTokens != totalTokens {
+			t.Fatalf("workers=%d: want every token counted, got %d by model and %d in groups of %d", workers, byModelTokens, groupTokens, totalTokens)
+		}
+	}
+
+	if response := aggregateMetrics(events[:1000], metricsQuery{From: end.Add(-7 * 24 * time.Hour), To: end}); response.GroupsCapped || len(response.ByModel) != 50 {
+		t.Fatalf("want 50 models under the default cap, got %d", len(response.ByModel))
+	}
+}
+
+func TestAggregateMetrics_CollapsesMinorModels(t *testing.T) {
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	tokens := map[string]int64{"big": 600, "mid": 300, "small": 60, "tiny": 40}
+	var events []usage.UsageEvent
+	for model, n := range tokens {
+		events = append(events, usage.UsageEvent{Timestamp: end.Add(-time.Hour), Model: model, TotalTokens: n})
+	}
+	query := metricsQuery{From: end.Add(-24 * time.Hour), To: end}
+
+	models := func(response MetricsResponse) []string {
+		var names []string
+		for _, m := range response.ByModel {
+			names = append(names, fmt.Sprintf("%s=%d", m.Model, m.Tokens))
+		}
+		return names
+	}
+	for _, tc := range []struct {
+		top      int
+		minShare float64
+		want     []string
+	}{
+		{top: 2, want: []string{"big=600", "mid=300", "(other)=100"}},
+		{minShare: 0.05, want: []string{"big=600", "mid=300", "small=60", "(other)=40"}},
+		{top: 3, minShare: 0.5, want: []string{"big=600", "(other)=400"}},
+		{top: 1, minShare: 0.05, want: []string{"big=600", "(other)=400"}},
+		{top: 10, want: []string{"big=600", "mid=300", "small=60", "tiny=40"}},
+	} {
+		query.Top, query.MinShare = tc.top, tc.minShare
+		response := aggregateMetrics(events, query)
+		if got := models(response); !slices.Equal(got, tc.want) {
+			t.Fatalf("top=%d min_share=%v: want %v, got %v", tc.top, tc.minShare, tc.want, got)
+		}
+		wantOther := 0
+		if tc.want[len(tc.want)-1] != "tiny=40" {
+			wantOther = len(tokens) - len(tc.want) + 1
+		}
+		if response.OtherModels != wantOther {
+			t.Fatalf("top=%d min_share=%v: got other_models %d", tc.top, tc.minShare, response.OtherModels)
+		}
+	}
+
+	for _, invalid := range [][2]string{{"0", ""}, {"x", ""}, {"", "0"}, {"", "1.5"}, {"", "NaN"}} {
+		if _, _, err := parseQSCollapse(invalid[0], invalid[1]); err == nil {
+			t.Fatalf("want an error for top=%q min_share=%q", invalid[0], invalid[1])
+		}
+	}
+}
+
+func TestAggregateMetrics_KindFilter(t *testing.T) {
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	events := []usage.UsageEvent{
+		{Timestamp: end.Add(-3 * time.Hour), Model: "gpt-4o", TotalTokens: 100, Status: 200},
+		{Timestamp: end.Add(-2 * time.Hour), Kind: usage.EventKindPing, Status: 200},
+		{Timestamp: end.Add(-time.Hour), Kind: usage.EventKindPing, Status: 200},
+	}
+	query := metricsQuery{From: end.Add(-24 * time.Hour), To: end}
+
+	response := aggregateMetrics(events, query)
+	if response.Totals.Requests != 1 || len(response.ByModel) != 1 || response.Markers[usage.EventKindPing] != 2 {
+		t.Fatalf("want requests aggregated and pings counted apart, got %+v and markers %v", response.Totals, response.Markers)
+	}
+
+	query.Kind = usage.EventKindPing
+	response = aggregateMetrics(events, query)
+	if response.Totals.Requests != 2 || response.Totals.Tokens != 0 || response.Markers != nil {
+		t.Fatalf("want only the pings, got %+v and markers %v", response.Totals, response.Markers)
+	}
+
+	query.Kind = qsKindAll
+	if response = aggregateMetrics(events, query); response.Totals.Requests != 3 || response.Totals.Tokens != 100 {
+		t.Fatalf("want every event, got %+v", response.Totals)
+	}
+
+	if kind, err := parseQSKind("request"); err != nil || kind != "" {
+		t.Fatalf("want request as the default kind, got %q (%v)", kind, err)
+	}
+	if _, err := parseQSKind("no spaces"); err == nil {
+		t.Fatal("want an error for an invalid kind")
+	}
+}
+
+func TestAggregateMetrics_BucketByCompletion(t *testing.T) {
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	started := end.Add(-61 * time.Minute)
+	events := []usage.UsageEvent{
+		{Timestamp: started, CompletedAt: started.Add(2 * time.Minute), Model: "gpt-4o", TotalTokens: 100},
+		{Timestamp: started, LatencyMs: 30_000, Model: "gpt-4o", TotalTokens: 10},
+	}
+	query := metricsQuery{From: end.Add(-24 * time.Hour), To: end}
+
+	buckets := func(response MetricsResponse) map[time.Time]int64 {
+		tokens := make(map[time.Time]int64)
+		for _, bucket := range response.Timeseries {
+			tokens[bucket.BucketStart] = bucket.Tokens
+		}
+		return tokens
+	}
+	if got := buckets(aggregateMetrics(events, query)); len(got) != 1 || got[end.Add(-2*time.Hour)] != 110 {
+		t.Fatalf("want both events in the start hour, got %v", got)
+	}
+	query.BucketBy = qsBucketByCompleted
+	if got := buckets(aggregateMetrics(events, query)); len(got) != 2 || got[end.Add(-time.Hour)] != 100 || got[end.Add(-2*time.Hour)] != 10 {
+		t.Fatalf("want the long stream in its completion hour, got %v", got)
+	}
+	if _, err := parseQSBucketBy("finished"); err == nil {
+		t.Fatal("want an error for an invalid bucket_by")
+	}
+}
+
+func TestGetQSMetrics_ServesWarmedWindows(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := usage.NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), usage.WithPeriodicFlush(false))
+	defer func() { _ = store.Close() }()
+	if err := store.Write(usage.UsageEvent{Timestamp: time.Now().Add(-30 * time.Minute), Model: "gpt-4o", TotalTokens: 10}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	h := &Handler{cfg: &config.Config{}, jsonStore: store}
+	h.cfg.UsageStore.Warm.Windows = []string{"1h", "24h", "60m", "forever"}
+	h.warmQSMetrics()
+
+	get := func(query string) MetricsResponse {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest("GET", "/v0/management/qs/metrics"+query, nil)
+		h.GetQSMetrics(c)
+		var response MetricsResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: decode %q: %v", query, recorder.Body.String(), err)
+		}
+		return response
+	}
+	for _, query := range []string{"?window=1h", "?window=60m&pretty=true", ""} {
+		if response := get(query); response.CachedAt == nil || response.Totals.Tokens != 10 {
+			t.Fatalf("%q: want a warmed response, got %+v", query, response)
+		}
+	}
+	for _, query := range []string{"?window=7d", "?window=1h&model=gpt-4o"} {
+		if response := get(query); response.CachedAt != nil || response.Totals.Tokens != 10 {
+			t.Fatalf("%q: want a fresh aggregation, got %+v", query, response)
+		}
+	}
+
+	// A stale entry is aggregated afresh
+	entry, _ := h.warmCache.get(time.Hour)
+	entry.computedAt = time.Now().Add(-3 * qsDefaultWarmInterval)
+	h.warmCache.replace(map[time.Duration]qsWarmEntry{time.Hour: entry})
+	if response := get("?window=1h"); response.CachedAt != nil {
+		t.Fatal("want a stale warmed response skipped")
+	}
+}
+
+func TestAggregateMetrics_GroupByLabel(t *testing.T) {
+	for _, invalid := range []string{"label:", "label:re gion", "label:" + strings.Repeat("x", 65)} {
+		if _, err := parseQSGroupBy(invalid); err == nil {
+			t.Fatalf("want an error for group_by=%q", invalid)
+		}
+	}
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	events := syntheticEvents(300, end)
+	for i := range events {
+		switch i % 3 {
+		case 0:
+			events[i].Labels = map[string]string{"region": "eu", "env": "prod"}
+		case 1:
+			events[i].Labels = map[string]string{"region": "us", "env": "prod"}
+		}
+	}
+	groupBy, err := parseQSGroupBy("label:region,label:env")
+	if err != nil {
+		t.Fatalf("parse group_by: %v", err)
+	}
+	query := metricsQuery{From: end.Add(-7 * 24 * time.Hour), To: end, GroupBy: groupBy}
+
+	response := aggregateMetrics(events, query)
+	if len(response.Groups) != 3 {
+		t.Fatalf("want 3 label rows, got %+v", response.Groups)
+	}
+	found := make(map[string]int64)
+	for _, row := range response.Groups {
+		if row.Model != "" || row.Provider != "" || len(row.Labels) != 2 {
+			t.Fatalf("unexpected row dimensions %+v", row)
+		}
+		found[row.Labels["region"]+"/"+row.Labels["env"]] += row.Requests
+	}
+	if found["eu/prod"] != 100 || found["us/prod"] != 100 || found["(none)/(none)"] != 100 {
+		t.Fatalf("unexpected label rows %v", found)
+	}
+}
+
+func TestAggregateMetrics_CacheSavings(t *testing.T) {
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	events := []usage.UsageEvent{
+		{Timestamp: end.Add(-time.Hour), Model: "gpt-4o", PromptTokens: 1_000_000, CachedTokens: 600_000, TotalTokens: 1_000_000},
+		{Timestamp: end.Add(-time.Hour), Model: "unpriced", PromptTokens: 1_000_000, CachedTokens: 400_000, TotalTokens: 1_000_000},
+	}
+	h := &Handler{cfg: &config.Config{}}
+	h.cfg.UsageStore.Pricing = map[string]config.UsageModelPrice{"gpt-4o": {Input: 2.5, CachedInput: 1.25}}
+	query := metricsQuery{From: end.Add(-24 * time.Hour), To: end, Pricing: h.qsPricing()}
+
+	totals := aggregateMetrics(events, query).Totals
+	if totals.CachedTokens != 1_000_000 || totals.CacheHitRatio != 0.5 {
+		t.Fatalf("want 1M cached tokens at a 0.5 hit ratio, got %+v", totals)
+	}
+	if math.Abs(totals.CacheSavingsUSD-0.75) > 1e-9 {
+		t.Fatalf("want $0.75 saved, got %v", totals.CacheSavingsUSD)
+	}
+}
+
+func TestAggregateMetrics_SortByCost(t *testing.T) {
+	if _, _, err := parseQSModelSort("latency", ""); err == nil {
+		t.Fatal("want an error for an unknown sort key")
+	}
+	if _, _, err := parseQSModelSort("cost", "up"); err == nil {
+		t.Fatal("want an error for an unknown order")
+	}
+
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	at := end.Add(-time.Hour)
+	events := []usage.UsageEvent{
+		{Timestamp: at, Model: "cheap", PromptTokens: 9000, TotalTokens: 9000},
+		{Timestamp: at, Model: "pricey", PromptTokens: 500, CompletionTokens: 500, TotalTokens: 1000},
+		{Timestamp: at, Model: "free", TotalTokens: 10},
+		{Timestamp: at, Model: "free", TotalTokens: 10},
+	}
+	pricing := map[string]config.UsageModelPrice{"cheap": {Input: 0.1}, "pricey": {Input: 10, Output: 30}}
+	query := metricsQuery{From: end.Add(-24 * time.Hour), To: end, Pricing: pricing, Sort: "cost"}
+
+	names := func(response MetricsResponse) []string {
+		var out []string
+		for _, m := range response.ByModel {
+			out = append(out, m.Model)
+		}
+		return out
+	}
+	response := aggregateMetrics(events, query)
+	if got := names(response); !reflect.DeepEqual(got, []string{"pricey", "cheap", "free"}) {
+		t.Fatalf("want cost-descending order, got %v", got)
+	}
+	if math.Abs(response.ByModel[0].CostUSD-0.02) > 1e-12 || response.ByModel[2].CostUSD != 0 {
+		t.Fatalf("unexpected costs %+v", response.ByModel)
+	}
+
+	query.Sort, query.Ascending = "requests", true
+	if got := names(aggregateMetrics(events, query)); !reflect.DeepEqual(got, []string{"cheap", "pricey", "free"}) {
+		t.Fatalf("want requests-ascending order, got %v", got)
+	}
+	query.Sort, query.Ascending = "", false
+	if got := names(aggregateMetrics(events, query)); !reflect.DeepEqual(got, []string{"cheap", "pricey", "free"}) {
+		t.Fatalf("want the default token order, got %v", got)
+	}
+}
+
+func TestAggregateMetrics_ActiveSince(t *testing.T) {
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	events := []usage.UsageEvent{
+		{Timestamp: end.Add(-20 * 24 * time.Hour), Model: "retired", TotalTokens: 500},
+		{Timestamp: end.Add(-20 * 24 * time.Hour), Model: "current", TotalTokens: 10},
+		{Timestamp: end.Add(-time.Hour), Model: "current", TotalTokens: 10},
+	}
+	query := metricsQuery{From: end.Add(-30 * 24 * time.Hour), To: end, ActiveSince: end.Add(-7 * 24 * time.Hour)}
+
+	response := aggregateMetrics(events, query)
+	if len(response.ByModel) != 1 || response.ByModel[0].Model != "current" || response.ByModel[0].Tokens != 20 {
+		t.Fatalf("want only the active model with its full-range tokens, got %+v", response.ByModel)
+	}
+	if response.InactiveModels != 1 || response.Totals.Tokens != 520 {
+		t.Fatalf("want 1 inactive model and unfiltered totals, got %d and %+v", response.InactiveModels, response.Totals)
+	}
+}
+
+func TestAggregateMetrics_IncludeDelta(t *testing.T) {
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	events := []usage.UsageEvent{
+		{Timestamp: end.Add(-150 * time.Minute), Model: "m", TotalTokens: 10},
+		{Timestamp: end.Add(-90 * time.Minute), Model: "m", TotalTokens: 30},
+		{Timestamp: end.Add(-80 * time.Minute), Model: "m", TotalTokens: 5},
+		{Timestamp: end.Add(-30 * time.Minute), Model: "m", TotalTokens: 15},
+	}
+	query := metricsQuery{From: end.Add(-3 * time.Hour), To: end}
+
+	if ts := aggregateMetrics(events, query).Timeseries; ts[0].TokensDelta != nil {
+		t.Fatal("want no deltas unless requested")
+	}
+	query.IncludeDelta = true
+	ts := aggregateMetrics(events, query).Timeseries
+	if len(ts) != 3 {
+		t.Fatalf("want 3 buckets, got %+v", ts)
+	}
+	wantTokens := []int64{0, 25, -20}
+	wantRequests := []int64{0, 1, -1}
+	for i := range ts {
+		if *ts[i].TokensDelta != wantTokens[i] || *ts[i].RequestsDelta != wantRequests[i] {
+			t.Fatalf("bucket %d: want deltas %d/%d, got %d/%d", i, wantTokens[i], wantRequests[i], *ts[i].TokensDelta, *ts[i].RequestsDelta)
+		}
+	}
+}
+
+func TestAggregateMetrics_CostPerRequest(t *testing.T) {
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	events := []usage.UsageEvent{
+		{Timestamp: end.Add(-150 * time.Minute), Model: "m", PromptTokens: 1_000_000, TotalTokens: 1_000_000},
+		{Timestamp: end.Add(-90 * time.Minute), Model: "m", PromptTokens: 3_000_000, TotalTokens: 3_000_000},
+		{Timestamp: end.Add(-80 * time.Minute), Model: "unpriced", TotalTokens: 5},
+	}
+	query := metricsQuery{
+		From:     end.Add(-3 * time.Hour),
+		To:       end,
+		Pricing:  map[string]config.UsageModelPrice{"m": {Input: 2}},
+		FillGaps: true,
+	}
+	if ts := aggregateMetrics(events, query).Timeseries; ts[0].CostPerRequest != nil {
+		t.Fatal("want no cost per request unless requested")
+	}
+
+	query.CostPerRequest = true
+	query.Workers = 2
+	ts := aggregateMetrics(events, query).Timeseries
+	if len(ts) != 4 || ts[0].CostPerRequest == nil || *ts[0].CostPerRequest != 2 {
+		t.Fatalf("want $2 per request in the first bucket, got %+v", ts)
+	}
+	// $6 over a priced and an unpriced request
+	if ts[1].CostPerRequest == nil || *ts[1].CostPerRequest != 3 {
+		t.Fatalf("want $3 per request in the second bucket, got %+v", ts[1])
+	}
+	if ts[2].Requests != 0 || ts[2].CostPerRequest != nil {
+		t.Fatalf("want the empty bucket without a cost per request, got %+v", ts[2])
+	}
+}
+
+func TestAggregateMetrics_FillGaps(t *testing.T) {
+	end := time.Date(2025, 11, 25, 12, 30, 0, 0, time.UTC)
+	events := []usage.UsageEvent{
+		{Timestamp: end.Add(-5 * time.Hour), Model: "m", TotalTokens: 10},
+		{Timestamp: end.Add(-time.Hour), Model: "m", TotalTokens: 30},
+	}
+	query := metricsQuery{From: end.Add(-6 * time.Hour), To: end}
+
+	if ts := aggregateMetrics(events, query).Timeseries; len(ts) != 2 {
+		t.Fatalf("want only the 2 busy buckets by default, got %+v", ts)
+	}
+	query.FillGaps, query.IncludeDelta = true, true
+	ts := aggregateMetrics(events, query).Timeseries
+	if len(ts) != 7 || !ts[0].BucketStart.Equal(query.From.Truncate(time.Hour)) || !ts[6].BucketStart.Equal(end.Truncate(time.Hour)) {
+		t.Fatalf("want 7 hourly buckets from 06:00 to 12:00, got %+v", ts)
+	}
+	if ts[1].Tokens != 10 || ts[5].Tokens != 30 || ts[2].Tokens != 0 || *ts[2].TokensDelta != -10 {
+		t.Fatalf("unexpected filled series %+v", ts)
+	}
+
+	// A day served from rollups keeps a single daily bucket
+	day := time.Date(2025, 11, 23, 0, 0, 0, 0, time.UTC)
+	query = metricsQuery{
+		From:     day,
+		To:       day.Add(36 * time.Hour),
+		FillGaps: true,
+		Rollups:  []usage.Rollup{{Period: "day", Start: day, ByModel: map[string]usage.ModelTotals{"m": {Tokens: 5, Requests: 1}}}},
+	}
+	ts = aggregateMetrics(nil, query).Timeseries
+	if len(ts) != 14 || ts[0].Tokens != 5 || !ts[1].BucketStart.Equal(day.AddDate(0, 0, 1)) {
+		t.Fatalf("want the rollup day then 13 hourly buckets, 14 in all, got %d: %+v", len(ts), ts)
+	}
+}
+
+func TestCompareModels(t *testing.T) {
+	a := []ModelMetrics{{Model: "gpt-4o", Tokens: 150, Requests: 3}, {Model: "new", Tokens: 10, Requests: 1}}
+	b := []ModelMetrics{{Model: "gpt-4o", Tokens: 100, Requests: 2}, {Model: "gone", Tokens: 80, Requests: 4}}
+
+	deltas := compareModels(a, b)
+	if len(deltas) != 3 || deltas[0].Model != "gone" || deltas[1].Model != "gpt-4o" || deltas[2].Model != "new" {
+		t.Fatalf("unexpected order: %+v", deltas)
+	}
+	if d := deltas[1]; d.Tokens != 50 || d.Requests != 1 || d.TokensChangePct == nil || *d.TokensChangePct != 50 {
+		t.Fatalf("unexpected gpt-4o delta: %+v", d)
+	}
+	if deltas[0].Tokens != -80 || deltas[2].TokensChangePct != nil {
+		t.Fatalf("unexpected deltas for models in one window only: %+v", deltas)
+	}
+}
+
+func TestAggregateMetrics_TotalsOnly(t *testing.T) {
+	if _, err := parseQSView("summary"); err == nil {
+		t.Fatal("want an error for an unknown view")
+	}
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	events := syntheticEvents(1000, end)
+	query := metricsQuery{From: end.Add(-3 * 24 * time.Hour), To: end, Model: "gpt-4o"}
+	full := aggregateMetrics(events, query)
+
+	query.TotalsOnly = true
+	totals := aggregateMetrics(events, query)
+	if !reflect.DeepEqual(totals.Totals, full.Totals) {
+		t.Fatalf("want the full view's totals %+v, got %+v", full.Totals, totals.Totals)
+	}
+	if len(totals.ByModel) != 0 || len(totals.Timeseries) != 0 || totals.Totals.Requests == 0 {
+		t.Fatalf("want only totals, got %d models and %d buckets", len(totals.ByModel), len(totals.Timeseries))
+	}
+}
+
+func TestGetQSMetrics_Fields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := usage.NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), usage.WithPeriodicFlush(false))
+	defer func() { _ = store.Close() }()
+	if err := store.Write(usage.UsageEvent{Timestamp: time.Now().Add(-30 * time.Minute), Model: "gpt-4o", TotalTokens: 10}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	h := &Handler{cfg: &config.Config{}, jsonStore: store}
+
+	get := func(query string) (int, map[string]json.RawMessage) {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest("GET", "/v0/management/qs/metrics"+query, nil)
+		h.GetQSMetrics(c)
+		var sections map[string]json.RawMessage
+		if err := json.Unmarshal(recorder.Body.Bytes(), &sections); err != nil {
+			t.Fatalf("%s: decode %q: %v", query, recorder.Body.String(), err)
+		}
+		return recorder.Code, sections
+	}
+	code, sections := get("?window=24h&fields=totals,timeseries")
+	if code != 200 || sections["totals"] == nil || sections["timeseries"] == nil || sections["by_model"] != nil || sections["bucket_seconds"] == nil {
+		t.Fatalf("want totals, timeseries and metadata only, got %d %v", code, slices.Sorted(maps.Keys(sections)))
+	}
+	if code, sections = get("?window=24h"); sections["by_model"] == nil {
+		t.Fatalf("want every section by default, got %d %v", code, slices.Sorted(maps.Keys(sections)))
+	}
+	if code, _ = get("?fields=totals,cost"); code != 400 {
+		t.Fatalf("want 400 for an unknown field, got %d", code)
+	}
+
+	// Unselected sections are not computed
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	events := syntheticEvents(1000, end)
+	query := metricsQuery{From: end.Add(-3 * 24 * time.Hour), To: end, Fields: map[string]bool{"totals": true, "by_model": true}}
+	response := aggregateMetrics(events, applyQSFields(query))
+	if len(response.Timeseries) != 0 || len(response.ByModel) == 0 {
+		t.Fatalf("want by_model without timeseries, got %d models and %d buckets", len(response.ByModel), len(response.Timeseries))
+	}
+	if query = applyQSFields(metricsQuery{Fields: map[string]bool{"totals": true}}); !query.TotalsOnly {
+		t.Fatal("want fields=totals to aggregate like view=totals")
+	}
+}
+
+func BenchmarkAggregateMetrics(b *testing.B) {
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	events := syntheticEvents(2_000_000, end)
+	query := metricsQuery{From: end.Add(-7 * 24 * time.Hour), To: end}
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		query.Workers = workers
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				aggregateMetrics(events, query)
+			}
+		})
+	}
+	query.Workers = 1
+	query.TotalsOnly = true
+	b.Run("view=totals", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			aggregateMetrics(events, query)
+		}
+	})
+}
+
+func TestAggregateFiles_MatchesOneAggregation(t *testing.T) {
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	events := syntheticEvents(25_000, end)
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json")}
+	for i, path := range paths {
+		store := usage.NewJSONStore(path, usage.WithPeriodicFlush(false))
+		for _, event := range events[i*len(events)/2 : (i+1)*len(events)/2] {
+			if err := store.Write(event); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+		}
+		if err := store.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+	}
+
+	from := end.Add(-7 * 24 * time.Hour)
+	combined, err := AggregateFiles(paths, AggregateOptions{From: from, To: end, GroupBy: []string{"model"}})
+	if err != nil {
+		t.Fatalf("aggregate files: %v", err)
+	}
+	want := aggregateMetrics(events, metricsQuery{From: from, To: end, GroupBy: []string{"model"}, Sort: "tokens"})
+	if !reflect.DeepEqual(combined.Totals, want.Totals) || !reflect.DeepEqual(combined.ByModel, want.ByModel) ||
+		!reflect.DeepEqual(combined.Timeseries, want.Timeseries) || !reflect.DeepEqual(combined.Groups, want.Groups) {
+		t.Fatalf("combined aggregation differs:\n got %+v\nwant %+v", combined.Totals, want.Totals)
+	}
+
+	if _, err := AggregateFiles(paths, AggregateOptions{GroupBy: []string{"color"}}); err == nil {
+		t.Fatal("want an invalid group_by rejected")
+	}
+}
+
+func TestPostQSCombinedMetrics_ValidatesStores(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := usage.NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), usage.WithPeriodicFlush(false))
+	defer func() { _ = store.Close() }()
+	if err := store.Write(usage.UsageEvent{Timestamp: time.Now().Add(-time.Hour), Model: "gpt-4o", TotalTokens: 10}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	h := &Handler{cfg: &config.Config{}, jsonStore: store}
+
+	post := func(body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest("POST", "/v0/management/qs/metrics/combined", strings.NewReader(body))
+		h.PostQSCombinedMetrics(c)
+		return recorder
+	}
+	recorder := post(`{"stores":["main"]}`)
+	var response MetricsResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || recorder.Code != 200 || response.Totals.Tokens != 10 {
+		t.Fatalf("want the main store aggregated, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	for _, body := range []string{`{}`, `{"stores":["main","main"]}`, `{"stores":["other"]}`, `{"stores":["segment:usage.json.missing"]}`} {
+		if recorder := post(body); recorder.Code != 400 {
+			t.Fatalf("%s: want 400, got %d %s", body, recorder.Code, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,520 @@
+package management
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+var (
+	qsOpenAPIOnce sync.Once
+	qsOpenAPISpec map[string]any
+)
+
+// GetQSOpenAPI serves an OpenAPI 3 description of the metrics endpoints.
+// GET /v0/management/qs/openapi.json
+//
+// Response schemas are generated from the handlers' Go structs through their
+// json tags, so the spec follows the structs as they change.
+func (h *Handler) GetQSOpenAPI(c *gin.Context) {
+	qsOpenAPIOnce.Do(func() {
+		qsOpenAPISpec = buildQSOpenAPISpec()
+	})
+	c.JSON(http.StatusOK, qsOpenAPISpec)
+}
+
+// qsOpenAPIParam describes one query parameter of an endpoint.
+type qsOpenAPIParam struct {
+	name        string
+	typ         string
+	description string
+	required    bool
+}
+
+var (
+	qsParamFrom         = qsOpenAPIParam{name: "from", typ: "string", description: "Range start as RFC3339 or Unix epoch seconds/milliseconds; defaults to 24h before 'to'"}
+	qsParamTo           = qsOpenAPIParam{name: "to", typ: "string", description: "Range end as RFC3339 or Unix epoch seconds/milliseconds; defaults to now"}
+	qsParamIncludeDelta = qsOpenAPIParam{name: "include_delta", typ: "boolean", description: "Add tokens_delta and requests_delta, the change from the previous bucket, to each timeseries bucket"}
+	qsParamNonzeroOnly  = qsOpenAPIParam{name: "nonzero_only", typ: "boolean", description: "true (default) lists only timeseries buckets with activity; false zero-fills every bucket of the range, at most 10000"}
+	qsParamModel        = qsOpenAPIParam{name: "model", typ: "string", description: "Only include events of this model; the unknown-model label, \"(unknown)\" by default, selects events without one"}
+	qsParamTenant       = qsOpenAPIParam{name: "tenant", typ: "string", description: "Use a tenant's own store"}
+
+	qsParamRequestIDPrefix = qsOpenAPIParam{name: "request_id_prefix", typ: "string", description: "Only include events whose request ID starts with this prefix"}
+)
+
+// buildQSOpenAPISpec assembles the spec document.
+func buildQSOpenAPISpec() map[string]any {
+	schemas := qsOpenAPISchemas{components: make(map[string]any)}
+	errorSchema := map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"error": map[string]any{"type": "string"}},
+	}
+
+	paths := map[string]any{
+		"/qs/health": qsOpenAPIGet("Health check with all-time totals", nil, map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"ok":                map[string]any{"type": "boolean"},
+				"total_requests":    map[string]any{"type": "integer", "format": "int64"},
+				"total_tokens":      map[string]any{"type": "integer", "format": "int64"},
+				"totals_since":      map[string]any{"type": "string", "format": "date-time"},
+				"disk_bytes":        map[string]any{"type": "integer", "format": "int64"},
+				"self_check":        schemas.ref(reflect.TypeOf(usage.SelfCheckResult{})),
+				"token_adjustments": schemas.ref(reflect.TypeOf(usage.TokenAdjustments{})),
+			},
+		}, errorSchema),
+		"/qs/metrics": qsOpenAPIGet("Aggregated usage metrics", []qsOpenAPIParam{
+			qsParamFrom, qsParamTo, qsParamModel,
+			{name: "window", typ: "string", description: "Range ending now instead of from and to, in days (7d) or a Go duration (1h); served from memory when usage-store.warm precomputes it"},
+			{name: "sparklines", typ: "boolean", description: "Add 24 hourly sparkline points to each model"},
+			{name: "exclude_suspicious", typ: "boolean", description: "Skip events above the token sanity cap"},
+			{name: "buckets", typ: "integer", description: "Approximate number of timeseries buckets"},
+			qsParamRequestIDPrefix,
+			{name: "group_by", typ: "string", description: "Comma-separated pivot dimensions: model, family, provider, status, api_key_hash, label:<name>"},
+			{name: "sort", typ: "string", description: "Order by_model by tokens (default), cost or requests"},
+			{name: "order", typ: "string", description: "desc (default) or asc"},
+			{name: "active_since", typ: "string", description: "Only list models with an event since this time in by_model; same formats as from"},
+			{name: "top", typ: "integer", description: "Merge all but the N models with the most tokens into (other) in by_model"},
+			{name: "min_share", typ: "number", description: "Merge models below this fraction of all tokens, e.g. 0.02, into (other) in by_model"},
+			{name: "bucket_by", typ: "string", description: "started (default) buckets the timeseries and sparklines by receive time, completed by response completion time"},
+			{name: "kind", typ: "string", description: "request (default) aggregates proxied requests and counts markers such as health pings under markers; all, or a marker kind such as ping, aggregates those events instead"},
+			qsParamIncludeDelta,
+			{name: "cost_per_request", typ: "boolean", description: "Add cost_per_request, the bucket's estimated cost over its requests, to each timeseries bucket with requests"},
+			qsParamNonzeroOnly,
+			{name: "view", typ: "string", description: "full (default) or totals, which computes only totals and leaves by_model and timeseries empty"},
+			{name: "fields", typ: "string", description: "Comma-separated sections to return (totals, by_model, timeseries, groups, markers); the others are omitted and not computed. Default all"},
+			qsParamTenant,
+			{name: "pretty", typ: "boolean", description: "Indent the JSON response"},
+		}, schemas.ref(reflect.TypeOf(MetricsResponse{})), errorSchema),
+		"/qs/summary": qsOpenAPIGet("Cheap KPIs from the in-memory recent cache", []qsOpenAPIParam{
+			{name: "window", typ: "string", description: "Go duration up to 24h, default 15m"},
+		}, schemas.ref(reflect.TypeOf(SummaryResponse{})), errorSchema),
+		"/qs/slo": qsOpenAPIGet("Error budget per upstream provider", []qsOpenAPIParam{
+			{name: "window", typ: "string", description: "Days (30d) or Go duration, default 30d"},
+		}, schemas.ref(reflect.TypeOf(SLOResponse{})), errorSchema),
+		"/qs/compare": qsOpenAPIGet("Aggregates of two windows and their differences (A - B)", []qsOpenAPIParam{
+			{name: "a_from", typ: "string", description: "Start of window A", required: true},
+			{name: "a_to", typ: "string", description: "End of window A", required: true},
+			{name: "b_from", typ: "string", description: "Start of window B", required: true},
+			{name: "b_to", typ: "string", description: "End of window B", required: true},
+			qsParamModel, qsParamTenant,
+		}, schemas.ref(reflect.TypeOf(CompareResponse{})), errorSchema),
+		"/qs/metrics/weekly": qsOpenAPIGet("Usage grouped by ISO week, optionally split into business and off hours", []qsOpenAPIParam{
+			qsParamFrom, qsParamTo, qsParamModel,
+			{name: "business_hours", typ: "boolean", description: "Split each week into business-hours and off-hours traffic"},
+			{name: "exclude_suspicious", typ: "boolean", description: "Skip events above the token sanity cap"},
+			qsParamTenant,
+		}, schemas.ref(reflect.TypeOf(WeeklyMetricsResponse{})), errorSchema),
+		"/qs/metrics/hour-of-day": qsOpenAPIGet("Usage by hour of the day (0-23) across the range, summed and averaged per day", []qsOpenAPIParam{
+			qsParamFrom, qsParamTo,
+			{name: "tz", typ: "string", description: "IANA time zone hours are read in (default UTC)"},
+			qsParamModel,
+			{name: "exclude_suspicious", typ: "boolean", description: "Skip events above the token sanity cap"},
+			qsParamTenant,
+		}, schemas.ref(reflect.TypeOf(HourOfDayResponse{})), errorSchema),
+		"/qs/metrics/day-of-week": qsOpenAPIGet("Usage by day of the week (Monday to Sunday) across the range, summed and averaged per day", []qsOpenAPIParam{
+			qsParamFrom, qsParamTo,
+			{name: "tz", typ: "string", description: "IANA time zone days are read in (default UTC)"},
+			qsParamModel,
+			{name: "exclude_suspicious", typ: "boolean", description: "Skip events above the token sanity cap"},
+			qsParamTenant,
+		}, schemas.ref(reflect.TypeOf(DayOfWeekResponse{})), errorSchema),
+		"/qs/latency": qsOpenAPIGet("Latency percentiles (p50/p90/p99/max) per provider or model", []qsOpenAPIParam{
+			qsParamFrom, qsParamTo, qsParamModel,
+			{name: "group_by", typ: "string", description: "provider (default) or model"},
+			{name: "exclude_suspicious", typ: "boolean", description: "Skip events above the token sanity cap"},
+			qsParamTenant,
+		}, schemas.ref(reflect.TypeOf(LatencyResponse{})), errorSchema),
+		"/qs/range": qsOpenAPIGet("Earliest and latest event timestamps and the event count, for default date ranges", []qsOpenAPIParam{
+			qsParamTenant,
+		}, schemas.ref(reflect.TypeOf(RangeResponse{})), errorSchema),
+		"/qs/config": qsOpenAPIGet("Effective usage store settings and the usage-store configuration, secrets redacted", []qsOpenAPIParam{
+			qsParamTenant,
+		}, schemas.ref(reflect.TypeOf(QSConfigResponse{})), errorSchema),
+		"/qs/top-requests": qsOpenAPIGet("The individual requests with the most tokens or the highest estimated cost", []qsOpenAPIParam{
+			qsParamFrom, qsParamTo, qsParamModel,
+			{name: "by", typ: "string", description: "tokens (default) or cost; cost skips models without a configured price"},
+			{name: "limit", typ: "integer", description: "Requests to return, default 20, max 1000"},
+			{name: "exclude_suspicious", typ: "boolean", description: "Skip events above the token sanity cap"},
+			qsParamTenant,
+		}, schemas.ref(reflect.TypeOf(TopRequestsResponse{})), errorSchema),
+		"/qs/size-mix": qsOpenAPIGet("Requests per time bucket split into small, medium and large by token count", []qsOpenAPIParam{
+			qsParamFrom, qsParamTo, qsParamModel,
+			{name: "tokens", typ: "string", description: "Count to categorize by: total (default), prompt or completion"},
+			{name: "interval", typ: "string", description: "minute, hour (default) or day"},
+			{name: "buckets", typ: "integer", description: "Approximate number of buckets; overrides interval"},
+			{name: "exclude_suspicious", typ: "boolean", description: "Skip events above the token sanity cap"},
+			qsParamTenant,
+		}, schemas.ref(reflect.TypeOf(SizeMixResponse{})), errorSchema),
+		"/qs/report": map[string]any{
+			"get": map[string]any{
+				"summary": "Monthly usage statement per API key hash, with estimated costs",
+				"parameters": qsOpenAPIParams([]qsOpenAPIParam{
+					{name: "month", typ: "string", description: "UTC calendar month as YYYY-MM; defaults to the current month"},
+					qsParamTenant,
+				}),
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": "Report as JSON, or CSV (one row per key and model) for Accept: text/csv",
+						"content": map[string]any{
+							"application/json": map[string]any{"schema": schemas.ref(reflect.TypeOf(UsageReportResponse{}))},
+							"text/csv":         map[string]any{"schema": map[string]any{"type": "string"}},
+						},
+					},
+					"default": qsOpenAPIJSONResponse("Error", errorSchema),
+				},
+			},
+		},
+		"/qs/buffer": qsOpenAPIGet("Events buffered in memory and not yet on disk", []qsOpenAPIParam{qsParamTenant}, map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"buffered": map[string]any{"type": "integer"}},
+		}, errorSchema),
+		"/qs/flush": map[string]any{
+			"post": map[string]any{
+				"summary":    "Flush buffered events to disk",
+				"parameters": qsOpenAPIParams([]qsOpenAPIParam{qsParamTenant}),
+				"responses": map[string]any{
+					"200": qsOpenAPIJSONResponse("OK", map[string]any{
+						"type":       "object",
+						"properties": map[string]any{"flushed": map[string]any{"type": "integer"}},
+					}),
+					"default": qsOpenAPIJSONResponse("Error", errorSchema),
+				},
+			},
+		},
+		"/qs/counters/reset": map[string]any{
+			"post": map[string]any{
+				"summary":    "Zero the running counters behind /qs/summary all_time and /qs/health without deleting data",
+				"parameters": qsOpenAPIParams([]qsOpenAPIParam{qsParamTenant}),
+				"responses": map[string]any{
+					"200": qsOpenAPIJSONResponse("OK", map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"reset": map[string]any{"type": "boolean"},
+							"since": map[string]any{"type": "string", "format": "date-time"},
+						},
+					}),
+					"default": qsOpenAPIJSONResponse("Error", errorSchema),
+				},
+			},
+		},
+		"/qs/replay": map[string]any{
+			"post": map[string]any{
+				"summary":    "Re-send stored events in a range to the configured replay sink",
+				"parameters": qsOpenAPIParams([]qsOpenAPIParam{qsParamFrom, qsParamTo}),
+				"responses": map[string]any{
+					"200": qsOpenAPIJSONResponse("OK", map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"sink":     map[string]any{"type": "string"},
+							"replayed": map[string]any{"type": "integer", "format": "int64"},
+						},
+					}),
+					"default": qsOpenAPIJSONResponse("Error", errorSchema),
+				},
+			},
+		},
+		"/qs/import": map[string]any{
+			"post": map[string]any{
+				"summary": "Write the usage events of a JSON lines body, optionally gzipped, into the store",
+				"parameters": qsOpenAPIParams([]qsOpenAPIParam{
+					{name: "dedupe", typ: "boolean", description: "Leave out events the store already holds"},
+					qsParamTenant,
+				}),
+				"requestBody": map[string]any{
+					"required": true,
+					"content":  map[string]any{"application/x-ndjson": map[string]any{"schema": schemas.ref(reflect.TypeOf(usage.UsageEvent{}))}},
+				},
+				"responses": map[string]any{
+					"200":     qsOpenAPIJSONResponse("OK", schemas.ref(reflect.TypeOf(usage.ImportResult{}))),
+					"default": qsOpenAPIJSONResponse("Error", errorSchema),
+				},
+			},
+		},
+		"/qs/validate": qsOpenAPIGet("Integrity scan of the store file", nil,
+			schemas.ref(reflect.TypeOf(usage.ValidationReport{})), errorSchema),
+		"/qs/metrics/by-key-timeseries": qsOpenAPIGet("Usage over time for one API key hash", []qsOpenAPIParam{
+			{name: "api_key_hash", typ: "string", description: "Hashed API key", required: true},
+			{name: "interval", typ: "string", description: "minute, hour or day"},
+			{name: "buckets", typ: "integer", description: "Approximate number of buckets; overrides interval"},
+			qsParamFrom, qsParamTo, qsParamIncludeDelta, qsParamNonzeroOnly,
+		}, schemas.ref(reflect.TypeOf(KeyTimeseriesResponse{})), errorSchema),
+		"/qs/events/export": qsOpenAPIExport(schemas.ref(reflect.TypeOf(usage.UsageEvent{})), errorSchema),
+		"/qs/events/follow": map[string]any{
+			"get": map[string]any{
+				"summary":    "Server-Sent Events stream of events as they are flushed",
+				"parameters": qsOpenAPIParams([]qsOpenAPIParam{qsParamModel, qsParamTenant}),
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": "One data: line of UsageEvent JSON per flushed event; a final 'end' event when the stream is closed by the server",
+						"content":     map[string]any{"text/event-stream": map[string]any{"schema": map[string]any{"type": "string"}}},
+					},
+					"429":     qsOpenAPIJSONResponse("Too many followers", errorSchema),
+					"default": qsOpenAPIJSONResponse("Error", errorSchema),
+				},
+			},
+		},
+		"/qs/export.parquet": map[string]any{
+			"get": map[string]any{
+				"summary":    "Stream raw events as a Parquet file",
+				"parameters": qsOpenAPIParams([]qsOpenAPIParam{qsParamFrom, qsParamTo, qsParamModel}),
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": "Snappy-compressed Parquet file",
+						"content": map[string]any{
+							"application/vnd.apache.parquet": map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+						},
+					},
+					"default": qsOpenAPIJSONResponse("Error", errorSchema),
+				},
+			},
+		},
+		"/qs/download": map[string]any{
+			"get": map[string]any{
+				"summary": "Download the raw store files for backups, flushed and captured consistently",
+				"parameters": qsOpenAPIParams([]qsOpenAPIParam{
+					{name: "format", typ: "string", description: "jsonl (default; the files concatenated) or tar.gz (the files bundled unchanged)"},
+					{name: "segments", typ: "boolean", description: "Include rotated segments, oldest first, before the live file"},
+					qsParamTenant,
+				}),
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": "The store files as one JSON Lines stream or a tar.gz archive",
+						"content": map[string]any{
+							"application/x-ndjson": map[string]any{"schema": map[string]any{"type": "string"}},
+							"application/gzip":     map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+						},
+					},
+					"default": qsOpenAPIJSONResponse("Error", errorSchema),
+				},
+			},
+		},
+		"/qs/events": qsOpenAPIGet("Page through persisted events with stable cursors", []qsOpenAPIParam{
+			{name: "order", typ: "string", description: "asc (oldest first, default) or desc"},
+			{name: "limit", typ: "integer", description: "Events per page, default 100, max 1000"},
+			{name: "cursor", typ: "string", description: "next_cursor or prev_cursor from the previous page"},
+			qsParamModel, qsParamRequestIDPrefix,
+		}, schemas.ref(reflect.TypeOf(EventsPageResponse{})), errorSchema),
+		"/qs/events/tail": qsOpenAPIGet("Events after a cursor for incremental consumers", []qsOpenAPIParam{
+			{name: "after", typ: "string", description: "Cursor from the previous call or an RFC3339 timestamp"},
+			{name: "generation", typ: "string", description: "Generation returned with the cursor, to detect a rotation since"},
+			{name: "limit", typ: "integer", description: "Maximum events to return, default 1000"},
+		}, schemas.ref(reflect.TypeOf(TailResponse{})), errorSchema),
+		"/qs/events/recent": qsOpenAPIGet("Most recent events from the in-memory cache", []qsOpenAPIParam{
+			{name: "n", typ: "integer", description: "Number of events, default 100"},
+		}, map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"events": map[string]any{"type": "array", "items": schemas.ref(reflect.TypeOf(usage.UsageEvent{}))},
+			},
+		}, errorSchema),
+	}
+	paths["/qs/metrics/combined"] = map[string]any{
+		"post": map[string]any{
+			"summary":    "One metrics aggregation over several usage stores",
+			"parameters": qsOpenAPIParams([]qsOpenAPIParam{{name: "pretty", typ: "boolean", description: "Indent the JSON response"}}),
+			"requestBody": map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": schemas.ref(reflect.TypeOf(CombinedMetricsRequest{}))}},
+			},
+			"responses": map[string]any{
+				"200":     qsOpenAPIJSONResponse("OK", schemas.ref(reflect.TypeOf(MetricsResponse{}))),
+				"default": qsOpenAPIJSONResponse("Error", errorSchema),
+			},
+		},
+	}
+	paths["/qs/metrics"].(map[string]any)["post"] = map[string]any{
+		"summary":    "Aggregated usage metrics with filters from a JSON body",
+		"parameters": qsOpenAPIParams([]qsOpenAPIParam{qsParamTenant, {name: "pretty", typ: "boolean", description: "Indent the JSON response"}}),
+		"requestBody": map[string]any{
+			"required": true,
+			"content":  map[string]any{"application/json": map[string]any{"schema": schemas.ref(reflect.TypeOf(MetricsQueryRequest{}))}},
+		},
+		"responses": map[string]any{
+			"200":     qsOpenAPIJSONResponse("OK", schemas.ref(reflect.TypeOf(MetricsResponse{}))),
+			"default": qsOpenAPIJSONResponse("Error", errorSchema),
+		},
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "CLIProxyAPI usage metrics",
+			"version": "1",
+		},
+		"servers":  []any{map[string]any{"url": "/v0/management"}},
+		"security": []any{map[string]any{"bearerAuth": []any{}}, map[string]any{"managementKey": []any{}}},
+		"paths":    paths,
+		"components": map[string]any{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]any{
+				"bearerAuth":    map[string]any{"type": "http", "scheme": "bearer"},
+				"managementKey": map[string]any{"type": "apiKey", "in": "header", "name": "X-Management-Key"},
+			},
+		},
+	}
+}
+
+// qsOpenAPIGet describes a GET endpoint returning JSON.
+func qsOpenAPIGet(summary string, params []qsOpenAPIParam, okSchema, errorSchema map[string]any) map[string]any {
+	return map[string]any{
+		"get": map[string]any{
+			"summary":    summary,
+			"parameters": qsOpenAPIParams(params),
+			"responses": map[string]any{
+				"200":     qsOpenAPIJSONResponse("OK", okSchema),
+				"default": qsOpenAPIJSONResponse("Error", errorSchema),
+			},
+		},
+	}
+}
+
+// qsOpenAPIExport describes the CSV/NDJSON export endpoint.
+func qsOpenAPIExport(eventSchema, errorSchema map[string]any) map[string]any {
+	params := qsOpenAPIParams([]qsOpenAPIParam{
+		{name: "format", typ: "string", description: "csv (default) or ndjson"},
+		{name: "columns", typ: "string", description: "Comma-separated CSV columns in output order, e.g. timestamp,model,total_tokens,status"},
+		qsParamFrom, qsParamTo, qsParamModel,
+	})
+	headers := map[string]any{
+		"X-Row-Count": map[string]any{"schema": map[string]any{"type": "integer"}, "description": "Number of exported events"},
+	}
+	return map[string]any{
+		"get": map[string]any{
+			"summary":    "Stream raw events as CSV or NDJSON",
+			"parameters": params,
+			"responses": map[string]any{
+				"200": map[string]any{
+					"description": "Exported events",
+					"headers":     headers,
+					"content": map[string]any{
+						"text/csv":             map[string]any{"schema": map[string]any{"type": "string"}},
+						"application/x-ndjson": map[string]any{"schema": eventSchema},
+					},
+				},
+				"default": qsOpenAPIJSONResponse("Error", errorSchema),
+			},
+		},
+		"head": map[string]any{
+			"summary":    "Row count and Content-Length of the export without the body",
+			"parameters": params,
+			"responses": map[string]any{
+				"200": map[string]any{"description": "Export headers", "headers": headers},
+			},
+		},
+	}
+}
+
+func qsOpenAPIParams(params []qsOpenAPIParam) []any {
+	out := make([]any, 0, len(params))
+	for _, p := range params {
+		out = append(out, map[string]any{
+			"name":        p.name,
+			"in":          "query",
+			"required":    p.required,
+			"description": p.description,
+			"schema":      map[string]any{"type": p.typ},
+		})
+	}
+	return out
+}
+
+func qsOpenAPIJSONResponse(description string, schema map[string]any) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": schema},
+		},
+	}
+}
+
+// qsOpenAPISchemas generates JSON schemas from Go types, registering named
+// structs as reusable components.
+type qsOpenAPISchemas struct {
+	components map[string]any
+}
+
+var qsTimeType = reflect.TypeOf(time.Time{})
+
+// ref returns the schema for t, referencing a component for named structs.
+func (s qsOpenAPISchemas) ref(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == qsTimeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		if _, ok := s.components[t.Name()]; !ok {
+			// Register before recursing so self-referencing types terminate
+			s.components[t.Name()] = map[string]any{}
+			s.components[t.Name()] = s.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	case t.Kind() == reflect.Struct:
+		return s.object(t)
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": s.ref(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.ref(t.Elem())}
+	default:
+		return map[string]any{}
+	}
+}
+
+// object builds an object schema from the exported, json-tagged fields of t.
+// Fields without omitempty are listed as required.
+func (s qsOpenAPISchemas) object(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	s.addFields(t, properties, &required)
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// addFields adds the json fields of t to properties, flattening untagged
+// embedded structs as encoding/json does.
+func (s qsOpenAPISchemas) addFields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			s.addFields(field.Type, properties, required)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = s.ref(field.Type)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package management
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/parquet-go/parquet-go"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+func TestExportQSEventsParquet_RoundTrip(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := usage.NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), usage.WithPeriodicFlush(false))
+	defer func() { _ = store.Close() }()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	written := []usage.UsageEvent{
+		{Timestamp: now.Add(-3 * time.Hour), Model: "gpt-4o", Provider: "openai", PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15, CachedTokens: 2, Status: 200, RequestID: "req-1", UpstreamRequestID: "up-1", APIKeyHash: "hash-1", LatencyMs: 120},
+		{Timestamp: now.Add(-2 * time.Hour), Model: "claude-sonnet", Provider: "anthropic", TotalTokens: 30, Status: 529, RequestID: "req-2", LatencyMs: 900, Suspicious: true},
+		{Timestamp: now.Add(-time.Hour), Model: "gpt-4o", Provider: "openai", TotalTokens: 7, Status: 200, RequestID: "req-3"},
+		// Outside the requested range
+		{Timestamp: now.Add(-48 * time.Hour), Model: "gpt-4o", TotalTokens: 99, Status: 200, RequestID: "req-old"},
+	}
+	for _, event := range written {
+		if err := store.Write(event); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	h := &Handler{cfg: &config.Config{}, jsonStore: store}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	query := url.Values{"from": {now.Add(-4 * time.Hour).Format(time.RFC3339)}, "to": {now.Format(time.RFC3339Nano)}}
+	c.Request = httptest.NewRequest("GET", "/v0/management/qs/export.parquet?"+query.Encode(), nil)
+	h.ExportQSEventsParquet(c)
+	if recorder.Code != 200 || recorder.Header().Get("Content-Type") != "application/vnd.apache.parquet" {
+		t.Fatalf("unexpected response %d %v", recorder.Code, recorder.Header())
+	}
+
+	data := recorder.Body.Bytes()
+	file, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("open parquet: %v", err)
+	}
+	for _, column := range []string{"timestamp", "model", "provider", "prompt_tokens", "completion_tokens", "total_tokens", "cached_tokens", "status", "request_id", "upstream_request_id", "api_key_hash", "latency_ms", "suspicious"} {
+		if _, ok := file.Schema().Lookup(column); !ok {
+			t.Fatalf("want column %s in schema %v", column, file.Schema())
+		}
+	}
+	if file.NumRows() != 3 {
+		t.Fatalf("want 3 rows, got %d", file.NumRows())
+	}
+
+	reader := parquet.NewGenericReader[qsParquetEvent](bytes.NewReader(data))
+	defer func() { _ = reader.Close() }()
+	rows := make([]qsParquetEvent, 10)
+	n, err := reader.Read(rows)
+	if err != nil && !errors.Is(err, io.EOF) {
+		t.Fatalf("read rows: %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("want 3 rows read, got %d", n)
+	}
+	for i, row := range rows[:n] {
+		want := newQSParquetEvent(written[i])
+		if !row.Timestamp.Equal(want.Timestamp) {
+			t.Fatalf("row %d: want timestamp %v, got %v", i, want.Timestamp, row.Timestamp)
+		}
+		row.Timestamp = want.Timestamp
+		if row != want {
+			t.Fatalf("row %d: want %+v, got %+v", i, want, row)
+		}
+	}
+}
+
+func TestExportQSEventsParquet_ReadsSegments(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// Every flush rotates, so each event ends up in a segment of its own
+	store := usage.NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), usage.WithPeriodicFlush(false), usage.WithRotation(usage.RotationPolicy{MaxBytes: 1}))
+	defer func() { _ = store.Close() }()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for i := 3; i > 0; i-- {
+		if err := store.Write(usage.UsageEvent{Timestamp: now.Add(-time.Duration(i) * time.Hour), Model: "gpt-4o", TotalTokens: int64(i), Status: 200}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if err := store.Flush(); err != nil {
+			t.Fatalf("flush: %v", err)
+		}
+	}
+	if segments, err := store.Segments(); err != nil || len(segments) != 3 {
+		t.Fatalf("want 3 segments, got %v %v", segments, err)
+	}
+	h := &Handler{cfg: &config.Config{}, jsonStore: store}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	query := url.Values{"from": {now.Add(-4 * time.Hour).Format(time.RFC3339)}, "to": {now.Format(time.RFC3339Nano)}}
+	c.Request = httptest.NewRequest("GET", "/v0/management/qs/export.parquet?"+query.Encode(), nil)
+	h.ExportQSEventsParquet(c)
+	if recorder.Code != 200 {
+		t.Fatalf("unexpected status %d", recorder.Code)
+	}
+	data := recorder.Body.Bytes()
+	file, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("open parquet: %v", err)
+	}
+	if file.NumRows() != 3 {
+		t.Fatalf("want all 3 rotated events, got %d rows", file.NumRows())
+	}
+}
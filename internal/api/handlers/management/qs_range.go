@@ -0,0 +1,49 @@
+package management
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+// RangeResponse is the span of the recorded events, for defaulting a
+// dashboard's date pickers to the actual data.
+type RangeResponse struct {
+	// Earliest and Latest are null when no events are recorded.
+	Earliest    *time.Time `json:"earliest"`
+	Latest      *time.Time `json:"latest"`
+	TotalEvents int64      `json:"total_events"`
+}
+
+// GetQSRange returns the earliest and latest event timestamps and the event count.
+// GET /v0/management/qs/range?tenant=...
+//
+// The span is maintained by the store as events are written, so no file is
+// scanned. It covers the live store file and the buffer, the events metrics
+// queries read; rotated segments are not included. Without persistence it
+// covers what the in-memory live store retains.
+func (h *Handler) GetQSRange(c *gin.Context) {
+	store, ok := h.qsStoreForRequest(c)
+	if !ok {
+		return
+	}
+	var span usage.EventSpan
+	if store != nil {
+		var err error
+		if span, err = store.Span(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read usage store range"})
+			return
+		}
+	} else if live := usage.GetLiveStore(); live != nil && c.Query("tenant") == "" {
+		span = live.Span()
+	}
+
+	response := RangeResponse{TotalEvents: span.Events}
+	if span.Events > 0 {
+		earliest, latest := span.Earliest.UTC(), span.Latest.UTC()
+		response.Earliest, response.Latest = &earliest, &latest
+	}
+	writeQSJSON(c, http.StatusOK, response)
+}
@@ -0,0 +1,58 @@
+package management
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// qsRedactedModel replaces model names hidden from shared-key callers.
+	qsRedactedModel = "(internal)"
+	// qsRedactModelsKey is set on the gin context of shared-key requests.
+	qsRedactModelsKey = "qsRedactModels"
+)
+
+// qsSharedKeyRoutes are the aggregated endpoints shared keys may read.
+var qsSharedKeyRoutes = map[string]bool{
+	"/v0/management/qs/metrics": true,
+	"/v0/management/qs/summary": true,
+	"/v0/management/qs/health":  true,
+}
+
+// authorizeQSSharedKey reports whether provided is a configured shared
+// dashboard key and the request is one it may make. Accepted requests are
+// marked so their model names are redacted.
+func (h *Handler) authorizeQSSharedKey(c *gin.Context, provided string) bool {
+	if h.cfg == nil || c.Request.Method != http.MethodGet || !qsSharedKeyRoutes[c.FullPath()] {
+		return false
+	}
+	for _, key := range h.cfg.UsageStore.ModelRedaction.SharedKeys {
+		if key != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(key)) == 1 {
+			c.Set(qsRedactModelsKey, true)
+			return true
+		}
+	}
+	return false
+}
+
+// qsModelRedactor returns the function mapping model names to what the caller
+// may see, or nil when the caller sees every name.
+func (h *Handler) qsModelRedactor(c *gin.Context) func(string) string {
+	if !c.GetBool(qsRedactModelsKey) {
+		return nil
+	}
+	allowed := make(map[string]bool)
+	if h.cfg != nil {
+		for _, model := range h.cfg.UsageStore.ModelRedaction.Allow {
+			allowed[model] = true
+		}
+	}
+	return func(model string) string {
+		if allowed[model] {
+			return model
+		}
+		return qsRedactedModel
+	}
+}
@@ -0,0 +1,58 @@
+package management
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+// PostQSReplay re-sends stored events in a time range to the configured
+// replay sink (usage-store.replay), e.g. to backfill a new collector.
+// POST /v0/management/qs/replay?from=...&to=...
+//
+// Buffered events are flushed first so the replay covers everything recorded.
+// Only one replay runs at a time; a concurrent request gets 409. The sink is
+// never the primary store, so events are not recorded twice.
+func (h *Handler) PostQSReplay(c *gin.Context) {
+	fromTime, toTime, ok := h.parseQSTimeRange(c)
+	if !ok {
+		return
+	}
+	sink := usage.GetReplaySink()
+	if sink == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no replay sink configured (usage-store.replay.target)"})
+		return
+	}
+	store := h.qsStore()
+	if store == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "usage store is not configured"})
+		return
+	}
+	if !h.replayRunning.CompareAndSwap(false, true) {
+		c.JSON(http.StatusConflict, gin.H{"error": "a replay is already running"})
+		return
+	}
+	defer h.replayRunning.Store(false)
+
+	if err := store.Flush(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to flush usage store: " + err.Error()})
+		return
+	}
+	var replayed int64
+	err := store.Replay(fromTime, toTime, func(event usage.UsageEvent) error {
+		if err := sink.Write(event); err != nil {
+			return err
+		}
+		replayed++
+		return nil
+	})
+	if err == nil && sink.Flush != nil {
+		err = sink.Flush()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"sink": sink.Name, "replayed": replayed, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sink": sink.Name, "replayed": replayed})
+}
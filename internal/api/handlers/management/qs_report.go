@@ -0,0 +1,244 @@
+package management
+
+import (
+	"cmp"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+// qsMIMECSV is the content type of CSV responses.
+const qsMIMECSV = "text/csv"
+
+// UsageReportResponse is the usage statement of one calendar month, per API key hash.
+type UsageReportResponse struct {
+	// Month is the reported month, e.g. "2025-11".
+	Month string      `json:"month"`
+	From  time.Time   `json:"from"`
+	To    time.Time   `json:"to"`
+	Total ReportUsage `json:"total"`
+	Keys  []KeyReport `json:"keys"`
+	// UnpricedModels lists models without usage-store.pricing; their cost is zero.
+	UnpricedModels []string `json:"unpriced_models,omitempty"`
+	Estimated      bool     `json:"estimated,omitempty"`
+	SampleRate     float64  `json:"sample_rate,omitempty"`
+}
+
+// ReportUsage holds the billable counts and estimated cost of a report entry.
+type ReportUsage struct {
+	Requests         int64 `json:"requests"`
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+	// CostUSD is rounded to usage-store.cost-decimals; CostMicroUSD is the
+	// unrounded cost in whole micro-dollars.
+	CostUSD      float64 `json:"cost_usd"`
+	CostMicroUSD int64   `json:"cost_micro_usd"`
+}
+
+// KeyReport is the usage of one API key hash, largest cost first in a report.
+type KeyReport struct {
+	APIKeyHash string `json:"api_key_hash"`
+	ReportUsage
+	ByModel []ModelReport `json:"by_model"`
+}
+
+// ModelReport is the usage of one model within a KeyReport.
+type ModelReport struct {
+	Model string `json:"model"`
+	ReportUsage
+}
+
+func (u *ReportUsage) add(other ReportUsage) {
+	u.Requests += other.Requests
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+	u.CostUSD += other.CostUSD
+}
+
+// scale extrapolates sampled counts back to estimated totals.
+func (u *ReportUsage) scale(sampleRate float64) {
+	scale := func(v int64) int64 { return int64(math.Round(float64(v) / sampleRate)) }
+	u.Requests = scale(u.Requests)
+	u.PromptTokens = scale(u.PromptTokens)
+	u.CompletionTokens = scale(u.CompletionTokens)
+	u.TotalTokens = scale(u.TotalTokens)
+	u.CostUSD /= sampleRate
+}
+
+// qsEventCost estimates the USD cost of an event: uncached prompt tokens at the
+// input price, cached ones at the cached-input price and completion tokens at
+// the output price.
+func qsEventCost(event usage.UsageEvent, price config.UsageModelPrice) float64 {
+	return qsTokenCost(event.PromptTokens, event.CachedTokens, event.CompletionTokens, price)
+}
+
+// qsTokenCost prices token counts as qsEventCost does.
+func qsTokenCost(prompt, cached, completion int64, price config.UsageModelPrice) float64 {
+	cached = min(cached, prompt)
+	return (float64(prompt-cached)*price.Input +
+		float64(cached)*price.CachedInput +
+		float64(completion)*price.Output) / 1_000_000
+}
+
+// parseQSReportMonth parses a "YYYY-MM" month into its UTC bounds, defaulting
+// to the current month.
+func parseQSReportMonth(raw string, now time.Time) (start, end time.Time, err error) {
+	if raw == "" {
+		now = now.UTC()
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	} else if start, err = time.Parse("2006-01", raw); err != nil {
+		return start, end, fmt.Errorf("invalid 'month', expected YYYY-MM")
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// GetQSReport returns a monthly usage statement per API key hash, with
+// per-model breakdowns and costs estimated from usage-store.pricing.
+// GET /v0/management/qs/report?month=2025-11&tenant=...
+//
+// The month is a UTC calendar month and defaults to the current one. With
+// Accept: text/csv the statement is returned as CSV, one row per key and model.
+func (h *Handler) GetQSReport(c *gin.Context) {
+	start, end, err := parseQSReportMonth(c.Query("month"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	store, ok := h.qsStoreForRequest(c)
+	if !ok {
+		return
+	}
+
+	var events []usage.UsageEvent
+	var sampleRate float64
+	if store != nil {
+		events, err = store.LoadRange(start, end)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load usage events"})
+			return
+		}
+		events = mergeQSBufferedEvents(events, store.BufferedEvents(), metricsQuery{From: start, To: end})
+		sampleRate = store.SampleRate()
+	}
+	report := aggregateQSReport(events, start, end, h.qsPricing(), h.qsUnknownModelLabel(), sampleRate)
+	roundQSReportCosts(&report, h.qsCostDecimals())
+
+	c.Header("Vary", "Accept")
+	if c.NegotiateFormat(gin.MIMEJSON, qsMIMECSV) == qsMIMECSV {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"usage-report-%s.csv\"", report.Month))
+		c.Status(http.StatusOK)
+		if err := writeQSReportCSV(c.Writer, report); err != nil {
+			_ = c.Error(err)
+			c.Abort()
+		}
+		return
+	}
+	writeQSJSON(c, http.StatusOK, report)
+}
+
+// aggregateQSReport groups the events in [start, end) by API key hash and
+// model. Models without pricing cost nothing and are listed in UnpricedModels.
+func aggregateQSReport(events []usage.UsageEvent, start, end time.Time, pricing map[string]config.UsageModelPrice, unknownModel string, sampleRate float64) UsageReportResponse {
+	report := UsageReportResponse{Month: start.Format("2006-01"), From: start, To: end, Keys: []KeyReport{}}
+	query := metricsQuery{UnknownModel: unknownModel}
+
+	byKey := make(map[string]map[string]*ReportUsage)
+	unpriced := make(map[string]bool)
+	for _, event := range events {
+		if event.IsMarker() || event.Timestamp.Before(start) || !event.Timestamp.Before(end) {
+			continue
+		}
+		model := query.modelName(event.Model)
+		entry := ReportUsage{
+			Requests:         1,
+			PromptTokens:     event.PromptTokens,
+			CompletionTokens: event.CompletionTokens,
+			TotalTokens:      event.TotalTokens,
+		}
+		if price, ok := pricing[event.Model]; ok {
+			entry.CostUSD = qsEventCost(event, price)
+		} else {
+			unpriced[model] = true
+		}
+
+		models, ok := byKey[event.APIKeyHash]
+		if !ok {
+			models = make(map[string]*ReportUsage)
+			byKey[event.APIKeyHash] = models
+		}
+		if models[model] == nil {
+			models[model] = &ReportUsage{}
+		}
+		models[model].add(entry)
+	}
+
+	sampled := sampleRate > 0 && sampleRate < 1
+	for keyHash, models := range byKey {
+		key := KeyReport{APIKeyHash: keyHash, ByModel: make([]ModelReport, 0, len(models))}
+		for model, counts := range models {
+			if sampled {
+				counts.scale(sampleRate)
+			}
+			key.add(*counts)
+			key.ByModel = append(key.ByModel, ModelReport{Model: model, ReportUsage: *counts})
+		}
+		slices.SortFunc(key.ByModel, func(a, b ModelReport) int {
+			return cmp.Or(cmp.Compare(b.CostUSD, a.CostUSD), cmp.Compare(b.TotalTokens, a.TotalTokens), cmp.Compare(a.Model, b.Model))
+		})
+		report.Total.add(key.ReportUsage)
+		report.Keys = append(report.Keys, key)
+	}
+	slices.SortFunc(report.Keys, func(a, b KeyReport) int {
+		return cmp.Or(cmp.Compare(b.CostUSD, a.CostUSD), cmp.Compare(b.TotalTokens, a.TotalTokens), cmp.Compare(a.APIKeyHash, b.APIKeyHash))
+	})
+	for model := range unpriced {
+		report.UnpricedModels = append(report.UnpricedModels, model)
+	}
+	slices.Sort(report.UnpricedModels)
+	if sampled {
+		report.Estimated = true
+		report.SampleRate = sampleRate
+	}
+	return report
+}
+
+// writeQSReportCSV renders a report as CSV with one row per key and model.
+func writeQSReportCSV(w io.Writer, report UsageReportResponse) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{
+		"month", "api_key_hash", "model", "requests",
+		"prompt_tokens", "completion_tokens", "total_tokens", "cost_usd",
+	}); err != nil {
+		return err
+	}
+	for _, key := range report.Keys {
+		for _, model := range key.ByModel {
+			if err := writer.Write([]string{
+				report.Month,
+				key.APIKeyHash,
+				model.Model,
+				strconv.FormatInt(model.Requests, 10),
+				strconv.FormatInt(model.PromptTokens, 10),
+				strconv.FormatInt(model.CompletionTokens, 10),
+				strconv.FormatInt(model.TotalTokens, 10),
+				strconv.FormatFloat(model.CostUSD, 'f', 6, 64),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,111 @@
+package management
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+func TestAggregateQSReport_GroupsByKeyAndPrices(t *testing.T) {
+	start, end, err := parseQSReportMonth("2025-11", time.Now())
+	if err != nil {
+		t.Fatalf("parse month: %v", err)
+	}
+	if !end.Equal(time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("want the month to end on 2025-12-01, got %s", end)
+	}
+	if _, _, err := parseQSReportMonth("2025-13", time.Now()); err == nil {
+		t.Fatal("want an error for an invalid month")
+	}
+
+	in := time.Date(2025, 11, 10, 12, 0, 0, 0, time.UTC)
+	events := []usage.UsageEvent{
+		{Timestamp: in, APIKeyHash: "a", Model: "gpt", PromptTokens: 1000, CachedTokens: 400, CompletionTokens: 100, TotalTokens: 1100},
+		{Timestamp: in, APIKeyHash: "a", Model: "other", PromptTokens: 10, CompletionTokens: 10, TotalTokens: 20},
+		{Timestamp: in, APIKeyHash: "b", Model: "gpt", PromptTokens: 100, CompletionTokens: 100, TotalTokens: 200},
+		// Outside the month on either side
+		{Timestamp: start.Add(-time.Second), APIKeyHash: "a", Model: "gpt", TotalTokens: 1},
+		{Timestamp: end, APIKeyHash: "a", Model: "gpt", TotalTokens: 1},
+	}
+	pricing := map[string]config.UsageModelPrice{"gpt": {Input: 2, CachedInput: 1, Output: 10}}
+
+	report := aggregateQSReport(events, start, end, pricing, "", 1)
+	if report.Month != "2025-11" || len(report.Keys) != 2 || report.Total.Requests != 3 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	a := report.Keys[0]
+	if a.APIKeyHash != "a" || a.Requests != 2 || a.TotalTokens != 1120 || len(a.ByModel) != 2 {
+		t.Fatalf("unexpected key a %+v", a)
+	}
+	// 600 uncached x 2 + 400 cached x 1 + 100 completion x 10, per million
+	if wantCost := 2600.0 / 1_000_000; math.Abs(a.CostUSD-wantCost) > 1e-12 || a.ByModel[0].Model != "gpt" {
+		t.Fatalf("want key a to cost %v in gpt, got %+v", wantCost, a)
+	}
+	if len(report.UnpricedModels) != 1 || report.UnpricedModels[0] != "other" {
+		t.Fatalf("want 'other' unpriced, got %v", report.UnpricedModels)
+	}
+
+	var csv strings.Builder
+	if err := writeQSReportCSV(&csv, report); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(csv.String()), "\n")
+	if len(lines) != 4 || lines[1] != "2025-11,a,gpt,1,1000,100,1100,0.002600" {
+		t.Fatalf("unexpected csv:\n%s", csv.String())
+	}
+}
+
+func TestRoundQSReportCosts(t *testing.T) {
+	report := UsageReportResponse{Keys: []KeyReport{{
+		ReportUsage: ReportUsage{CostUSD: 0.1234567 + 0.0000004},
+		ByModel: []ModelReport{
+			{Model: "a", ReportUsage: ReportUsage{CostUSD: 0.1234567}},
+			{Model: "b", ReportUsage: ReportUsage{CostUSD: 0.0000004}},
+		},
+	}}}
+	report.Total = report.Keys[0].ReportUsage
+
+	roundQSReportCosts(&report, 4)
+	key := report.Keys[0]
+	if key.ByModel[0].CostUSD != 0.1235 || key.ByModel[0].CostMicroUSD != 123457 || key.ByModel[1].CostUSD != 0 {
+		t.Fatalf("unexpected model costs %+v", key.ByModel)
+	}
+	// Micro-dollars add up across the models; the rounded key cost is
+	// computed from the unrounded sum
+	if key.CostMicroUSD != 123457 || report.Total.CostMicroUSD != 123457 || key.CostUSD != 0.1235 {
+		t.Fatalf("unexpected key %+v and total %+v", key.ReportUsage, report.Total)
+	}
+
+	if cost := roundQSCost(0.1234567, -1); cost != 0.1234567 {
+		t.Fatalf("want full precision for negative decimals, got %v", cost)
+	}
+	if cost := roundQSCost(1.2351, 2); cost != 1.24 {
+		t.Fatalf("want two decimals, got %v", cost)
+	}
+}
+
+func TestRoundQSMetricsCosts(t *testing.T) {
+	costPerRequest := 0.0123456
+	cached := MetricsResponse{
+		Totals:     MetricsTotals{CacheSavingsUSD: 1.234567},
+		ByModel:    []ModelMetrics{{Model: "a", CostUSD: 0.1234567}},
+		Timeseries: []TimeseriesBucket{{Requests: 2, CostPerRequest: &costPerRequest}, {}},
+	}
+
+	response := cached
+	roundQSMetricsCosts(&response, 4)
+	if response.Totals.CacheSavingsUSD != 1.2346 || response.ByModel[0].CostUSD != 0.1235 {
+		t.Fatalf("unexpected totals %+v and models %+v", response.Totals, response.ByModel)
+	}
+	if cost := response.Timeseries[0].CostPerRequest; cost == nil || *cost != 0.0123 || response.Timeseries[1].CostPerRequest != nil {
+		t.Fatalf("unexpected timeseries %+v", response.Timeseries)
+	}
+	// The cached response keeps its unrounded costs
+	if cached.ByModel[0].CostUSD != 0.1234567 || *cached.Timeseries[0].CostPerRequest != 0.0123456 {
+		t.Fatalf("cached response modified: %+v %v", cached.ByModel, *cached.Timeseries[0].CostPerRequest)
+	}
+}
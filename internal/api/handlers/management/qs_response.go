@@ -0,0 +1,72 @@
+package management
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// qsGzipPool reuses gzip writers across metrics responses.
+var qsGzipPool = sync.Pool{
+	New: func() any { return gzip.NewWriter(io.Discard) },
+}
+
+// writeQSJSON writes obj as JSON, indented when the request sets pretty=true
+// and gzip-compressed when the client accepts it.
+func writeQSJSON(c *gin.Context, status int, obj any) {
+	var (
+		body []byte
+		err  error
+	)
+	if c.Query("pretty") == "true" {
+		body, err = json.MarshalIndent(obj, "", "  ")
+	} else {
+		body, err = json.Marshal(obj)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encode response"})
+		return
+	}
+
+	c.Header("Vary", "Accept-Encoding")
+	if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+		c.Data(status, "application/json; charset=utf-8", body)
+		return
+	}
+
+	c.Header("Content-Encoding", "gzip")
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(status)
+
+	zw := qsGzipPool.Get().(*gzip.Writer)
+	defer qsGzipPool.Put(zw)
+	zw.Reset(c.Writer)
+	if _, err = zw.Write(body); err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		_ = c.Error(err)
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			weight, err := strconv.ParseFloat(q, 64)
+			return err == nil && weight > 0
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+package management
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+// SizeMixResponse is a stacked timeseries splitting each bucket's requests by
+// token-size category.
+type SizeMixResponse struct {
+	// Tokens is the count requests are categorized by: total, prompt or completion.
+	Tokens     string         `json:"tokens"`
+	From       time.Time      `json:"from"`
+	To         time.Time      `json:"to"`
+	Categories []SizeCategory `json:"categories"`
+	// BucketSeconds is the width of the timeseries buckets.
+	BucketSeconds int64 `json:"bucket_seconds"`
+	// Totals counts the requests of the whole range per category.
+	Totals     map[string]int64 `json:"totals"`
+	Timeseries []SizeMixBucket  `json:"timeseries"`
+}
+
+// SizeCategory is a token-size range; MaxTokens is omitted for the last,
+// unbounded category.
+type SizeCategory struct {
+	Name      string `json:"name"`
+	MinTokens int64  `json:"min_tokens"`
+	MaxTokens *int64 `json:"max_tokens,omitempty"`
+}
+
+// SizeMixBucket counts one time bucket's requests per category; every
+// category is listed, zero or not, so the series stack directly.
+type SizeMixBucket struct {
+	BucketStart time.Time        `json:"bucket_start"`
+	Requests    int64            `json:"requests"`
+	Counts      map[string]int64 `json:"counts"`
+}
+
+// Default size thresholds; 1000 and 10000 tokens are also bounds of the
+// exported OTEL token histogram, so both views line up.
+const (
+	qsDefaultSmallMaxTokens  = 1000
+	qsDefaultMediumMaxTokens = 10000
+)
+
+// qsSizeCategoryNames are the size categories, smallest first.
+var qsSizeCategoryNames = []string{"small", "medium", "large"}
+
+// qsSizeCategories returns the size categories under usage-store.size-mix.
+// Thresholds that do not increase fall back to the defaults with a warning.
+func (h *Handler) qsSizeCategories() []SizeCategory {
+	small, medium := int64(qsDefaultSmallMaxTokens), int64(qsDefaultMediumMaxTokens)
+	if h.cfg != nil {
+		cfg := h.cfg.UsageStore.SizeMix
+		configSmall, configMedium := small, medium
+		if cfg.SmallMaxTokens > 0 {
+			configSmall = cfg.SmallMaxTokens
+		}
+		if cfg.MediumMaxTokens > 0 {
+			configMedium = cfg.MediumMaxTokens
+		}
+		if configMedium > configSmall {
+			small, medium = configSmall, configMedium
+		} else {
+			log.Warnf("ignoring usage-store.size-mix: medium-max-tokens (%d) must exceed small-max-tokens (%d)", configMedium, configSmall)
+		}
+	}
+	return []SizeCategory{
+		{Name: qsSizeCategoryNames[0], MinTokens: 0, MaxTokens: &small},
+		{Name: qsSizeCategoryNames[1], MinTokens: small + 1, MaxTokens: &medium},
+		{Name: qsSizeCategoryNames[2], MinTokens: medium + 1},
+	}
+}
+
+// GetQSSizeMix returns per-bucket request counts per token-size category.
+// GET /v0/management/qs/size-mix?from=...&to=...&interval=hour&buckets=N&tokens=total|prompt|completion&model=...&exclude_suspicious=true&tenant=...
+//
+// A request is small up to usage-store.size-mix.small-max-tokens (default
+// 1000), medium up to medium-max-tokens (default 10000) and large above.
+// Only buckets with requests are listed.
+func (h *Handler) GetQSSizeMix(c *gin.Context) {
+	tokens := c.DefaultQuery("tokens", "total")
+	if tokens != "total" && tokens != "prompt" && tokens != "completion" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'tokens', expected total, prompt or completion"})
+		return
+	}
+	interval, ok := qsIntervals[c.DefaultQuery("interval", "hour")]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'interval', expected minute, hour or day"})
+		return
+	}
+	fromTime, toTime, ok := h.parseQSTimeRange(c)
+	if !ok {
+		return
+	}
+	if _, set := c.GetQuery("buckets"); set {
+		if interval, ok = parseQSBuckets(c, fromTime, toTime); !ok {
+			return
+		}
+	}
+	query := metricsQuery{
+		From:              fromTime,
+		To:                toTime,
+		Model:             c.Query("model"),
+		ExcludeSuspicious: c.Query("exclude_suspicious") == "true",
+		Interval:          interval,
+		RedactModel:       h.qsModelRedactor(c),
+		RawOnly:           true,
+		UnknownModel:      h.qsUnknownModelLabel(),
+	}
+
+	store, ok := h.qsStoreForRequest(c)
+	if !ok {
+		return
+	}
+	var events []usage.UsageEvent
+	if store != nil {
+		var err error
+		events, err = loadQSMetricsEvents(store, &query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load usage events"})
+			return
+		}
+	} else if live := usage.GetLiveStore(); live != nil && c.Query("tenant") == "" {
+		events = live.Since(query.From)
+	}
+
+	writeQSJSON(c, http.StatusOK, aggregateSizeMix(events, query, tokens, h.qsSizeCategories()))
+}
+
+// aggregateSizeMix counts the events matching the query per time bucket and
+// size category, categorizing each by its total, prompt or completion tokens.
+func aggregateSizeMix(events []usage.UsageEvent, query metricsQuery, tokens string, categories []SizeCategory) SizeMixResponse {
+	newCounts := func() map[string]int64 {
+		counts := make(map[string]int64, len(categories))
+		for _, category := range categories {
+			counts[category.Name] = 0
+		}
+		return counts
+	}
+	response := SizeMixResponse{
+		Tokens:        tokens,
+		From:          query.From,
+		To:            query.To,
+		Categories:    categories,
+		BucketSeconds: int64(query.Interval / time.Second),
+		Totals:        newCounts(),
+		Timeseries:    []SizeMixBucket{},
+	}
+	buckets := make(map[time.Time]*SizeMixBucket)
+	for _, event := range events {
+		if event.IsMarker() || event.Timestamp.Before(query.From) || event.Timestamp.After(query.To) {
+			continue
+		}
+		model := query.modelName(event.Model)
+		if query.Model != "" && model != query.Model {
+			continue
+		}
+		if query.ExcludeSuspicious && event.Suspicious {
+			continue
+		}
+
+		size := event.TotalTokens
+		switch tokens {
+		case "prompt":
+			size = event.PromptTokens
+		case "completion":
+			size = event.CompletionTokens
+		}
+		category := categories[len(categories)-1].Name
+		for _, candidate := range categories {
+			if candidate.MaxTokens != nil && size <= *candidate.MaxTokens {
+				category = candidate.Name
+				break
+			}
+		}
+
+		start := event.Timestamp.Truncate(query.Interval)
+		bucket, exists := buckets[start]
+		if !exists {
+			bucket = &SizeMixBucket{BucketStart: start, Counts: newCounts()}
+			buckets[start] = bucket
+		}
+		bucket.Requests++
+		bucket.Counts[category]++
+		response.Totals[category]++
+	}
+
+	for _, bucket := range buckets {
+		response.Timeseries = append(response.Timeseries, *bucket)
+	}
+	slices.SortFunc(response.Timeseries, func(a, b SizeMixBucket) int {
+		return a.BucketStart.Compare(b.BucketStart)
+	})
+	return response
+}
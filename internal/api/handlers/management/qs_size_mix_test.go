@@ -0,0 +1,45 @@
+package management
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+func TestAggregateSizeMix_CountsPerBucketAndCategory(t *testing.T) {
+	h := &Handler{cfg: &config.Config{UsageStore: config.UsageStoreConfig{SizeMix: config.UsageSizeMixConfig{SmallMaxTokens: 100}}}}
+	categories := h.qsSizeCategories()
+	if *categories[0].MaxTokens != 100 || categories[1].MinTokens != 101 || *categories[1].MaxTokens != 10000 || categories[2].MaxTokens != nil {
+		t.Fatalf("unexpected categories %+v", categories)
+	}
+
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	events := []usage.UsageEvent{
+		{Timestamp: end.Add(-90 * time.Minute), TotalTokens: 100, CompletionTokens: 20_000},
+		{Timestamp: end.Add(-80 * time.Minute), TotalTokens: 101},
+		{Timestamp: end.Add(-30 * time.Minute), TotalTokens: 50_000},
+		{Timestamp: end.Add(-20 * time.Minute), TotalTokens: 10_000},
+		{Timestamp: end.Add(-10 * time.Minute), TotalTokens: 1, Suspicious: true},
+		{Timestamp: end.Add(-5 * time.Hour), TotalTokens: 1},
+	}
+	query := metricsQuery{From: end.Add(-2 * time.Hour), To: end, Interval: time.Hour, ExcludeSuspicious: true}
+
+	response := aggregateSizeMix(events, query, "total", categories)
+	want := []SizeMixBucket{
+		{BucketStart: end.Add(-2 * time.Hour), Requests: 2, Counts: map[string]int64{"small": 1, "medium": 1, "large": 0}},
+		{BucketStart: end.Add(-time.Hour), Requests: 2, Counts: map[string]int64{"small": 0, "medium": 1, "large": 1}},
+	}
+	if !reflect.DeepEqual(response.Timeseries, want) {
+		t.Fatalf("want %+v, got %+v", want, response.Timeseries)
+	}
+	if !reflect.DeepEqual(response.Totals, map[string]int64{"small": 1, "medium": 2, "large": 1}) || response.BucketSeconds != 3600 {
+		t.Fatalf("unexpected totals %v or bucket width %d", response.Totals, response.BucketSeconds)
+	}
+
+	if totals := aggregateSizeMix(events, query, "completion", categories).Totals; totals["large"] != 1 || totals["small"] != 3 {
+		t.Fatalf("want requests categorized by completion tokens, got %v", totals)
+	}
+}
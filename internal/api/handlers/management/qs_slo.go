@@ -0,0 +1,176 @@
+package management
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	qsSLODefaultWindow = 30 * 24 * time.Hour
+	qsSLODefaultTarget = 0.99
+	// qsSLOBurnWindow is the short window used for the current burn rate.
+	qsSLOBurnWindow = time.Hour
+)
+
+// SLOResponse reports error budget consumption per upstream provider.
+type SLOResponse struct {
+	Window    string        `json:"window"`
+	From      time.Time     `json:"from"`
+	To        time.Time     `json:"to"`
+	Providers []ProviderSLO `json:"providers"`
+}
+
+// ProviderSLO is the SLO status of one provider over the window.
+type ProviderSLO struct {
+	Provider    string  `json:"provider"`
+	Target      float64 `json:"target"`
+	Requests    int64   `json:"requests"`
+	Failed      int64   `json:"failed"`
+	SuccessRate float64 `json:"success_rate"`
+	// ErrorBudgetRemaining is the fraction of the window's error budget left;
+	// it goes negative once the budget is exhausted.
+	ErrorBudgetRemaining float64 `json:"error_budget_remaining"`
+	// BurnRate is the observed error rate divided by the allowed error rate
+	// over the whole window; 1 spends the budget exactly by the window's end.
+	BurnRate float64 `json:"burn_rate"`
+	// BurnRate1h is the same ratio over the last hour.
+	BurnRate1h float64 `json:"burn_rate_1h"`
+}
+
+// GetQSSLO returns the observed success rate, remaining error budget and burn
+// rate of each provider against its configured target (usage-store.slo).
+// GET /v0/management/qs/slo?window=30d
+//
+// window accepts a number of days ("30d") or a Go duration ("12h") and may not
+// exceed the maximum lookback. Events recorded without a provider are grouped
+// under "unknown".
+func (h *Handler) GetQSSLO(c *gin.Context) {
+	window := qsSLODefaultWindow
+	maxWindow := time.Duration(h.qsMaxLookbackDays()) * 24 * time.Hour
+	if windowStr := c.Query("window"); windowStr != "" {
+		parsed, ok := parseQSWindow(windowStr)
+		if !ok || parsed <= 0 || parsed > maxWindow {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'window', expected a positive duration within the maximum lookback (e.g. 30d or 12h)"})
+			return
+		}
+		window = parsed
+	}
+
+	toTime := time.Now()
+	fromTime := toTime.Add(-window)
+	response := SLOResponse{
+		Window:    c.DefaultQuery("window", "30d"),
+		From:      fromTime,
+		To:        toTime,
+		Providers: []ProviderSLO{},
+	}
+	store := h.qsStore()
+	if store == nil {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	events, err := store.LoadRange(fromTime, toTime)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load usage events"})
+		return
+	}
+	events = mergeQSBufferedEvents(events, store.BufferedEvents(), metricsQuery{From: fromTime, To: toTime})
+
+	type counts struct {
+		requests, failed     int64
+		recentReq, recentErr int64
+	}
+	burnFrom := toTime.Add(-qsSLOBurnWindow)
+	byProvider := make(map[string]*counts)
+	for _, event := range events {
+		if event.IsMarker() || event.Timestamp.Before(fromTime) || event.Timestamp.After(toTime) {
+			continue
+		}
+		provider := event.Provider
+		if provider == "" {
+			provider = "unknown"
+		}
+		stats, ok := byProvider[provider]
+		if !ok {
+			stats = &counts{}
+			byProvider[provider] = stats
+		}
+		failed := event.Status >= http.StatusBadRequest
+		stats.requests++
+		if failed {
+			stats.failed++
+		}
+		if !event.Timestamp.Before(burnFrom) {
+			stats.recentReq++
+			if failed {
+				stats.recentErr++
+			}
+		}
+	}
+
+	for provider, stats := range byProvider {
+		target := h.qsSLOTarget(provider)
+		budget := 1 - target
+		slo := ProviderSLO{
+			Provider:    provider,
+			Target:      target,
+			Requests:    stats.requests,
+			Failed:      stats.failed,
+			SuccessRate: 1 - float64(stats.failed)/float64(stats.requests),
+		}
+		if budget > 0 {
+			slo.BurnRate = float64(stats.failed) / float64(stats.requests) / budget
+			slo.ErrorBudgetRemaining = 1 - slo.BurnRate
+			if stats.recentReq > 0 {
+				slo.BurnRate1h = float64(stats.recentErr) / float64(stats.recentReq) / budget
+			}
+		}
+		response.Providers = append(response.Providers, slo)
+	}
+	// Providers closest to exhausting their budget first
+	sort.Slice(response.Providers, func(i, j int) bool {
+		a, b := response.Providers[i], response.Providers[j]
+		if a.ErrorBudgetRemaining != b.ErrorBudgetRemaining {
+			return a.ErrorBudgetRemaining < b.ErrorBudgetRemaining
+		}
+		return a.Provider < b.Provider
+	})
+
+	c.JSON(http.StatusOK, response)
+}
+
+// qsSLOTarget returns the configured success rate target for a provider.
+func (h *Handler) qsSLOTarget(provider string) float64 {
+	if h.cfg != nil {
+		slo := h.cfg.UsageStore.SLO
+		if target, ok := slo.Targets[provider]; ok && target > 0 && target <= 1 {
+			return target
+		}
+		if slo.DefaultTarget > 0 && slo.DefaultTarget <= 1 {
+			return slo.DefaultTarget
+		}
+	}
+	return qsSLODefaultTarget
+}
+
+// parseQSWindow parses a window given as whole days ("30d") or a Go duration.
+func parseQSWindow(value string) (time.Duration, bool) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, false
+		}
+		return time.Duration(n) * 24 * time.Hour, true
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, false
+	}
+	return d, true
+}
@@ -0,0 +1,113 @@
+package management
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	qsSummaryDefaultWindow = 15 * time.Minute
+	qsSummaryMaxWindow     = 24 * time.Hour
+)
+
+// SummaryResponse carries the at-a-glance KPIs for a recent window.
+type SummaryResponse struct {
+	Window       string  `json:"window"`
+	Requests     int64   `json:"requests"`
+	Tokens       int64   `json:"tokens"`
+	ErrorRate    float64 `json:"error_rate"`
+	AvgLatencyMs int64   `json:"avg_latency_ms"`
+	// Complete is false when the in-memory buffer does not reach back over the
+	// whole window, in which case the KPIs only cover the buffered events.
+	Complete bool `json:"complete"`
+	// Estimated is set when the store is sampled and requests and tokens were
+	// scaled up by 1/SampleRate, because its exact counters do not cover the
+	// window. Error rate and latency are always taken from the kept sample.
+	Estimated  bool    `json:"estimated,omitempty"`
+	SampleRate float64 `json:"sample_rate,omitempty"`
+	// AllTime holds the store's running all-time counters.
+	AllTime SummaryAllTime `json:"all_time"`
+}
+
+// SummaryAllTime are O(1) all-time totals from the store's running counters.
+type SummaryAllTime struct {
+	Requests int64 `json:"requests"`
+	Tokens   int64 `json:"tokens"`
+	Failed   int64 `json:"failed"`
+	// Since is set when the counters were reset; they then count from it.
+	Since *time.Time `json:"since,omitempty"`
+}
+
+// GetQSSummary returns requests, tokens, error rate and average latency for a
+// recent window, computed from the store's in-memory recent buffer without
+// reading the file. It is cheap enough to poll every few seconds.
+// GET /v0/management/qs/summary?window=15m
+func (h *Handler) GetQSSummary(c *gin.Context) {
+	window := qsSummaryDefaultWindow
+	if windowStr := c.Query("window"); windowStr != "" {
+		parsed, err := time.ParseDuration(windowStr)
+		if err != nil || parsed <= 0 || parsed > qsSummaryMaxWindow {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'window', expected a positive duration up to 24h (e.g. 15m)"})
+			return
+		}
+		window = parsed
+	}
+
+	response := SummaryResponse{Window: window.String(), Complete: true}
+	store := h.qsStore()
+	if store == nil {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	totals := store.Totals()
+	response.AllTime = SummaryAllTime{Requests: totals.Requests, Tokens: totals.Tokens, Failed: totals.Failed}
+	if !totals.Since.IsZero() {
+		response.AllTime.Since = &totals.Since
+	}
+
+	from := time.Now().Add(-window)
+	events, ok := store.RecentSince(from)
+	if !ok {
+		events = store.Recent(math.MaxInt)
+		response.Complete = false
+	}
+
+	var failed, latencyTotal, latencyCount int64
+	for _, event := range events {
+		if event.IsMarker() || event.Timestamp.Before(from) {
+			continue
+		}
+		response.Requests++
+		response.Tokens += event.TotalTokens
+		if event.Status >= http.StatusBadRequest {
+			failed++
+		}
+		if event.LatencyMs > 0 {
+			latencyTotal += event.LatencyMs
+			latencyCount++
+		}
+	}
+	if response.Requests > 0 {
+		response.ErrorRate = float64(failed) / float64(response.Requests)
+	}
+	if latencyCount > 0 {
+		response.AvgLatencyMs = latencyTotal / latencyCount
+	}
+	if rate := store.SampleRate(); rate < 1 {
+		// The recent buffer only holds the kept sample
+		if counts, ok := store.ExactCountsBetween(from, time.Now()); ok {
+			response.Requests, response.Tokens = counts.Requests, counts.Tokens
+		} else {
+			response.Requests = int64(math.Round(float64(response.Requests) / rate))
+			response.Tokens = int64(math.Round(float64(response.Tokens) / rate))
+			response.Estimated = true
+			response.SampleRate = rate
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
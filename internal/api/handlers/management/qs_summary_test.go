@@ -0,0 +1,57 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+func TestGetQSSummary_ScalesSampledCounts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	summary := func(counted bool) SummaryResponse {
+		path := filepath.Join(t.TempDir(), "usage.json")
+		if counted {
+			// Exact counters that started long before the window
+			if err := os.WriteFile(path+".counters", []byte(`{"since":"2000-01-01T00:00:00Z","minutes":{}}`), 0o600); err != nil {
+				t.Fatalf("write counters: %v", err)
+			}
+		}
+		store := usage.NewJSONStore(path, usage.WithPeriodicFlush(false), usage.WithSampling(0.5))
+		defer func() { _ = store.Close() }()
+		now := time.Now()
+		for i := 0; i < 200; i++ {
+			if err := store.Write(usage.UsageEvent{Timestamp: now.Add(-time.Minute), Model: "m", TotalTokens: 10, Status: 200}); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+		}
+		h := &Handler{cfg: &config.Config{}, jsonStore: store}
+
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest("GET", "/v0/management/qs/summary", nil)
+		h.GetQSSummary(c)
+		var response SummaryResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("decode %d: %v", recorder.Code, err)
+		}
+		kept := int64(len(store.Recent(1000)))
+		if !counted && (response.Requests != 2*kept || response.Tokens != 20*kept) {
+			t.Fatalf("want the %d kept events scaled up, got %+v", kept, response)
+		}
+		return response
+	}
+
+	if estimated := summary(false); !estimated.Estimated || estimated.SampleRate != 0.5 {
+		t.Fatalf("want an estimate without exact counters, got %+v", estimated)
+	}
+	if exact := summary(true); exact.Estimated || exact.Requests != 200 || exact.Tokens != 2000 {
+		t.Fatalf("want the exact counts, got %+v", exact)
+	}
+}
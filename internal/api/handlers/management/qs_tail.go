@@ -0,0 +1,132 @@
+package management
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+const (
+	qsTailDefaultLimit = 1000
+	qsTailMaxLimit     = 10000
+)
+
+// TailResponse is a page of events newer than the caller's cursor.
+type TailResponse struct {
+	Events []usage.UsageEvent `json:"events"`
+	// Cursor is the byte offset to pass as 'after' on the next poll.
+	Cursor int64 `json:"cursor"`
+	// Generation identifies the store file Cursor points into; pass it as
+	// 'generation' with the cursor so a rotation is detected.
+	Generation string `json:"generation"`
+	// Reset is true when the previous cursor no longer matched the store file
+	// (truncated or rotated) and reading restarted from the beginning.
+	Reset bool `json:"reset"`
+	// SampleRate is set when the store is sampled: the events are only this
+	// fraction of all requests.
+	SampleRate float64 `json:"sample_rate,omitempty"`
+}
+
+// GetQSEventsTail returns persisted events after a cursor for incremental consumers.
+// GET /v0/management/qs/events/tail?after=<offset|RFC3339 timestamp>&generation=<generation>&limit=1000
+//
+// 'after' is normally the cursor returned by the previous call, which is a
+// byte offset into the current store file, and 'generation' the generation
+// returned with it. After a rotation the offset may still fall on a line
+// boundary of the new file; the changed generation makes the read restart
+// from the beginning with reset set instead. Without 'generation' only the
+// offset is checked. An RFC3339 timestamp may be given
+// instead for the first poll; the file is then scanned from the start and
+// only events newer than the timestamp are returned. Events still buffered in
+// memory are not visible until they are flushed.
+func (h *Handler) GetQSEventsTail(c *gin.Context) {
+	var (
+		offset int64
+		since  time.Time
+	)
+	generation, checkGeneration := c.GetQuery("generation")
+	if after := c.Query("after"); after != "" {
+		if n, err := strconv.ParseInt(after, 10, 64); err == nil {
+			offset = n
+		} else if t, err := time.Parse(time.RFC3339, after); err == nil {
+			since = t
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'after', expected a byte offset or RFC3339 timestamp"})
+			return
+		}
+	}
+
+	limit := qsTailDefaultLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'limit', expected a positive integer"})
+			return
+		}
+		limit = min(n, qsTailMaxLimit)
+	}
+
+	store := h.qsStore()
+	if store == nil {
+		c.JSON(http.StatusOK, TailResponse{Events: []usage.UsageEvent{}, Cursor: 0, Reset: offset != 0})
+		return
+	}
+
+	response := TailResponse{Events: []usage.UsageEvent{}}
+	for len(response.Events) < limit {
+		var page usage.TailResult
+		var err error
+		if checkGeneration {
+			page, err = store.ReadFromGeneration(generation, offset, limit-len(response.Events))
+		} else {
+			page, err = store.ReadFrom(offset, limit-len(response.Events))
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read usage events"})
+			return
+		}
+		response.Reset = response.Reset || page.Reset
+		offset, generation, checkGeneration = page.Offset, page.Generation, true
+		if len(page.Events) == 0 {
+			break
+		}
+		for _, event := range page.Events {
+			if !since.IsZero() && !event.Timestamp.After(since) {
+				continue
+			}
+			response.Events = append(response.Events, event)
+		}
+	}
+	response.Cursor, response.Generation = offset, generation
+	if rate := store.SampleRate(); rate < 1 {
+		response.SampleRate = rate
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+const qsRecentDefaultCount = 100
+
+// GetQSEventsRecent returns the most recently recorded events from the store's in-memory cache.
+// GET /v0/management/qs/events/recent?n=100
+func (h *Handler) GetQSEventsRecent(c *gin.Context) {
+	n := qsRecentDefaultCount
+	if nStr := c.Query("n"); nStr != "" {
+		parsed, err := strconv.Atoi(nStr)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'n', expected a positive integer"})
+			return
+		}
+		n = parsed
+	}
+
+	store := h.qsStore()
+	response := gin.H{"events": store.Recent(n)}
+	if rate := store.SampleRate(); rate < 1 {
+		response["sample_rate"] = rate
+	}
+	c.JSON(http.StatusOK, response)
+}
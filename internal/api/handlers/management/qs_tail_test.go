@@ -0,0 +1,83 @@
+package management
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+func TestGetQSEventsTail_ResetsAcrossRotation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	base := time.Now().UTC().Add(-time.Hour)
+	event := func(i int) usage.UsageEvent {
+		return usage.UsageEvent{Timestamp: base.Add(time.Duration(i) * time.Second), Model: "m", RequestID: fmt.Sprintf("req-%02d", i)}
+	}
+	// Rotate once the file holds 6 of these events
+	sizing := usage.NewJSONStore(filepath.Join(dir, "sizing.json"), usage.WithPeriodicFlush(false))
+	for i := 0; i < 6; i++ {
+		_ = sizing.Write(event(i))
+	}
+	_ = sizing.Close()
+	info, err := os.Stat(filepath.Join(dir, "sizing.json"))
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+
+	store := usage.NewJSONStore(filepath.Join(dir, "usage.json"), usage.WithPeriodicFlush(false), usage.WithRotation(usage.RotationPolicy{MaxBytes: info.Size()}))
+	defer func() { _ = store.Close() }()
+	h := &Handler{cfg: &config.Config{}, jsonStore: store}
+	write := func(from, to int) {
+		for i := from; i < to; i++ {
+			if err := store.Write(event(i)); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+		}
+		if err := store.Flush(); err != nil {
+			t.Fatalf("flush: %v", err)
+		}
+	}
+	tail := func(previous *TailResponse) TailResponse {
+		query := url.Values{}
+		if previous != nil {
+			query.Set("after", fmt.Sprint(previous.Cursor))
+			query.Set("generation", previous.Generation)
+		}
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest("GET", "/v0/management/qs/events/tail?"+query.Encode(), nil)
+		h.GetQSEventsTail(c)
+		var response TailResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("decode %d: %v", recorder.Code, err)
+		}
+		return response
+	}
+
+	write(0, 3)
+	first := tail(nil)
+	if len(first.Events) != 3 || first.Reset {
+		t.Fatalf("want the first 3 events, got %+v", first)
+	}
+	// The next flush rotates; the old cursor then falls on a line boundary
+	// of the new file
+	write(3, 7)
+	write(7, 11)
+	second := tail(&first)
+	if !second.Reset || len(second.Events) != 4 || second.Events[0].RequestID != "req-07" || second.Generation == first.Generation {
+		t.Fatalf("want a reset and the new file from its start, got %+v", second)
+	}
+	write(11, 12)
+	if third := tail(&second); third.Reset || len(third.Events) != 1 || third.Events[0].RequestID != "req-11" {
+		t.Fatalf("want only the new event, got %+v", third)
+	}
+}
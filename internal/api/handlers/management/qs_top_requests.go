@@ -0,0 +1,203 @@
+package management
+
+import (
+	"cmp"
+	"container/heap"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+// TopRequestsResponse lists the largest individual requests of a range.
+type TopRequestsResponse struct {
+	By   string    `json:"by"`
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Requests is sorted largest first.
+	Requests []TopRequest `json:"requests"`
+}
+
+// TopRequest is one recorded request of a top-requests ranking.
+type TopRequest struct {
+	Timestamp        time.Time `json:"timestamp"`
+	Model            string    `json:"model"`
+	Provider         string    `json:"provider,omitempty"`
+	RequestID        string    `json:"request_id,omitempty"`
+	APIKeyHash       string    `json:"api_key_hash,omitempty"`
+	Status           int       `json:"status"`
+	PromptTokens     int64     `json:"prompt_tokens"`
+	CompletionTokens int64     `json:"completion_tokens"`
+	TotalTokens      int64     `json:"total_tokens"`
+	// CostUSD is estimated from usage-store.pricing and rounded to
+	// usage-store.cost-decimals; omitted for unpriced models.
+	CostUSD *float64 `json:"cost_usd,omitempty"`
+	// CostMicroUSD is the unrounded cost in whole micro-dollars.
+	CostMicroUSD *int64 `json:"cost_micro_usd,omitempty"`
+}
+
+const (
+	qsTopRequestsDefaultLimit = 20
+	qsTopRequestsMaxLimit     = 1000
+)
+
+// topRequest is a candidate held in the ranking heap.
+type topRequest struct {
+	score   float64
+	seq     int
+	request TopRequest
+}
+
+// topRequestHeap is a min-heap keeping the smallest of the current top N at
+// the root, so each event is compared against it and the heap never holds
+// more than N entries. Among equal scores the later event ranks lower.
+type topRequestHeap []topRequest
+
+func (h topRequestHeap) Len() int { return len(h) }
+func (h topRequestHeap) Less(i, j int) bool {
+	if h[i].score != h[j].score {
+		return h[i].score < h[j].score
+	}
+	return h[i].seq > h[j].seq
+}
+func (h topRequestHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *topRequestHeap) Push(x any)   { *h = append(*h, x.(topRequest)) }
+func (h *topRequestHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// GetQSTopRequests returns the individual requests with the most tokens or the highest cost.
+// GET /v0/management/qs/top-requests?from=...&to=...&by=tokens|cost&limit=20&model=...&exclude_suspicious=true&tenant=...
+//
+// by=cost ranks by the estimated cost from usage-store.pricing and skips
+// requests of unpriced models. limit defaults to 20 and is capped at 1000.
+func (h *Handler) GetQSTopRequests(c *gin.Context) {
+	by := c.DefaultQuery("by", "tokens")
+	if by != "tokens" && by != "cost" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'by', expected tokens or cost"})
+		return
+	}
+	limit := qsTopRequestsDefaultLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'limit', expected a positive integer"})
+			return
+		}
+		limit = min(n, qsTopRequestsMaxLimit)
+	}
+	fromTime, toTime, ok := h.parseQSTimeRange(c)
+	if !ok {
+		return
+	}
+	query := metricsQuery{
+		From:              fromTime,
+		To:                toTime,
+		Model:             c.Query("model"),
+		ExcludeSuspicious: c.Query("exclude_suspicious") == "true",
+		RedactModel:       h.qsModelRedactor(c),
+		RawOnly:           true,
+		Pricing:           h.qsPricing(),
+		UnknownModel:      h.qsUnknownModelLabel(),
+	}
+
+	store, ok := h.qsStoreForRequest(c)
+	if !ok {
+		return
+	}
+	var events []usage.UsageEvent
+	if store != nil {
+		var err error
+		events, err = loadQSMetricsEvents(store, &query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load usage events"})
+			return
+		}
+	} else if live := usage.GetLiveStore(); live != nil && c.Query("tenant") == "" {
+		events = live.Since(query.From)
+	}
+
+	requests := topRequests(events, query, by, limit)
+	decimals := h.qsCostDecimals()
+	for i, request := range requests {
+		if request.CostUSD != nil {
+			micro, rounded := qsMicroUSD(*request.CostUSD), roundQSCost(*request.CostUSD, decimals)
+			requests[i].CostUSD, requests[i].CostMicroUSD = &rounded, &micro
+		}
+	}
+	writeQSJSON(c, http.StatusOK, TopRequestsResponse{
+		By:       by,
+		From:     query.From,
+		To:       query.To,
+		Requests: requests,
+	})
+}
+
+// topRequests returns the limit events matching the query with the most
+// tokens or the highest cost, largest first, in a single pass that keeps
+// only the current top limit in a bounded min-heap.
+func topRequests(events []usage.UsageEvent, query metricsQuery, by string, limit int) []TopRequest {
+	top := make(topRequestHeap, 0, limit)
+	for i, event := range events {
+		if event.IsMarker() || event.Timestamp.Before(query.From) || event.Timestamp.After(query.To) {
+			continue
+		}
+		model := query.modelName(event.Model)
+		if query.Model != "" && model != query.Model {
+			continue
+		}
+		if query.ExcludeSuspicious && event.Suspicious {
+			continue
+		}
+
+		var cost *float64
+		if price, ok := query.Pricing[event.Model]; ok {
+			value := qsTokenCost(event.PromptTokens, event.CachedTokens, event.CompletionTokens, price)
+			cost = &value
+		}
+		score := float64(event.TotalTokens)
+		if by == "cost" {
+			if cost == nil {
+				continue
+			}
+			score = *cost
+		}
+		if len(top) == limit && score <= top[0].score {
+			// Ties keep the earlier event, which is already ranked
+			continue
+		}
+		candidate := topRequest{score: score, seq: i, request: TopRequest{
+			Timestamp:        event.Timestamp,
+			Model:            model,
+			Provider:         event.Provider,
+			RequestID:        event.RequestID,
+			APIKeyHash:       event.APIKeyHash,
+			Status:           event.Status,
+			PromptTokens:     event.PromptTokens,
+			CompletionTokens: event.CompletionTokens,
+			TotalTokens:      event.TotalTokens,
+			CostUSD:          cost,
+		}}
+		if len(top) < limit {
+			heap.Push(&top, candidate)
+			continue
+		}
+		top[0] = candidate
+		heap.Fix(&top, 0)
+	}
+
+	slices.SortFunc(top, func(a, b topRequest) int {
+		return cmp.Or(cmp.Compare(b.score, a.score), cmp.Compare(a.seq, b.seq))
+	})
+	requests := make([]TopRequest, len(top))
+	for i, entry := range top {
+		requests[i] = entry.request
+	}
+	return requests
+}
@@ -0,0 +1,66 @@
+package management
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+func TestTopRequests_MatchesFullSort(t *testing.T) {
+	end := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	events := syntheticEvents(5000, end)
+	for i := range events {
+		events[i].RequestID = strconv.Itoa(i)
+	}
+	query := metricsQuery{From: end.Add(-7 * 24 * time.Hour), To: end}
+
+	got := topRequests(events, query, "tokens", 20)
+	want := slices.Clone(events)
+	slices.SortStableFunc(want, func(a, b usage.UsageEvent) int {
+		return int(b.TotalTokens - a.TotalTokens)
+	})
+	if len(got) != 20 {
+		t.Fatalf("want 20 requests, got %d", len(got))
+	}
+	for i := range got {
+		if got[i].RequestID != want[i].RequestID || got[i].TotalTokens != want[i].TotalTokens {
+			t.Fatalf("rank %d: want %s with %d tokens, got %s with %d", i, want[i].RequestID, want[i].TotalTokens, got[i].RequestID, got[i].TotalTokens)
+		}
+	}
+}
+
+func TestTopRequests_ByCostSkipsUnpricedModels(t *testing.T) {
+	now := time.Now()
+	events := []usage.UsageEvent{
+		{Timestamp: now, Model: "cheap", PromptTokens: 900_000, TotalTokens: 900_000, RequestID: "a"},
+		{Timestamp: now, Model: "pricey", PromptTokens: 1_000, TotalTokens: 1_000, RequestID: "b"},
+		{Timestamp: now, Model: "unpriced", PromptTokens: 5_000_000, TotalTokens: 5_000_000, RequestID: "c"},
+		{Timestamp: now.Add(-2 * time.Hour), Model: "pricey", PromptTokens: 1_000_000, TotalTokens: 1_000_000, RequestID: "d"},
+	}
+	query := metricsQuery{
+		From: now.Add(-time.Hour),
+		To:   now,
+		Pricing: map[string]config.UsageModelPrice{
+			"cheap":  {Input: 0.1},
+			"pricey": {Input: 100},
+		},
+	}
+
+	got := topRequests(events, query, "cost", 10)
+	if len(got) != 2 || got[0].RequestID != "b" || got[1].RequestID != "a" {
+		t.Fatalf("want b then a, got %+v", got)
+	}
+	if got[0].CostUSD == nil || math.Abs(*got[0].CostUSD-0.1) > 1e-9 {
+		t.Fatalf("want cost 0.1 for b, got %v", got[0].CostUSD)
+	}
+
+	got = topRequests(events, query, "tokens", 1)
+	if len(got) != 1 || got[0].RequestID != "c" || got[0].CostUSD != nil {
+		t.Fatalf("want the unpriced request c without a cost, got %+v", got)
+	}
+}
@@ -0,0 +1,145 @@
+package management
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// qsDefaultWarmInterval applies when usage-store.warm.interval-seconds is unset.
+const qsDefaultWarmInterval = time.Minute
+
+// qsWarmMaxAgeIntervals is how many warm intervals a cached response stays
+// servable, so one slow or failed run does not send dashboards to disk.
+const qsWarmMaxAgeIntervals = 2
+
+// qsWarmEntry is a /qs/metrics response the warmer computed for one window.
+type qsWarmEntry struct {
+	response   MetricsResponse
+	computedAt time.Time
+}
+
+// qsWarmCache holds the warmed responses by window.
+type qsWarmCache struct {
+	mu      sync.RWMutex
+	entries map[time.Duration]qsWarmEntry
+}
+
+func (w *qsWarmCache) get(window time.Duration) (qsWarmEntry, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	entry, ok := w.entries[window]
+	return entry, ok
+}
+
+func (w *qsWarmCache) replace(entries map[time.Duration]qsWarmEntry) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.entries = entries
+}
+
+// qsWarmInterval returns how often the warmer runs.
+func (h *Handler) qsWarmInterval() time.Duration {
+	if h.cfg != nil && h.cfg.UsageStore.Warm.IntervalSeconds > 0 {
+		return time.Duration(h.cfg.UsageStore.Warm.IntervalSeconds) * time.Second
+	}
+	return qsDefaultWarmInterval
+}
+
+// qsWarmWindows returns the configured windows, skipping invalid ones with
+// a warning.
+func (h *Handler) qsWarmWindows() []time.Duration {
+	if h.cfg == nil {
+		return nil
+	}
+	maxWindow := time.Duration(h.qsMaxLookbackDays()) * 24 * time.Hour
+	var windows []time.Duration
+	for _, value := range h.cfg.UsageStore.Warm.Windows {
+		window, ok := parseQSWindow(value)
+		if !ok || window <= 0 || window > maxWindow {
+			log.Warnf("ignoring usage-store.warm window %q: expected a positive duration within the maximum lookback (e.g. 24h or 7d)", value)
+			continue
+		}
+		if !slices.Contains(windows, window) {
+			windows = append(windows, window)
+		}
+	}
+	return windows
+}
+
+// StartQSWarmer starts the background warmer, which recomputes /qs/metrics
+// for each window under usage-store.warm every interval. Windows and interval
+// are re-read on every run, so config reloads apply without a restart.
+//
+// Returns:
+//   - func(): Stops the warmer; safe to call more than once
+func (h *Handler) StartQSWarmer() func() {
+	done := make(chan struct{})
+	go func() {
+		for {
+			h.warmQSMetrics()
+			timer := time.NewTimer(h.qsWarmInterval())
+			select {
+			case <-timer.C:
+			case <-done:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// warmQSMetrics computes the default /qs/metrics response for each warmed
+// window, ending now, and replaces the cached ones.
+func (h *Handler) warmQSMetrics() {
+	windows := h.qsWarmWindows()
+	store := h.qsStore()
+	if len(windows) == 0 || store == nil {
+		h.warmCache.replace(nil)
+		return
+	}
+	entries := make(map[time.Duration]qsWarmEntry, len(windows))
+	for _, window := range windows {
+		now := time.Now()
+		response, err := aggregateQSStoreMetrics(store, h.qsDefaultMetricsQuery(now.Add(-window), now))
+		if err != nil {
+			log.Warnf("failed to warm usage metrics for the last %s: %v", window, err)
+			continue
+		}
+		entries[window] = qsWarmEntry{response: response, computedAt: now}
+	}
+	h.warmCache.replace(entries)
+}
+
+// qsWarmMetrics returns the warmed response for a /qs/metrics request, if
+// it asks for nothing but a window (or the default 24 hours), reads the
+// shared store with full model names and the entry is fresh.
+func (h *Handler) qsWarmMetrics(c *gin.Context) (MetricsResponse, bool) {
+	for param := range c.Request.URL.Query() {
+		if param != "window" && param != "pretty" {
+			return MetricsResponse{}, false
+		}
+	}
+	if h.qsModelRedactor(c) != nil {
+		return MetricsResponse{}, false
+	}
+	window := qsDefaultWindow
+	if value := c.Query("window"); value != "" {
+		var ok bool
+		if window, ok = parseQSWindow(value); !ok {
+			return MetricsResponse{}, false
+		}
+	}
+	entry, ok := h.warmCache.get(window)
+	if !ok || time.Since(entry.computedAt) > qsWarmMaxAgeIntervals*h.qsWarmInterval() {
+		return MetricsResponse{}, false
+	}
+	response := entry.response
+	response.CachedAt = &entry.computedAt
+	return response, true
+}
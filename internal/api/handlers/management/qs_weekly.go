@@ -0,0 +1,220 @@
+package management
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+// WeeklyMetricsResponse is usage grouped by ISO week.
+type WeeklyMetricsResponse struct {
+	// Timezone is the zone weeks (and business hours) are computed in.
+	Timezone string         `json:"timezone"`
+	Totals   MetricsTotals  `json:"totals"`
+	Weeks    []WeeklyBucket `json:"weeks"`
+	// BusinessHours splits the totals when the request sets business_hours=true.
+	BusinessHours *BusinessHoursSplit `json:"business_hours,omitempty"`
+	Estimated     bool                `json:"estimated,omitempty"`
+	SampleRate    float64             `json:"sample_rate,omitempty"`
+}
+
+// WeeklyBucket is one ISO week; weeks without traffic are included with zero counts.
+type WeeklyBucket struct {
+	// Week is the ISO week label, e.g. "2025-W48".
+	Week          string              `json:"week"`
+	WeekStart     time.Time           `json:"week_start"`
+	Tokens        int64               `json:"tokens"`
+	Requests      int64               `json:"requests"`
+	BusinessHours *BusinessHoursSplit `json:"business_hours,omitempty"`
+}
+
+// BusinessHoursSplit divides counts into business-hours and off-hours traffic.
+type BusinessHoursSplit struct {
+	Business HoursCounts `json:"business"`
+	OffHours HoursCounts `json:"off_hours"`
+}
+
+// HoursCounts holds the counts of one side of a BusinessHoursSplit.
+type HoursCounts struct {
+	Tokens   int64 `json:"tokens"`
+	Requests int64 `json:"requests"`
+}
+
+func (s *BusinessHoursSplit) add(business bool, tokens int64) {
+	side := &s.OffHours
+	if business {
+		side = &s.Business
+	}
+	side.Tokens += tokens
+	side.Requests++
+}
+
+// qsBusinessHours is the resolved usage-store.business-hours configuration.
+type qsBusinessHours struct {
+	start, end int
+	loc        *time.Location
+}
+
+// contains reports whether t falls on a weekday within business hours.
+func (b qsBusinessHours) contains(t time.Time) bool {
+	local := t.In(b.loc)
+	if local.Weekday() == time.Saturday || local.Weekday() == time.Sunday {
+		return false
+	}
+	return local.Hour() >= b.start && local.Hour() < b.end
+}
+
+// qsBusinessHours returns the configured business hours, defaulting to 9-17 UTC.
+func (h *Handler) qsBusinessHours() (qsBusinessHours, error) {
+	hours := qsBusinessHours{start: 9, end: 17, loc: time.UTC}
+	if h.cfg == nil {
+		return hours, nil
+	}
+	cfg := h.cfg.UsageStore.BusinessHours
+	if cfg.StartHour != 0 || cfg.EndHour != 0 {
+		if cfg.StartHour < 0 || cfg.EndHour > 24 || cfg.StartHour >= cfg.EndHour {
+			return hours, fmt.Errorf("invalid usage-store.business-hours: start-hour must be before end-hour within 0-24")
+		}
+		hours.start, hours.end = cfg.StartHour, cfg.EndHour
+	}
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return hours, fmt.Errorf("invalid usage-store.business-hours.timezone: %w", err)
+		}
+		hours.loc = loc
+	}
+	return hours, nil
+}
+
+// GetQSWeeklyMetrics returns usage grouped by ISO week, zero-filling weeks
+// without traffic.
+// GET /v0/management/qs/metrics/weekly?from=...&to=...&model=...&business_hours=true&tenant=...
+//
+// Weeks start on Monday 00:00 in the configured business-hours timezone. With
+// business_hours=true each event is tagged as business-hours or off-hours
+// traffic and every week, as well as the totals, carries the split.
+func (h *Handler) GetQSWeeklyMetrics(c *gin.Context) {
+	fromTime, toTime, ok := h.parseQSTimeRange(c)
+	if !ok {
+		return
+	}
+	hours, err := h.qsBusinessHours()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	query := metricsQuery{
+		From:              fromTime,
+		To:                toTime,
+		Model:             c.Query("model"),
+		ExcludeSuspicious: c.Query("exclude_suspicious") == "true",
+		RedactModel:       h.qsModelRedactor(c),
+		RawOnly:           true,
+		UnknownModel:      h.qsUnknownModelLabel(),
+	}
+
+	store, ok := h.qsStoreForRequest(c)
+	if !ok {
+		return
+	}
+	var events []usage.UsageEvent
+	if store != nil {
+		events, err = loadQSMetricsEvents(store, &query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load usage events"})
+			return
+		}
+		query.SampleRate = store.SampleRate()
+	} else if live := usage.GetLiveStore(); live != nil && c.Query("tenant") == "" {
+		events = live.Since(query.From)
+	}
+
+	writeQSJSON(c, http.StatusOK, aggregateWeekly(events, query, hours, c.Query("business_hours") == "true"))
+}
+
+// aggregateWeekly groups the events matching the query into ISO weeks in
+// hours' timezone, optionally splitting each week into business and off hours.
+func aggregateWeekly(events []usage.UsageEvent, query metricsQuery, hours qsBusinessHours, split bool) WeeklyMetricsResponse {
+	response := WeeklyMetricsResponse{Timezone: hours.loc.String(), Weeks: []WeeklyBucket{}}
+	if split {
+		response.BusinessHours = &BusinessHoursSplit{}
+	}
+
+	index := make(map[time.Time]int)
+	last := isoWeekStart(query.To, hours.loc)
+	for start := isoWeekStart(query.From, hours.loc); !start.After(last); start = start.AddDate(0, 0, 7) {
+		year, week := start.ISOWeek()
+		bucket := WeeklyBucket{Week: fmt.Sprintf("%04d-W%02d", year, week), WeekStart: start}
+		if split {
+			bucket.BusinessHours = &BusinessHoursSplit{}
+		}
+		index[start] = len(response.Weeks)
+		response.Weeks = append(response.Weeks, bucket)
+	}
+
+	for _, event := range events {
+		if event.IsMarker() || event.Timestamp.Before(query.From) || event.Timestamp.After(query.To) {
+			continue
+		}
+		if query.Model != "" && query.modelName(event.Model) != query.Model {
+			continue
+		}
+		if query.ExcludeSuspicious && event.Suspicious {
+			continue
+		}
+		i, ok := index[isoWeekStart(event.Timestamp, hours.loc)]
+		if !ok {
+			continue
+		}
+		bucket := &response.Weeks[i]
+		bucket.Tokens += event.TotalTokens
+		bucket.Requests++
+		response.Totals.Tokens += event.TotalTokens
+		response.Totals.Requests++
+		if split {
+			business := hours.contains(event.Timestamp)
+			bucket.BusinessHours.add(business, event.TotalTokens)
+			response.BusinessHours.add(business, event.TotalTokens)
+		}
+	}
+
+	if query.SampleRate > 0 && query.SampleRate < 1 {
+		scaleWeekly(&response, query.SampleRate)
+	}
+	return response
+}
+
+// isoWeekStart returns Monday 00:00 in loc of the ISO week containing t.
+func isoWeekStart(t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	daysSinceMonday := (int(local.Weekday()) + 6) % 7
+	return time.Date(local.Year(), local.Month(), local.Day()-daysSinceMonday, 0, 0, 0, 0, loc)
+}
+
+// scaleWeekly extrapolates sampled weekly counts back to estimated totals.
+func scaleWeekly(response *WeeklyMetricsResponse, sampleRate float64) {
+	scale := func(v int64) int64 { return int64(math.Round(float64(v) / sampleRate)) }
+	scaleSplit := func(s *BusinessHoursSplit) {
+		if s == nil {
+			return
+		}
+		s.Business.Tokens, s.Business.Requests = scale(s.Business.Tokens), scale(s.Business.Requests)
+		s.OffHours.Tokens, s.OffHours.Requests = scale(s.OffHours.Tokens), scale(s.OffHours.Requests)
+	}
+
+	response.Totals.Tokens = scale(response.Totals.Tokens)
+	response.Totals.Requests = scale(response.Totals.Requests)
+	scaleSplit(response.BusinessHours)
+	for i := range response.Weeks {
+		response.Weeks[i].Tokens = scale(response.Weeks[i].Tokens)
+		response.Weeks[i].Requests = scale(response.Weeks[i].Requests)
+		scaleSplit(response.Weeks[i].BusinessHours)
+	}
+	response.Estimated = true
+	response.SampleRate = sampleRate
+}
@@ -0,0 +1,50 @@
+package management
+
+import (
+	"testing"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+func TestAggregateWeekly_ZeroFillsAndSplitsBusinessHours(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+	hours := qsBusinessHours{start: 9, end: 17, loc: berlin}
+	// Monday 2025-11-24 to Sunday 2025-12-14 in Berlin: ISO weeks 48, 49 and 50
+	query := metricsQuery{
+		From: time.Date(2025, 11, 24, 0, 0, 0, 0, berlin),
+		To:   time.Date(2025, 12, 14, 23, 0, 0, 0, berlin),
+	}
+	events := []usage.UsageEvent{
+		// Monday 10:00 Berlin is 09:00 UTC: business hours
+		{Timestamp: time.Date(2025, 11, 24, 9, 0, 0, 0, time.UTC), Model: "m", TotalTokens: 10},
+		// Tuesday 17:30 Berlin: off hours
+		{Timestamp: time.Date(2025, 11, 25, 16, 30, 0, 0, time.UTC), Model: "m", TotalTokens: 20},
+		// Sunday 2025-12-14 23:30 UTC is already Monday in Berlin, outside the range
+		{Timestamp: time.Date(2025, 12, 14, 23, 30, 0, 0, time.UTC), Model: "m", TotalTokens: 40},
+		// Saturday in week 50: off hours
+		{Timestamp: time.Date(2025, 12, 13, 11, 0, 0, 0, time.UTC), Model: "m", TotalTokens: 5},
+	}
+
+	response := aggregateWeekly(events, query, hours, true)
+	if len(response.Weeks) != 3 {
+		t.Fatalf("want 3 weeks, got %d", len(response.Weeks))
+	}
+	for i, want := range []string{"2025-W48", "2025-W49", "2025-W50"} {
+		if response.Weeks[i].Week != want {
+			t.Fatalf("week %d: want %s, got %s", i, want, response.Weeks[i].Week)
+		}
+	}
+	if w := response.Weeks[0]; w.Requests != 2 || w.BusinessHours.Business.Tokens != 10 || w.BusinessHours.OffHours.Tokens != 20 {
+		t.Fatalf("unexpected week 48: %+v %+v", w, *w.BusinessHours)
+	}
+	if w := response.Weeks[1]; w.Requests != 0 || w.BusinessHours == nil {
+		t.Fatalf("want zero-filled week 49, got %+v", w)
+	}
+	if response.Totals.Tokens != 35 || response.BusinessHours.Business.Requests != 1 || response.BusinessHours.OffHours.Requests != 2 {
+		t.Fatalf("unexpected totals: %+v %+v", response.Totals, *response.BusinessHours)
+	}
+}
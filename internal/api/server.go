@@ -8,6 +8,7 @@ import (
 	"context"
 	"crypto/subtle"
 	"errors"
+	"expvar"
 	"fmt"
 	"net/http"
 	"os"
@@ -165,6 +166,12 @@ type Server struct {
 	keepAliveOnTimeout func()
 	keepAliveHeartbeat chan struct{}
 	keepAliveStop      chan struct{}
+
+	// stopQSWarmer stops the /qs/metrics warmer started by Start.
+	stopQSWarmer func()
+
+	// stopQSBudgets stops the usage budget evaluator started by Start.
+	stopQSBudgets func()
 }
 
 // NewServer creates and initializes a new API server instance.
@@ -263,6 +270,8 @@ func NewServer(cfg *config.Config, authManager *auth.Manager, accessManager *sdk
 	}
 	s.mgmt.SetLogDirectory(logDir)
 	s.mgmt.SetJSONStore(usage.GetJSONStore())
+	// The /qs endpoints follow usage-store.cors instead of corsMiddleware
+	engine.Use(s.mgmt.QSCORSMiddleware())
 	s.localPassword = optionState.localPassword
 
 	// Setup routes
@@ -562,11 +571,57 @@ func (s *Server) registerManagementRoutes() {
 		// QuantumSpring metrics endpoints (API only; UI is registered separately without auth middleware)
 		mgmt.GET("/qs/health", s.mgmt.GetQSHealth)
 		mgmt.GET("/qs/metrics", s.mgmt.GetQSMetrics)
+		mgmt.POST("/qs/metrics", s.mgmt.PostQSMetrics)
+		mgmt.POST("/qs/metrics/combined", s.mgmt.PostQSCombinedMetrics)
+		mgmt.GET("/qs/summary", s.mgmt.GetQSSummary)
+		mgmt.GET("/qs/slo", s.mgmt.GetQSSLO)
+		mgmt.GET("/qs/compare", s.mgmt.GetQSCompare)
+		mgmt.GET("/qs/metrics/weekly", s.mgmt.GetQSWeeklyMetrics)
+		mgmt.GET("/qs/metrics/hour-of-day", s.mgmt.GetQSHourOfDayMetrics)
+		mgmt.GET("/qs/metrics/day-of-week", s.mgmt.GetQSDayOfWeekMetrics)
+		mgmt.GET("/qs/report", s.mgmt.GetQSReport)
+		mgmt.GET("/qs/latency", s.mgmt.GetQSLatency)
+		mgmt.GET("/qs/download", s.mgmt.GetQSDownload)
+		mgmt.GET("/qs/range", s.mgmt.GetQSRange)
+		mgmt.GET("/qs/top-requests", s.mgmt.GetQSTopRequests)
+		mgmt.GET("/qs/size-mix", s.mgmt.GetQSSizeMix)
+		mgmt.GET("/qs/openapi.json", s.mgmt.GetQSOpenAPI)
+		mgmt.GET("/qs/tenants", s.mgmt.GetQSTenants)
+		mgmt.GET("/qs/validate", s.mgmt.GetQSValidate)
+		mgmt.GET("/qs/config", s.mgmt.GetQSConfig)
+		mgmt.GET("/qs/buffer", s.mgmt.GetQSBuffer)
+		mgmt.POST("/qs/flush", s.mgmt.PostQSFlush)
+		mgmt.POST("/qs/counters/reset", s.mgmt.PostQSCountersReset)
+		mgmt.POST("/qs/replay", s.mgmt.PostQSReplay)
+		mgmt.POST("/qs/import", s.mgmt.PostQSImport)
+		mgmt.GET("/qs/metrics/by-key-timeseries", s.mgmt.GetQSKeyTimeseries)
+		mgmt.GET("/qs/export.parquet", s.mgmt.ExportQSEventsParquet)
+		mgmt.GET("/qs/events/export", s.mgmt.ExportQSEvents)
+		mgmt.HEAD("/qs/events/export", s.mgmt.ExportQSEvents)
+		mgmt.GET("/qs/events", s.mgmt.GetQSEvents)
+		mgmt.GET("/qs/events/tail", s.mgmt.GetQSEventsTail)
+		mgmt.GET("/qs/events/recent", s.mgmt.GetQSEventsRecent)
+		mgmt.GET("/qs/events/follow", s.mgmt.GetQSEventsFollow)
 	}
 
 	// QuantumSpring metrics dashboard UI (no management key required for HTML shell;
 	// JavaScript inside the page prompts for key and calls the protected API endpoints).
 	s.engine.GET("/v0/management/qs/metrics/ui", s.mgmt.GetQSMetricsUI)
+
+	// Go expvar counters for /debug/vars scrapers, only when usage-store.expvar is set
+	s.engine.GET("/debug/vars", s.serveExpvar)
+}
+
+func (s *Server) serveExpvar(c *gin.Context) {
+	if s.cfg == nil || !s.cfg.UsageStore.Expvar {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	// Only the usage counters: the default handler would also list the
+	// command line and its flags to anyone who can reach the port
+	usage.PublishExpvar()
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	_, _ = fmt.Fprintf(c.Writer, "{\n%q: %s\n}\n", usage.ExpvarName, expvar.Get(usage.ExpvarName).String())
 }
 
 func (s *Server) managementAvailabilityMiddleware() gin.HandlerFunc {
@@ -641,6 +696,8 @@ func (s *Server) handleKeepAlive(c *gin.Context) {
 	}
 
 	s.signalKeepAlive()
+	// Count the ping as traffic without adding to the token workload
+	usage.RecordMarker(usage.EventKindPing, "")
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
@@ -713,6 +770,13 @@ func (s *Server) Start() error {
 		return fmt.Errorf("failed to start HTTP server: server not initialized")
 	}
 
+	if s.mgmt != nil && s.stopQSWarmer == nil {
+		s.stopQSWarmer = s.mgmt.StartQSWarmer()
+	}
+	if s.mgmt != nil && s.stopQSBudgets == nil {
+		s.stopQSBudgets = s.mgmt.StartQSBudgets()
+	}
+
 	useTLS := s.cfg != nil && s.cfg.TLS.Enable
 	if useTLS {
 		cert := strings.TrimSpace(s.cfg.TLS.Cert)
@@ -753,6 +817,13 @@ func (s *Server) Stop(ctx context.Context) error {
 		}
 	}
 
+	if s.stopQSWarmer != nil {
+		s.stopQSWarmer()
+	}
+	if s.stopQSBudgets != nil {
+		s.stopQSBudgets()
+	}
+
 	// Shutdown the HTTP server.
 	if err := s.server.Shutdown(ctx); err != nil {
 		return fmt.Errorf("failed to shutdown HTTP server: %v", err)
@@ -763,12 +834,17 @@ func (s *Server) Stop(ctx context.Context) error {
 }
 
 // corsMiddleware returns a Gin middleware handler that adds CORS headers
-// to every response, allowing cross-origin requests.
+// to every response, allowing cross-origin requests. The /qs management
+// endpoints are skipped; their CORS policy is configured under usage-store.cors.
 //
 // Returns:
 //   - gin.HandlerFunc: The CORS middleware handler
 func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if strings.HasPrefix(c.Request.URL.Path, managementHandlers.QSRoutePrefix) {
+			c.Next()
+			return
+		}
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		c.Header("Access-Control-Allow-Headers", "*")
@@ -1,15 +1,18 @@
 package api
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	gin "github.com/gin-gonic/gin"
 	proxyconfig "github.com/router-for-me/CLIProxyAPI/v6/internal/config"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
 	sdkaccess "github.com/router-for-me/CLIProxyAPI/v6/sdk/access"
 	"github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/auth"
 	sdkconfig "github.com/router-for-me/CLIProxyAPI/v6/sdk/config"
@@ -109,3 +112,110 @@ func TestAmpProviderModelRoutes(t *testing.T) {
 		})
 	}
 }
+
+func TestQSCORS_SameOriginByDefaultAndConfiguredOrigins(t *testing.T) {
+	server := newTestServer(t)
+	preflight := func(origin, method, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/v0/management/qs/metrics", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		req.Header.Set("Access-Control-Request-Headers", headers)
+		rr := httptest.NewRecorder()
+		server.engine.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := preflight("https://admin.example.com", http.MethodGet, "X-Management-Key")
+	if rr.Code != http.StatusForbidden || rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("want a refused preflight without CORS config, got %d %v", rr.Code, rr.Header())
+	}
+
+	server.cfg.UsageStore.CORS.AllowedOrigins = []string{"https://admin.example.com/"}
+	rr = preflight("https://admin.example.com", http.MethodGet, "x-management-key, content-type")
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("want 204 for an allowed preflight, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "https://admin.example.com" {
+		t.Fatalf("unexpected allowed origin %q", got)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(got, "X-Management-Key") {
+		t.Fatalf("want X-Management-Key allowed, got %q", got)
+	}
+	if got := rr.Header().Get("Access-Control-Max-Age"); got != "600" {
+		t.Fatalf("want the default max age, got %q", got)
+	}
+
+	for name, rr := range map[string]*httptest.ResponseRecorder{
+		"other origin":    preflight("https://evil.example.com", http.MethodGet, ""),
+		"disallowed verb": preflight("https://admin.example.com", http.MethodDelete, ""),
+		"unlisted header": preflight("https://admin.example.com", http.MethodGet, "X-Custom"),
+	} {
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("%s: want 403, got %d", name, rr.Code)
+		}
+	}
+
+	// Other routes keep the global wildcard policy
+	req := httptest.NewRequest(http.MethodOptions, "/v1/models", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	rr = httptest.NewRecorder()
+	server.engine.ServeHTTP(rr, req)
+	if rr.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("want the wildcard policy outside /qs, got %v", rr.Header())
+	}
+}
+
+func TestDebugVars_ServesOnlyUsageCounters(t *testing.T) {
+	server := newTestServer(t)
+	// Registered with the management routes once a secret key is set
+	server.registerManagementRoutes()
+	get := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		server.engine.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+		return rr
+	}
+
+	if rr := get(); rr.Code != http.StatusNotFound {
+		t.Fatalf("want 404 while expvar is off, got %d", rr.Code)
+	}
+
+	server.cfg.UsageStore.Expvar = true
+	rr := get()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", rr.Code)
+	}
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(rr.Body.Bytes(), &vars); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(vars) != 1 || vars[usage.ExpvarName] == nil {
+		t.Fatalf("want only %s, got %s", usage.ExpvarName, rr.Body.String())
+	}
+}
+
+func TestKeepAlive_RecordsPingMarker(t *testing.T) {
+	server := newTestServer(t)
+	server.enableKeepAlive(time.Hour, func() {})
+	defer func() { server.keepAliveStop <- struct{}{} }()
+	enabled := usage.StatisticsEnabled()
+	usage.SetStatisticsEnabled(true)
+	live := usage.NewLiveStore(time.Hour, 0)
+	usage.SetLiveStore(live)
+	defer func() {
+		usage.SetLiveStore(nil)
+		usage.SetStatisticsEnabled(enabled)
+	}()
+
+	rr := httptest.NewRecorder()
+	server.engine.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/keep-alive", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", rr.Code)
+	}
+	events := live.Since(time.Time{})
+	if len(events) != 1 || events[0].Kind != usage.EventKindPing {
+		t.Fatalf("want one ping marker, got %+v", events)
+	}
+	if totals := live.Totals(); totals.Requests != 0 {
+		t.Fatalf("want markers kept out of the totals, got %+v", totals)
+	}
+}
@@ -49,6 +49,9 @@ type Config struct {
 	// UsageStatisticsEnabled toggles in-memory usage aggregation; when false, usage data is discarded.
 	UsageStatisticsEnabled bool `yaml:"usage-statistics-enabled" json:"usage-statistics-enabled"`
 
+	// UsageStore configures the on-disk usage event store used by the metrics endpoints.
+	UsageStore UsageStoreConfig `yaml:"usage-store" json:"usage-store"`
+
 	// DisableCooling disables quota cooldown scheduling when true.
 	DisableCooling bool `yaml:"disable-cooling" json:"disable-cooling"`
 
@@ -105,6 +108,309 @@ type RemoteManagement struct {
 	DisableControlPanel bool `yaml:"disable-control-panel"`
 }
 
+// UsageStoreConfig holds settings for the persisted usage event store under 'usage-store'.
+type UsageStoreConfig struct {
+	// SampleRate persists only this fraction (0..1) of usage events; 0 or 1 records every event.
+	SampleRate float64 `yaml:"sample-rate" json:"sample-rate"`
+
+	// CounterRetentionDays is how long a sampled store keeps exact per-minute counters; 0 uses the default of 7 days.
+	CounterRetentionDays int `yaml:"counter-retention-days" json:"counter-retention-days"`
+
+	// MaxLookbackDays bounds how far back metrics queries may reach; 0 uses the default of 90 days.
+	MaxLookbackDays int `yaml:"max-lookback-days" json:"max-lookback-days"`
+
+	// RecentCapacity is how many recent events are cached in memory for cheap recent-window queries; 0 uses the default of 1000.
+	RecentCapacity int `yaml:"recent-capacity" json:"recent-capacity"`
+
+	// LatenessWindowSeconds is how far past a query's end range scans keep reading for out-of-order events; 0 uses the default of 600.
+	LatenessWindowSeconds int `yaml:"lateness-window-seconds" json:"lateness-window-seconds"`
+
+	// SuspiciousTokenCap flags recorded requests whose total tokens exceed this value; 0 disables the check.
+	SuspiciousTokenCap int64 `yaml:"suspicious-token-cap" json:"suspicious-token-cap"`
+
+	// ClampSuspicious clamps token counts of flagged requests to SuspiciousTokenCap instead of only flagging them.
+	ClampSuspicious bool `yaml:"clamp-suspicious" json:"clamp-suspicious"`
+
+	// CloseTimeoutSeconds bounds the final flush of the usage store on
+	// shutdown; 0 uses the default of 10.
+	CloseTimeoutSeconds int `yaml:"close-timeout-seconds" json:"close-timeout-seconds"`
+
+	// DropZeroTokenFailures discards failed requests that report no tokens
+	// instead of recording them. Negative token counts are always clamped to zero.
+	DropZeroTokenFailures bool `yaml:"drop-zero-token-failures" json:"drop-zero-token-failures"`
+
+	// MaxFieldLength caps the characters recorded for a model name or label
+	// value; longer values are truncated with "…". 0 uses the default of 128,
+	// a negative value disables the limit.
+	MaxFieldLength int `yaml:"max-field-length" json:"max-field-length"`
+
+	// LineFormat selects the on-disk line format: "json" (default), "envelope",
+	// which wraps each event as {"level","service","message"} for log ingesters,
+	// or "binary", compact length-prefixed records for very high volume.
+	LineFormat string `yaml:"line-format" json:"line-format"`
+
+	// LineFormatService is the service name written into envelope lines.
+	LineFormatService string `yaml:"line-format-service" json:"line-format-service"`
+
+	// KeyHash selects the hash applied to API keys before they are stored:
+	// "sha256" (default) or "sha512". KeyHashLength truncates the hex digest
+	// when > 0. Changing either makes older events unmatchable by key filters.
+	KeyHash       string `yaml:"key-hash" json:"key-hash"`
+	KeyHashLength int    `yaml:"key-hash-length" json:"key-hash-length"`
+
+	// Labels are stamped onto every recorded event, e.g. region or build
+	// version, and can be grouped by with group_by=label:<name>.
+	Labels map[string]string `yaml:"labels" json:"labels"`
+
+	// BufferErrors keeps failed events on the buffered path instead of flushing
+	// them to disk as soon as they are recorded.
+	BufferErrors bool `yaml:"buffer-errors" json:"buffer-errors"`
+
+	// MaxBufferBytes flushes buffered events once their estimated encoded size
+	// reaches this many bytes, in addition to the 50-event limit. 0 disables it.
+	MaxBufferBytes int64 `yaml:"max-buffer-bytes" json:"max-buffer-bytes"`
+
+	// SyncEveryFlushes and SyncEverySeconds fsync the usage file only every N
+	// flushes or once this many seconds have passed since the last fsync,
+	// instead of on every flush; 0 disables each. See usage.WithSyncPolicy.
+	SyncEveryFlushes int `yaml:"sync-every-flushes" json:"sync-every-flushes"`
+	SyncEverySeconds int `yaml:"sync-every-seconds" json:"sync-every-seconds"`
+
+	// RotateMaxMB rotates the usage file once it reaches this many megabytes; 0 disables it.
+	RotateMaxMB int64 `yaml:"rotate-max-mb" json:"rotate-max-mb"`
+
+	// RotateDaily rotates the usage file at the first flush after 00:00 UTC.
+	RotateDaily bool `yaml:"rotate-daily" json:"rotate-daily"`
+
+	// RotateMaxTotalMB deletes the oldest rotated segments after a rotation
+	// until the live file and segments fit in this many megabytes; 0 keeps all.
+	RotateMaxTotalMB int64 `yaml:"rotate-max-total-mb" json:"rotate-max-total-mb"`
+
+	// RotateProtectDays keeps segments written within this many days from the
+	// disk cap even when it is exceeded; 0 uses 7, a negative value disables
+	// the guard. RotateForcePrune deletes them anyway, logging each one.
+	RotateProtectDays int  `yaml:"rotate-protect-days" json:"rotate-protect-days"`
+	RotateForcePrune  bool `yaml:"rotate-force-prune" json:"rotate-force-prune"`
+
+	// Archive uploads rotated segments to an S3-compatible bucket.
+	Archive UsageArchiveConfig `yaml:"archive" json:"archive"`
+
+	// MaxFollowers bounds concurrent /qs/events/follow streams per store; 0 uses the default of 8.
+	MaxFollowers int `yaml:"max-followers" json:"max-followers"`
+
+	// AggregationWorkers caps the goroutines aggregating one large metrics
+	// query. 0 uses GOMAXPROCS.
+	AggregationWorkers int `yaml:"aggregation-workers" json:"aggregation-workers"`
+
+	// MaxGroups caps the distinct models, and group_by rows, one metrics query
+	// tracks; events beyond it are counted under "(other)". 0 uses 1000.
+	MaxGroups int `yaml:"max-groups" json:"max-groups"`
+
+	// DisablePersistence keeps usage in memory only instead of writing usage.json;
+	// the metrics endpoints then serve the last LiveRetentionMinutes (default 60).
+	DisablePersistence   bool `yaml:"disable-persistence" json:"disable-persistence"`
+	LiveRetentionMinutes int  `yaml:"live-retention-minutes" json:"live-retention-minutes"`
+
+	// RollupIntervalMinutes regenerates daily/weekly rollups used by long-range
+	// metrics queries at this interval. 0 disables rollups.
+	RollupIntervalMinutes int `yaml:"rollup-interval-minutes" json:"rollup-interval-minutes"`
+
+	// SelfCheckIntervalMinutes runs a background integrity scan of usage.json at
+	// this interval, reported by /qs/health. 0 disables it.
+	SelfCheckIntervalMinutes int `yaml:"self-check-interval-minutes" json:"self-check-interval-minutes"`
+
+	// Tenants optionally keeps an additional usage file per tenant.
+	Tenants UsageTenantsConfig `yaml:"tenants" json:"tenants"`
+
+	// OTEL optionally exports every usage event to an OpenTelemetry collector.
+	OTEL UsageOTELConfig `yaml:"otel" json:"otel"`
+
+	// SLO sets per-provider success rate targets for the error budget endpoint.
+	SLO UsageSLOConfig `yaml:"slo" json:"slo"`
+
+	// ModelRedaction hides model names from callers using shared dashboard keys.
+	ModelRedaction UsageModelRedactionConfig `yaml:"model-redaction" json:"model-redaction"`
+
+	// CORS lets web admins served from other origins call the /qs endpoints.
+	CORS UsageCORSConfig `yaml:"cors" json:"cors"`
+
+	// Replay configures the secondary sink for the management replay endpoint.
+	Replay UsageReplayConfig `yaml:"replay" json:"replay"`
+
+	// Expvar publishes the all-time usage counters via expvar as "cliproxy_usage"
+	// and serves that variable alone, unauthenticated, at /debug/vars.
+	Expvar bool `yaml:"expvar" json:"expvar"`
+
+	// Pricing maps model names to their token prices, used to report the
+	// savings from cached prompt tokens and the costs in the monthly report.
+	Pricing map[string]UsageModelPrice `yaml:"pricing" json:"pricing"`
+
+	// CostDecimals is the number of decimal places of the cost figures in
+	// responses and budget alerts; 0 uses 4 and a negative value keeps full
+	// precision.
+	CostDecimals int `yaml:"cost-decimals" json:"cost-decimals"`
+
+	// Budgets alerts when a model's month-to-date cost nears or exceeds its
+	// monthly budget.
+	Budgets UsageBudgetsConfig `yaml:"budgets" json:"budgets"`
+
+	// BusinessHours defines the working hours used by the weekly breakdown.
+	BusinessHours UsageBusinessHoursConfig `yaml:"business-hours" json:"business-hours"`
+
+	// ModelFamilies map model names to families such as "gpt-4 family" for
+	// group_by=family; the first matching rule wins and unmatched models are
+	// their own family.
+	ModelFamilies []UsageModelFamily `yaml:"model-families" json:"model-families"`
+
+	// SizeMix sets the token thresholds of the /qs/size-mix categories.
+	SizeMix UsageSizeMixConfig `yaml:"size-mix" json:"size-mix"`
+
+	// Warm precomputes /qs/metrics for fixed windows in the background.
+	Warm UsageWarmConfig `yaml:"warm" json:"warm"`
+
+	// UnknownModelLabel names the metrics bucket of events without a model.
+	// Empty uses "(unknown)".
+	UnknownModelLabel string `yaml:"unknown-model-label" json:"unknown-model-label"`
+}
+
+// UsageSizeMixConfig sets the token-size categories of /qs/size-mix: small
+// up to SmallMaxTokens, medium up to MediumMaxTokens and large above.
+type UsageSizeMixConfig struct {
+	// SmallMaxTokens is the largest small request; 0 uses 1000.
+	SmallMaxTokens int64 `yaml:"small-max-tokens" json:"small-max-tokens"`
+	// MediumMaxTokens is the largest medium request; 0 uses 10000.
+	MediumMaxTokens int64 `yaml:"medium-max-tokens" json:"medium-max-tokens"`
+}
+
+// UsageWarmConfig makes a background warmer recompute /qs/metrics for each
+// of Windows, so dashboards asking for /qs/metrics?window=... (or the default
+// last 24 hours) are served from memory. Empty Windows disables it.
+type UsageWarmConfig struct {
+	// Windows are the warmed windows, in days ("7d") or Go durations ("1h").
+	Windows []string `yaml:"windows" json:"windows"`
+	// IntervalSeconds is how often the warmer runs; 0 uses 60.
+	IntervalSeconds int `yaml:"interval-seconds" json:"interval-seconds"`
+}
+
+// UsageModelPrice is a model's token pricing in USD per million tokens.
+type UsageModelPrice struct {
+	Input       float64 `yaml:"input-per-million" json:"input-per-million"`
+	CachedInput float64 `yaml:"cached-input-per-million" json:"cached-input-per-million"`
+	Output      float64 `yaml:"output-per-million" json:"output-per-million"`
+}
+
+// UsageBudgetsConfig sets monthly cost budgets per model. Costs are estimated
+// from Pricing over the current UTC calendar month, as in the monthly report.
+type UsageBudgetsConfig struct {
+	// Models maps model names to their monthly budget in USD.
+	Models map[string]float64 `yaml:"models" json:"models"`
+	// WarnAt is the fraction of a budget (0..1) that raises a warning; 0 uses 0.8.
+	WarnAt float64 `yaml:"warn-at" json:"warn-at"`
+	// WebhookURL receives each alert as a JSON POST; empty only logs alerts.
+	WebhookURL string `yaml:"webhook-url" json:"webhook-url"`
+	// IntervalSeconds is how often budgets are evaluated; 0 uses 300.
+	IntervalSeconds int `yaml:"interval-seconds" json:"interval-seconds"`
+}
+
+// UsageBusinessHoursConfig defines business hours as [StartHour, EndHour) on
+// Monday to Friday in Timezone.
+type UsageBusinessHoursConfig struct {
+	// StartHour and EndHour bound the business day in hours 0-24; both 0 uses 9 to 17.
+	StartHour int `yaml:"start-hour" json:"start-hour"`
+	EndHour   int `yaml:"end-hour" json:"end-hour"`
+	// Timezone is an IANA zone name such as Europe/Berlin; empty uses UTC. ISO weeks are grouped in it too.
+	Timezone string `yaml:"timezone" json:"timezone"`
+}
+
+// UsageReplayConfig selects where replayed usage events are sent.
+type UsageReplayConfig struct {
+	// Target is "otel" (the configured collector), "file" or empty to disable replay.
+	Target string `yaml:"target" json:"target"`
+	// Path is the usage file written by the "file" target, relative to auth-dir unless absolute; it must differ from usage.json.
+	Path string `yaml:"path" json:"path"`
+}
+
+// UsageArchiveConfig configures shipping rotated usage segments to an
+// S3-compatible bucket. Archiving is enabled when Endpoint and Bucket are set.
+type UsageArchiveConfig struct {
+	// Endpoint is the host[:port] of the S3-compatible service, without a scheme.
+	Endpoint  string `yaml:"endpoint" json:"endpoint"`
+	Bucket    string `yaml:"bucket" json:"bucket"`
+	AccessKey string `yaml:"access-key" json:"-"`
+	SecretKey string `yaml:"secret-key" json:"-"`
+	Region    string `yaml:"region" json:"region"`
+	// Prefix is prepended to the object names, e.g. "usage/prod".
+	Prefix    string `yaml:"prefix" json:"prefix"`
+	UseSSL    bool   `yaml:"use-ssl" json:"use-ssl"`
+	PathStyle bool   `yaml:"path-style" json:"path-style"`
+	// Compress gzips segments while uploading them as <name>.gz.
+	Compress bool `yaml:"compress" json:"compress"`
+	// DeleteLocal removes a segment from disk once it is uploaded.
+	DeleteLocal bool `yaml:"delete-local" json:"delete-local"`
+	// MaxAttempts is how many times each upload is tried; 0 uses 5.
+	MaxAttempts int `yaml:"max-attempts" json:"max-attempts"`
+}
+
+// UsageModelRedactionConfig configures read-only shared keys for the metrics
+// endpoints whose responses only name allow-listed models.
+type UsageModelRedactionConfig struct {
+	// Allow lists the model names shown to shared-key callers; every other model is reported as "(internal)".
+	Allow []string `yaml:"allow" json:"allow"`
+	// SharedKeys may be used instead of the management key to read /qs/metrics, /qs/summary and /qs/health.
+	SharedKeys []string `yaml:"shared-keys" json:"shared-keys"`
+}
+
+// UsageModelFamily assigns the models matching Prefix or the regular
+// expression Match to Family; set one of them.
+type UsageModelFamily struct {
+	Family string `yaml:"family" json:"family"`
+	Prefix string `yaml:"prefix" json:"prefix"`
+	Match  string `yaml:"match" json:"match"`
+}
+
+// UsageCORSConfig configures cross-origin access to the /qs management
+// endpoints. With no allowed origins they are same-origin only.
+type UsageCORSConfig struct {
+	// AllowedOrigins lists the origins, e.g. "https://admin.example.com", allowed to call the endpoints; "*" allows any.
+	AllowedOrigins []string `yaml:"allowed-origins" json:"allowed-origins"`
+	// AllowedMethods lists the methods cross-origin callers may use; empty allows GET, HEAD and POST.
+	AllowedMethods []string `yaml:"allowed-methods" json:"allowed-methods"`
+	// MaxAgeSeconds is how long browsers may cache a preflight response; 0 uses the default of 600.
+	MaxAgeSeconds int `yaml:"max-age-seconds" json:"max-age-seconds"`
+}
+
+// UsageSLOConfig configures per-provider success rate objectives.
+type UsageSLOConfig struct {
+	// DefaultTarget is the target success rate (e.g. 0.99) for providers not listed in Targets.
+	DefaultTarget float64 `yaml:"default-target" json:"default-target"`
+	// Targets maps provider names to their target success rate.
+	Targets map[string]float64 `yaml:"targets" json:"targets"`
+}
+
+// UsageTenantsConfig configures per-tenant usage stores.
+type UsageTenantsConfig struct {
+	// Enable writes every event to a per-tenant file under auth-dir/usage-tenants in addition to usage.json.
+	Enable bool `yaml:"enable" json:"enable"`
+	// Header names the request header identifying the tenant; when empty or absent the API key hash is used.
+	Header string `yaml:"header" json:"header"`
+	// MaxOpen bounds the number of tenant stores kept open at once; 0 uses the default of 64.
+	MaxOpen int `yaml:"max-open" json:"max-open"`
+	// IdleTimeoutSeconds closes tenant stores unused for this long; 0 uses the default of 600.
+	IdleTimeoutSeconds int `yaml:"idle-timeout-seconds" json:"idle-timeout-seconds"`
+}
+
+// UsageOTELConfig configures OTLP/HTTP export of usage events.
+type UsageOTELConfig struct {
+	// Enable toggles exporting usage events as OTLP metrics and spans.
+	Enable bool `yaml:"enable" json:"enable"`
+	// Endpoint is the OTLP/HTTP collector base URL, e.g. http://localhost:4318.
+	Endpoint string `yaml:"endpoint" json:"endpoint"`
+	// Headers are sent with every export request, e.g. for collector authentication.
+	Headers map[string]string `yaml:"headers" json:"-"`
+	// ServiceName is reported as the service.name resource attribute.
+	ServiceName string `yaml:"service-name" json:"service-name"`
+}
+
 // QuotaExceeded defines the behavior when API quota limits are exceeded.
 // It provides configuration options for automatic failover mechanisms.
 type QuotaExceeded struct {
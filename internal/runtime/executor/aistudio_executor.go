@@ -79,6 +79,7 @@ func (e *AIStudioExecutor) Execute(ctx context.Context, auth *cliproxyauth.Auth,
 		return resp, err
 	}
 	recordAPIResponseMetadata(ctx, e.cfg, wsResp.Status, wsResp.Headers.Clone())
+	reporter.setUpstreamRequestID(wsResp.Headers)
 	if len(wsResp.Body) > 0 {
 		appendAPIResponseChunk(ctx, e.cfg, bytes.Clone(wsResp.Body))
 	}
@@ -139,6 +140,7 @@ func (e *AIStudioExecutor) ExecuteStream(ctx context.Context, auth *cliproxyauth
 		metadataLogged := false
 		if firstEvent.Status > 0 {
 			recordAPIResponseMetadata(ctx, e.cfg, firstEvent.Status, firstEvent.Headers.Clone())
+			reporter.setUpstreamRequestID(firstEvent.Headers)
 			metadataLogged = true
 		}
 		var body bytes.Buffer
@@ -188,6 +190,7 @@ func (e *AIStudioExecutor) ExecuteStream(ctx context.Context, auth *cliproxyauth
 			case wsrelay.MessageTypeStreamStart:
 				if !metadataLogged && event.Status > 0 {
 					recordAPIResponseMetadata(ctx, e.cfg, event.Status, event.Headers.Clone())
+					reporter.setUpstreamRequestID(event.Headers)
 					metadataLogged = true
 				}
 			case wsrelay.MessageTypeStreamChunk:
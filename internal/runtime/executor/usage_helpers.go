@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
@@ -23,7 +24,26 @@ type usageReporter struct {
 	apiKey      string
 	source      string
 	requestedAt time.Time
-	once        sync.Once
+	// upstreamRequestID is the provider's own ID for the request, if it returned one.
+	upstreamRequestID string
+	once              sync.Once
+}
+
+// upstreamRequestIDHeaders are the response headers providers use for their
+// request IDs: x-request-id (OpenAI and compatible APIs) and request-id (Anthropic).
+var upstreamRequestIDHeaders = []string{"x-request-id", "request-id"}
+
+// setUpstreamRequestID remembers the provider's request ID from the response headers.
+func (r *usageReporter) setUpstreamRequestID(headers http.Header) {
+	if r == nil || headers == nil {
+		return
+	}
+	for _, name := range upstreamRequestIDHeaders {
+		if id := strings.TrimSpace(headers.Get(name)); id != "" {
+			r.upstreamRequestID = id
+			return
+		}
+	}
 }
 
 func newUsageReporter(ctx context.Context, provider, model string, auth *cliproxyauth.Auth) *usageReporter {
@@ -74,15 +94,16 @@ func (r *usageReporter) publishWithOutcome(ctx context.Context, detail usage.Det
 	}
 	r.once.Do(func() {
 		usage.PublishRecord(ctx, usage.Record{
-			Provider:    r.provider,
-			Model:       r.model,
-			Source:      r.source,
-			APIKey:      r.apiKey,
-			AuthID:      r.authID,
-			AuthIndex:   r.authIndex,
-			RequestedAt: r.requestedAt,
-			Failed:      failed,
-			Detail:      detail,
+			Provider:          r.provider,
+			Model:             r.model,
+			Source:            r.source,
+			APIKey:            r.apiKey,
+			AuthID:            r.authID,
+			AuthIndex:         r.authIndex,
+			RequestedAt:       r.requestedAt,
+			Failed:            failed,
+			Detail:            detail,
+			UpstreamRequestID: r.upstreamRequestID,
 		})
 	})
 }
@@ -97,15 +118,16 @@ func (r *usageReporter) ensurePublished(ctx context.Context) {
 	}
 	r.once.Do(func() {
 		usage.PublishRecord(ctx, usage.Record{
-			Provider:    r.provider,
-			Model:       r.model,
-			Source:      r.source,
-			APIKey:      r.apiKey,
-			AuthID:      r.authID,
-			AuthIndex:   r.authIndex,
-			RequestedAt: r.requestedAt,
-			Failed:      false,
-			Detail:      usage.Detail{},
+			Provider:          r.provider,
+			Model:             r.model,
+			Source:            r.source,
+			APIKey:            r.apiKey,
+			AuthID:            r.authID,
+			AuthIndex:         r.authIndex,
+			RequestedAt:       r.requestedAt,
+			Failed:            false,
+			Detail:            usage.Detail{},
+			UpstreamRequestID: r.upstreamRequestID,
 		})
 	})
 }
@@ -0,0 +1,54 @@
+package usage
+
+import "maps"
+
+// WithEnricher adds a function that stamps metadata, typically Labels such as
+// region, build version or environment, onto each event before it is recorded.
+// Enrichers run in the order added, on every event passed to Write (but not
+// on imported ones), outside the store lock; they must be safe for concurrent use and should be cheap,
+// since they run on the recording path. They only affect this store; use
+// SetEnrichers to label recorded events for every store and exporter.
+func WithEnricher(enrich func(*UsageEvent)) StoreOption {
+	return func(s *JSONStore) {
+		if enrich != nil {
+			s.enrichers = append(s.enrichers, enrich)
+		}
+	}
+}
+
+// StaticLabels returns an enricher that sets the given labels on every event,
+// keeping any label the event already carries.
+func StaticLabels(labels map[string]string) func(*UsageEvent) {
+	labels = maps.Clone(labels)
+	return func(event *UsageEvent) {
+		if len(labels) == 0 {
+			return
+		}
+		if event.Labels == nil {
+			event.Labels = make(map[string]string, len(labels))
+		}
+		for key, value := range labels {
+			if _, ok := event.Labels[key]; !ok {
+				event.Labels[key] = value
+			}
+		}
+	}
+}
+
+// enrich applies the store's enrichers to a copy of event.
+func (s *JSONStore) enrich(event UsageEvent) UsageEvent {
+	return applyEnrichers(event, s.enrichers)
+}
+
+// applyEnrichers applies enrichers to a copy of event. Labels are cloned
+// first so enrichers never modify a map the caller still holds.
+func applyEnrichers(event UsageEvent, enrichers []func(*UsageEvent)) UsageEvent {
+	if len(enrichers) == 0 {
+		return event
+	}
+	event.Labels = maps.Clone(event.Labels)
+	for _, enrich := range enrichers {
+		enrich(&event)
+	}
+	return event
+}
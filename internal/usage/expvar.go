@@ -0,0 +1,56 @@
+package usage
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+)
+
+// ExpvarName is the expvar variable the usage counters are published under.
+const ExpvarName = "cliproxy_usage"
+
+var (
+	// flushErrorCount counts failed flushes of any usage store.
+	flushErrorCount atomic.Int64
+	// droppedEventCount counts events the OTEL exporter discarded because its queue was full.
+	droppedEventCount atomic.Int64
+
+	publishExpvarOnce sync.Once
+)
+
+// ExpvarCounters is the value published under ExpvarName. Requests, Tokens
+// and Failed are the same all-time totals /qs/summary reports.
+type ExpvarCounters struct {
+	Requests      int64 `json:"requests"`
+	Tokens        int64 `json:"tokens"`
+	Failed        int64 `json:"failed"`
+	FlushErrors   int64 `json:"flush_errors"`
+	DroppedEvents int64 `json:"dropped_events"`
+}
+
+// PublishExpvar publishes the usage counters via expvar so /debug/vars
+// scrapers pick them up. Calling it again is a no-op.
+func PublishExpvar() {
+	publishExpvarOnce.Do(func() {
+		expvar.Publish(ExpvarName, expvar.Func(func() any { return CurrentExpvarCounters() }))
+	})
+}
+
+// CurrentExpvarCounters returns the counters as published, read from the
+// shared store or, without persistence, the live store.
+func CurrentExpvarCounters() ExpvarCounters {
+	counters := ExpvarCounters{
+		FlushErrors:   flushErrorCount.Load(),
+		DroppedEvents: droppedEventCount.Load(),
+	}
+	var totals RunningTotals
+	if store := GetJSONStore(); store != nil {
+		totals = store.Totals()
+	} else if live := GetLiveStore(); live != nil {
+		totals = live.Totals()
+	}
+	counters.Requests = totals.Requests
+	counters.Tokens = totals.Tokens
+	counters.Failed = totals.Failed
+	return counters
+}
@@ -3,25 +3,104 @@ package usage
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"sync"
 	"time"
+	"weak"
 )
 
 // UsageEvent represents a single API request event for persistence.
 // This struct captures the essential metrics for each request.
 type UsageEvent struct {
+	// Timestamp is when the request was received, an alias of StartedAt
+	// that every range scan, rollup and page cursor is keyed on.
 	Timestamp        time.Time `json:"timestamp"`
 	Model            string    `json:"model"`
+	Provider         string    `json:"provider,omitempty"`
 	PromptTokens     int64     `json:"prompt_tokens"`
 	CompletionTokens int64     `json:"completion_tokens"`
 	TotalTokens      int64     `json:"total_tokens"`
 	Status           int       `json:"status"`
 	RequestID        string    `json:"request_id,omitempty"`
 	APIKeyHash       string    `json:"api_key_hash,omitempty"`
+	LatencyMs        int64     `json:"latency_ms,omitempty"`
+	// CachedTokens counts prompt tokens the provider served from its prompt cache.
+	CachedTokens int64 `json:"cached_tokens,omitempty"`
+	// UpstreamRequestID is the provider's request ID from its response headers,
+	// for correlating with the provider's support.
+	UpstreamRequestID string `json:"upstream_request_id,omitempty"`
+	// Suspicious marks events whose token count exceeded the configured sanity cap.
+	Suspicious bool `json:"suspicious,omitempty"`
+	// Labels are free-form metadata such as region or build version, set by
+	// the store's enrichers; see WithEnricher.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Kind is EventKindRequest (written as "") for proxied requests, or the
+	// kind of a marker event such as EventKindPing; see RecordMarker.
+	Kind string `json:"kind,omitempty"`
+	// StartedAt is when the request was received. The store keeps it in
+	// Timestamp and only writes it when the two differ; use StartTime.
+	StartedAt time.Time `json:"started_at,omitzero"`
+	// CompletedAt is when the response finished, zero for events recorded
+	// before it was tracked; use CompletionTime.
+	CompletedAt time.Time `json:"completed_at,omitzero"`
+}
+
+// StartTime returns when the request was received: StartedAt, or Timestamp
+// when StartedAt is unset.
+func (e UsageEvent) StartTime() time.Time {
+	if e.StartedAt.IsZero() {
+		return e.Timestamp
+	}
+	return e.StartedAt
+}
+
+// CompletionTime returns when the response finished: CompletedAt, or for
+// older events the start time plus the latency.
+func (e UsageEvent) CompletionTime() time.Time {
+	if e.CompletedAt.IsZero() {
+		return e.StartTime().Add(time.Duration(e.LatencyMs) * time.Millisecond)
+	}
+	return e.CompletedAt
+}
+
+// normalizeEventTimes makes Timestamp the start time, filling it from
+// StartedAt when only that is set, and drops StartedAt when it repeats it.
+func normalizeEventTimes(event *UsageEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = event.StartedAt
+	}
+	if event.StartedAt.Equal(event.Timestamp) {
+		event.StartedAt = time.Time{}
+	}
+}
+
+// Event kinds. Markers, every kind but EventKindRequest, carry no tokens and
+// are left out of running totals, exact counters and rollup token sums.
+const (
+	EventKindRequest = "request"
+	EventKindPing    = "ping"
+)
+
+// EventKind returns the event's kind, EventKindRequest when Kind is empty.
+func (e UsageEvent) EventKind() string {
+	if e.Kind == "" {
+		return EventKindRequest
+	}
+	return e.Kind
+}
+
+// IsMarker reports whether the event is a marker rather than a proxied request.
+func (e UsageEvent) IsMarker() bool {
+	return e.Kind != "" && e.Kind != EventKindRequest
 }
 
 // JSONStore provides append-only JSON Lines storage for usage events.
@@ -32,35 +111,267 @@ type JSONStore struct {
 	mu     sync.Mutex
 	buffer []UsageEvent
 	file   *os.File
-	ticker *time.Ticker
 	done   chan struct{}
+
+	// flushPeriodically starts the 30s flush goroutine; closeOnce guards stopping
+	// background goroutines so Close can be called more than once.
+	flushPeriodically bool
+	closeOnce         sync.Once
+	// onLeak, when set, is called by finalizeUnclosed besides counting the
+	// store in LeakedStores, so a test can tell its own store's leak apart.
+	onLeak func()
+	// closed makes Write flush each event at once, so writers still holding
+	// the store after Close (e.g. during a SetJSONStore swap) lose nothing.
+	closed bool
+	// readOnly rejects writes and skips every file write; see NewReadOnlyStore.
+	readOnly bool
+
+	// flushImmediately selects events that are flushed as soon as they are
+	// written instead of waiting in the buffer; nil disables it.
+	flushImmediately func(UsageEvent) bool
+
+	// maxBufferBytes flushes the buffer once bufferBytes, the estimated
+	// encoded size of the buffered events, reaches it; 0 disables the limit.
+	maxBufferBytes int64
+	bufferBytes    int64
+
+	// sampleRate is the fraction of events persisted; 1 disables sampling.
+	sampleRate float64
+	// seen counts every event passed to Write, recorded or not.
+	seen int64
+	// recorded counts events that were kept after sampling.
+	recorded int64
+	// counters keep exact per-minute counts of every event when sampling;
+	// nil for unsampled stores.
+	counters         *exactCounters
+	counterRetention time.Duration
+
+	// recent keeps the newest recorded events in memory for cheap recent-window queries.
+	recent         *recentRing
+	recentCapacity int
+
+	// latenessWindow is how far past a range's end LoadRange keeps scanning for late events.
+	latenessWindow time.Duration
+
+	// formatLine and parseLine override the default JSON line encoding when set.
+	formatLine LineFormatter
+	parseLine  LineParser
+	// binaryFormat creates new files in the binary record format.
+	binaryFormat bool
+
+	// totals are all-time counters of recorded events, on disk and buffered.
+	// They only include earlier runs once totalsRebuilt is set by RebuildTotals,
+	// and checkpoints are only written from then on.
+	totals        RunningTotals
+	totalsRebuilt bool
+	// span covers the events written to the store file, checkpointed with totals.
+	span EventSpan
+	// latestSegment caches the newest rotated segment's base name for
+	// checkpoints, once latestSegmentKnown is set.
+	latestSegment      string
+	latestSegmentKnown bool
+
+	// keyHasher overrides the SHA-256 hash applied to API keys when recording.
+	keyHasher KeyHasher
+
+	// enrichers stamp metadata onto each event before it is recorded.
+	enrichers []func(*UsageEvent)
+
+	// rotation closes off the file as a segment by size or day; segmentDay is
+	// the UTC day the live file started on when rotating daily.
+	rotation   RotationPolicy
+	segmentDay time.Time
+	// archive uploads rotated segments; archiveQueue feeds its background
+	// goroutine and is nil when archiving is disabled.
+	archive      ArchivePolicy
+	archiveQueue chan string
+	// now returns the current time; replaced in tests.
+	now func() time.Time
+
+	// rollupInterval enables background rollup generation; rollups caches the last generated set.
+	rollupInterval time.Duration
+	rollups        *RollupSet
+
+	// selfCheckInterval enables background integrity checks; selfCheck is the latest result.
+	selfCheckInterval time.Duration
+	selfCheck         *SelfCheckResult
+
+	// followers receive events as they are flushed, up to maxFollowers at once.
+	followers    map[*follower]struct{}
+	maxFollowers int
+
+	// syncEveryN and syncEveryT relax the fsync on every flush; see
+	// WithSyncPolicy. unsyncedFlushes counts flushes written since lastSync.
+	syncEveryN      int
+	syncEveryT      time.Duration
+	unsyncedFlushes int
+	lastSync        time.Time
+}
+
+// StoreOption configures a JSONStore.
+type StoreOption func(*JSONStore)
+
+// WithSampling makes the store persist only a random fraction of events.
+// The rate must be in the open interval (0, 1); any other value disables sampling.
+// Sampled stores mark their file with a header line so readers can scale
+// aggregates back up by 1/rate, and keep exact per-minute counts of every
+// event (see ExactCountsBetween) so range totals need no estimate.
+func WithSampling(rate float64) StoreOption {
+	return func(s *JSONStore) {
+		if rate <= 0 || rate >= 1 {
+			s.sampleRate = 1
+			return
+		}
+		s.sampleRate = rate
+	}
+}
+
+// WithRecentCapacity sets how many of the most recent events are kept in
+// memory for Recent and RecentSince. The default is 1000; 0 disables the cache.
+func WithRecentCapacity(n int) StoreOption {
+	return func(s *JSONStore) {
+		if n < 0 {
+			n = 0
+		}
+		s.recentCapacity = n
+	}
+}
+
+// WithPeriodicFlush controls whether the store flushes its buffer every 30
+// seconds in a background goroutine (the default). When disabled, buffered
+// events only reach disk when the buffer fills or on Flush and Close, which
+// suits short-lived processes and tests.
+func WithPeriodicFlush(enabled bool) StoreOption {
+	return func(s *JSONStore) {
+		s.flushPeriodically = enabled
+	}
+}
+
+// WithImmediateFlushOn makes Write flush the buffer right away when the given
+// predicate matches the written event, so those events survive a crash that
+// follows them. Other events stay on the buffered path. The default flushes
+// immediately on server errors (status >= 500); pass nil to always buffer.
+func WithImmediateFlushOn(match func(UsageEvent) bool) StoreOption {
+	return func(s *JSONStore) {
+		s.flushImmediately = match
+	}
+}
+
+// isServerError is the default immediate-flush predicate.
+func isServerError(event UsageEvent) bool {
+	return event.Status >= 500
+}
+
+// WithMaxBufferBytes makes Write also flush once the estimated encoded size
+// of the buffered events reaches n bytes, in addition to the 50-event limit.
+// Zero (the default) disables the byte limit.
+func WithMaxBufferBytes(n int64) StoreOption {
+	return func(s *JSONStore) {
+		if n < 0 {
+			n = 0
+		}
+		s.maxBufferBytes = n
+	}
 }
 
+// defaultLatenessWindow is used by LoadRange when WithLatenessWindow is not given.
+const defaultLatenessWindow = 10 * time.Minute
+
+// bufferFlushEvents is how many buffered events trigger a flush, and
+// periodicFlushInterval how often the background goroutine flushes the rest.
+const (
+	bufferFlushEvents     = 50
+	periodicFlushInterval = 30 * time.Second
+)
+
+// WithLatenessWindow sets how far past the end of a range LoadRange keeps
+// scanning for out-of-order events before stopping. The default is 10 minutes.
+func WithLatenessWindow(d time.Duration) StoreOption {
+	return func(s *JSONStore) {
+		if d < 0 {
+			d = 0
+		}
+		s.latenessWindow = d
+	}
+}
+
+// storeHeader is written after the schema line of a sampled store file.
+type storeHeader struct {
+	SampleRate float64 `json:"sample_rate"`
+}
+
+// headerLine wraps storeHeader so it can be told apart from event lines.
+type headerLine struct {
+	Header storeHeader `json:"_header"`
+}
+
+// headerPrefix identifies header lines without a full decode.
+var headerPrefix = []byte(`{"_header":`)
+
 // NewJSONStore creates a new JSON store at the specified path.
 // The file will be created if it doesn't exist, or opened for append if it does.
-// A background goroutine will periodically flush buffered events every 30 seconds.
+// A background goroutine will periodically flush buffered events every 30 seconds
+// unless WithPeriodicFlush(false) is given.
 //
 // Parameters:
 //   - path: The file path where usage events will be stored
+//   - opts: Optional store settings such as WithSampling
 //
 // Returns:
 //   - *JSONStore: A new JSON store instance
-func NewJSONStore(path string) *JSONStore {
+func NewJSONStore(path string, opts ...StoreOption) *JSONStore {
 	s := &JSONStore{
-		path:   path,
-		buffer: make([]UsageEvent, 0, 50),
-		ticker: time.NewTicker(30 * time.Second),
-		done:   make(chan struct{}),
+		path:              path,
+		buffer:            make([]UsageEvent, 0, bufferFlushEvents),
+		flushPeriodically: true,
+		flushImmediately:  isServerError,
+		now:               time.Now,
+		sampleRate:        1,
+		recentCapacity:    defaultRecentCapacity,
+		latenessWindow:    defaultLatenessWindow,
+		totals:            newRunningTotals(),
+		counterRetention:  defaultCounterRetention,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.sampleRate < 1 {
+		s.loadCounters()
 	}
+	s.recent = newRecentRing(s.recentCapacity, time.Now())
 
-	// Start periodic flush goroutine
-	go s.periodicFlush()
+	if s.archive.Uploader != nil {
+		s.archiveQueue = make(chan string, archiveQueueSize)
+	}
+	if s.flushPeriodically || s.rollupInterval > 0 || s.selfCheckInterval > 0 || s.archiveQueue != nil {
+		s.done = make(chan struct{})
+	}
+
+	// Start background goroutines; they hold the store weakly so one that
+	// is never closed can still be collected, see finalizeUnclosed
+	if s.flushPeriodically {
+		go runEvery(weak.Make(s), periodicFlushInterval, s.done, (*JSONStore).periodicFlush)
+	}
+	if s.rollupInterval > 0 {
+		go runEvery(weak.Make(s), s.rollupInterval, s.done, (*JSONStore).periodicRollup)
+	}
+	if s.selfCheckInterval > 0 {
+		go runEvery(weak.Make(s), s.selfCheckInterval, s.done, (*JSONStore).runSelfCheck)
+	}
+	if s.archiveQueue != nil {
+		go runArchiver(weak.Make(s), s.archive, s.archiveQueue, s.done)
+	}
+	if s.done != nil {
+		runtime.SetFinalizer(s, (*JSONStore).finalizeUnclosed)
+	}
 
 	return s
 }
 
 // Write adds a usage event to the store's buffer.
 // Events are buffered in memory and periodically flushed to disk for performance.
+// When sampling is enabled only a fraction of events is buffered, but every
+// call is added to the exact counters.
 // This method is thread-safe and non-blocking.
 //
 // Parameters:
@@ -72,22 +383,65 @@ func (s *JSONStore) Write(event UsageEvent) error {
 	if s == nil {
 		return fmt.Errorf("json store is nil")
 	}
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	event = s.enrich(event)
+	truncateLongFields(&event)
+	normalizeEventTimes(&event)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.seen++
+	s.countLocked(event)
+	if s.sampleRate < 1 && rand.Float64() >= s.sampleRate {
+		return nil
+	}
+	s.recorded++
+	return s.appendLocked(event)
+}
+
+// appendLocked buffers an event that is to be stored and flushes when the
+// buffer is full or the event must not wait.
+// Must be called with s.mu held.
+func (s *JSONStore) appendLocked(event UsageEvent) error {
+	s.recent.push(event)
+	s.totals.add(event, 1)
 	s.buffer = append(s.buffer, event)
+	s.bufferBytes += estimateEventBytes(event)
 
-	// Auto-flush if buffer gets large (50 events)
-	if len(s.buffer) >= 50 {
+	// Auto-flush if buffer gets large (50 events or the byte limit) or the event must not be lost
+	if s.closed || len(s.buffer) >= bufferFlushEvents || (s.maxBufferBytes > 0 && s.bufferBytes >= s.maxBufferBytes) {
+		return s.flushLocked()
+	}
+	if s.flushImmediately != nil && s.flushImmediately(event) {
 		return s.flushLocked()
 	}
 
 	return nil
 }
 
+// eventFixedBytes approximates the encoded size of an event's keys, numbers
+// and timestamp; estimateEventBytes adds its variable-length strings.
+const eventFixedBytes = 220
+
+// estimateEventBytes cheaply estimates the size of an event's encoded line.
+func estimateEventBytes(event UsageEvent) int64 {
+	size := eventFixedBytes + int64(len(event.Model)+len(event.Provider)+len(event.RequestID)+len(event.APIKeyHash))
+	if !event.CompletedAt.IsZero() {
+		size += 50
+	}
+	for key, value := range event.Labels {
+		size += int64(len(key) + len(value) + 6)
+	}
+	return size
+}
+
 // Flush writes all buffered events to disk.
 // This should be called periodically and before shutdown to ensure data persistence.
+// Flushes are serialized, so one racing the periodic flush finds an empty
+// buffer and neither rotates nor checks the file size again.
 //
 // Returns:
 //   - error: An error if the flush operation fails
@@ -95,6 +449,9 @@ func (s *JSONStore) Flush() error {
 	if s == nil {
 		return fmt.Errorf("json store is nil")
 	}
+	if s.readOnly {
+		return ErrReadOnly
+	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -102,118 +459,572 @@ func (s *JSONStore) Flush() error {
 	return s.flushLocked()
 }
 
+// FlushCount flushes like Flush and reports how many events were written.
+//
+// Returns:
+//   - int: The number of buffered events written to disk
+//   - error: An error if the flush operation fails
+func (s *JSONStore) FlushCount() (int, error) {
+	if s == nil {
+		return 0, fmt.Errorf("json store is nil")
+	}
+	if s.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.buffer)
+	if err := s.flushLocked(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Drain atomically removes and returns all buffered events without writing
+// them to disk. Unlike Flush, the events are handed to the caller, which
+// becomes responsible for them (e.g. forwarding to another sink when the
+// local disk is unavailable). They are also removed from the recent events,
+// so Recent, RecentSince and the endpoints served from them no longer report
+// them.
+//
+// Returns:
+//   - []UsageEvent: The events that were buffered, oldest first
+//   - error: An error if the store is nil
+func (s *JSONStore) Drain() ([]UsageEvent, error) {
+	if s == nil {
+		return nil, fmt.Errorf("json store is nil")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	drained := make([]UsageEvent, len(s.buffer))
+	copy(drained, s.buffer)
+	s.buffer = s.buffer[:0]
+	s.bufferBytes = 0
+
+	// Drained events never reach disk, so they no longer count toward the
+	// totals or the recent events; the buffer always holds the newest of those
+	for _, event := range drained {
+		s.totals.add(event, -1)
+	}
+	s.recent.dropNewest(len(drained))
+
+	return drained, nil
+}
+
 // flushLocked performs the actual flush operation.
 // Must be called with s.mu held.
 func (s *JSONStore) flushLocked() error {
-	if len(s.buffer) == 0 {
+	if s.readOnly {
+		// Nothing is ever buffered, so readers flushing first just proceed
 		return nil
 	}
+	// Exact counters change even when sampling buffered nothing
+	s.saveCountersLocked(false)
 
+	if len(s.buffer) == 0 {
+		// Let an idle store catch up on fsyncs the policy deferred
+		return s.syncPendingLocked(!s.closed)
+	}
+
+	// Written events leave the buffer as soon as they are on disk, so after
+	// an error only the unwritten ones are retried
+	size, err := s.flushRotatingLocked()
+	if err != nil {
+		flushErrorCount.Add(1)
+		return err
+	}
+
+	// Totals now match the file exactly; checkpoint them for fast startup
+	if s.totalsRebuilt {
+		s.saveCheckpointLocked(size)
+	}
+
+	return nil
+}
+
+// appendEventsLocked appends events to the store file, creating it (with the
+// schema line, and the sample header when sampled) if needed, and returns the
+// resulting file size.
+// Must be called with s.mu held.
+func (s *JSONStore) appendEventsLocked(events []UsageEvent) (int64, error) {
 	// Ensure directory exists
 	dir := filepath.Dir(s.path)
 	if err := os.MkdirAll(dir, 0o755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
+		return 0, fmt.Errorf("failed to create directory: %w", err)
 	}
 
 	// Open file for append (create if doesn't exist)
 	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
 	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+		return 0, fmt.Errorf("failed to open file: %w", err)
 	}
 	defer f.Close()
 
-	// Write each event as a JSON line
-	encoder := json.NewEncoder(f)
-	for i := range s.buffer {
-		if err := encoder.Encode(&s.buffer[i]); err != nil {
-			return fmt.Errorf("failed to encode event: %w", err)
+	w := bufio.NewWriter(f)
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+	// New files take the configured format; existing ones keep their own
+	binaryFile := s.binaryFormat
+	if info.Size() > 0 {
+		binaryFile = isBinaryPath(s.path)
+	}
+	if info.Size() == 0 {
+		if binaryFile {
+			if _, err := w.Write(binaryMagic); err != nil {
+				return 0, fmt.Errorf("failed to write binary magic: %w", err)
+			}
+		}
+		if err := json.NewEncoder(w).Encode(schemaLine{Schema: SchemaCurrent}); err != nil {
+			return 0, fmt.Errorf("failed to encode schema line: %w", err)
+		}
+		// Mark new sampled files so readers know the data is a sample
+		if s.sampleRate < 1 {
+			if err := json.NewEncoder(w).Encode(headerLine{Header: storeHeader{SampleRate: s.sampleRate}}); err != nil {
+				return 0, fmt.Errorf("failed to encode header: %w", err)
+			}
 		}
 	}
 
-	// Sync to disk
-	if err := f.Sync(); err != nil {
-		return fmt.Errorf("failed to sync file: %w", err)
+	if err := s.writeEvents(w, events, binaryFile); err != nil {
+		return 0, err
+	}
+	if err := w.Flush(); err != nil {
+		return 0, fmt.Errorf("failed to write file: %w", err)
 	}
 
-	// Clear buffer after successful write
-	s.buffer = s.buffer[:0]
+	// Sync to disk, every flush unless a sync policy relaxes it
+	if s.syncDueLocked() {
+		if err := f.Sync(); err != nil {
+			return 0, fmt.Errorf("failed to sync file: %w", err)
+		}
+		s.markSyncedLocked()
+	}
+
+	s.span.add(events...)
 
+	info, err = f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+	return info.Size(), nil
+}
+
+// writeEvents writes each event as a single line, or a record in binary files.
+func (s *JSONStore) writeEvents(w *bufio.Writer, events []UsageEvent, binaryFile bool) error {
+	var record []byte
+	for i := range events {
+		if binaryFile {
+			record = appendBinaryRecord(record[:0], events[i])
+			if _, err := w.Write(record); err != nil {
+				return fmt.Errorf("failed to write event: %w", err)
+			}
+			continue
+		}
+		line, err := s.encodeLine(events[i])
+		if err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+		if _, err := w.Write(line); err != nil {
+			return fmt.Errorf("failed to write event: %w", err)
+		}
+	}
 	return nil
 }
 
-// periodicFlush runs in a background goroutine and flushes buffered events every 30 seconds.
+// fileExistsLocked reports whether the store file has been created, which
+// happens on the first flush that has events to write.
+// Must be called with s.mu held.
+func (s *JSONStore) fileExistsLocked() bool {
+	_, err := os.Stat(s.path)
+	return err == nil
+}
+
+// periodicFlush is run by a background goroutine every 30 seconds.
 // This ensures that events are persisted even if the buffer doesn't fill up.
 func (s *JSONStore) periodicFlush() {
-	for {
-		select {
-		case <-s.ticker.C:
-			// Periodic flush every 30 seconds
-			if err := s.Flush(); err != nil {
-				fmt.Fprintf(os.Stderr, "periodic flush error: %v\n", err)
-			}
-		case <-s.done:
-			// Stop signal received
-			return
-		}
+	if err := s.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "periodic flush error: %v\n", err)
 	}
 }
 
-// Load reads all usage events from the file.
+// Load reads all usage events from the file. Events still buffered in
+// memory are not included, so the result matches what a backup of the file
+// would hold; LoadAll adds them.
 // This is typically called on server startup to restore historical data.
+// A store that was never written returns no events and the file is not created.
+// A gzipped JSON Lines file, such as a compressed backup opened with
+// NewReadOnlyStore, is decompressed on the fly.
+//
+// The store lock is only held to take a snapshot of the file size; the file
+// is read afterwards, so a long read never stalls Write. Events flushed
+// during the read are not included.
 //
 // Returns:
 //   - []UsageEvent: All events stored in the file
 //   - error: An error if the load operation fails
 func (s *JSONStore) Load() ([]UsageEvent, error) {
+	events, _, err := s.load(false)
+	return events, err
+}
+
+// LoadAll reads all usage events from the file followed by the events still
+// buffered in memory, i.e. every event recorded before the call, including
+// those of the last flush interval. The file size and the buffer are
+// snapshotted under one lock, so an event flushed while the file is read is
+// returned once, from the buffer copy, and never twice.
+//
+// Returns:
+//   - []UsageEvent: The file's events in file order, then the buffered ones in write order
+//   - error: An error if the load operation fails
+func (s *JSONStore) LoadAll() ([]UsageEvent, error) {
+	events, buffered, err := s.load(true)
+	if err != nil {
+		return nil, err
+	}
+	return append(events, buffered...), nil
+}
+
+// load reads the file as of the call and, with withBuffer, copies the
+// buffer at the same moment.
+func (s *JSONStore) load(withBuffer bool) (events, buffered []UsageEvent, err error) {
 	if s == nil {
-		return nil, fmt.Errorf("json store is nil")
+		return nil, nil, fmt.Errorf("json store is nil")
 	}
 
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	snapshot, err := s.openSnapshotLocked()
+	if withBuffer {
+		buffered = slices.Clone(s.buffer)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer snapshot.close()
+
+	events = []UsageEvent{}
+	err = s.scanSnapshot(snapshot, func(event UsageEvent) bool {
+		events = append(events, event)
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return events, buffered, nil
+}
+
+// LoadRange reads the events whose timestamps fall within [from, to], from
+// the segments that may hold them and then the live file.
+//
+// Each segment and the file is in roughly chronological order, so segments
+// last written before from are skipped and the scan of each file stops at its
+// first event stamped later than to plus the store's lateness window instead
+// of reading to the end. The stop is per file since import segments may
+// overlap the time covered by others. Events are recorded asynchronously and
+// may land slightly out of order; any in-range event is still returned as
+// long as it was written before an event more than the lateness window past
+// to in the same file. Like Load it reads
+// the files as of the call without holding the store lock, and buffered
+// events are not included.
+//
+// Parameters:
+//   - from: Inclusive start of the range
+//   - to: Inclusive end of the range
+//
+// Returns:
+//   - []UsageEvent: The events inside the range, oldest segment first, in file order
+//   - error: An error if the load operation fails
+func (s *JSONStore) LoadRange(from, to time.Time) ([]UsageEvent, error) {
+	events := []UsageEvent{}
+	err := s.IterateRange(from, to, func(event UsageEvent) bool {
+		events = append(events, event)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// IterateRange calls fn with each event LoadRange would return, in the same
+// order, until fn returns false, without holding the events in memory. Like
+// LoadRange it reads the files as of the call, so events written meanwhile
+// are not included however long fn takes.
+//
+// Parameters:
+//   - from: Inclusive start of the range
+//   - to: Inclusive end of the range
+//   - fn: Called for every event in the range; return false to stop
+//
+// Returns:
+//   - error: An error if a file cannot be read
+func (s *JSONStore) IterateRange(from, to time.Time, fn func(UsageEvent) bool) error {
+	if s == nil {
+		return fmt.Errorf("json store is nil")
+	}
+
+	s.mu.Lock()
+	snapshots, err := s.openStoreSnapshotsLocked(from)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	defer closeSnapshots(snapshots)
+
+	stopAfter := to.Add(s.latenessWindow)
+	stopped := false
+	for _, snapshot := range snapshots {
+		err := s.scanSnapshot(snapshot, func(event UsageEvent) bool {
+			if event.Timestamp.After(stopAfter) {
+				return false
+			}
+			if !event.Timestamp.Before(from) && !event.Timestamp.After(to) {
+				stopped = !fn(event)
+			}
+			return !stopped
+		})
+		if err != nil || stopped {
+			return err
+		}
+	}
+	return nil
+}
 
-	// Check if file exists
-	if _, err := os.Stat(s.path); os.IsNotExist(err) {
-		// File doesn't exist yet, return empty slice
-		return []UsageEvent{}, nil
+// Iterate calls fn with each event of the rotated segments, oldest first,
+// and then of the live file, until fn returns false, without holding the
+// events in memory. Buffered events are not included. Like Load it reads the
+// files as of the call without holding the store lock, so fn may take its
+// time.
+//
+// Parameters:
+//   - fn: Called for every event; return false to stop
+//
+// Returns:
+//   - error: An error if the file cannot be read
+func (s *JSONStore) Iterate(fn func(UsageEvent) bool) error {
+	if s == nil {
+		return fmt.Errorf("json store is nil")
 	}
 
-	// Open file for reading
+	s.mu.Lock()
+	snapshots, err := s.openStoreSnapshotsLocked(time.Time{})
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	defer closeSnapshots(snapshots)
+
+	return s.scanSnapshots(snapshots, fn)
+}
+
+// fileSnapshot is the store file opened for reading and its size at the time.
+// Flushes write whole lines and records under s.mu, so a size taken under
+// the lock is always at an event boundary. The file is only ever appended
+// to, and rotation renames it, so the open file keeps serving the same bytes
+// without the lock. A nil file means there was no file yet.
+type fileSnapshot struct {
+	file *os.File
+	size int64
+}
+
+func (f fileSnapshot) close() {
+	if f.file != nil {
+		_ = f.file.Close()
+	}
+}
+
+// openSnapshotLocked opens the store file for a read outside the lock.
+// Must be called with s.mu held.
+func (s *JSONStore) openSnapshotLocked() (fileSnapshot, error) {
 	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		// File doesn't exist yet, nothing to read
+		return fileSnapshot{}, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
+		return fileSnapshot{}, fmt.Errorf("failed to open file: %w", err)
 	}
-	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fileSnapshot{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+	return fileSnapshot{file: f, size: info.Size()}, nil
+}
+
+// openStoreSnapshotsLocked opens the rotated segments last written at or
+// after from, oldest first, followed by the live file.
+// Must be called with s.mu held.
+func (s *JSONStore) openStoreSnapshotsLocked(from time.Time) ([]fileSnapshot, error) {
+	snapshots, err := s.openSegmentSnapshotsLocked(from)
+	if err != nil {
+		return nil, err
+	}
+	live, err := s.openSnapshotLocked()
+	if err != nil {
+		closeSnapshots(snapshots)
+		return nil, err
+	}
+	return append(snapshots, live), nil
+}
 
-	// Read events line by line
-	var events []UsageEvent
-	scanner := bufio.NewScanner(f)
+// openSegmentSnapshotsLocked opens the rotated segments last written at or
+// after from, oldest first; a zero from opens every segment. A segment only
+// holds events recorded before its rotation, so one last modified before
+// from has none stamped within a range starting there. Segments are never
+// written again, so their snapshots cover them whole.
+// Must be called with s.mu held.
+func (s *JSONStore) openSegmentSnapshotsLocked(from time.Time) ([]fileSnapshot, error) {
+	segments, err := s.Segments()
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]fileSnapshot, 0, len(segments)+1)
+	for _, path := range segments {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			// Pruned since it was listed
+			continue
+		}
+		if err != nil {
+			closeSnapshots(snapshots)
+			return nil, fmt.Errorf("failed to open segment: %w", err)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			closeSnapshots(snapshots)
+			return nil, fmt.Errorf("failed to stat segment: %w", err)
+		}
+		if info.ModTime().Before(from) {
+			_ = f.Close()
+			continue
+		}
+		snapshots = append(snapshots, fileSnapshot{file: f, size: info.Size()})
+	}
+	return snapshots, nil
+}
+
+func closeSnapshots(snapshots []fileSnapshot) {
+	for _, snapshot := range snapshots {
+		snapshot.close()
+	}
+}
+
+// scanSnapshots is scanSnapshot over several files in order, stopping at the
+// first fn call that returns false.
+func (s *JSONStore) scanSnapshots(snapshots []fileSnapshot, fn func(UsageEvent) bool) error {
+	stopped := false
+	for _, snapshot := range snapshots {
+		err := s.scanSnapshot(snapshot, func(event UsageEvent) bool {
+			stopped = !fn(event)
+			return !stopped
+		})
+		if err != nil || stopped {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanSnapshot is scanLocked for a snapshot; it does not need s.mu.
+func (s *JSONStore) scanSnapshot(snapshot fileSnapshot, fn func(UsageEvent) bool) error {
+	_, err := s.scanSnapshotLines(snapshot, func(lineNum int, event UsageEvent, err error) bool {
+		if err != nil {
+			// Log warning but continue reading other events
+			fmt.Fprintf(os.Stderr, "warning: failed to parse event on line %d: %v\n", lineNum, err)
+			return true
+		}
+		return fn(event)
+	})
+	return err
+}
+
+// scanLocked decodes each event in the store file in order, calling fn until it returns false.
+// Lines that fail to parse are skipped with a warning.
+// Must be called with s.mu held.
+func (s *JSONStore) scanLocked(fn func(UsageEvent) bool) error {
+	snapshot, err := s.openSnapshotLocked()
+	if err != nil {
+		return err
+	}
+	defer snapshot.close()
+	return s.scanSnapshot(snapshot, fn)
+}
+
+// scanSnapshotLines calls visit for every event line of a snapshot, reading
+// no further than its size, with its 1-based line number and either the
+// decoded event or the parse error, until visit returns false. Empty lines,
+// the schema line and the store header are not visited.
+// It returns the number of lines read. It does not need s.mu.
+func (s *JSONStore) scanSnapshotLines(snapshot fileSnapshot, visit func(lineNum int, event UsageEvent, err error) bool) (int, error) {
+	f := snapshot.file
+	if f == nil {
+		return 0, nil
+	}
+
+	if isBinaryFile(f) {
+		// Binary files have records rather than lines; number them instead
+		start, version, err := binaryDataStart(f)
+		if err != nil {
+			return 0, err
+		}
+		records := 0
+		_, err = s.scanBinaryRecords(f, start, version, func(_, end int64, event UsageEvent, err error) bool {
+			if end > snapshot.size {
+				return false
+			}
+			records++
+			return visit(records, event, err)
+		})
+		return records, err
+	}
+
+	// Read events line by line, decompressing a gzipped file on the fly
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek file: %w", err)
+	}
+	input, closer, err := gunzipIfCompressed(io.LimitReader(f, snapshot.size))
+	if err != nil {
+		return 0, err
+	}
+	defer closer.Close()
+	scanner := bufio.NewScanner(input)
 	lineNum := 0
+	version := SchemaLegacy
 
 	for scanner.Scan() {
 		lineNum++
 		line := scanner.Bytes()
 
-		// Skip empty lines
-		if len(line) == 0 {
+		// Skip empty lines, comments, the schema line and the store header
+		if v, ok := parseSchemaLine(line); ok {
+			version = v
 			continue
 		}
-
-		var event UsageEvent
-		if err := json.Unmarshal(line, &event); err != nil {
-			// Log warning but continue reading other events
-			fmt.Fprintf(os.Stderr, "warning: failed to parse event on line %d: %v\n", lineNum, err)
+		if isSkippedLine(line) {
 			continue
 		}
 
-		events = append(events, event)
+		event, err := s.decodeLine(line, version)
+		if !visit(lineNum, event, err) {
+			return lineNum, nil
+		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("failed to read file: %w", err)
+		return lineNum, fmt.Errorf("failed to read file: %w", err)
 	}
 
-	return events, nil
+	return lineNum, nil
 }
 
 // Close flushes any remaining buffered events and closes the store.
@@ -226,20 +1037,74 @@ func (s *JSONStore) Close() error {
 		return nil
 	}
 
-	// Stop the periodic flush goroutine
-	if s.ticker != nil {
-		s.ticker.Stop()
+	// Stop background goroutines; they may never have been started
+	s.stopBackground()
+
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	// Flush any remaining events
+	if !s.readOnly {
+		if err := s.Flush(); err != nil {
+			return err
+		}
 	}
-	if s.done != nil {
-		close(s.done)
+
+	s.mu.Lock()
+	s.saveCountersLocked(true)
+	s.closeFollowersLocked()
+	s.mu.Unlock()
+
+	return nil
+}
+
+// ErrCloseTimeout is returned by CloseWithTimeout when the final flush does
+// not finish before the deadline.
+var ErrCloseTimeout = errors.New("usage store close timed out")
+
+// CloseWithTimeout closes the store like Close but gives up waiting after d,
+// so shutdown does not hang on a stuck disk. On timeout it returns an error
+// wrapping ErrCloseTimeout. The flush keeps running in the background and
+// only clears the buffer once its write succeeds, so buffered events are still
+// persisted if the disk recovers. A d of zero or less waits without limit.
+//
+// Returns:
+//   - error: An error if the close fails or does not finish in time
+func (s *JSONStore) CloseWithTimeout(d time.Duration) error {
+	if d <= 0 {
+		return s.Close()
 	}
 
-	// Flush any remaining events
-	if err := s.Flush(); err != nil {
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Close()
+	}()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case err := <-done:
 		return err
+	case <-timer.C:
+		return fmt.Errorf("%w after %s with events still buffered", ErrCloseTimeout, d)
 	}
+}
 
-	return nil
+// BufferedEvents returns a copy of the events recorded but not yet flushed to
+// disk, oldest first. Readers of the file can merge them in to see the most
+// recent traffic before the next flush.
+func (s *JSONStore) BufferedEvents() []UsageEvent {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	buffered := make([]UsageEvent, len(s.buffer))
+	copy(buffered, s.buffer)
+	return buffered
 }
 
 // Len returns the number of events currently in the buffer (not yet flushed).
@@ -254,3 +1119,244 @@ func (s *JSONStore) Len() int {
 	return len(s.buffer)
 }
 
+// SampleRate returns the fraction of events persisted by this store.
+// The rate recorded in the file header takes precedence over the configured
+// rate, since it describes the data actually on disk.
+func (s *JSONStore) SampleRate() float64 {
+	if s == nil {
+		return 1
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rate, ok := readHeaderRate(s.path); ok {
+		return rate
+	}
+	return s.sampleRate
+}
+
+// Counts returns how many events were passed to Write and how many of them
+// were kept after sampling, since the store was created.
+func (s *JSONStore) Counts() (seen, recorded int64) {
+	if s == nil {
+		return 0, 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.seen, s.recorded
+}
+
+// Recent returns up to n of the most recently recorded events, oldest first,
+// without touching disk.
+func (s *JSONStore) Recent(n int) []UsageEvent {
+	if s == nil {
+		return []UsageEvent{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.recent.last(n)
+}
+
+// RecentSince returns the in-memory events if they hold every event recorded
+// at or after from. ok is false when the cache is disabled or from reaches
+// further back than the cache covers, in which case callers should fall back
+// to Load.
+func (s *JSONStore) RecentSince(from time.Time) (events []UsageEvent, ok bool) {
+	if s == nil {
+		return nil, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.recent == nil || from.Before(s.recent.coveredSince) {
+		return nil, false
+	}
+	return s.recent.last(s.recent.count), true
+}
+
+// readHeaderRate returns the sample rate from the header line of the file at path.
+func readHeaderRate(path string) (float64, bool) {
+	var rate float64
+	var found bool
+	readMetaLines(path, func(line []byte) {
+		line = trimBOM(line)
+		if !bytes.HasPrefix(line, headerPrefix) {
+			return
+		}
+		var h headerLine
+		if err := json.Unmarshal(line, &h); err != nil {
+			return
+		}
+		if h.Header.SampleRate <= 0 || h.Header.SampleRate > 1 {
+			return
+		}
+		rate, found = h.Header.SampleRate, true
+	})
+	return rate, found
+}
+
+// TailResult is a page of events read from a byte offset in the store file.
+type TailResult struct {
+	// Events are the complete event lines read after the requested offset.
+	Events []UsageEvent
+	// Offset is the cursor to pass to the next ReadFrom call.
+	Offset int64
+	// Reset reports that the requested offset no longer matched the file
+	// (for example after truncation or rotation) and reading restarted at 0.
+	Reset bool
+	// Generation identifies the live file Offset belongs to: the base name of
+	// the newest rotated segment when it was read, "" before any rotation.
+	// Pass it back to ReadFromGeneration with Offset.
+	Generation string
+}
+
+// ReadFrom reads up to limit events starting at the given byte offset of the
+// store file. Only complete lines are consumed, so the returned offset always
+// points at the start of the next unread line. A limit <= 0 reads to the end.
+// An offset kept across a rotation may still fall on a line boundary of the
+// new file, so callers that resume from an earlier result should use
+// ReadFromGeneration instead.
+//
+// Parameters:
+//   - offset: Byte offset returned by a previous call, or 0 to start at the beginning
+//   - limit: Maximum number of events to return
+//
+// Returns:
+//   - TailResult: The events read and the next cursor
+//   - error: An error if the file cannot be read
+func (s *JSONStore) ReadFrom(offset int64, limit int) (TailResult, error) {
+	if s == nil {
+		return TailResult{}, fmt.Errorf("json store is nil")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.readFromLocked(offset, limit)
+}
+
+// ReadFromGeneration reads like ReadFrom from an offset returned together
+// with generation. When the live file has been rotated since, the offset
+// belongs to what is now a segment, so reading restarts at 0 of the new live
+// file with Reset set, even if offset falls on one of its line boundaries.
+//
+// Parameters:
+//   - generation: The Generation of the TailResult that returned offset
+//   - offset: Byte offset returned by that call
+//   - limit: Maximum number of events to return
+//
+// Returns:
+//   - TailResult: The events read and the next cursor
+//   - error: An error if the file cannot be read
+func (s *JSONStore) ReadFromGeneration(generation string, offset int64, limit int) (TailResult, error) {
+	if s == nil {
+		return TailResult{}, fmt.Errorf("json store is nil")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if generation != s.latestSegmentLocked() {
+		result, err := s.readFromLocked(0, limit)
+		result.Reset = true
+		return result, err
+	}
+	return s.readFromLocked(offset, limit)
+}
+
+// Generation returns the generation of the live file, as reported in
+// TailResult.Generation.
+func (s *JSONStore) Generation() string {
+	if s == nil {
+		return ""
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.latestSegmentLocked()
+}
+
+// readFromLocked implements ReadFrom. Must be called with s.mu held.
+func (s *JSONStore) readFromLocked(offset int64, limit int) (TailResult, error) {
+	result, err := s.readFileFromLocked(s.path, offset, limit)
+	if err != nil {
+		return result, err
+	}
+	result.Generation = s.latestSegmentLocked()
+	return result, nil
+}
+
+// readFileFromLocked reads events from offset on in the store file or one of
+// its rotated segments. Must be called with s.mu held.
+func (s *JSONStore) readFileFromLocked(path string, offset int64, limit int) (TailResult, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return TailResult{Events: []UsageEvent{}, Reset: offset != 0}, nil
+	}
+	if err != nil {
+		return TailResult{}, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return TailResult{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	if isBinaryFile(f) {
+		return s.readBinaryFromLocked(f, info.Size(), offset, limit)
+	}
+
+	result := TailResult{Events: []UsageEvent{}}
+	if offset < 0 || offset > info.Size() || !atLineStart(f, offset) {
+		offset = 0
+		result.Reset = true
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return TailResult{}, fmt.Errorf("failed to seek file: %w", err)
+	}
+
+	// The schema line is at the top, so read it separately when resuming mid-file
+	version := readSchemaVersion(path)
+	reader := bufio.NewReader(f)
+	for limit <= 0 || len(result.Events) < limit {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// Partial trailing line: leave it for the next call
+			break
+		}
+		offset += int64(len(line))
+		line = bytes.TrimSpace(line)
+		if isSkippedLine(line) {
+			continue
+		}
+		event, err := s.decodeLine(line, version)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to parse event at offset %d: %v\n", offset, err)
+			continue
+		}
+		result.Events = append(result.Events, event)
+	}
+	result.Offset = offset
+
+	return result, nil
+}
+
+// atLineStart reports whether offset falls on a line boundary in f.
+func atLineStart(f *os.File, offset int64) bool {
+	if offset == 0 {
+		return true
+	}
+	prev := make([]byte, 1)
+	if _, err := f.ReadAt(prev, offset-1); err != nil {
+		return false
+	}
+	return prev[0] == '\n'
+}
@@ -0,0 +1,175 @@
+package usage
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+	"weak"
+)
+
+const (
+	archiveQueueSize           = 64
+	archiveDefaultMaxAttempts  = 5
+	archiveDefaultInitialDelay = 10 * time.Second
+	archiveMaxDelay            = 10 * time.Minute
+)
+
+// SegmentUploader ships rotated store segments to long-term storage, such as
+// an S3-compatible bucket (see NewS3SegmentUploader).
+type SegmentUploader interface {
+	// Upload stores body as the object called name, replacing any object of
+	// that name. After a failure it may be called again with a fresh body.
+	Upload(ctx context.Context, name string, body io.Reader) error
+}
+
+// ArchivePolicy configures shipping rotated segments off-box. Each segment is
+// uploaded in the background after its rotation, named after its file, e.g.
+// usage.json.2025-11-25.
+type ArchivePolicy struct {
+	// Uploader receives the segments; nil disables archiving.
+	Uploader SegmentUploader
+	// Compress gzips each segment while it is uploaded and adds ".gz" to its name.
+	Compress bool
+	// DeleteLocal removes a segment from disk once its upload succeeded.
+	DeleteLocal bool
+	// MaxAttempts is how many times a segment is tried; 0 uses 5.
+	MaxAttempts int
+	// RetryDelay is the wait before the second attempt, doubling for each
+	// later one up to 10 minutes; 0 uses 10 seconds.
+	RetryDelay time.Duration
+}
+
+// WithArchive uploads every rotated segment with policy.Uploader, retrying
+// failed uploads with backoff. A segment whose upload never succeeds is kept
+// on disk, as is every segment unless DeleteLocal is set. Uploads run on one
+// background goroutine in rotation order, so a slow destination never delays
+// writes. Segments still queued or uploading when the store is closed stay on
+// disk and are not retried after a restart. Only rotation hands segments to
+// the uploader, so archiving needs WithRotation.
+func WithArchive(policy ArchivePolicy) StoreOption {
+	return func(s *JSONStore) {
+		if policy.MaxAttempts <= 0 {
+			policy.MaxAttempts = archiveDefaultMaxAttempts
+		}
+		if policy.RetryDelay <= 0 {
+			policy.RetryDelay = archiveDefaultInitialDelay
+		}
+		s.archive = policy
+	}
+}
+
+// queueArchiveLocked hands a freshly rotated segment to the archiver. When
+// the queue is full the segment is kept local with a warning rather than
+// blocking the flush. Must be called with s.mu held.
+func (s *JSONStore) queueArchiveLocked(segment string) {
+	if s.archiveQueue == nil {
+		return
+	}
+	select {
+	case s.archiveQueue <- segment:
+	default:
+		fmt.Fprintf(os.Stderr, "warning: usage archive queue is full, keeping segment %s local\n", segment)
+	}
+}
+
+// runArchiver uploads the queued segments until done is closed. Like runEvery
+// it holds the store weakly; the store is only needed to delete uploaded
+// segments under its lock.
+func runArchiver(store weak.Pointer[JSONStore], policy ArchivePolicy, queue <-chan string, done <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-done
+		cancel()
+	}()
+
+	for {
+		select {
+		case segment := <-queue:
+			if err := archiveSegment(ctx, policy, segment); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: failed to archive usage segment %s, keeping it local: %v\n", segment, err)
+				continue
+			}
+			if policy.DeleteLocal {
+				removeArchivedSegment(store, segment)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// archiveSegment uploads one segment, retrying with backoff. It gives up
+// early when the segment is gone, e.g. deleted by the disk cap, or ctx ends.
+func archiveSegment(ctx context.Context, policy ArchivePolicy, segment string) error {
+	name := filepath.Base(segment)
+	if policy.Compress {
+		name += ".gz"
+	}
+	delay := policy.RetryDelay
+	var lastErr error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		lastErr = uploadSegment(ctx, policy, segment, name)
+		if lastErr == nil {
+			return nil
+		}
+		if errors.Is(lastErr, os.ErrNotExist) || attempt == policy.MaxAttempts {
+			break
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fmt.Errorf("store closed during retries: %w", lastErr)
+		}
+		delay = min(delay*2, archiveMaxDelay)
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", policy.MaxAttempts, lastErr)
+}
+
+// uploadSegment makes one upload attempt, gzipping the file on the fly when
+// the policy compresses.
+func uploadSegment(ctx context.Context, policy ArchivePolicy, segment, name string) error {
+	f, err := os.Open(segment)
+	if err != nil {
+		return fmt.Errorf("failed to open segment: %w", err)
+	}
+	defer f.Close()
+
+	var body io.Reader = f
+	if policy.Compress {
+		pr, pw := io.Pipe()
+		// Closing the reader unblocks the writer if the upload stops early
+		defer pr.Close()
+		go func() {
+			gz := gzip.NewWriter(pw)
+			_, err := io.Copy(gz, f)
+			if closeErr := gz.Close(); err == nil {
+				err = closeErr
+			}
+			pw.CloseWithError(err)
+		}()
+		body = pr
+	}
+	if err := policy.Uploader.Upload(ctx, name, body); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", name, err)
+	}
+	return nil
+}
+
+// removeArchivedSegment deletes an uploaded segment, under the store lock
+// while the store is alive so readers listing segments never open a file
+// that is being removed.
+func removeArchivedSegment(store weak.Pointer[JSONStore], segment string) {
+	if s := store.Value(); s != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	if err := os.Remove(segment); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "warning: failed to delete archived usage segment %s: %v\n", segment, err)
+	}
+}
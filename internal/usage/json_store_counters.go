@@ -0,0 +1,158 @@
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ExactCounts are request and token counts over every event passed to Write,
+// including the ones sampling did not persist.
+type ExactCounts struct {
+	Requests int64 `json:"requests"`
+	Tokens   int64 `json:"tokens"`
+}
+
+const (
+	// counterResolution is the width of an exact counter bucket.
+	counterResolution = time.Minute
+	// defaultCounterRetention is how long exact counters are kept by default.
+	defaultCounterRetention = 7 * 24 * time.Hour
+	// counterSaveInterval limits how often the counters sidecar is rewritten.
+	counterSaveInterval = time.Minute
+)
+
+// WithCounterRetention sets how long a sampled store keeps its per-minute
+// exact counters. The default is 7 days.
+func WithCounterRetention(d time.Duration) StoreOption {
+	return func(s *JSONStore) {
+		if d > 0 {
+			s.counterRetention = d
+		}
+	}
+}
+
+// exactCounters buckets ExactCounts by minute for a sampled store, so range
+// totals stay exact while only a sample of events is written to the file.
+type exactCounters struct {
+	// Since is the first minute from which every event was counted.
+	Since time.Time `json:"since"`
+	// Minutes maps a bucket's Unix time in seconds to its counts.
+	Minutes map[int64]ExactCounts `json:"minutes"`
+	savedAt time.Time
+}
+
+func (s *JSONStore) countersPath() string {
+	return s.path + ".counters"
+}
+
+// loadCounters starts exact counting, resuming from the sidecar written by an
+// earlier run when there is one.
+func (s *JSONStore) loadCounters() {
+	now := s.now().UTC()
+	// Events earlier in the current minute were not seen
+	since := now.Truncate(counterResolution)
+	if since.Before(now) {
+		since = since.Add(counterResolution)
+	}
+	counters := &exactCounters{Since: since, Minutes: make(map[int64]ExactCounts)}
+	if data, err := os.ReadFile(s.countersPath()); err == nil {
+		var loaded exactCounters
+		if errUnmarshal := json.Unmarshal(data, &loaded); errUnmarshal == nil && !loaded.Since.IsZero() {
+			if loaded.Minutes == nil {
+				loaded.Minutes = make(map[int64]ExactCounts)
+			}
+			counters = &loaded
+		}
+	}
+	s.counters = counters
+}
+
+// countLocked adds an event to the exact counters, skipping markers.
+// Must be called with s.mu held.
+func (s *JSONStore) countLocked(event UsageEvent) {
+	if s.counters == nil || event.IsMarker() {
+		return
+	}
+	key := event.Timestamp.UTC().Truncate(counterResolution).Unix()
+	c := s.counters.Minutes[key]
+	c.Requests++
+	c.Tokens += event.TotalTokens
+	s.counters.Minutes[key] = c
+}
+
+// saveCountersLocked prunes counters past the retention and writes the
+// sidecar, at most once per counterSaveInterval unless force is set.
+// Must be called with s.mu held.
+func (s *JSONStore) saveCountersLocked(force bool) {
+	if s.counters == nil {
+		return
+	}
+	now := s.now().UTC()
+	if !force && now.Sub(s.counters.savedAt) < counterSaveInterval {
+		return
+	}
+
+	cutoff := now.Add(-s.counterRetention).Truncate(counterResolution)
+	for key := range s.counters.Minutes {
+		if time.Unix(key, 0).Before(cutoff) {
+			delete(s.counters.Minutes, key)
+		}
+	}
+	if s.counters.Since.Before(cutoff) {
+		s.counters.Since = cutoff
+	}
+	// Don't create the sidecar (or its directory) until something was counted
+	if len(s.counters.Minutes) == 0 {
+		if _, err := os.Stat(s.countersPath()); os.IsNotExist(err) {
+			return
+		}
+	}
+
+	data, err := json.Marshal(s.counters)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to write usage counters: %v\n", err)
+		return
+	}
+	tmp := s.countersPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to write usage counters: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmp, s.countersPath()); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to write usage counters: %v\n", err)
+		return
+	}
+	s.counters.savedAt = now
+}
+
+// ExactCountsBetween returns the exact request and token counts of events
+// stamped in [from, to], with both ends rounded down to whole minutes.
+// ok is false when the store is not sampled (its file already holds every
+// event) or from is earlier than the counters cover.
+func (s *JSONStore) ExactCountsBetween(from, to time.Time) (counts ExactCounts, ok bool) {
+	if s == nil {
+		return ExactCounts{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.counters == nil || from.Before(s.counters.Since) {
+		return ExactCounts{}, false
+	}
+	first := from.UTC().Truncate(counterResolution).Unix()
+	last := to.UTC().Truncate(counterResolution).Unix()
+	for key, c := range s.counters.Minutes {
+		if key >= first && key <= last {
+			counts.Requests += c.Requests
+			counts.Tokens += c.Tokens
+		}
+	}
+	return counts, true
+}
@@ -0,0 +1,116 @@
+package usage
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrTooManyFollowers is returned by Follow when the store already has its
+// maximum number of followers.
+var ErrTooManyFollowers = errors.New("too many usage event followers")
+
+const (
+	// defaultMaxFollowers bounds concurrent followers unless WithMaxFollowers is given.
+	defaultMaxFollowers = 8
+	// followerBuffer is how many flushed events a follower may fall behind by
+	// before it is disconnected.
+	followerBuffer = 1024
+)
+
+// follower receives events as they are flushed; see Follow.
+type follower struct {
+	events chan UsageEvent
+}
+
+// WithMaxFollowers bounds the number of concurrent Follow subscriptions.
+// Values below 1 keep the default of 8.
+func WithMaxFollowers(n int) StoreOption {
+	return func(s *JSONStore) {
+		if n > 0 {
+			s.maxFollowers = n
+		}
+	}
+}
+
+// Follow subscribes to events as they are flushed to disk, starting with the
+// next flush. The returned channel is closed when ctx is done, when the
+// store is closed, or when the subscriber falls more than followerBuffer
+// events behind; a slow follower is dropped rather than holding up writes.
+// It returns ErrTooManyFollowers when the follower limit is reached.
+func (s *JSONStore) Follow(ctx context.Context) (<-chan UsageEvent, error) {
+	if s == nil {
+		return nil, errors.New("json store is nil")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, errors.New("json store is closed")
+	}
+	limit := s.maxFollowers
+	if limit <= 0 {
+		limit = defaultMaxFollowers
+	}
+	if len(s.followers) >= limit {
+		return nil, ErrTooManyFollowers
+	}
+	if s.followers == nil {
+		s.followers = make(map[*follower]struct{})
+	}
+	f := &follower{events: make(chan UsageEvent, followerBuffer)}
+	s.followers[f] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		s.removeFollowerLocked(f)
+		s.mu.Unlock()
+	}()
+	return f.events, nil
+}
+
+// Followers returns the number of active Follow subscriptions.
+func (s *JSONStore) Followers() int {
+	if s == nil {
+		return 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.followers)
+}
+
+// publishLocked hands flushed events to every follower without blocking.
+// Must be called with s.mu held.
+func (s *JSONStore) publishLocked(events []UsageEvent) {
+	for f := range s.followers {
+		for _, event := range events {
+			select {
+			case f.events <- event:
+				continue
+			default:
+			}
+			s.removeFollowerLocked(f)
+			break
+		}
+	}
+}
+
+// removeFollowerLocked unsubscribes f and closes its channel, once.
+// Must be called with s.mu held.
+func (s *JSONStore) removeFollowerLocked(f *follower) {
+	if _, ok := s.followers[f]; !ok {
+		return
+	}
+	delete(s.followers, f)
+	close(f.events)
+}
+
+// closeFollowersLocked ends every follow subscription.
+// Must be called with s.mu held.
+func (s *JSONStore) closeFollowersLocked() {
+	for f := range s.followers {
+		s.removeFollowerLocked(f)
+	}
+}
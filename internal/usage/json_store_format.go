@@ -0,0 +1,144 @@
+package usage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// LineFormatter renders a usage event as a single line of the store file.
+// The returned bytes must not contain newlines; the store appends one.
+type LineFormatter func(UsageEvent) ([]byte, error)
+
+// LineParser decodes a line written by a matching LineFormatter.
+type LineParser func([]byte) (UsageEvent, error)
+
+// WithLineFormatter replaces the default compact JSON line format, e.g. to wrap
+// each event in the envelope a log ingester expects.
+//
+// Load, LoadRange and ReadFrom only understand the default format unless a
+// matching parser is supplied with WithLineParser. Without one, a custom
+// format is effectively write-only: lines that do not decode as a plain
+// UsageEvent are skipped with a warning and the metrics endpoints will not
+// see them.
+func WithLineFormatter(format LineFormatter) StoreOption {
+	return func(s *JSONStore) {
+		s.formatLine = format
+	}
+}
+
+// WithLineParser sets the decoder used when reading the store file back. It
+// should accept every line produced by the configured LineFormatter.
+func WithLineParser(parse LineParser) StoreOption {
+	return func(s *JSONStore) {
+		s.parseLine = parse
+	}
+}
+
+// eventEnvelope wraps an event with the metadata fields common log ingesters expect.
+type eventEnvelope struct {
+	Level   string     `json:"level"`
+	Service string     `json:"service"`
+	Message UsageEvent `json:"message"`
+}
+
+// EnvelopeLineFormatter returns a formatter that writes each event as
+// {"level":..., "service":..., "message":{...event...}}.
+func EnvelopeLineFormatter(service, level string) LineFormatter {
+	if level == "" {
+		level = "info"
+	}
+	return func(event UsageEvent) ([]byte, error) {
+		return json.Marshal(eventEnvelope{Level: level, Service: service, Message: event})
+	}
+}
+
+// EnvelopeLineParser reads lines written by EnvelopeLineFormatter. Plain event
+// lines are accepted too, so a store can switch formats without losing history.
+func EnvelopeLineParser(line []byte) (UsageEvent, error) {
+	var envelope struct {
+		Message *UsageEvent `json:"message"`
+	}
+	if err := json.Unmarshal(line, &envelope); err != nil {
+		return UsageEvent{}, err
+	}
+	if envelope.Message != nil {
+		return *envelope.Message, nil
+	}
+	var event UsageEvent
+	err := json.Unmarshal(line, &event)
+	return event, err
+}
+
+// encodeLine renders an event with the configured formatter, newline terminated.
+func (s *JSONStore) encodeLine(event UsageEvent) ([]byte, error) {
+	if s.formatLine == nil {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		return append(line, '\n'), nil
+	}
+	line, err := s.formatLine(event)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.ContainsAny(line, "\r\n") {
+		return nil, fmt.Errorf("line formatter produced a multi-line record")
+	}
+	return append(line, '\n'), nil
+}
+
+// decodeLine parses a stored line with the configured parser, applying the
+// defaults of the file's schema version. A byte order mark left by an editor
+// at the start of the file is ignored.
+func (s *JSONStore) decodeLine(line []byte, version SchemaVersion) (UsageEvent, error) {
+	line = trimBOM(line)
+	var event UsageEvent
+	var err error
+	if s.parseLine != nil {
+		event, err = s.parseLine(line)
+	} else {
+		err = json.Unmarshal(line, &event)
+	}
+	if err != nil {
+		return event, err
+	}
+	upgradeEvent(version, &event)
+	normalizeEventTimes(&event)
+	return event, nil
+}
+
+// epochSecondsLimit separates legacy "ts" values given in seconds from those
+// in milliseconds: 1e11 seconds is in the year 5138, while 1e11 milliseconds
+// is in 1973, before any usage was recorded.
+const epochSecondsLimit = 1e11
+
+// UnmarshalJSON decodes an event, also accepting the "ts" field (epoch
+// milliseconds or seconds) written by older exporters in place of the RFC
+// 3339 "timestamp". When both are present, "timestamp" wins.
+func (e *UsageEvent) UnmarshalJSON(data []byte) error {
+	type plainEvent UsageEvent
+	var decoded struct {
+		plainEvent
+		TS *json.Number `json:"ts"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*e = UsageEvent(decoded.plainEvent)
+	if decoded.TS == nil || !e.Timestamp.IsZero() {
+		return nil
+	}
+	ts, err := decoded.TS.Float64()
+	if err != nil {
+		return fmt.Errorf("invalid ts %q: %w", decoded.TS.String(), err)
+	}
+	if math.Abs(ts) < epochSecondsLimit {
+		ts *= 1000
+	}
+	e.Timestamp = time.UnixMilli(int64(math.Round(ts))).UTC()
+	return nil
+}
@@ -0,0 +1,28 @@
+package usage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// gzipMagic starts every gzip stream, e.g. a compressed export or a segment
+// archived with ArchivePolicy.Compress.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// gunzipIfCompressed returns r decompressed when it starts with the gzip
+// magic, whatever its file name, and r unchanged otherwise. The caller closes
+// the returned closer.
+func gunzipIfCompressed(r io.Reader) (io.Reader, io.Closer, error) {
+	buffered := bufio.NewReader(r)
+	if magic, err := buffered.Peek(len(gzipMagic)); err != nil || !bytes.Equal(magic, gzipMagic) {
+		return buffered, io.NopCloser(buffered), nil
+	}
+	gz, err := gzip.NewReader(buffered)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open gzip stream: %w", err)
+	}
+	return gz, gz, nil
+}
@@ -0,0 +1,333 @@
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxImportLineBytes bounds one line of an import; a longer line stops it.
+const maxImportLineBytes = 1 << 20
+
+// maxReportedImportErrors caps how many invalid lines an ImportResult lists.
+const maxReportedImportErrors = 100
+
+// importRunEvents caps how many imported events are sorted in memory at a
+// time before they are written to an import segment.
+const importRunEvents = 10000
+
+// importSegmentTag follows the timestamp in the suffix of the segments Import
+// writes, telling them apart from rotated ones.
+const importSegmentTag = "-import"
+
+// ImportResult counts the outcome of an Import.
+type ImportResult struct {
+	// Imported counts the events written to the store.
+	Imported int64 `json:"imported"`
+	// Skipped counts lines that are not valid events; Errors lists the first
+	// maxReportedImportErrors of them.
+	Skipped int64         `json:"skipped"`
+	Errors  []CorruptLine `json:"errors"`
+	// Deduped counts events left out because the store already held them.
+	Deduped int64 `json:"deduped"`
+}
+
+// importKey identifies an event for deduplication; two events with the same
+// timestamp, model, request ID, key and tokens are taken to be one.
+type importKey struct {
+	timestamp   int64
+	model       string
+	requestID   string
+	apiKeyHash  string
+	totalTokens int64
+}
+
+func newImportKey(event UsageEvent) importKey {
+	return importKey{
+		timestamp:   event.Timestamp.UnixNano(),
+		model:       event.Model,
+		requestID:   event.RequestID,
+		apiKeyHash:  event.APIKeyHash,
+		totalTokens: event.TotalTokens,
+	}
+}
+
+// Import reads usage events as JSON lines from r and stores each valid one,
+// e.g. to seed a new instance from an NDJSON export or merge another node's
+// usage.json. The body is parsed line by line, never held in memory as a
+// whole, and decompressed on the fly when it is gzipped. Empty lines and the
+// schema and header lines of a store file are ignored; other lines that do
+// not parse, lack a timestamp or carry negative counts are skipped.
+//
+// Unlike Write, imported events are already history: they are neither
+// sampled nor enriched or truncated, and they are not added to the recent
+// events. Appended to the live file behind newer events they would be missed
+// by range scans, so they go to import segments of their own instead: runs of
+// up to importRunEvents are sorted by time, and a run starting before the end
+// of the current import segment opens a new one. Each segment's modification
+// time is set to its newest event, so it is listed among the rotated segments
+// by the time it covers. The running totals include the imported events.
+//
+// With dedupe, an event identical to one already in the store (in a
+// segment, on disk or buffered) or earlier in r, by timestamp, model, request
+// ID, key hash and total tokens, is not written again, so importing the same data twice is
+// harmless. The identities of all stored events are held in memory for that.
+//
+// Parameters:
+//   - r: The JSON lines to import
+//   - dedupe: Whether to leave out events the store already holds
+//
+// Returns:
+//   - ImportResult: The counts of imported, skipped and deduped events, also on error
+//   - error: An error if r or the store cannot be read, or a write fails
+func (s *JSONStore) Import(r io.Reader, dedupe bool) (ImportResult, error) {
+	result := ImportResult{Errors: []CorruptLine{}}
+	if s == nil {
+		return result, fmt.Errorf("json store is nil")
+	}
+	if s.readOnly {
+		return result, ErrReadOnly
+	}
+
+	var seen map[importKey]struct{}
+	if dedupe {
+		var err error
+		if seen, err = s.importKeys(); err != nil {
+			return result, err
+		}
+	}
+
+	input, closer, err := gunzipIfCompressed(r)
+	if err != nil {
+		return result, err
+	}
+	defer closer.Close()
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLineBytes)
+	version := SchemaCurrent
+	lineNum := 0
+	var segment importSegment
+	var run []UsageEvent
+	writeRun := func() error {
+		if len(run) == 0 {
+			return nil
+		}
+		if err := s.writeImportRun(&segment, run); err != nil {
+			return fmt.Errorf("write events before line %d: %w", lineNum+1, err)
+		}
+		result.Imported += int64(len(run))
+		run = run[:0]
+		return nil
+	}
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Bytes()
+		if v, ok := parseSchemaLine(line); ok {
+			version = v
+			continue
+		}
+		if isSkippedLine(line) {
+			continue
+		}
+
+		var event UsageEvent
+		err := json.Unmarshal(trimBOM(line), &event)
+		if err == nil {
+			upgradeEvent(version, &event)
+			err = validateImportedEvent(event)
+		}
+		if err != nil {
+			result.Skipped++
+			if len(result.Errors) < maxReportedImportErrors {
+				result.Errors = append(result.Errors, CorruptLine{Line: lineNum, Error: err.Error()})
+			}
+			continue
+		}
+
+		if seen != nil {
+			key := newImportKey(event)
+			if _, ok := seen[key]; ok {
+				result.Deduped++
+				continue
+			}
+			seen[key] = struct{}{}
+		}
+		normalizeEventTimes(&event)
+		run = append(run, event)
+		if len(run) == importRunEvents {
+			if err := writeRun(); err != nil {
+				return result, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return result, fmt.Errorf("line %d is longer than %d bytes", lineNum+1, maxImportLineBytes)
+		}
+		return result, fmt.Errorf("read import: %w", err)
+	}
+	if err := writeRun(); err != nil {
+		return result, err
+	}
+
+	// Checkpoint the totals, which now include the imported events
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.flushLocked(); err != nil {
+		return result, err
+	}
+	if s.totalsRebuilt {
+		if info, err := os.Stat(s.path); err == nil {
+			s.saveCheckpointLocked(info.Size())
+		}
+	}
+	return result, nil
+}
+
+// importSegment is the import segment an Import currently appends to.
+type importSegment struct {
+	path string
+	// newest is the timestamp of the newest event in it.
+	newest time.Time
+}
+
+// writeImportRun sorts run by time and appends it to segment, or to a new
+// import segment when it starts more than the lateness window before the
+// newest event already there.
+func (s *JSONStore) writeImportRun(segment *importSegment, run []UsageEvent) error {
+	slices.SortStableFunc(run, func(a, b UsageEvent) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if segment.path == "" || run[0].Timestamp.Before(segment.newest.Add(-s.latenessWindow)) {
+		segment.path = s.path + "." + s.now().UTC().Format("20060102T150405Z") + importSegmentTag
+		for i := 1; ; i++ {
+			if _, err := os.Stat(segment.path); os.IsNotExist(err) {
+				break
+			}
+			segment.path = fmt.Sprintf("%s.%s%s-%d", s.path, s.now().UTC().Format("20060102T150405Z"), importSegmentTag, i)
+		}
+		segment.newest = time.Time{}
+	}
+	if err := s.appendSegmentLocked(segment.path, run); err != nil {
+		return err
+	}
+	for _, event := range run {
+		s.countLocked(event)
+		// As on a rescan, events before a totals reset are not counted
+		if !event.Timestamp.Before(s.totals.Since) {
+			s.totals.add(event, 1)
+		}
+	}
+	if last := run[len(run)-1].Timestamp; last.After(segment.newest) {
+		segment.newest = last
+	}
+	// Like a rotated segment, an import segment is last modified when its
+	// newest event was recorded, so ranges starting later skip it
+	if err := os.Chtimes(segment.path, segment.newest, segment.newest); err != nil {
+		return fmt.Errorf("failed to date import segment: %w", err)
+	}
+	return nil
+}
+
+// appendSegmentLocked appends events to the import segment at path, creating
+// it with the schema line in the store's format if needed. Imported events
+// are not a sample, so no sample header is written.
+// Must be called with s.mu held.
+func (s *JSONStore) appendSegmentLocked(path string, events []UsageEvent) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open import segment: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat import segment: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	if info.Size() == 0 {
+		if s.binaryFormat {
+			if _, err := w.Write(binaryMagic); err != nil {
+				return fmt.Errorf("failed to write binary magic: %w", err)
+			}
+		}
+		if err := json.NewEncoder(w).Encode(schemaLine{Schema: SchemaCurrent}); err != nil {
+			return fmt.Errorf("failed to encode schema line: %w", err)
+		}
+	}
+	if err := s.writeEvents(w, events, s.binaryFormat); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write import segment: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync import segment: %w", err)
+	}
+	return nil
+}
+
+// isImportSegment reports whether a segment was written by Import rather
+// than rotated from the live file.
+func isImportSegment(path string) bool {
+	return strings.Contains(filepath.Base(path), importSegmentTag)
+}
+
+// rotatedSegments drops the import segments from segments.
+func rotatedSegments(segments []string) []string {
+	return slices.DeleteFunc(segments, isImportSegment)
+}
+
+// importKeys returns the identities of every event in the segments, on disk
+// and in the buffer.
+func (s *JSONStore) importKeys() (map[importKey]struct{}, error) {
+	s.mu.Lock()
+	snapshots, err := s.openStoreSnapshotsLocked(time.Time{})
+	buffered := slices.Clone(s.buffer)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	defer closeSnapshots(snapshots)
+
+	keys := make(map[importKey]struct{})
+	err = s.scanSnapshots(snapshots, func(event UsageEvent) bool {
+		keys[newImportKey(event)] = struct{}{}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range buffered {
+		keys[newImportKey(event)] = struct{}{}
+	}
+	return keys, nil
+}
+
+// validateImportedEvent rejects events that could not have been recorded.
+func validateImportedEvent(event UsageEvent) error {
+	if event.Timestamp.IsZero() {
+		return fmt.Errorf("missing timestamp")
+	}
+	if event.Timestamp.After(time.Now().Add(24 * time.Hour)) {
+		return fmt.Errorf("timestamp %s is in the future", event.Timestamp.Format(time.RFC3339))
+	}
+	if event.PromptTokens < 0 || event.CompletionTokens < 0 || event.TotalTokens < 0 || event.CachedTokens < 0 || event.LatencyMs < 0 {
+		return fmt.Errorf("negative token count or latency")
+	}
+	return nil
+}
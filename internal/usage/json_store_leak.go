@@ -0,0 +1,65 @@
+package usage
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+	"weak"
+)
+
+// leakedStoreCount counts stores garbage-collected without Close while their
+// background goroutines were still running.
+var leakedStoreCount atomic.Int64
+
+// LeakedStores returns how many stores were garbage-collected without Close
+// since the process started. Each one also logs a warning.
+func LeakedStores() int64 {
+	return leakedStoreCount.Load()
+}
+
+// runEvery calls fn on the store every interval until done is closed or the
+// store has been garbage-collected. Holding the store only weakly between
+// ticks keeps an unclosed store collectable, so its finalizer can stop this
+// goroutine instead of it running forever.
+func runEvery(store weak.Pointer[JSONStore], interval time.Duration, done <-chan struct{}, fn func(*JSONStore)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s := store.Value()
+			if s == nil {
+				return
+			}
+			fn(s)
+		case <-done:
+			return
+		}
+	}
+}
+
+// stopBackground stops the background goroutines, at most once, and drops
+// the finalizer since the store no longer needs one.
+func (s *JSONStore) stopBackground() {
+	s.closeOnce.Do(func() {
+		if s.done != nil {
+			close(s.done)
+			runtime.SetFinalizer(s, nil)
+		}
+	})
+}
+
+// finalizeUnclosed runs when a store with background goroutines becomes
+// unreachable without Close, a common misuse in tests and config reloads.
+// It warns, since buffered events are lost, and stops the goroutines.
+func (s *JSONStore) finalizeUnclosed() {
+	leakedStoreCount.Add(1)
+	if s.onLeak != nil {
+		s.onLeak()
+	}
+	fmt.Fprintf(os.Stderr, "warning: usage store %s was garbage-collected without Close, %d buffered events lost; stopping its background goroutines\n", s.path, len(s.buffer))
+	s.stopBackground()
+}
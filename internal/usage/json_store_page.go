@@ -0,0 +1,216 @@
+package usage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrInvalidOffset is returned when a page offset does not fall on a line
+// boundary of the store file, e.g. after it was truncated or rotated.
+var ErrInvalidOffset = errors.New("offset does not match the store file")
+
+// pageChunkSize is how many bytes EventsBefore reads at a time while walking backwards.
+const pageChunkSize = 64 * 1024
+
+// PositionedEvent is an event together with the byte range of its line in the store file.
+type PositionedEvent struct {
+	Event UsageEvent
+	// Offset is the start of the event's line.
+	Offset int64
+	// End is the start of the following line.
+	End int64
+	// Generation identifies the live file the offsets belong to, see
+	// TailResult.Generation.
+	Generation string
+}
+
+// EventsAfter returns up to limit events from the lines starting at or after
+// offset, in file order. Only events for which match returns true are
+// counted; a nil match accepts every event. Offsets of an append-only file
+// never move, so pages read this way stay stable while events are written.
+//
+// Parameters:
+//   - offset: A line boundary of the store file, such as a PositionedEvent's End
+//   - limit: Maximum number of events to return
+//   - match: Optional filter
+//
+// Returns:
+//   - []PositionedEvent: The events read, oldest first
+//   - error: ErrInvalidOffset if offset is not a line boundary, or a read error
+func (s *JSONStore) EventsAfter(offset int64, limit int, match func(UsageEvent) bool) ([]PositionedEvent, error) {
+	if s == nil {
+		return nil, fmt.Errorf("json store is nil")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events, err := s.eventsAfterLocked(offset, limit, match)
+	return withGeneration(events, s.latestSegmentLocked()), err
+}
+
+// eventsAfterLocked implements EventsAfter. Must be called with s.mu held.
+func (s *JSONStore) eventsAfterLocked(offset int64, limit int, match func(UsageEvent) bool) ([]PositionedEvent, error) {
+	f, size, err := s.openForPageLocked()
+	if err != nil || f == nil {
+		return []PositionedEvent{}, err
+	}
+	defer f.Close()
+
+	if isBinaryFile(f) {
+		return s.binaryEventsAfter(f, size, offset, limit, match)
+	}
+	if offset < 0 || offset > size || !atLineStart(f, offset) {
+		return nil, ErrInvalidOffset
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek file: %w", err)
+	}
+
+	version := readSchemaVersion(s.path)
+	events := []PositionedEvent{}
+	reader := bufio.NewReader(f)
+	for len(events) < limit {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// End of file, or a partial trailing line still being written
+			break
+		}
+		start := offset
+		offset += int64(len(line))
+		if event, ok := s.decodePageLine(line, version, match); ok {
+			events = append(events, PositionedEvent{Event: event, Offset: start, End: offset})
+		}
+	}
+	return events, nil
+}
+
+// EventsBefore returns up to limit events from the lines ending at or before
+// offset, nearest first (i.e. in reverse file order). A negative offset reads
+// from the end of the file. match filters events as in EventsAfter.
+//
+// Returns:
+//   - []PositionedEvent: The events read, newest first
+//   - error: ErrInvalidOffset if offset is not a line boundary, or a read error
+func (s *JSONStore) EventsBefore(offset int64, limit int, match func(UsageEvent) bool) ([]PositionedEvent, error) {
+	if s == nil {
+		return nil, fmt.Errorf("json store is nil")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events, err := s.eventsBeforeLocked(offset, limit, match)
+	return withGeneration(events, s.latestSegmentLocked()), err
+}
+
+// eventsBeforeLocked implements EventsBefore. Must be called with s.mu held.
+func (s *JSONStore) eventsBeforeLocked(offset int64, limit int, match func(UsageEvent) bool) ([]PositionedEvent, error) {
+	f, size, err := s.openForPageLocked()
+	if err != nil || f == nil {
+		return []PositionedEvent{}, err
+	}
+	defer f.Close()
+
+	if isBinaryFile(f) {
+		return s.binaryEventsBefore(f, size, offset, limit, match)
+	}
+	if offset < 0 {
+		offset = size
+	} else if offset > size || !atLineStart(f, offset) {
+		return nil, ErrInvalidOffset
+	}
+
+	version := readSchemaVersion(s.path)
+	events := []PositionedEvent{}
+	// data holds the unprocessed bytes [pos, pos+len(data)); the lines in it
+	// are consumed from the back until only a partial first line remains
+	var data []byte
+	pos := offset
+	first := true
+	for len(events) < limit && (pos > 0 || len(data) > 0) {
+		n := int64(pageChunkSize)
+		if n > pos {
+			n = pos
+		}
+		chunk := make([]byte, n, int(n)+len(data))
+		if _, err := f.ReadAt(chunk, pos-n); err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		pos -= n
+		data = append(chunk, data...)
+
+		if first {
+			// Drop a partial trailing line still being written
+			i := bytes.LastIndexByte(data, '\n')
+			data = data[:i+1]
+			if i < 0 {
+				continue
+			}
+			first = false
+		}
+
+		end := len(data)
+		for end > 0 && len(events) < limit {
+			i := bytes.LastIndexByte(data[:end-1], '\n')
+			if i < 0 && pos > 0 {
+				// The line starts in an earlier chunk
+				break
+			}
+			lineStart := i + 1
+			if event, ok := s.decodePageLine(data[lineStart:end], version, match); ok {
+				events = append(events, PositionedEvent{Event: event, Offset: pos + int64(lineStart), End: pos + int64(end)})
+			}
+			end = lineStart
+		}
+		data = data[:end]
+	}
+	return events, nil
+}
+
+// withGeneration stamps generation on each event and returns them.
+func withGeneration(events []PositionedEvent, generation string) []PositionedEvent {
+	for i := range events {
+		events[i].Generation = generation
+	}
+	return events
+}
+
+// openForPageLocked opens the store file and returns its size, or a nil file
+// when it does not exist yet. Must be called with s.mu held.
+func (s *JSONStore) openForPageLocked() (*os.File, int64, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+	return f, info.Size(), nil
+}
+
+// decodePageLine decodes an event line for paging, skipping meta lines,
+// unparseable lines and events rejected by match.
+func (s *JSONStore) decodePageLine(line []byte, version SchemaVersion, match func(UsageEvent) bool) (UsageEvent, bool) {
+	line = bytes.TrimSpace(line)
+	if isSkippedLine(line) {
+		return UsageEvent{}, false
+	}
+	event, err := s.decodeLine(line, version)
+	if err != nil {
+		return UsageEvent{}, false
+	}
+	if match != nil && !match(event) {
+		return UsageEvent{}, false
+	}
+	return event, true
+}
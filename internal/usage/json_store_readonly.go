@@ -0,0 +1,28 @@
+package usage
+
+import "errors"
+
+// ErrReadOnly is returned by the writing methods of a store opened with
+// NewReadOnlyStore.
+var ErrReadOnly = errors.New("usage store is read-only")
+
+// NewReadOnlyStore opens the store file at path for reading only, e.g. for a
+// dashboard sidecar serving metrics from a file another process writes. It
+// starts no background goroutine and never opens the file, or any sidecar,
+// for writing: Write, Flush, FlushCount, GenerateRollups and ResetTotals
+// return ErrReadOnly, while Load, Iterate, LoadRange and the other readers
+// see every event the writer has flushed so far. Rollups the writer generated
+// are used as usual. RebuildTotals computes Totals and Span from the files,
+// resuming from the writer's checkpoint, but does not advance it; since
+// nothing is written through this store, they stay as of that call.
+//
+// Parameters:
+//   - path: The store file written by another process
+//
+// Returns:
+//   - *JSONStore: A read-only store
+func NewReadOnlyStore(path string) *JSONStore {
+	s := NewJSONStore(path, WithPeriodicFlush(false))
+	s.readOnly = true
+	return s
+}
@@ -0,0 +1,76 @@
+package usage
+
+import (
+	"fmt"
+	"time"
+)
+
+// replayPageSize is how many events Replay reads per lock acquisition.
+const replayPageSize = 1000
+
+// ReplaySink is a secondary destination for replayed events, such as an OTLP
+// exporter or a separate store file. It must never be the primary store, or
+// replayed events would be recorded twice.
+type ReplaySink struct {
+	// Name describes the sink in responses and logs.
+	Name string
+	// Write receives each replayed event.
+	Write func(UsageEvent) error
+	// Flush, if set, is called once after a replay completes.
+	Flush func() error
+}
+
+// Replay streams the stored events stamped within [from, to] to sink in file
+// order, e.g. to backfill a new sink or re-run events through fixed processing.
+// As with LoadRange, reading stops at the first event past to plus the
+// lateness window. Events still in the write buffer are not replayed; call
+// Flush first to include them.
+//
+// The store lock is only held while each page is read, so recording continues
+// during a long replay. The sink must not write back into this store.
+//
+// Parameters:
+//   - from: Inclusive start of the range
+//   - to: Inclusive end of the range
+//   - sink: Receives each event; an error stops the replay and is returned
+//
+// Returns:
+//   - error: An error if the store cannot be read, the file is rotated mid-replay, or sink fails
+func (s *JSONStore) Replay(from, to time.Time, sink func(UsageEvent) error) error {
+	if s == nil {
+		return fmt.Errorf("json store is nil")
+	}
+
+	s.mu.Lock()
+	stopAfter := to.Add(s.latenessWindow)
+	s.mu.Unlock()
+
+	var offset int64
+	var generation string
+	for {
+		s.mu.Lock()
+		page, err := s.readFromLocked(offset, replayPageSize)
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if offset != 0 && (page.Reset || page.Generation != generation) {
+			return fmt.Errorf("store file was rotated or truncated during replay")
+		}
+		for _, event := range page.Events {
+			if event.Timestamp.After(stopAfter) {
+				return nil
+			}
+			if event.Timestamp.Before(from) || event.Timestamp.After(to) {
+				continue
+			}
+			if err := sink(event); err != nil {
+				return fmt.Errorf("replay sink failed: %w", err)
+			}
+		}
+		if len(page.Events) < replayPageSize {
+			return nil
+		}
+		offset, generation = page.Offset, page.Generation
+	}
+}
@@ -0,0 +1,313 @@
+package usage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	// RollupDay and RollupWeek are the supported rollup periods. Days start at
+	// 00:00 UTC and weeks on Monday 00:00 UTC.
+	RollupDay  = "day"
+	RollupWeek = "week"
+
+	rollupDayLength  = 24 * time.Hour
+	rollupWeekLength = 7 * rollupDayLength
+)
+
+// Rollup is a pre-aggregated summary of every event in one period.
+type Rollup struct {
+	Period   string                 `json:"period"`
+	Start    time.Time              `json:"start"`
+	Requests int64                  `json:"requests"`
+	Tokens   int64                  `json:"tokens"`
+	Failed   int64                  `json:"failed"`
+	ByModel  map[string]ModelTotals `json:"by_model"`
+	// Markers counts the period's marker events per kind; they are not
+	// included in Requests or ByModel.
+	Markers map[string]int64 `json:"markers,omitempty"`
+}
+
+// RollupSet is the content of the rollup file.
+type RollupSet struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	// CoveredUntil is the end of the last complete day included in Daily.
+	CoveredUntil time.Time `json:"covered_until"`
+	// TailOffset is the byte offset of the first event at or after
+	// CoveredUntil minus the lateness window; raw events after the covered
+	// span can be read from there without rescanning the whole file. It is
+	// -1 when some of them are in a rotated segment, to be read with
+	// LoadRange instead.
+	TailOffset int64 `json:"tail_offset"`
+	// Generation is the live file generation TailOffset belongs to, see
+	// TailResult.Generation.
+	Generation string `json:"generation,omitempty"`
+	// SourceSize is the store file size the rollups were built from. A smaller
+	// file means it was truncated or rotated and the rollups are stale.
+	SourceSize int64    `json:"source_size"`
+	Daily      []Rollup `json:"daily"`
+	Weekly     []Rollup `json:"weekly"`
+}
+
+// WithRollupInterval regenerates daily and weekly rollups in the background at
+// the given interval. Zero (the default) disables background generation;
+// GenerateRollups can still be called directly.
+func WithRollupInterval(d time.Duration) StoreOption {
+	return func(s *JSONStore) {
+		if d < 0 {
+			d = 0
+		}
+		s.rollupInterval = d
+	}
+}
+
+func (s *JSONStore) rollupPath() string {
+	return s.path + ".rollups"
+}
+
+// GenerateRollups scans the rotated segments and the store file and writes
+// daily and weekly summaries
+// of every complete period (days ending before 00:00 UTC of the current day,
+// once the lateness window has passed) to a compact rollup file next to the store.
+// It does nothing until the first flush has created the file.
+//
+// Returns:
+//   - error: An error if the store cannot be read or the rollup file written
+func (s *JSONStore) GenerateRollups() error {
+	if s == nil {
+		return fmt.Errorf("json store is nil")
+	}
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Nothing to roll up, and no sidecar to litter, until the first flush;
+	// right after a rotation only segments exist
+	if !s.fileExistsLocked() {
+		if segments, err := s.Segments(); err != nil || len(segments) == 0 {
+			return err
+		}
+	}
+
+	// Only roll up days that ended at least a lateness window ago, so
+	// out-of-order events for them have already been written
+	now := time.Now().UTC()
+	set := RollupSet{
+		GeneratedAt:  now,
+		CoveredUntil: now.Add(-s.latenessWindow).Truncate(rollupDayLength),
+		TailOffset:   -1,
+	}
+	tailFrom := set.CoveredUntil.Add(-s.latenessWindow)
+	weekCoveredUntil := set.CoveredUntil.Truncate(rollupWeekLength)
+
+	daily := make(map[time.Time]*Rollup)
+	weekly := make(map[time.Time]*Rollup)
+	add := func(event UsageEvent) {
+		ts := event.Timestamp.UTC()
+		if !ts.Before(set.CoveredUntil) {
+			return
+		}
+		addToRollup(daily, RollupDay, ts.Truncate(rollupDayLength), event)
+		if ts.Before(weekCoveredUntil) {
+			addToRollup(weekly, RollupWeek, ts.Truncate(rollupWeekLength), event)
+		}
+	}
+
+	// Rotated segments hold the older events; a tail reaching into one
+	// cannot be read from an offset of the live file
+	segments, err := s.openSegmentSnapshotsLocked(time.Time{})
+	if err != nil {
+		return err
+	}
+	tailInSegment := false
+	err = s.scanSnapshots(segments, func(event UsageEvent) bool {
+		if !event.Timestamp.Before(tailFrom) {
+			tailInSegment = true
+		}
+		add(event)
+		return true
+	})
+	closeSnapshots(segments)
+	if err != nil {
+		return err
+	}
+
+	size, err := s.scanOffsetsLocked(func(offset int64, event UsageEvent) {
+		if set.TailOffset < 0 && !event.Timestamp.Before(tailFrom) {
+			set.TailOffset = offset
+		}
+		add(event)
+	})
+	if err != nil {
+		return err
+	}
+	set.SourceSize = size
+	set.Generation = s.latestSegmentLocked()
+	switch {
+	case tailInSegment:
+		set.TailOffset = -1
+	case set.TailOffset < 0:
+		set.TailOffset = size
+	}
+	set.Daily = sortedRollups(daily)
+	set.Weekly = sortedRollups(weekly)
+
+	data, err := json.Marshal(set)
+	if err != nil {
+		return fmt.Errorf("failed to encode rollups: %w", err)
+	}
+	tmp := s.rollupPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write rollups: %w", err)
+	}
+	if err := os.Rename(tmp, s.rollupPath()); err != nil {
+		return fmt.Errorf("failed to write rollups: %w", err)
+	}
+	s.rollups = &set
+
+	return nil
+}
+
+// Rollups returns the most recently generated rollups. ok is false when none
+// exist or the store file has shrunk since they were generated.
+func (s *JSONStore) Rollups() (set RollupSet, ok bool) {
+	if s == nil {
+		return RollupSet{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rollups == nil {
+		data, err := os.ReadFile(s.rollupPath())
+		if err != nil {
+			return RollupSet{}, false
+		}
+		var loaded RollupSet
+		if err := json.Unmarshal(data, &loaded); err != nil {
+			return RollupSet{}, false
+		}
+		s.rollups = &loaded
+	}
+
+	// A live file not recreated since a rotation has size 0
+	var size int64
+	if info, err := os.Stat(s.path); err == nil {
+		size = info.Size()
+	} else if !os.IsNotExist(err) {
+		return RollupSet{}, false
+	}
+	if size < s.rollups.SourceSize {
+		return RollupSet{}, false
+	}
+	return *s.rollups, true
+}
+
+// periodicRollup is run by a background goroutine every rollupInterval.
+func (s *JSONStore) periodicRollup() {
+	if err := s.GenerateRollups(); err != nil {
+		fmt.Fprintf(os.Stderr, "rollup generation error: %v\n", err)
+	}
+}
+
+// scanOffsetsLocked calls fn with the byte offset and decoded event of every
+// event line and returns the number of bytes read.
+// Must be called with s.mu held.
+func (s *JSONStore) scanOffsetsLocked(fn func(offset int64, event UsageEvent)) (int64, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	if isBinaryFile(f) {
+		start, version, err := binaryDataStart(f)
+		if err != nil {
+			return 0, err
+		}
+		return s.scanBinaryRecords(f, start, version, func(offset, _ int64, event UsageEvent, err error) bool {
+			if err == nil {
+				fn(offset, event)
+			}
+			return true
+		})
+	}
+
+	var offset int64
+	version := SchemaLegacy
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Done, or a partial trailing line still being written
+			break
+		}
+		if err != nil {
+			return offset, fmt.Errorf("failed to read file: %w", err)
+		}
+		lineStart := offset
+		offset += int64(len(line))
+		line = bytes.TrimSpace(line)
+		if v, ok := parseSchemaLine(line); ok {
+			version = v
+			continue
+		}
+		if isSkippedLine(line) {
+			continue
+		}
+		event, errDecode := s.decodeLine(line, version)
+		if errDecode != nil {
+			continue
+		}
+		fn(lineStart, event)
+	}
+	return offset, nil
+}
+
+func addToRollup(rollups map[time.Time]*Rollup, period string, start time.Time, event UsageEvent) {
+	r, ok := rollups[start]
+	if !ok {
+		r = &Rollup{Period: period, Start: start, ByModel: make(map[string]ModelTotals)}
+		rollups[start] = r
+	}
+	if event.IsMarker() {
+		if r.Markers == nil {
+			r.Markers = make(map[string]int64)
+		}
+		r.Markers[event.Kind]++
+		return
+	}
+	r.Requests++
+	r.Tokens += event.TotalTokens
+	if event.Status >= httpStatusBadRequest {
+		r.Failed++
+	}
+	m := r.ByModel[event.Model]
+	m.Requests++
+	m.Tokens += event.TotalTokens
+	m.PromptTokens += event.PromptTokens
+	m.CompletionTokens += event.CompletionTokens
+	m.CachedTokens += event.CachedTokens
+	r.ByModel[event.Model] = m
+}
+
+func sortedRollups(rollups map[time.Time]*Rollup) []Rollup {
+	out := make([]Rollup, 0, len(rollups))
+	for _, r := range rollups {
+		out = append(out, *r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out
+}
@@ -0,0 +1,250 @@
+package usage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RotationPolicy decides when the store file is closed off as a segment and
+// a fresh file is started. Rotated segments keep the store path with a suffix,
+// e.g. usage.json.2025-11-25 or usage.json.20251125T120000Z.
+//
+// Rotation always happens at a flush and never splits the buffer arbitrarily:
+// with MaxBytes the whole buffer is written to the current file first and the
+// file is rotated once it has reached the limit; with Daily the buffered events
+// stamped before 00:00 UTC go to the ending day's segment and the rest to the
+// new file.
+type RotationPolicy struct {
+	// MaxBytes rotates once the file has reached this size; 0 disables it.
+	MaxBytes int64
+	// Daily rotates at the first flush after 00:00 UTC.
+	Daily bool
+	// MaxTotalBytes caps the combined size of the live file and its segments;
+	// after each rotation the oldest segments are deleted until the total is
+	// under it. 0 keeps every segment.
+	MaxTotalBytes int64
+	// ProtectedWindow keeps segments last written within this window from the
+	// disk cap, so a cap set too low cannot wipe recent usage: the cap then
+	// stays exceeded, with a warning, until they age out. 0 protects nothing.
+	ProtectedWindow time.Duration
+	// ForcePrune lets the disk cap delete protected segments anyway; every
+	// such deletion is logged.
+	ForcePrune bool
+}
+
+// WithRotation enables file rotation with the given policy.
+func WithRotation(policy RotationPolicy) StoreOption {
+	return func(s *JSONStore) {
+		if policy.MaxBytes < 0 {
+			policy.MaxBytes = 0
+		}
+		if policy.MaxTotalBytes < 0 {
+			policy.MaxTotalBytes = 0
+		}
+		if policy.ProtectedWindow < 0 {
+			policy.ProtectedWindow = 0
+		}
+		s.rotation = policy
+	}
+}
+
+// Segments lists the rotated segment files of the store, and the import
+// segments written by Import, oldest first by modification time. The live
+// file at the store path is not included.
+func (s *JSONStore) Segments() ([]string, error) {
+	if s == nil {
+		return nil, fmt.Errorf("json store is nil")
+	}
+	matches, err := filepath.Glob(s.path + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list segments: %w", err)
+	}
+	segments := make([]string, 0, len(matches))
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, s.path+".")
+		if suffix == "" || suffix[0] < '0' || suffix[0] > '9' {
+			// Sidecar files such as .totals and .rollups
+			continue
+		}
+		segments = append(segments, match)
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segmentModTime(segments[i]).Before(segmentModTime(segments[j]))
+	})
+	return segments, nil
+}
+
+func segmentModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// flushRotatingLocked writes the buffer like flushLocked, rotating the file
+// according to the rotation policy. Must be called with s.mu held.
+func (s *JSONStore) flushRotatingLocked() (int64, error) {
+	events := s.buffer
+	if s.rotation.Daily {
+		now := s.now().UTC()
+		if s.segmentDay.IsZero() {
+			s.segmentDay = now.Truncate(rollupDayLength)
+			if info, err := os.Stat(s.path); err == nil {
+				s.segmentDay = info.ModTime().UTC().Truncate(rollupDayLength)
+			}
+		}
+		if dayEnd := s.segmentDay.Add(rollupDayLength); !now.Before(dayEnd) {
+			// Grace flush: events of the ending day still go to its segment
+			var ending, next []UsageEvent
+			for _, event := range events {
+				if event.Timestamp.Before(dayEnd) {
+					ending = append(ending, event)
+				} else {
+					next = append(next, event)
+				}
+			}
+			if len(ending) > 0 {
+				if _, err := s.appendEventsLocked(ending); err != nil {
+					return 0, err
+				}
+				s.commitFlushedLocked(ending, next)
+			}
+			if err := s.rotateLocked(s.segmentDay.Format("2006-01-02")); err != nil {
+				return 0, err
+			}
+			s.segmentDay = now.Truncate(rollupDayLength)
+			events = next
+		}
+	}
+
+	var size int64
+	if len(events) > 0 {
+		var err error
+		if size, err = s.appendEventsLocked(events); err != nil {
+			return 0, err
+		}
+		s.commitFlushedLocked(events, nil)
+	}
+	if s.rotation.MaxBytes > 0 && size >= s.rotation.MaxBytes {
+		if err := s.rotateLocked(s.now().UTC().Format("20060102T150405Z")); err != nil {
+			return 0, err
+		}
+		size = 0
+	}
+	return size, nil
+}
+
+// commitFlushedLocked hands events just appended to the file to followers and
+// leaves only remaining in the buffer. It runs right after each append and
+// before any rotation, so a rotation that fails cannot make the next flush
+// write the same events again. Must be called with s.mu held.
+func (s *JSONStore) commitFlushedLocked(written, remaining []UsageEvent) {
+	s.publishLocked(written)
+	s.buffer = append(s.buffer[:0], remaining...)
+	s.bufferBytes = 0
+	for _, event := range s.buffer {
+		s.bufferBytes += estimateEventBytes(event)
+	}
+}
+
+// rotateLocked renames the live file to a segment named with suffix. A missing
+// live file is not an error. Must be called with s.mu held.
+func (s *JSONStore) rotateLocked(suffix string) error {
+	if _, err := os.Stat(s.path); os.IsNotExist(err) {
+		return nil
+	}
+	// Later fsyncs only reach the new file, so sync what is pending first
+	if err := s.syncPendingLocked(false); err != nil {
+		return err
+	}
+	target := s.path + "." + suffix
+	for i := 1; ; i++ {
+		if _, err := os.Stat(target); os.IsNotExist(err) {
+			break
+		}
+		target = fmt.Sprintf("%s.%s-%d", s.path, suffix, i)
+	}
+	if err := os.Rename(s.path, target); err != nil {
+		return fmt.Errorf("failed to rotate usage file: %w", err)
+	}
+	s.span = EventSpan{}
+	s.latestSegment, s.latestSegmentKnown = filepath.Base(target), true
+	s.queueArchiveLocked(target)
+	s.enforceDiskCapLocked()
+	return nil
+}
+
+// DiskUsage returns the combined size in bytes of the live store file and its
+// rotated segments. Sidecar files such as totals and rollups are not counted.
+func (s *JSONStore) DiskUsage() (int64, error) {
+	if s == nil {
+		return 0, fmt.Errorf("json store is nil")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total, _, err := s.diskUsageLocked()
+	return total, err
+}
+
+// diskUsageLocked returns the total size of the live file and segments, and
+// the segments oldest first. Must be called with s.mu held.
+func (s *JSONStore) diskUsageLocked() (int64, []string, error) {
+	var total int64
+	if info, err := os.Stat(s.path); err == nil {
+		total = info.Size()
+	}
+	segments, err := s.Segments()
+	if err != nil {
+		return total, nil, err
+	}
+	for _, segment := range segments {
+		if info, err := os.Stat(segment); err == nil {
+			total += info.Size()
+		}
+	}
+	return total, segments, nil
+}
+
+// enforceDiskCapLocked deletes the oldest segments until the store's disk
+// usage is under the rotation policy's MaxTotalBytes. The live file is never
+// deleted, nor, without ForcePrune, segments within the ProtectedWindow.
+// Must be called with s.mu held.
+func (s *JSONStore) enforceDiskCapLocked() {
+	if s.rotation.MaxTotalBytes <= 0 {
+		return
+	}
+	total, segments, err := s.diskUsageLocked()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to check usage disk cap: %v\n", err)
+		return
+	}
+	for _, segment := range segments {
+		if total <= s.rotation.MaxTotalBytes {
+			return
+		}
+		info, err := os.Stat(segment)
+		if err != nil {
+			continue
+		}
+		if window := s.rotation.ProtectedWindow; window > 0 && info.ModTime().After(s.now().Add(-window)) {
+			if !s.rotation.ForcePrune {
+				// Segments are oldest first, so every later one is protected too
+				fmt.Fprintf(os.Stderr, "warning: usage disk cap of %d bytes exceeded (%d bytes) but segment %s holds usage from the last %s; keeping it and every newer segment\n", s.rotation.MaxTotalBytes, total, segment, window)
+				return
+			}
+			fmt.Fprintf(os.Stderr, "warning: force-deleting usage segment %s written within the protected window of %s to meet the disk cap\n", segment, window)
+		}
+		if err := os.Remove(segment); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to delete usage segment %s: %v\n", segment, err)
+			continue
+		}
+		total -= info.Size()
+	}
+}
@@ -0,0 +1,132 @@
+package usage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+)
+
+// SchemaVersion identifies the event layout of a store file. It is written as
+// a {"_schema":N} line at the top of every new file and rotated segment, so
+// readers and migrations know which fields and defaults to expect.
+type SchemaVersion int
+
+const (
+	// SchemaLegacy is assumed for files without a schema line.
+	SchemaLegacy SchemaVersion = 0
+	// SchemaCurrent is the version written by this build. Version 1 events
+	// may carry provider, latency_ms, upstream_request_id and cached_tokens.
+	SchemaCurrent SchemaVersion = 1
+)
+
+// schemaLine is the first line of every file written since schema versioning.
+type schemaLine struct {
+	Schema SchemaVersion `json:"_schema"`
+}
+
+// schemaPrefix identifies schema lines without a full decode.
+var schemaPrefix = []byte(`{"_schema":`)
+
+// utf8BOM is the byte order mark some editors put at the start of a file.
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// trimBOM strips a leading UTF-8 byte order mark from line.
+func trimBOM(line []byte) []byte {
+	return bytes.TrimPrefix(line, utf8BOM)
+}
+
+// isMetaLine reports whether line is a schema or store header rather than an event.
+func isMetaLine(line []byte) bool {
+	line = trimBOM(line)
+	return bytes.HasPrefix(line, schemaPrefix) || bytes.HasPrefix(line, headerPrefix)
+}
+
+// isSkippedLine reports whether a line of a JSON store file holds no event:
+// it is empty, a schema or header line, or a '#' comment added by hand.
+// Binary records are length-prefixed and never checked for comments.
+func isSkippedLine(line []byte) bool {
+	line = bytes.TrimSpace(trimBOM(line))
+	return len(line) == 0 || line[0] == '#' || isMetaLine(line)
+}
+
+// parseSchemaLine returns the version declared by a schema line.
+func parseSchemaLine(line []byte) (SchemaVersion, bool) {
+	line = trimBOM(line)
+	if !bytes.HasPrefix(line, schemaPrefix) {
+		return 0, false
+	}
+	var sl schemaLine
+	if err := json.Unmarshal(line, &sl); err != nil {
+		return 0, false
+	}
+	return sl.Schema, true
+}
+
+// readMetaLines calls fn for each schema or header line at the top of the file
+// at path, after the magic of binary files, stopping at the first event.
+func readMetaLines(path string, fn func(line []byte)) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	binaryFile := isBinaryFile(f)
+	if binaryFile {
+		_, _ = reader.Discard(len(binaryMagic))
+	}
+	for {
+		line, err := reader.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		switch {
+		case isMetaLine(line):
+			fn(line)
+		case binaryFile || !isSkippedLine(line):
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readSchemaVersion returns the schema version of the file at path, or
+// SchemaLegacy when it has no schema line.
+func readSchemaVersion(path string) SchemaVersion {
+	version := SchemaLegacy
+	readMetaLines(path, func(line []byte) {
+		if v, ok := parseSchemaLine(line); ok {
+			version = v
+		}
+	})
+	return version
+}
+
+// SchemaVersion returns the schema version of the live store file. A store
+// that has not written its file yet reports SchemaCurrent.
+func (s *JSONStore) SchemaVersion() SchemaVersion {
+	if s == nil {
+		return SchemaCurrent
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := os.Stat(s.path); os.IsNotExist(err) {
+		return SchemaCurrent
+	}
+	return readSchemaVersion(s.path)
+}
+
+// upgradeEvent applies the defaults of older schema versions so readers
+// always see events in the current layout.
+func upgradeEvent(version SchemaVersion, event *UsageEvent) {
+	if version < 1 {
+		// Legacy lines may omit total_tokens; derive it from its parts
+		if event.TotalTokens == 0 {
+			event.TotalTokens = event.PromptTokens + event.CompletionTokens
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package usage
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// SelfCheckResult is the outcome of the latest background integrity check.
+type SelfCheckResult struct {
+	CheckedAt time.Time `json:"checked_at"`
+	// Checks counts the checks run since the store was opened.
+	Checks int64 `json:"checks"`
+	// Corrupt is the number of unparseable lines found by the latest check.
+	Corrupt int `json:"corrupt"`
+	// NewCorrupt is how many more corrupt lines the latest check found than the one before.
+	NewCorrupt int    `json:"new_corrupt"`
+	Error      string `json:"error,omitempty"`
+}
+
+// WithSelfCheck runs Validate in the background at the given interval and
+// records the result for SelfCheck, warning when the number of corrupt lines
+// grows. Each check reads the whole file while holding the store lock, so the
+// interval should be long (e.g. hourly). Zero (the default) disables it.
+func WithSelfCheck(interval time.Duration) StoreOption {
+	return func(s *JSONStore) {
+		if interval < 0 {
+			interval = 0
+		}
+		s.selfCheckInterval = interval
+	}
+}
+
+// SelfCheck returns the result of the latest background integrity check.
+// ok is false when self-checks are disabled or none has run yet.
+func (s *JSONStore) SelfCheck() (result SelfCheckResult, ok bool) {
+	if s == nil {
+		return SelfCheckResult{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.selfCheck == nil {
+		return SelfCheckResult{}, false
+	}
+	return *s.selfCheck, true
+}
+
+// runSelfCheck validates the store file once and records the result. A
+// background goroutine runs it every selfCheckInterval.
+func (s *JSONStore) runSelfCheck() {
+	report, err := s.Validate()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := SelfCheckResult{CheckedAt: s.now().UTC(), Checks: 1}
+	var previous int
+	if s.selfCheck != nil {
+		result.Checks = s.selfCheck.Checks + 1
+		previous = s.selfCheck.Corrupt
+	}
+	if err != nil {
+		// Keep the last known count so a read error does not hide corruption
+		result.Corrupt = previous
+		result.Error = err.Error()
+		fmt.Fprintf(os.Stderr, "warning: usage store self-check failed: %v\n", err)
+	} else {
+		result.Corrupt = report.Corrupt
+		if report.Corrupt > previous {
+			result.NewCorrupt = report.Corrupt - previous
+			fmt.Fprintf(os.Stderr, "warning: usage store self-check found %d new corrupt lines in %s (%d total)\n", result.NewCorrupt, s.path, report.Corrupt)
+		}
+	}
+	s.selfCheck = &result
+}
@@ -0,0 +1,116 @@
+package usage
+
+// StoreSettings are the effective settings of a store, after defaults and
+// the validation of its options, for reporting what actually took effect.
+// Durations are whole seconds; 0 means disabled unless noted.
+type StoreSettings struct {
+	Path     string `json:"path"`
+	ReadOnly bool   `json:"read_only"`
+	// Format is "json", "binary" or "custom" for a WithLineFormatter format;
+	// it applies to new files and segments.
+	Format string `json:"format"`
+	// FlushIntervalSeconds is how often buffered events are flushed in the
+	// background; 0 flushes only when the buffer fills and on Flush or Close.
+	FlushIntervalSeconds int64 `json:"flush_interval_seconds"`
+	// BufferEvents and MaxBufferBytes are the buffer sizes that trigger a
+	// flush; MaxBufferBytes is 0 without a byte limit.
+	BufferEvents   int   `json:"buffer_events"`
+	MaxBufferBytes int64 `json:"max_buffer_bytes"`
+	// FlushImmediately is set when some events, by default server errors,
+	// skip the buffer.
+	FlushImmediately bool `json:"flush_immediately"`
+	// SyncEveryFlushes and SyncEverySeconds relax the fsync of every flush.
+	SyncEveryFlushes int   `json:"sync_every_flushes"`
+	SyncEverySeconds int64 `json:"sync_every_seconds"`
+	// SampleRate is the fraction of events persisted; 1 records every event.
+	SampleRate               float64          `json:"sample_rate"`
+	CounterRetentionSeconds  int64            `json:"counter_retention_seconds,omitempty"`
+	RecentCapacity           int              `json:"recent_capacity"`
+	LatenessWindowSeconds    int64            `json:"lateness_window_seconds"`
+	MaxFollowers             int              `json:"max_followers"`
+	RollupIntervalSeconds    int64            `json:"rollup_interval_seconds"`
+	SelfCheckIntervalSeconds int64            `json:"self_check_interval_seconds"`
+	Rotation                 RotationSettings `json:"rotation"`
+	Archive                  ArchiveSettings  `json:"archive"`
+}
+
+// RotationSettings report the RotationPolicy of a store.
+type RotationSettings struct {
+	MaxBytes               int64 `json:"max_bytes"`
+	Daily                  bool  `json:"daily"`
+	MaxTotalBytes          int64 `json:"max_total_bytes"`
+	ProtectedWindowSeconds int64 `json:"protected_window_seconds"`
+	ForcePrune             bool  `json:"force_prune"`
+}
+
+// ArchiveSettings report the ArchivePolicy of a store, without its uploader
+// and so without any credentials.
+type ArchiveSettings struct {
+	Enabled           bool  `json:"enabled"`
+	Compress          bool  `json:"compress"`
+	DeleteLocal       bool  `json:"delete_local"`
+	MaxAttempts       int   `json:"max_attempts,omitempty"`
+	RetryDelaySeconds int64 `json:"retry_delay_seconds,omitempty"`
+}
+
+// Settings returns the effective settings of the store.
+//
+// Returns:
+//   - StoreSettings: The settings in effect; zero for a nil store
+func (s *JSONStore) Settings() StoreSettings {
+	if s == nil {
+		return StoreSettings{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	settings := StoreSettings{
+		Path:                     s.path,
+		ReadOnly:                 s.readOnly,
+		Format:                   "json",
+		BufferEvents:             bufferFlushEvents,
+		MaxBufferBytes:           s.maxBufferBytes,
+		FlushImmediately:         s.flushImmediately != nil,
+		SyncEveryFlushes:         s.syncEveryN,
+		SyncEverySeconds:         int64(s.syncEveryT.Seconds()),
+		SampleRate:               s.sampleRate,
+		RecentCapacity:           s.recentCapacity,
+		LatenessWindowSeconds:    int64(s.latenessWindow.Seconds()),
+		MaxFollowers:             s.maxFollowers,
+		RollupIntervalSeconds:    int64(s.rollupInterval.Seconds()),
+		SelfCheckIntervalSeconds: int64(s.selfCheckInterval.Seconds()),
+		Rotation: RotationSettings{
+			MaxBytes:               s.rotation.MaxBytes,
+			Daily:                  s.rotation.Daily,
+			MaxTotalBytes:          s.rotation.MaxTotalBytes,
+			ProtectedWindowSeconds: int64(s.rotation.ProtectedWindow.Seconds()),
+			ForcePrune:             s.rotation.ForcePrune,
+		},
+	}
+	switch {
+	case s.binaryFormat:
+		settings.Format = "binary"
+	case s.formatLine != nil:
+		settings.Format = "custom"
+	}
+	if s.flushPeriodically {
+		settings.FlushIntervalSeconds = int64(periodicFlushInterval.Seconds())
+	}
+	if s.sampleRate < 1 {
+		settings.CounterRetentionSeconds = int64(s.counterRetention.Seconds())
+	}
+	if settings.MaxFollowers <= 0 {
+		settings.MaxFollowers = defaultMaxFollowers
+	}
+	if s.archive.Uploader != nil {
+		settings.Archive = ArchiveSettings{
+			Enabled:           true,
+			Compress:          s.archive.Compress,
+			DeleteLocal:       s.archive.DeleteLocal,
+			MaxAttempts:       s.archive.MaxAttempts,
+			RetryDelaySeconds: int64(s.archive.RetryDelay.Seconds()),
+		}
+	}
+	return settings
+}
@@ -0,0 +1,49 @@
+package usage
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventSpan is the time span and number of the events a store holds.
+// Earliest and Latest are zero when it holds none.
+type EventSpan struct {
+	Earliest time.Time `json:"earliest"`
+	Latest   time.Time `json:"latest"`
+	Events   int64     `json:"total_events"`
+}
+
+// add widens the span to cover events.
+func (sp *EventSpan) add(events ...UsageEvent) {
+	for _, event := range events {
+		if sp.Events == 0 || event.Timestamp.Before(sp.Earliest) {
+			sp.Earliest = event.Timestamp
+		}
+		if sp.Events == 0 || event.Timestamp.After(sp.Latest) {
+			sp.Latest = event.Timestamp
+		}
+		sp.Events++
+	}
+}
+
+// Span returns the span of the events in the store file and the buffer, the
+// events metrics queries read, without scanning the file: it is kept up to
+// date as events are flushed and reset when the file is rotated. Like Totals,
+// it only covers earlier runs once RebuildTotals has run, which recomputes it
+// from disk on startup. Unlike Totals, ResetTotals does not zero it.
+//
+// Returns:
+//   - EventSpan: The earliest and latest event timestamps and the event count
+//   - error: An error if the store is nil
+func (s *JSONStore) Span() (EventSpan, error) {
+	if s == nil {
+		return EventSpan{}, fmt.Errorf("json store is nil")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	span := s.span
+	span.add(s.buffer...)
+	return span, nil
+}
@@ -0,0 +1,79 @@
+package usage
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// WithSyncPolicy makes flushes fsync the store file only every everyN
+// flushes or once everyT has passed since the last fsync, whichever comes
+// first, instead of on every flush (the default). A value of zero or less
+// disables that trigger; with both disabled, or everyN of 1, every flush
+// syncs.
+//
+// Durability: a flush still writes its events to the file, so they survive
+// the process crashing, but until the next fsync they may sit in the OS page
+// cache and be lost if the machine loses power or the kernel crashes. At most
+// everyN-1 flushes, or everyT of events, are exposed this way. The pending
+// data is always synced before the file is rotated and when the store is
+// closed, and with periodic flushing an idle store syncs within 30 seconds of
+// everyT passing; with only everyN set, the last flushes before a quiet spell
+// stay pending until the next flush that syncs. Immediate flushes, e.g. for
+// server errors, follow the policy too.
+func WithSyncPolicy(everyN int, everyT time.Duration) StoreOption {
+	return func(s *JSONStore) {
+		s.syncEveryN = max(everyN, 0)
+		s.syncEveryT = max(everyT, 0)
+	}
+}
+
+// syncAlwaysLocked reports whether every flush must fsync: the default
+// policy, and a closed store, whose writes must not be left pending.
+// Must be called with s.mu held.
+func (s *JSONStore) syncAlwaysLocked() bool {
+	return s.closed || (s.syncEveryN <= 1 && s.syncEveryT <= 0)
+}
+
+// syncDueLocked counts a flush just written to the store file and reports
+// whether the policy asks for an fsync now. Must be called with s.mu held.
+func (s *JSONStore) syncDueLocked() bool {
+	s.unsyncedFlushes++
+	if s.syncAlwaysLocked() {
+		return true
+	}
+	if s.lastSync.IsZero() {
+		s.lastSync = s.now()
+	}
+	return (s.syncEveryN > 0 && s.unsyncedFlushes >= s.syncEveryN) ||
+		(s.syncEveryT > 0 && s.now().Sub(s.lastSync) >= s.syncEveryT)
+}
+
+// markSyncedLocked records that every written flush is on stable storage.
+// Must be called with s.mu held.
+func (s *JSONStore) markSyncedLocked() {
+	s.unsyncedFlushes = 0
+	s.lastSync = s.now()
+}
+
+// syncPendingLocked fsyncs the store file when flushes are still unsynced,
+// or only once everyT has passed when onlyIfDue is set.
+// Must be called with s.mu held.
+func (s *JSONStore) syncPendingLocked(onlyIfDue bool) error {
+	if s.unsyncedFlushes == 0 {
+		return nil
+	}
+	if onlyIfDue && (s.syncEveryT <= 0 || s.now().Sub(s.lastSync) < s.syncEveryT) {
+		return nil
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	s.markSyncedLocked()
+	return nil
+}
@@ -0,0 +1,2138 @@
+package usage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJSONStore_LoadRangeCountsLateEvents(t *testing.T) {
+	store := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), WithLatenessWindow(5*time.Minute))
+	defer store.Close()
+
+	to := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	from := to.Add(-time.Hour)
+
+	events := []UsageEvent{
+		{Timestamp: from.Add(10 * time.Minute), Model: "in-range", TotalTokens: 1},
+		{Timestamp: to.Add(2 * time.Minute), Model: "after-range", TotalTokens: 2},
+		// Recorded late: stamped inside the range but written after a newer event
+		{Timestamp: to.Add(-time.Minute), Model: "late", TotalTokens: 3},
+		{Timestamp: to.Add(10 * time.Minute), Model: "past-window", TotalTokens: 4},
+		// Written after the scan stops, so it is outside the guarantee
+		{Timestamp: to.Add(-2 * time.Minute), Model: "too-late", TotalTokens: 5},
+	}
+	for _, event := range events {
+		if err := store.Write(event); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	got, err := store.LoadRange(from, to)
+	if err != nil {
+		t.Fatalf("load range: %v", err)
+	}
+
+	var models []string
+	for _, event := range got {
+		models = append(models, event.Model)
+	}
+	if len(models) != 2 || models[0] != "in-range" || models[1] != "late" {
+		t.Fatalf("want [in-range late], got %v", models)
+	}
+}
+
+func TestJSONStore_SyncPolicyDefersFsync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	store := NewJSONStore(path, WithPeriodicFlush(false), WithSyncPolicy(3, time.Minute))
+	clock := time.Date(2025, 11, 25, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return clock }
+	flush := func() {
+		t.Helper()
+		_ = store.Write(UsageEvent{Timestamp: clock, Model: "m"})
+		if err := store.Flush(); err != nil {
+			t.Fatalf("flush: %v", err)
+		}
+	}
+	pending := func(want int) {
+		t.Helper()
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		if store.unsyncedFlushes != want {
+			t.Fatalf("want %d unsynced flushes, got %d", want, store.unsyncedFlushes)
+		}
+	}
+
+	flush()
+	flush()
+	pending(2)
+	flush() // every 3 flushes
+	pending(0)
+
+	flush()
+	pending(1)
+	_ = store.Flush() // nothing buffered and not yet due
+	pending(1)
+	clock = clock.Add(time.Minute)
+	_ = store.Flush() // an idle store syncs once everyT has passed
+	pending(0)
+
+	flush()
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	pending(0)
+	if events, err := store.Load(); err != nil || len(events) != 5 {
+		t.Fatalf("want 5 events, got %d (%v)", len(events), err)
+	}
+
+	always := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), WithPeriodicFlush(false))
+	defer always.Close()
+	_ = always.Write(UsageEvent{Timestamp: clock, Model: "m"})
+	_ = always.Flush()
+	if always.unsyncedFlushes != 0 {
+		t.Fatal("want every flush synced by default")
+	}
+}
+
+func TestJSONStore_CloseWithoutPeriodicFlush(t *testing.T) {
+	store := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), WithPeriodicFlush(false))
+
+	if err := store.Write(UsageEvent{Timestamp: time.Now(), Model: "m", TotalTokens: 1}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("second close: %v", err)
+	}
+
+	events, err := store.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("want 1 flushed event, got %d", len(events))
+	}
+}
+
+func TestJSONStore_CloseWithTimeoutLeavesBufferOnStuckFlush(t *testing.T) {
+	store := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), WithPeriodicFlush(false))
+	if err := store.Write(UsageEvent{Timestamp: time.Now(), Model: "m", TotalTokens: 1}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// Hold the store lock to stand in for a flush stuck on the disk
+	store.mu.Lock()
+	err := store.CloseWithTimeout(20 * time.Millisecond)
+	buffered := len(store.buffer)
+	store.mu.Unlock()
+	if !errors.Is(err, ErrCloseTimeout) {
+		t.Fatalf("want ErrCloseTimeout, got %v", err)
+	}
+	if buffered != 1 {
+		t.Fatalf("want the event still buffered after the timeout, got %d", buffered)
+	}
+
+	// Once unblocked, the background close still persists the event
+	if err := store.CloseWithTimeout(time.Second); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	events, err := store.Load()
+	if err != nil || len(events) != 1 {
+		t.Fatalf("want 1 persisted event, got %d (%v)", len(events), err)
+	}
+}
+
+func TestJSONStore_UnclosedStoreIsReportedWhenCollected(t *testing.T) {
+	dir := t.TempDir()
+	// Unclosed stores of other tests are counted in LeakedStores whenever
+	// they are collected, so each store here reports its own leak
+	var closedLeaks, leakedLeaks atomic.Int64
+	runtime.GC()
+	before := LeakedStores()
+
+	closed := NewJSONStore(filepath.Join(dir, "closed.json"), WithSelfCheck(time.Hour))
+	closed.onLeak = func() { closedLeaks.Add(1) }
+	if err := closed.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	// Never closed: only the finalizer can stop its flush goroutine
+	func() {
+		leaked := NewJSONStore(filepath.Join(dir, "leaked.json"))
+		leaked.onLeak = func() { leakedLeaks.Add(1) }
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for leakedLeaks.Load() == 0 && time.Now().Before(deadline) {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Give a wrongly reported closed store the same chance to show up
+	runtime.GC()
+	time.Sleep(10 * time.Millisecond)
+	if leakedLeaks.Load() != 1 || closedLeaks.Load() != 0 {
+		t.Fatalf("want only the unclosed store reported, got %d leaked and %d closed", leakedLeaks.Load(), closedLeaks.Load())
+	}
+	if LeakedStores()-before < 1 {
+		t.Fatal("want the unclosed store counted in LeakedStores")
+	}
+}
+
+func TestJSONStore_EnrichersStampLabels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	store := NewJSONStore(path, WithPeriodicFlush(false),
+		WithEnricher(StaticLabels(map[string]string{"region": "eu-west-1", "build": "v1"})),
+		WithEnricher(func(event *UsageEvent) { event.Labels["model_family"] = strings.SplitN(event.Model, "-", 2)[0] }),
+	)
+	defer func() { _ = store.Close() }()
+
+	own := map[string]string{"region": "us-east-1"}
+	if err := store.Write(UsageEvent{Timestamp: time.Now(), Model: "gpt-4", TotalTokens: 1, Labels: own}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if len(own) != 1 {
+		t.Fatalf("enrichers modified the caller's labels: %v", own)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	events, err := store.Load()
+	if err != nil || len(events) != 1 {
+		t.Fatalf("want 1 event, got %d (%v)", len(events), err)
+	}
+	want := map[string]string{"region": "us-east-1", "build": "v1", "model_family": "gpt"}
+	if !reflect.DeepEqual(events[0].Labels, want) {
+		t.Fatalf("want labels %v, got %v", want, events[0].Labels)
+	}
+}
+
+func TestJSONStore_NoFilesUntilFirstWrite(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "usage")
+	path := filepath.Join(dir, "usage.json")
+	store := NewJSONStore(path, WithPeriodicFlush(false), WithSampling(0.5))
+
+	events, err := store.Load()
+	if err != nil || len(events) != 0 {
+		t.Fatalf("want an empty load, got %d events (%v)", len(events), err)
+	}
+	if err := store.RebuildTotals(); err != nil {
+		t.Fatalf("rebuild totals: %v", err)
+	}
+	if err := store.GenerateRollups(); err != nil {
+		t.Fatalf("generate rollups: %v", err)
+	}
+	if _, err := store.ResetTotals(); err != nil {
+		t.Fatalf("reset totals: %v", err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("want no store directory after an unused store, got %v", err)
+	}
+
+	store = NewJSONStore(path, WithPeriodicFlush(false))
+	if err := store.Write(UsageEvent{Timestamp: time.Now(), Model: "m", TotalTokens: 1}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("want the store file after the first write: %v", err)
+	}
+}
+
+// loadSegments returns the events of every rotated segment followed by the live file.
+func loadSegments(t *testing.T, store *JSONStore) [][]UsageEvent {
+	t.Helper()
+	segments, err := store.Segments()
+	if err != nil {
+		t.Fatalf("segments: %v", err)
+	}
+	var out [][]UsageEvent
+	for _, path := range append(segments, store.path) {
+		events, err := NewJSONStore(path, WithPeriodicFlush(false)).Load()
+		if err != nil {
+			t.Fatalf("load %s: %v", path, err)
+		}
+		out = append(out, events)
+	}
+	return out
+}
+
+func TestJSONStore_SnapshotIsConsistentWhileWritesContinue(t *testing.T) {
+	store := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"),
+		WithPeriodicFlush(false),
+		WithRotation(RotationPolicy{MaxBytes: 4096}),
+	)
+	defer store.Close()
+
+	base := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	write := func(from, to int) {
+		for i := from; i < to; i++ {
+			if err := store.Write(UsageEvent{Timestamp: base.Add(time.Duration(i) * time.Second), Model: "m", RequestID: fmt.Sprintf("req-%d", i)}); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+		}
+	}
+	write(0, 75)
+
+	snapshot, err := store.Snapshot(true)
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	defer snapshot.Close()
+	if len(snapshot.Files) < 2 || snapshot.Files[len(snapshot.Files)-1].Name != "usage.json" {
+		t.Fatalf("want segments then the live file, got %+v", snapshot.Files)
+	}
+	// Later writes, flushes and rotations do not change the snapshot
+	write(75, 200)
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	var requestIDs []string
+	for _, file := range snapshot.Files {
+		data, err := io.ReadAll(file.Reader())
+		if err != nil {
+			t.Fatalf("read %s: %v", file.Name, err)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			if isMetaLine([]byte(line)) {
+				continue
+			}
+			var event UsageEvent
+			if err := json.Unmarshal([]byte(line), &event); err != nil {
+				t.Fatalf("%s: %v", file.Name, err)
+			}
+			requestIDs = append(requestIDs, event.RequestID)
+		}
+	}
+	if len(requestIDs) != 75 || requestIDs[0] != "req-0" || requestIDs[74] != "req-74" {
+		t.Fatalf("want exactly the 75 events flushed by the snapshot in order, got %d", len(requestIDs))
+	}
+}
+
+func TestJSONStore_RotateBySizeKeepsEveryEventOnce(t *testing.T) {
+	store := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"),
+		WithPeriodicFlush(false),
+		WithRotation(RotationPolicy{MaxBytes: 4096}),
+	)
+
+	const total = 137
+	base := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < total; i++ {
+		event := UsageEvent{Timestamp: base.Add(time.Duration(i) * time.Second), Model: "m", RequestID: fmt.Sprintf("req-%d", i)}
+		if err := store.Write(event); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	segments := loadSegments(t, store)
+	if len(segments) < 2 {
+		t.Fatalf("want at least one rotation, got %d files", len(segments))
+	}
+	next := 0
+	for _, events := range segments {
+		for _, event := range events {
+			if want := fmt.Sprintf("req-%d", next); event.RequestID != want {
+				t.Fatalf("want %s next, got %s", want, event.RequestID)
+			}
+			next++
+		}
+	}
+	if next != total {
+		t.Fatalf("want %d events across segments, got %d", total, next)
+	}
+}
+
+func TestJSONStore_LoadRangeReadsAcrossRotation(t *testing.T) {
+	store := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"),
+		WithPeriodicFlush(false),
+		WithRotation(RotationPolicy{MaxBytes: 4096}),
+	)
+
+	const total = 137
+	base := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < total; i++ {
+		event := UsageEvent{Timestamp: base.Add(time.Duration(i) * time.Minute), Model: "m", RequestID: fmt.Sprintf("req-%d", i)}
+		if err := store.Write(event); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	segments, err := store.Segments()
+	if err != nil || len(segments) < 2 {
+		t.Fatalf("want several segments, got %v (%v)", segments, err)
+	}
+
+	// Events 10 to 120 span the first segment, later ones and the live file
+	events, err := store.LoadRange(base.Add(10*time.Minute), base.Add(120*time.Minute))
+	if err != nil {
+		t.Fatalf("load range: %v", err)
+	}
+	if len(events) != 111 || events[0].RequestID != "req-10" || events[110].RequestID != "req-120" {
+		t.Fatalf("want req-10 to req-120 once each, got %d events", len(events))
+	}
+
+	var iterated int
+	if err := store.Iterate(func(UsageEvent) bool { iterated++; return true }); err != nil {
+		t.Fatalf("iterate: %v", err)
+	}
+	if iterated != total {
+		t.Fatalf("want %d events iterated, got %d", total, iterated)
+	}
+
+	// A segment last written before the range cannot hold events in it
+	old := base.Add(-time.Hour)
+	if err := os.Chtimes(segments[0], old, old); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	events, err = store.LoadRange(base, base.Add(time.Duration(total)*time.Minute))
+	if err != nil {
+		t.Fatalf("load range: %v", err)
+	}
+	if len(events) == 0 || len(events) == total || events[0].RequestID == "req-0" {
+		t.Fatalf("want the first segment skipped, got %d events", len(events))
+	}
+
+	// Rollups count the segments too
+	if err := store.GenerateRollups(); err != nil {
+		t.Fatalf("generate rollups: %v", err)
+	}
+	set, ok := store.Rollups()
+	if !ok || len(set.Daily) != 1 || set.Daily[0].Requests != total || set.TailOffset < 0 {
+		t.Fatalf("want one day of %d requests with the tail in the live file, got %+v", total, set)
+	}
+
+	// A tail rotated into a segment is not read by file offset
+	recent := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"),
+		WithPeriodicFlush(false),
+		WithRotation(RotationPolicy{MaxBytes: 1}),
+	)
+	defer recent.Close()
+	if err := recent.Write(UsageEvent{Timestamp: time.Now(), Model: "m"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := recent.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if err := recent.Write(UsageEvent{Timestamp: time.Now(), Model: "m"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := recent.GenerateRollups(); err != nil {
+		t.Fatalf("generate rollups: %v", err)
+	}
+	if set, ok := recent.Rollups(); !ok || set.TailOffset != -1 {
+		t.Fatalf("want tail_offset -1, got %+v", set)
+	}
+}
+
+func TestJSONStore_ConcurrentFlushesRotateOnce(t *testing.T) {
+	store := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"),
+		WithPeriodicFlush(false),
+		WithRotation(RotationPolicy{MaxBytes: 2048}),
+	)
+
+	const writers, perWriter = 4, 100
+	base := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				event := UsageEvent{Timestamp: base.Add(time.Duration(i) * time.Second), Model: "m", RequestID: fmt.Sprintf("req-%d-%d", w, i)}
+				if i%7 == 0 {
+					// Server errors skip the buffer by default
+					event.Status = 502
+				}
+				if err := store.Write(event); err != nil {
+					t.Errorf("write: %v", err)
+					return
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				if err := store.Flush(); err != nil {
+					t.Errorf("flush: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	segments := loadSegments(t, store)
+	if len(segments) < 3 {
+		t.Fatalf("want several rotations, got %d files", len(segments))
+	}
+	seen := make(map[string]bool)
+	for i, events := range segments {
+		// Only the live file, last, may be empty after a rotation
+		if len(events) == 0 && i < len(segments)-1 {
+			t.Fatalf("segment %d is empty: rotated twice", i)
+		}
+		for _, event := range events {
+			if seen[event.RequestID] {
+				t.Fatalf("%s written twice", event.RequestID)
+			}
+			seen[event.RequestID] = true
+		}
+	}
+	if len(seen) != writers*perWriter {
+		t.Fatalf("want %d events across segments, got %d", writers*perWriter, len(seen))
+	}
+}
+
+func TestJSONStore_RebuildTotalsReplaysSegmentsAfterCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	open := func() *JSONStore {
+		t.Helper()
+		store := NewJSONStore(path, WithPeriodicFlush(false), WithRotation(RotationPolicy{MaxBytes: 1024}))
+		if err := store.RebuildTotals(); err != nil {
+			t.Fatalf("rebuild: %v", err)
+		}
+		return store
+	}
+	base := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	written := 0
+	write := func(store *JSONStore, n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			_ = store.Write(UsageEvent{Timestamp: base.Add(time.Duration(written) * time.Second), Model: "m", TotalTokens: 1})
+			written++
+			if err := store.Flush(); err != nil {
+				t.Fatalf("flush: %v", err)
+			}
+		}
+	}
+	wantTotals := func(store *JSONStore, want int) {
+		t.Helper()
+		if totals := store.Totals(); totals.Requests != int64(want) || totals.Tokens != int64(want) {
+			t.Fatalf("want %d events counted, got %+v", want, totals)
+		}
+	}
+
+	store := open()
+	write(store, 30)
+	_ = store.Close()
+	segments, _ := store.Segments()
+	if len(segments) < 2 {
+		t.Fatalf("want rotated segments, got %v", segments)
+	}
+
+	// Without a checkpoint every segment is read
+	_ = os.Remove(path + ".totals")
+	store = open()
+	wantTotals(store, 30)
+	_ = store.Close()
+
+	// With one, earlier segments are not read again: an event slipped into
+	// the oldest segment is not counted
+	info, _ := os.Stat(segments[0])
+	f, _ := os.OpenFile(segments[0], os.O_APPEND|os.O_WRONLY, 0)
+	_, _ = f.WriteString(`{"timestamp":"2025-11-25T00:00:00Z","model":"m","total_tokens":1}` + "\n")
+	_ = f.Close()
+	_ = os.Chtimes(segments[0], info.ModTime(), info.ModTime()) // keep the segment order
+	store = open()
+	wantTotals(store, 30)
+
+	// A checkpoint older than the latest rotation resumes in the segment
+	// its live file became, then replays the newer files
+	checkpoint, _ := os.ReadFile(path + ".totals")
+	before, _ := store.Segments()
+	for after := before; len(after) == len(before); after, _ = store.Segments() {
+		write(store, 1)
+	}
+	write(store, 2)
+	_ = store.Close()
+	_ = os.WriteFile(path+".totals", checkpoint, 0o600)
+	store = open()
+	defer store.Close()
+	wantTotals(store, written)
+}
+
+func TestReadOnlyStore_ReadsWithoutWriting(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "usage.json")
+	writer := NewJSONStore(path, WithPeriodicFlush(false))
+	base := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		if err := writer.Write(UsageEvent{Timestamp: base.Add(time.Duration(i) * time.Minute), Model: "m", TotalTokens: 10}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+
+	reader := NewReadOnlyStore(path)
+	if reader.done != nil {
+		t.Fatal("want no background goroutines")
+	}
+	if err := reader.Write(UsageEvent{Timestamp: base, Model: "m"}); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("want ErrReadOnly from Write, got %v", err)
+	}
+	if err := reader.Flush(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("want ErrReadOnly from Flush, got %v", err)
+	}
+	if err := reader.GenerateRollups(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("want ErrReadOnly from GenerateRollups, got %v", err)
+	}
+
+	if events, err := reader.Load(); err != nil || len(events) != 5 {
+		t.Fatalf("want 5 events loaded, got %d (%v)", len(events), err)
+	}
+	if events, err := reader.LoadRange(base.Add(time.Minute), base.Add(2*time.Minute)); err != nil || len(events) != 2 {
+		t.Fatalf("want 2 events in range, got %d (%v)", len(events), err)
+	}
+	var iterated int
+	if err := reader.Iterate(func(UsageEvent) bool { iterated++; return iterated < 3 }); err != nil || iterated != 3 {
+		t.Fatalf("want iteration stopped after 3 events, got %d (%v)", iterated, err)
+	}
+	if err := reader.RebuildTotals(); err != nil || reader.Totals().Requests != 5 {
+		t.Fatalf("want totals of 5 requests, got %+v (%v)", reader.Totals(), err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("want only the store file, got %v (%v)", entries, err)
+	}
+	if after, err := os.Stat(path); err != nil || after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) {
+		t.Fatal("want the store file untouched")
+	}
+}
+
+// fakeUploader records uploaded objects, failing the first failures calls.
+type fakeUploader struct {
+	mu       sync.Mutex
+	failures int
+	calls    int
+	objects  map[string][]byte
+	uploaded chan string
+}
+
+func (u *fakeUploader) Upload(ctx context.Context, name string, body io.Reader) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.calls++
+	if u.calls <= u.failures {
+		return errors.New("unavailable")
+	}
+	u.objects[name] = data
+	u.uploaded <- name
+	return nil
+}
+
+func TestJSONStore_ArchiveUploadsRotatedSegments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	uploader := &fakeUploader{failures: 1, objects: make(map[string][]byte), uploaded: make(chan string, 16)}
+	store := NewJSONStore(path,
+		WithPeriodicFlush(false),
+		WithRotation(RotationPolicy{MaxBytes: 1024}),
+		WithArchive(ArchivePolicy{Uploader: uploader, Compress: true, DeleteLocal: true, RetryDelay: time.Millisecond}),
+	)
+	defer store.Close()
+
+	base := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 50; i++ {
+		if err := store.Write(UsageEvent{Timestamp: base.Add(time.Duration(i) * time.Second), Model: "m", RequestID: fmt.Sprintf("req-%d", i)}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	segments, err := store.Segments()
+	if err != nil || len(segments) != 1 {
+		t.Fatalf("want one rotated segment, got %v (%v)", segments, err)
+	}
+	local, err := os.ReadFile(segments[0])
+	if err != nil {
+		t.Fatalf("read segment: %v", err)
+	}
+
+	var name string
+	select {
+	case name = <-uploader.uploaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("segment was not uploaded")
+	}
+	if want := filepath.Base(segments[0]) + ".gz"; name != want || uploader.calls != 2 {
+		t.Fatalf("want %s uploaded on the second attempt, got %s after %d calls", want, name, uploader.calls)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(uploader.objects[name]))
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	if uploaded, err := io.ReadAll(gz); err != nil || !bytes.Equal(uploaded, local) {
+		t.Fatalf("want the segment content uploaded (%v)", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		if _, err := os.Stat(segments[0]); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("want the uploaded segment deleted locally")
+		}
+	}
+}
+
+func TestJSONStore_ArchiveKeepsSegmentWhenUploadsFail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	uploader := &fakeUploader{failures: 1 << 30, objects: make(map[string][]byte), uploaded: make(chan string, 16)}
+	store := NewJSONStore(path,
+		WithPeriodicFlush(false),
+		WithRotation(RotationPolicy{MaxBytes: 1024}),
+		WithArchive(ArchivePolicy{Uploader: uploader, DeleteLocal: true, MaxAttempts: 3, RetryDelay: time.Millisecond}),
+	)
+	defer store.Close()
+
+	base := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 50; i++ {
+		if err := store.Write(UsageEvent{Timestamp: base.Add(time.Duration(i) * time.Second), Model: "m"}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		uploader.mu.Lock()
+		calls := uploader.calls
+		uploader.mu.Unlock()
+		if calls == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want 3 attempts, got %d", calls)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if segments, err := store.Segments(); err != nil || len(segments) != 1 {
+		t.Fatalf("want the segment kept after failed uploads, got %v (%v)", segments, err)
+	}
+	uploader.mu.Lock()
+	defer uploader.mu.Unlock()
+	if uploader.calls != 3 {
+		t.Fatalf("want no more than 3 attempts, got %d", uploader.calls)
+	}
+}
+
+func TestJSONStore_RotateTotalCapKeepsProtectedSegments(t *testing.T) {
+	const maxTotal = 10000
+	for _, force := range []bool{false, true} {
+		store := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"),
+			WithPeriodicFlush(false),
+			WithRotation(RotationPolicy{MaxBytes: 4096, MaxTotalBytes: maxTotal, ProtectedWindow: time.Hour, ForcePrune: force}),
+		)
+		base := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+		for i := 0; i < 400; i++ {
+			if err := store.Write(UsageEvent{Timestamp: base.Add(time.Duration(i) * time.Second), Model: "m"}); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+		}
+		if err := store.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+
+		// Every segment was just written, so only a forced prune meets the cap
+		disk, err := store.DiskUsage()
+		if err != nil {
+			t.Fatalf("disk usage: %v", err)
+		}
+		if force && disk > maxTotal+4096 {
+			t.Fatalf("force: want the cap met, got %d bytes", disk)
+		}
+		if !force && disk <= maxTotal {
+			t.Fatalf("want protected segments kept over the cap, got %d bytes", disk)
+		}
+	}
+}
+
+func TestJSONStore_RotateTotalCapDeletesOldestSegments(t *testing.T) {
+	const maxTotal = 10000
+	store := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"),
+		WithPeriodicFlush(false),
+		WithRotation(RotationPolicy{MaxBytes: 4096, MaxTotalBytes: maxTotal}),
+	)
+
+	const total = 400
+	base := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < total; i++ {
+		event := UsageEvent{Timestamp: base.Add(time.Duration(i) * time.Second), Model: "m", RequestID: fmt.Sprintf("req-%d", i)}
+		if err := store.Write(event); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	segments, err := store.Segments()
+	if err != nil {
+		t.Fatalf("segments: %v", err)
+	}
+	var segmentBytes int64
+	for _, segment := range segments {
+		info, err := os.Stat(segment)
+		if err != nil {
+			t.Fatalf("stat: %v", err)
+		}
+		segmentBytes += info.Size()
+	}
+	if segmentBytes > maxTotal {
+		t.Fatalf("segments use %d bytes, want at most %d", segmentBytes, maxTotal)
+	}
+	disk, err := store.DiskUsage()
+	if err != nil {
+		t.Fatalf("disk usage: %v", err)
+	}
+	want := segmentBytes
+	if live, err := os.Stat(store.path); err == nil {
+		want += live.Size()
+	}
+	if disk != want {
+		t.Fatalf("disk usage %d, want %d", disk, want)
+	}
+
+	var kept []string
+	for _, events := range loadSegments(t, store) {
+		for _, event := range events {
+			kept = append(kept, event.RequestID)
+		}
+	}
+	if len(kept) == 0 || len(kept) == total {
+		t.Fatalf("want some but not all events kept, got %d", len(kept))
+	}
+	first := total - len(kept)
+	for i, id := range kept {
+		if want := fmt.Sprintf("req-%d", first+i); id != want {
+			t.Fatalf("want newest events kept in order, got %s at %d (want %s)", id, i, want)
+		}
+	}
+}
+
+func TestJSONStore_RotateDailySplitsBufferAtMidnight(t *testing.T) {
+	store := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"),
+		WithPeriodicFlush(false),
+		WithRotation(RotationPolicy{Daily: true}),
+	)
+	midnight := time.Date(2025, 11, 26, 0, 0, 0, 0, time.UTC)
+	clock := midnight.Add(-time.Hour)
+	store.now = func() time.Time { return clock }
+
+	write := func(id string, ts time.Time) {
+		if err := store.Write(UsageEvent{Timestamp: ts, Model: "m", RequestID: id}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	write("flushed-before", midnight.Add(-time.Hour))
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	// Buffered across midnight, flushed after it
+	write("buffered-before", midnight.Add(-time.Second))
+	write("buffered-after", midnight.Add(time.Second))
+	clock = midnight.Add(time.Minute)
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	segments := loadSegments(t, store)
+	if len(segments) != 2 {
+		t.Fatalf("want 1 rotated segment and the live file, got %d files", len(segments))
+	}
+	ids := func(events []UsageEvent) []string {
+		var out []string
+		for _, event := range events {
+			out = append(out, event.RequestID)
+		}
+		return out
+	}
+	if got := ids(segments[0]); len(got) != 2 || got[0] != "flushed-before" || got[1] != "buffered-before" {
+		t.Fatalf("want [flushed-before buffered-before] in the old segment, got %v", got)
+	}
+	if got := ids(segments[1]); len(got) != 1 || got[0] != "buffered-after" {
+		t.Fatalf("want [buffered-after] in the new file, got %v", got)
+	}
+}
+
+func TestJSONStore_SchemaVersion(t *testing.T) {
+	dir := t.TempDir()
+
+	legacyPath := filepath.Join(dir, "legacy.json")
+	legacyLine := `{"timestamp":"2025-11-25T12:00:00Z","model":"m","prompt_tokens":3,"completion_tokens":4,"status":200}` + "\n"
+	if err := os.WriteFile(legacyPath, []byte(legacyLine), 0o600); err != nil {
+		t.Fatalf("write legacy file: %v", err)
+	}
+	legacy := NewJSONStore(legacyPath, WithPeriodicFlush(false))
+	defer legacy.Close()
+	if v := legacy.SchemaVersion(); v != SchemaLegacy {
+		t.Fatalf("legacy schema = %d, want %d", v, SchemaLegacy)
+	}
+	events, err := legacy.Load()
+	if err != nil {
+		t.Fatalf("load legacy: %v", err)
+	}
+	if len(events) != 1 || events[0].TotalTokens != 7 {
+		t.Fatalf("want one legacy event with derived total 7, got %+v", events)
+	}
+
+	path := filepath.Join(dir, "usage.json")
+	store := NewJSONStore(path, WithPeriodicFlush(false))
+	defer store.Close()
+	if err := store.Write(UsageEvent{Timestamp: time.Now(), Model: "m", TotalTokens: 1}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if want := fmt.Sprintf("{\"_schema\":%d}\n", SchemaCurrent); !strings.HasPrefix(string(data), want) {
+		t.Fatalf("file does not start with %q: %q", want, data)
+	}
+	if v := store.SchemaVersion(); v != SchemaCurrent {
+		t.Fatalf("schema = %d, want %d", v, SchemaCurrent)
+	}
+	page, err := store.ReadFrom(0, 0)
+	if err != nil {
+		t.Fatalf("read from: %v", err)
+	}
+	if len(page.Events) != 1 {
+		t.Fatalf("want 1 event after the schema line, got %d", len(page.Events))
+	}
+}
+
+func TestJSONStore_SamplingKeepsExactCounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	start := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	store := NewJSONStore(path, WithPeriodicFlush(false), WithSampling(0.1), WithCounterRetention(24*time.Hour))
+	store.now = func() time.Time { return start }
+	store.loadCounters()
+
+	for i := 0; i < 1000; i++ {
+		event := UsageEvent{Timestamp: start.Add(time.Duration(i) * time.Second), Model: "m", TotalTokens: 2}
+		if err := store.Write(event); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if _, recorded := store.Counts(); recorded >= 1000 {
+		t.Fatalf("expected sampling to drop events, recorded %d", recorded)
+	}
+	counts, ok := store.ExactCountsBetween(start, start.Add(time.Hour))
+	if !ok || counts.Requests != 1000 || counts.Tokens != 2000 {
+		t.Fatalf("exact counts = %+v (ok=%v), want 1000 requests and 2000 tokens", counts, ok)
+	}
+	if _, ok := store.ExactCountsBetween(start.Add(-time.Minute), start); ok {
+		t.Fatal("expected no exact counts before counting started")
+	}
+
+	// A new store resumes from the saved counters
+	reopened := NewJSONStore(path, WithPeriodicFlush(false), WithSampling(0.1))
+	defer reopened.Close()
+	if counts, ok := reopened.ExactCountsBetween(start, start.Add(time.Hour)); !ok || counts.Requests != 1000 {
+		t.Fatalf("reopened exact counts = %+v (ok=%v), want 1000 requests", counts, ok)
+	}
+}
+
+func TestJSONStore_TailAcrossRotation(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	event := func(i int) UsageEvent {
+		return UsageEvent{Timestamp: base.Add(time.Duration(i) * time.Minute), Model: "m", RequestID: fmt.Sprintf("req-%02d", i)}
+	}
+	// Rotate once the file holds 6 of these events
+	sizing := NewJSONStore(filepath.Join(dir, "sizing.json"), WithPeriodicFlush(false))
+	for i := 0; i < 6; i++ {
+		_ = sizing.Write(event(i))
+	}
+	_ = sizing.Close()
+	info, err := os.Stat(sizing.path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+
+	store := NewJSONStore(filepath.Join(dir, "usage.json"), WithPeriodicFlush(false), WithRotation(RotationPolicy{MaxBytes: info.Size()}))
+	defer store.Close()
+	write := func(from, to int) {
+		for i := from; i < to; i++ {
+			if err := store.Write(event(i)); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+		}
+		if err := store.Flush(); err != nil {
+			t.Fatalf("flush: %v", err)
+		}
+	}
+
+	write(0, 3)
+	page, err := store.ReadFrom(0, 0)
+	if err != nil || len(page.Events) != 3 || page.Generation != "" {
+		t.Fatalf("want 3 events before any rotation, got %d %q (%v)", len(page.Events), page.Generation, err)
+	}
+	// Events 3 to 6 are rotated into a segment with the first three
+	write(3, 7)
+	write(7, 11)
+	if segments, _ := store.Segments(); len(segments) != 1 {
+		t.Fatalf("want one rotation, got %v", segments)
+	}
+
+	// The old offset falls on a line boundary of the new file, so it alone
+	// does not reveal the rotation
+	if stale, err := store.ReadFrom(page.Offset, 0); err != nil || stale.Reset || len(stale.Events) != 1 {
+		t.Fatalf("want the stale offset to look valid, got %d events, reset %v (%v)", len(stale.Events), stale.Reset, err)
+	}
+	next, err := store.ReadFromGeneration(page.Generation, page.Offset, 0)
+	if err != nil || !next.Reset || len(next.Events) != 4 || next.Events[0].RequestID != "req-07" {
+		t.Fatalf("want a reset and the whole new file, got %d events, reset %v (%v)", len(next.Events), next.Reset, err)
+	}
+	if next.Generation == "" || next.Generation != store.Generation() {
+		t.Fatalf("want the segment as the new generation, got %q", next.Generation)
+	}
+	write(11, 12)
+	if more, err := store.ReadFromGeneration(next.Generation, next.Offset, 0); err != nil || more.Reset || len(more.Events) != 1 {
+		t.Fatalf("want the next event without a reset, got %d events, reset %v (%v)", len(more.Events), more.Reset, err)
+	}
+
+	// Paging cursors carry the generation too
+	paged, err := store.EventsAfter(0, 1, nil)
+	if err != nil || len(paged) != 1 || paged[0].Generation != next.Generation {
+		t.Fatalf("want the generation on paged events, got %+v (%v)", paged, err)
+	}
+}
+
+func TestJSONStore_EventsBeforeMatchesEventsAfter(t *testing.T) {
+	store := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), WithPeriodicFlush(false))
+	defer store.Close()
+
+	// Enough events to span several backward read chunks
+	start := time.Date(2025, 11, 25, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 2000; i++ {
+		event := UsageEvent{Timestamp: start.Add(time.Duration(i) * time.Second), Model: fmt.Sprintf("m%d", i%3), TotalTokens: int64(i)}
+		if err := store.Write(event); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	forward, err := store.EventsAfter(0, 5000, nil)
+	if err != nil {
+		t.Fatalf("events after: %v", err)
+	}
+	backward, err := store.EventsBefore(-1, 5000, nil)
+	if err != nil {
+		t.Fatalf("events before: %v", err)
+	}
+	if len(forward) != 2000 || len(backward) != 2000 {
+		t.Fatalf("got %d forward and %d backward events, want 2000", len(forward), len(backward))
+	}
+	for i := range forward {
+		if !reflect.DeepEqual(forward[i], backward[len(backward)-1-i]) {
+			t.Fatalf("event %d differs: %+v vs %+v", i, forward[i], backward[len(backward)-1-i])
+		}
+	}
+
+	// Paging backwards from the middle resumes right before the reference event
+	mid := forward[1000]
+	page, err := store.EventsBefore(mid.Offset, 2, func(e UsageEvent) bool { return e.Model == "m0" })
+	if err != nil {
+		t.Fatalf("events before offset: %v", err)
+	}
+	if len(page) != 2 || page[0].Event.TotalTokens != 999 || page[1].Event.TotalTokens != 996 {
+		t.Fatalf("unexpected filtered page: %+v", page)
+	}
+	if _, err := store.EventsAfter(mid.Offset+1, 1, nil); err != ErrInvalidOffset {
+		t.Fatalf("want ErrInvalidOffset for a mid-line offset, got %v", err)
+	}
+}
+
+func TestJSONStore_SelfCheckCountsNewCorruption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	store := NewJSONStore(path, WithPeriodicFlush(false))
+	defer store.Close()
+
+	if _, ok := store.SelfCheck(); ok {
+		t.Fatal("expected no self-check result before the first run")
+	}
+	if err := store.Write(UsageEvent{Timestamp: time.Now(), Model: "m", TotalTokens: 1}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	store.runSelfCheck()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := f.WriteString("{not json\n{\"timestamp\":\n"); err != nil {
+		t.Fatalf("corrupt file: %v", err)
+	}
+	f.Close()
+	store.runSelfCheck()
+
+	result, ok := store.SelfCheck()
+	if !ok || result.Checks != 2 || result.Corrupt != 2 || result.NewCorrupt != 2 {
+		t.Fatalf("unexpected self-check result %+v (ok=%v)", result, ok)
+	}
+}
+
+func TestJSONStore_ValidateDoesNotBlockWrites(t *testing.T) {
+	reading := make(chan struct{})
+	release := make(chan struct{})
+	var block atomic.Bool
+	parse := func(line []byte) (UsageEvent, error) {
+		if block.CompareAndSwap(true, false) {
+			close(reading)
+			<-release
+		}
+		var event UsageEvent
+		err := json.Unmarshal(line, &event)
+		return event, err
+	}
+	store := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), WithPeriodicFlush(false), WithLineParser(parse))
+	defer store.Close()
+	if err := store.Write(UsageEvent{Timestamp: time.Now(), Model: "m"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	// Hold Validate in the middle of its scan
+	block.Store(true)
+	validated := make(chan ValidationReport, 1)
+	go func() {
+		report, _ := store.Validate()
+		validated <- report
+	}()
+	<-reading
+	flushed := make(chan error, 1)
+	go func() {
+		if err := store.Write(UsageEvent{Timestamp: time.Now(), Model: "m"}); err != nil {
+			flushed <- err
+			return
+		}
+		flushed <- store.Flush()
+	}()
+	select {
+	case err := <-flushed:
+		if err != nil {
+			t.Fatalf("write during validation: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write blocked by a running Validate")
+	}
+	close(release)
+
+	// The report covers the file as of the call
+	if report := <-validated; report.Events != 1 || report.Corrupt != 0 {
+		t.Fatalf("want the snapshot's single event validated, got %+v", report)
+	}
+}
+
+func TestJSONStore_ReplayStreamsRangeAcrossPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	store := NewJSONStore(path, WithPeriodicFlush(false), WithLatenessWindow(0))
+	defer store.Close()
+
+	start := time.Date(2025, 11, 25, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 2500; i++ {
+		if err := store.Write(UsageEvent{Timestamp: start.Add(time.Duration(i) * time.Second), Model: "m", TotalTokens: 1}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	secondary := NewJSONStore(filepath.Join(t.TempDir(), "replay.json"), WithPeriodicFlush(false))
+	defer secondary.Close()
+	from, to := start.Add(100*time.Second), start.Add(2199*time.Second)
+	if err := store.Replay(from, to, secondary.Write); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if err := secondary.Flush(); err != nil {
+		t.Fatalf("flush secondary: %v", err)
+	}
+
+	replayed, err := secondary.Load()
+	if err != nil {
+		t.Fatalf("load secondary: %v", err)
+	}
+	if len(replayed) != 2100 || !replayed[0].Timestamp.Equal(from) || !replayed[len(replayed)-1].Timestamp.Equal(to) {
+		t.Fatalf("replayed %d events, want 2100 from %v to %v", len(replayed), from, to)
+	}
+	if original, _ := store.Load(); len(original) != 2500 {
+		t.Fatalf("primary store changed: %d events", len(original))
+	}
+}
+
+func TestSetJSONStore_HotSwapDuringWrites(t *testing.T) {
+	dir := t.TempDir()
+	defer SetJSONStore(nil)
+
+	const writers, perWriter, swaps = 8, 500, 5
+	stores := make([]*JSONStore, 0, swaps)
+	newStore := func(i int) *JSONStore {
+		store := NewJSONStore(filepath.Join(dir, fmt.Sprintf("usage-%d.json", i)))
+		stores = append(stores, store)
+		return store
+	}
+	SetJSONStore(newStore(0))
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				if err := GetJSONStore().Write(UsageEvent{Timestamp: time.Now(), Model: "m", TotalTokens: 1}); err != nil {
+					t.Errorf("write: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 1; i < swaps; i++ {
+		time.Sleep(time.Millisecond)
+		SetJSONStore(newStore(i))
+	}
+	wg.Wait()
+	SetJSONStore(nil)
+
+	total := 0
+	for _, store := range stores {
+		if n := store.Len(); n != 0 {
+			t.Fatalf("replaced store still buffers %d events", n)
+		}
+		events, err := store.Load()
+		if err != nil {
+			t.Fatalf("load: %v", err)
+		}
+		total += len(events)
+	}
+	if total != writers*perWriter {
+		t.Fatalf("persisted %d events across swaps, want %d", total, writers*perWriter)
+	}
+}
+
+func TestCurrentExpvarCounters_MatchStoreTotals(t *testing.T) {
+	store := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), WithPeriodicFlush(false))
+	SetJSONStore(store)
+	defer SetJSONStore(nil)
+
+	for i := 0; i < 3; i++ {
+		if err := store.Write(UsageEvent{Timestamp: time.Now(), Model: "m", TotalTokens: 10, Status: 200 + i*250}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	counters := CurrentExpvarCounters()
+	totals := store.Totals()
+	if counters.Requests != totals.Requests || counters.Tokens != totals.Tokens || counters.Failed != totals.Failed {
+		t.Fatalf("counters %+v do not match totals %+v", counters, totals)
+	}
+	if counters.Requests != 3 || counters.Tokens != 30 {
+		t.Fatalf("unexpected counters %+v", counters)
+	}
+}
+
+func TestJSONStore_FollowReceivesFlushedEvents(t *testing.T) {
+	store := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), WithPeriodicFlush(false), WithMaxFollowers(1))
+	defer store.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := store.Follow(ctx)
+	if err != nil {
+		t.Fatalf("follow: %v", err)
+	}
+	if _, err := store.Follow(context.Background()); !errors.Is(err, ErrTooManyFollowers) {
+		t.Fatalf("want ErrTooManyFollowers, got %v", err)
+	}
+
+	if err := store.Write(UsageEvent{Timestamp: time.Now(), Model: "m", RequestID: "a"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("got %s before the flush", event.RequestID)
+	default:
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if event := <-events; event.RequestID != "a" {
+		t.Fatalf("want event a, got %s", event.RequestID)
+	}
+
+	cancel()
+	if _, open := <-events; open {
+		t.Fatal("want channel closed after cancel")
+	}
+	if n := store.Followers(); n != 0 {
+		t.Fatalf("want no followers after cancel, got %d", n)
+	}
+}
+
+func TestJSONStore_ResetTotalsKeepsDataAndSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	store := NewJSONStore(path, WithPeriodicFlush(false))
+	base := time.Now().Add(-time.Hour)
+	for i := 0; i < 3; i++ {
+		if err := store.Write(UsageEvent{Timestamp: base, Model: "m", TotalTokens: 10}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	since, err := store.ResetTotals()
+	if err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if totals := store.Totals(); totals.Requests != 0 || !totals.Since.Equal(since) {
+		t.Fatalf("want zeroed totals since %v, got %+v", since, totals)
+	}
+	if err := store.Write(UsageEvent{Timestamp: time.Now(), Model: "m", TotalTokens: 5}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	reopened := NewJSONStore(path, WithPeriodicFlush(false))
+	defer reopened.Close()
+	if err := reopened.RebuildTotals(); err != nil {
+		t.Fatalf("rebuild: %v", err)
+	}
+	if totals := reopened.Totals(); totals.Requests != 1 || totals.Tokens != 5 || !totals.Since.Equal(since) {
+		t.Fatalf("want 1 request since the reset after restart, got %+v", totals)
+	}
+	if events, err := reopened.Load(); err != nil || len(events) != 4 {
+		t.Fatalf("want all 4 events kept on disk, got %d (%v)", len(events), err)
+	}
+}
+
+func TestJSONStore_SpanSurvivesRestartAndResetsOnRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	base := time.Date(2025, 11, 25, 0, 0, 0, 0, time.UTC)
+	checkSpan := func(store *JSONStore, events int64, latest time.Time) {
+		t.Helper()
+		span, err := store.Span()
+		if err != nil || span.Events != events || !span.Earliest.Equal(base) || !span.Latest.Equal(latest) {
+			t.Fatalf("want %d events from %v to %v, got %+v (%v)", events, base, latest, span, err)
+		}
+	}
+
+	store := NewJSONStore(path, WithPeriodicFlush(false))
+	for _, offset := range []time.Duration{2 * time.Hour, 0, time.Hour} {
+		if err := store.Write(UsageEvent{Timestamp: base.Add(offset), Model: "m"}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	checkSpan(store, 3, base.Add(2*time.Hour)) // still buffered
+	if err := store.RebuildTotals(); err != nil {
+		t.Fatalf("rebuild: %v", err)
+	}
+	_ = store.Close()
+
+	// Startup continues from the checkpoint
+	reopened := NewJSONStore(path, WithPeriodicFlush(false))
+	if err := reopened.RebuildTotals(); err != nil {
+		t.Fatalf("rebuild: %v", err)
+	}
+	checkSpan(reopened, 3, base.Add(2*time.Hour))
+	_ = reopened.Write(UsageEvent{Timestamp: base.Add(3 * time.Hour), Model: "m"})
+	_ = reopened.Close()
+
+	// A checkpoint written before spans were tracked makes startup rescan
+	var cp map[string]any
+	data, _ := os.ReadFile(path + ".totals")
+	if err := json.Unmarshal(data, &cp); err != nil || cp["span"] == nil {
+		t.Fatalf("want the span checkpointed, got %s (%v)", data, err)
+	}
+	delete(cp, "span")
+	data, _ = json.Marshal(cp)
+	_ = os.WriteFile(path+".totals", data, 0o600)
+	legacy := NewJSONStore(path, WithPeriodicFlush(false))
+	if err := legacy.RebuildTotals(); err != nil {
+		t.Fatalf("rebuild: %v", err)
+	}
+	checkSpan(legacy, 4, base.Add(3*time.Hour))
+	if totals := legacy.Totals(); totals.Requests != 4 {
+		t.Fatalf("want totals counted once, got %+v", totals)
+	}
+	_ = legacy.Close()
+
+	rotating := NewJSONStore(path, WithPeriodicFlush(false), WithRotation(RotationPolicy{MaxBytes: 1}))
+	defer rotating.Close()
+	_ = rotating.RebuildTotals()
+	_ = rotating.Write(UsageEvent{Timestamp: base.Add(4 * time.Hour), Model: "m"})
+	if err := rotating.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if span, _ := rotating.Span(); span.Events != 0 || !span.Earliest.IsZero() {
+		t.Fatalf("want an empty span after rotation, got %+v", span)
+	}
+}
+
+func TestJSONStore_BinaryFormatRoundTripsAndPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	store := NewJSONStore(path, WithPeriodicFlush(false), WithBinaryFormat(true), WithSampling(0.999999))
+	defer store.Close()
+
+	base := time.Date(2025, 11, 25, 12, 0, 0, 123456789, time.UTC)
+	var written []UsageEvent
+	for i := 0; i < 120; i++ {
+		event := UsageEvent{
+			Timestamp: base.Add(time.Duration(i) * time.Minute), Model: fmt.Sprintf("model-%d", i%3), Provider: "openai",
+			PromptTokens: int64(i), CompletionTokens: 2, TotalTokens: int64(i) + 2, Status: 200, RequestID: fmt.Sprintf("req-%d", i),
+			APIKeyHash: "abc", LatencyMs: 250, CachedTokens: 1, UpstreamRequestID: "up", Suspicious: i == 7,
+			Labels: map[string]string{"region": "eu"},
+		}
+		written = append(written, event)
+		// Bypass sampling so every event is kept
+		store.mu.Lock()
+		store.buffer = append(store.buffer, event)
+		store.mu.Unlock()
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil || !strings.HasPrefix(string(raw), string(binaryMagic)) {
+		t.Fatalf("want a binary file, got %q (%v)", raw[:min(len(raw), 16)], err)
+	}
+	if rate := store.SampleRate(); rate != 0.999999 {
+		t.Fatalf("want the header rate read past the magic, got %v", rate)
+	}
+	events, err := store.Load()
+	if err != nil || !reflect.DeepEqual(events, written) {
+		t.Fatalf("want the written events back (%v), got %d", err, len(events))
+	}
+
+	// Tail and paging cursors are record boundaries
+	page, err := store.ReadFrom(0, 50)
+	if err != nil || len(page.Events) != 50 || page.Reset {
+		t.Fatalf("read from start: %d events, reset %v (%v)", len(page.Events), page.Reset, err)
+	}
+	rest, err := store.ReadFrom(page.Offset, 0)
+	if err != nil || len(rest.Events) != 70 || rest.Events[0].RequestID != "req-50" {
+		t.Fatalf("resume: %d events (%v)", len(rest.Events), err)
+	}
+	if reset, _ := store.ReadFrom(page.Offset+1, 1); !reset.Reset {
+		t.Fatal("want a reset for an offset inside a record")
+	}
+	backward, err := store.EventsBefore(-1, 10, nil)
+	if err != nil || len(backward) != 10 || backward[0].Event.RequestID != "req-119" {
+		t.Fatalf("events before: %d (%v)", len(backward), err)
+	}
+	forward, err := store.EventsAfter(backward[9].Offset, 10, nil)
+	if err != nil || len(forward) != 10 || forward[9].End != backward[0].End {
+		t.Fatalf("events after: %d (%v)", len(forward), err)
+	}
+	if _, err := store.EventsBefore(backward[0].Offset+1, 1, nil); !errors.Is(err, ErrInvalidOffset) {
+		t.Fatalf("want ErrInvalidOffset, got %v", err)
+	}
+
+	// A partial trailing record is left for later, like a partial line
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	record := appendBinaryRecord(nil, written[0])
+	_, _ = f.Write(record[:len(record)/2])
+	_ = f.Close()
+	if events, err := store.Load(); err != nil || len(events) != 120 {
+		t.Fatalf("want the partial record skipped, got %d (%v)", len(events), err)
+	}
+}
+
+func TestJSONStore_FormatFollowsExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	event := UsageEvent{Timestamp: time.Now(), Model: "m", TotalTokens: 1}
+
+	jsonStore := NewJSONStore(path, WithPeriodicFlush(false))
+	if err := jsonStore.Write(event); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = jsonStore.Close()
+
+	// A binary store keeps appending JSON lines to an existing JSON file
+	binaryStore := NewJSONStore(path, WithPeriodicFlush(false), WithBinaryFormat(true))
+	if err := binaryStore.Write(event); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = binaryStore.Close()
+	raw, _ := os.ReadFile(path)
+	if bytes.HasPrefix(raw, binaryMagic) || strings.Count(string(raw), "\n") != 3 {
+		t.Fatalf("want 3 JSON lines, got %q", raw)
+	}
+
+	// And a JSON store reads a binary file
+	binaryPath := filepath.Join(t.TempDir(), "usage.bin")
+	binaryStore = NewJSONStore(binaryPath, WithPeriodicFlush(false), WithBinaryFormat(true))
+	_ = binaryStore.Write(event)
+	_ = binaryStore.Close()
+	reader := NewJSONStore(binaryPath, WithPeriodicFlush(false))
+	defer reader.Close()
+	if err := reader.Write(event); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := reader.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if events, err := reader.Load(); err != nil || len(events) != 2 {
+		t.Fatalf("want 2 events from the binary file, got %d (%v)", len(events), err)
+	}
+	if report, err := reader.Validate(); err != nil || report.Events != 2 || report.Corrupt != 0 {
+		t.Fatalf("unexpected validation %+v (%v)", report, err)
+	}
+}
+
+func TestJSONStore_LoadSkipsBOMAndComments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	lines := "\xef\xbb\xbf{\"_schema\":0}\n" +
+		"# exported by hand\n" +
+		"  # indented note\n" +
+		`{"timestamp":"2025-11-25T10:00:00Z","model":"a","prompt_tokens":1,"completion_tokens":2}` + "\n"
+	if err := os.WriteFile(path, []byte(lines), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	store := NewJSONStore(path, WithPeriodicFlush(false))
+	defer store.Close()
+	if version := store.SchemaVersion(); version != SchemaLegacy {
+		t.Fatalf("want the schema line read past the BOM, got version %d", version)
+	}
+	events, err := store.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	// Version 0 derives total_tokens, so the schema line was honoured
+	if len(events) != 1 || events[0].Model != "a" || events[0].TotalTokens != 3 {
+		t.Fatalf("want one legacy event, got %+v", events)
+	}
+
+	// A BOM directly before the first event
+	if err := os.WriteFile(path, []byte("\xef\xbb\xbf"+`{"timestamp":"2025-11-25T10:00:00Z","model":"b","total_tokens":1}`+"\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if events, err = store.Load(); err != nil || len(events) != 1 || events[0].Model != "b" {
+		t.Fatalf("want the event after the BOM, got %+v, %v", events, err)
+	}
+}
+
+func TestJSONStore_LoadAcceptsLegacyTimestamp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	lines := `{"timestamp":"2025-11-25T10:00:00Z","model":"rfc3339","total_tokens":1}
+{"ts":1764064800123,"model":"millis","total_tokens":2}
+{"ts":1764064800,"model":"seconds","total_tokens":3}
+{"timestamp":"2025-11-25T10:00:00Z","ts":1,"model":"both","total_tokens":4}
+`
+	if err := os.WriteFile(path, []byte(lines), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	store := NewJSONStore(path, WithPeriodicFlush(false))
+	defer store.Close()
+	events, err := store.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	want := map[string]time.Time{
+		"rfc3339": time.Date(2025, 11, 25, 10, 0, 0, 0, time.UTC),
+		"millis":  time.Date(2025, 11, 25, 10, 0, 0, 123e6, time.UTC),
+		"seconds": time.Date(2025, 11, 25, 10, 0, 0, 0, time.UTC),
+		"both":    time.Date(2025, 11, 25, 10, 0, 0, 0, time.UTC),
+	}
+	if len(events) != len(want) {
+		t.Fatalf("want %d events, got %d", len(want), len(events))
+	}
+	for _, event := range events {
+		if !event.Timestamp.Equal(want[event.Model]) {
+			t.Errorf("%s: want %v, got %v", event.Model, want[event.Model], event.Timestamp)
+		}
+	}
+
+	var event UsageEvent
+	if err := json.Unmarshal([]byte(`{"ts":"soon","model":"m"}`), &event); err == nil {
+		t.Fatal("want an error for a non-numeric ts")
+	}
+}
+
+func BenchmarkJSONStore_Flush(b *testing.B) {
+	for _, policy := range []struct {
+		name   string
+		everyN int
+	}{{"sync-always", 0}, {"sync-every-100", 100}} {
+		b.Run(policy.name, func(b *testing.B) {
+			store := NewJSONStore(filepath.Join(b.TempDir(), "usage.json"), WithPeriodicFlush(false), WithSyncPolicy(policy.everyN, 0))
+			defer store.Close()
+			event := UsageEvent{Timestamp: time.Now(), Model: "gpt-4o", TotalTokens: 100, Status: 200}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = store.Write(event)
+				if err := store.Flush(); err != nil {
+					b.Fatalf("flush: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkJSONStore_Load(b *testing.B) {
+	base := time.Date(2025, 11, 25, 0, 0, 0, 0, time.UTC)
+	models := []string{"gpt-4o", "claude-sonnet", "gemini-pro"}
+	for _, format := range []string{"jsonl", "binary"} {
+		path := filepath.Join(b.TempDir(), "usage.json")
+		store := NewJSONStore(path, WithPeriodicFlush(false), WithBinaryFormat(format == "binary"))
+		for i := 0; i < 100_000; i++ {
+			_ = store.Write(UsageEvent{
+				Timestamp: base.Add(time.Duration(i) * time.Second), Model: models[i%len(models)], Provider: "openai",
+				PromptTokens: int64(i % 4000), CompletionTokens: int64(i % 500), TotalTokens: int64(i%4000 + i%500),
+				Status: 200, APIKeyHash: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", LatencyMs: int64(i % 3000),
+			})
+		}
+		_ = store.Close()
+		info, err := os.Stat(path)
+		if err != nil {
+			b.Fatalf("stat: %v", err)
+		}
+
+		b.Run("format="+format, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if events, err := store.Load(); err != nil || len(events) != 100_000 {
+					b.Fatalf("load: %d events (%v)", len(events), err)
+				}
+			}
+			b.ReportMetric(float64(info.Size())/100_000, "file-bytes/event")
+		})
+	}
+}
+
+func TestJSONStore_MarkersKeptOutOfTotalsAndRollups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	store := NewJSONStore(path, WithPeriodicFlush(false), WithBinaryFormat(true))
+	defer store.Close()
+
+	day := time.Now().UTC().Add(-72 * time.Hour).Truncate(24 * time.Hour)
+	written := []UsageEvent{
+		{Timestamp: day.Add(time.Hour), Model: "gpt-4o", TotalTokens: 100, Status: 200},
+		{Timestamp: day.Add(2 * time.Hour), Kind: EventKindPing, Status: 200},
+		{Timestamp: day.Add(3 * time.Hour), Kind: EventKindPing, Status: 200, Labels: map[string]string{"region": "eu"}},
+	}
+	for _, event := range written {
+		if err := store.Write(event); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	events, err := store.Load()
+	if err != nil || !reflect.DeepEqual(events, written) {
+		t.Fatalf("want the kinds read back from the binary file (%v), got %+v", err, events)
+	}
+	if totals := store.Totals(); totals.Requests != 1 || totals.Tokens != 100 {
+		t.Fatalf("want markers left out of the running totals, got %+v", totals)
+	}
+	if err := store.GenerateRollups(); err != nil {
+		t.Fatalf("generate rollups: %v", err)
+	}
+	set, ok := store.Rollups()
+	if !ok || len(set.Daily) != 1 {
+		t.Fatalf("want one daily rollup, got %+v", set.Daily)
+	}
+	if rollup := set.Daily[0]; rollup.Requests != 1 || len(rollup.ByModel) != 1 || rollup.Markers[EventKindPing] != 2 {
+		t.Fatalf("want markers counted apart from requests, got %+v", rollup)
+	}
+}
+
+func TestJSONStore_EventTimesRoundTrip(t *testing.T) {
+	started := time.Date(2025, 11, 25, 10, 59, 30, 0, time.UTC)
+	completed := started.Add(90 * time.Second)
+	for _, binaryFormat := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "usage.json")
+		store := NewJSONStore(path, WithPeriodicFlush(false), WithBinaryFormat(binaryFormat))
+		// StartedAt alone is moved to Timestamp; a repeat of it is dropped
+		for _, event := range []UsageEvent{
+			{StartedAt: started, CompletedAt: completed, Model: "a"},
+			{Timestamp: started, StartedAt: started, Model: "b"},
+		} {
+			if err := store.Write(event); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+		}
+		if err := store.Flush(); err != nil {
+			t.Fatalf("flush: %v", err)
+		}
+		events, err := store.Load()
+		_ = store.Close()
+		if err != nil || len(events) != 2 {
+			t.Fatalf("binary=%v: want 2 events (%v), got %d", binaryFormat, err, len(events))
+		}
+		for _, event := range events {
+			if !event.Timestamp.Equal(started) || !event.StartedAt.IsZero() || !event.StartTime().Equal(started) {
+				t.Fatalf("binary=%v: want the start time in Timestamp only, got %+v", binaryFormat, event)
+			}
+		}
+		if !events[0].CompletionTime().Equal(completed) || !events[1].CompletedAt.IsZero() {
+			t.Fatalf("binary=%v: want the completion time kept, got %+v", binaryFormat, events)
+		}
+	}
+
+	legacy := UsageEvent{Timestamp: started, LatencyMs: 2500}
+	if got := legacy.CompletionTime(); !got.Equal(started.Add(2500 * time.Millisecond)) {
+		t.Fatalf("want legacy events completed after their latency, got %v", got)
+	}
+}
+
+func TestJSONStore_LoadAllIncludesBuffer(t *testing.T) {
+	store := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), WithPeriodicFlush(false))
+	defer store.Close()
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 3 {
+		if err := store.Write(UsageEvent{Timestamp: base.Add(time.Duration(i) * time.Minute), Model: "m", RequestID: fmt.Sprint(i)}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	for i := 3; i < 5; i++ {
+		if err := store.Write(UsageEvent{Timestamp: base.Add(time.Duration(i) * time.Minute), Model: "m", RequestID: fmt.Sprint(i)}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	if events, err := store.Load(); err != nil || len(events) != 3 {
+		t.Fatalf("Load should return the 3 flushed events only, got %d (err %v)", len(events), err)
+	}
+	events, err := store.LoadAll()
+	if err != nil {
+		t.Fatalf("load all: %v", err)
+	}
+	if len(events) != 5 {
+		t.Fatalf("LoadAll should return 5 events, got %d", len(events))
+	}
+	for i, event := range events {
+		if event.RequestID != fmt.Sprint(i) {
+			t.Fatalf("event %d: want request %d, got %s", i, i, event.RequestID)
+		}
+	}
+
+	// Once flushed, the buffered events are returned once
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if events, err := store.LoadAll(); err != nil || len(events) != 5 {
+		t.Fatalf("LoadAll after flush should return 5 events, got %d (err %v)", len(events), err)
+	}
+}
+
+func TestJSONStore_LoadDuringConcurrentWrites(t *testing.T) {
+	for _, binaryFormat := range []bool{false, true} {
+		store := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), WithPeriodicFlush(false), WithBinaryFormat(binaryFormat))
+
+		const writers, perWriter = 4, 500
+		var wg sync.WaitGroup
+		for w := range writers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range perWriter {
+					event := UsageEvent{Timestamp: time.Now(), Model: "m", TotalTokens: 1, RequestID: fmt.Sprintf("%d-%d", w, i)}
+					if err := store.Write(event); err != nil {
+						t.Errorf("write: %v", err)
+						return
+					}
+				}
+			}()
+		}
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+
+		// Every snapshot holds each event once and never fewer than the last
+		previous := 0
+		for loading := true; loading; {
+			select {
+			case <-done:
+				loading = false
+			default:
+			}
+			events, err := store.LoadAll()
+			if err != nil {
+				t.Fatalf("binary=%v: load: %v", binaryFormat, err)
+			}
+			seen := make(map[string]bool, len(events))
+			for _, event := range events {
+				if seen[event.RequestID] {
+					t.Fatalf("binary=%v: event %s loaded twice", binaryFormat, event.RequestID)
+				}
+				seen[event.RequestID] = true
+			}
+			if len(events) < previous {
+				t.Fatalf("binary=%v: load went back from %d to %d events", binaryFormat, previous, len(events))
+			}
+			previous = len(events)
+		}
+		if previous != writers*perWriter {
+			t.Fatalf("binary=%v: want all %d events after the writers finished, got %d", binaryFormat, writers*perWriter, previous)
+		}
+
+		// A slow reader does not hold the lock: writes and flushes go on
+		reading := make(chan struct{})
+		release := make(chan struct{})
+		go func() {
+			_ = store.Iterate(func(UsageEvent) bool {
+				close(reading)
+				<-release
+				return false
+			})
+		}()
+		<-reading
+		flushed := make(chan error, 1)
+		go func() {
+			if err := store.Write(UsageEvent{Timestamp: time.Now(), Model: "m"}); err != nil {
+				flushed <- err
+				return
+			}
+			flushed <- store.Flush()
+		}()
+		select {
+		case err := <-flushed:
+			if err != nil {
+				t.Fatalf("binary=%v: write during a read: %v", binaryFormat, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("binary=%v: write blocked by a running Iterate", binaryFormat)
+		}
+		close(release)
+		_ = store.Close()
+	}
+}
+
+// iteratedEvents returns every event of the store's segments and live file.
+func iteratedEvents(t *testing.T, store *JSONStore) []UsageEvent {
+	t.Helper()
+	events := []UsageEvent{}
+	err := store.Iterate(func(event UsageEvent) bool {
+		events = append(events, event)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("iterate: %v", err)
+	}
+	return events
+}
+
+func TestJSONStore_Import(t *testing.T) {
+	store := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), WithPeriodicFlush(false))
+	defer store.Close()
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := store.Write(UsageEvent{Timestamp: base, Model: "m", RequestID: "existing", TotalTokens: 5}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	body := strings.Join([]string{
+		`{"_schema":1}`,
+		`{"timestamp":"2024-01-01T00:00:00Z","model":"m","request_id":"existing","total_tokens":5}`,
+		`{"timestamp":"2024-01-01T00:01:00Z","model":"m","request_id":"a","total_tokens":7}`,
+		``,
+		`{"timestamp":"2024-01-01T00:01:00Z","model":"m","request_id":"a","total_tokens":7}`,
+		`not json`,
+		`{"model":"m","total_tokens":1}`,
+		`{"timestamp":"2024-01-01T00:02:00Z","model":"m","total_tokens":-1}`,
+		`{"ts":1704067380000,"model":"m","request_id":"b","prompt_tokens":2,"completion_tokens":3,"total_tokens":5}`,
+	}, "\n")
+	result, err := store.Import(strings.NewReader(body), true)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if result.Imported != 2 || result.Skipped != 3 || result.Deduped != 2 {
+		t.Fatalf("want 2 imported, 3 skipped and 2 deduped, got %+v", result)
+	}
+	if len(result.Errors) != 3 || result.Errors[0].Line != 6 || result.Errors[2].Line != 8 {
+		t.Fatalf("unexpected errors %+v", result.Errors)
+	}
+
+	events := iteratedEvents(t, store)
+	if len(events) != 3 || events[0].RequestID != "a" || events[1].RequestID != "b" || events[1].TotalTokens != 5 || events[2].RequestID != "existing" {
+		t.Fatalf("want 2 imported events in a segment and the existing one on disk, got %+v", events)
+	}
+
+	// Without dedupe every valid event is written again
+	result, err = store.Import(strings.NewReader(body), false)
+	if err != nil || result.Imported != 4 || result.Deduped != 0 {
+		t.Fatalf("want 4 imported without dedupe, got %+v (err %v)", result, err)
+	}
+
+	if _, err := NewReadOnlyStore(store.path).Import(strings.NewReader(body), false); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("want ErrReadOnly from a read-only store, got %v", err)
+	}
+}
+
+func TestJSONStore_ImportBypassesSamplingAndEnrichment(t *testing.T) {
+	store := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"),
+		WithPeriodicFlush(false),
+		WithSampling(0.01),
+		WithEnricher(StaticLabels(map[string]string{"region": "eu"})),
+	)
+	defer store.Close()
+
+	const total = 100
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var body strings.Builder
+	for i := 0; i < total; i++ {
+		fmt.Fprintf(&body, `{"timestamp":%q,"model":"m","request_id":"req-%d","total_tokens":3}`+"\n", base.Add(time.Duration(i)*time.Minute).Format(time.RFC3339), i)
+	}
+	result, err := store.Import(strings.NewReader(body.String()), true)
+	if err != nil || result.Imported != total {
+		t.Fatalf("want all %d events imported, got %+v (err %v)", total, result, err)
+	}
+	if totals := store.Totals(); totals.Requests != total || totals.Tokens != 3*total {
+		t.Fatalf("want every imported event in the totals, got %+v", totals)
+	}
+	if recent := store.Recent(10); len(recent) != 0 {
+		t.Fatalf("want imported history kept out of the recent events, got %d", len(recent))
+	}
+
+	events, err := store.LoadRange(base, base.Add(total*time.Minute))
+	if err != nil || len(events) != total {
+		t.Fatalf("want every imported event stored despite sampling, got %d (err %v)", len(events), err)
+	}
+	for _, event := range events {
+		if event.Labels != nil {
+			t.Fatalf("want imported events stored as they are, got labels %v", event.Labels)
+		}
+	}
+
+	result, err = store.Import(strings.NewReader(body.String()), true)
+	if err != nil || result.Imported != 0 || result.Deduped != total {
+		t.Fatalf("want a repeated import fully deduped, got %+v (err %v)", result, err)
+	}
+}
+
+func TestJSONStore_ImportedHistoryIsFoundByRange(t *testing.T) {
+	store := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), WithPeriodicFlush(false))
+	defer store.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i := 0; i < 3; i++ {
+		if err := store.Write(UsageEvent{Timestamp: now.Add(time.Duration(i-3) * time.Minute), Model: "live"}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	recent := store.Recent(10)
+
+	// Two days back, out of order, and a run that overlaps the first
+	old := now.Add(-48 * time.Hour)
+	line := func(ts time.Time, id string) string {
+		return fmt.Sprintf(`{"timestamp":%q,"model":"imported","request_id":%q}`, ts.Format(time.RFC3339), id) + "\n"
+	}
+	first := line(old.Add(time.Minute), "b") + line(old, "a") + line(old.Add(30*time.Minute), "c")
+	if _, err := store.Import(strings.NewReader(first), false); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if _, err := store.Import(strings.NewReader(line(old.Add(2*time.Minute), "d")), false); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	events, err := store.LoadRange(old.Add(-time.Hour), old.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("load range: %v", err)
+	}
+	var ids []string
+	for _, event := range events {
+		ids = append(ids, event.RequestID)
+	}
+	// Segments are listed by their newest event, so the second import's
+	// comes first; each import is sorted
+	if !reflect.DeepEqual(ids, []string{"d", "a", "b", "c"}) {
+		t.Fatalf("want the imported events in range, got %v", ids)
+	}
+	if live, err := store.LoadRange(now.Add(-time.Hour), now); err != nil || len(live) != 3 {
+		t.Fatalf("want the live events still in their range, got %d (%v)", len(live), err)
+	}
+	if got := store.Recent(10); !reflect.DeepEqual(got, recent) {
+		t.Fatalf("want the recent events unchanged by the import, got %+v", got)
+	}
+
+	// Import segments are dated by their newest event, and the live file's
+	// generation is unchanged
+	segments, err := store.Segments()
+	if err != nil || len(segments) != 2 {
+		t.Fatalf("want one segment per import, got %v (%v)", segments, err)
+	}
+	for _, segment := range segments {
+		if info, err := os.Stat(segment); err != nil || info.ModTime().After(old.Add(time.Hour)) {
+			t.Fatalf("want %s dated by its events, got %v (%v)", segment, info.ModTime(), err)
+		}
+	}
+	if generation := store.Generation(); generation != "" {
+		t.Fatalf("want imports to leave the generation alone, got %q", generation)
+	}
+
+	// A restart rebuilds the same totals from the checkpoint and from scratch
+	want := store.Totals()
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	reopened := NewJSONStore(store.path, WithPeriodicFlush(false))
+	defer reopened.Close()
+	if err := reopened.RebuildTotals(); err != nil || reopened.Totals().Requests != 7 || reopened.Totals().Requests != want.Requests {
+		t.Fatalf("want 7 requests after a resumed rebuild, got %+v (%v)", reopened.Totals(), err)
+	}
+	if err := os.Remove(reopened.checkpointPath()); err != nil {
+		t.Fatalf("remove checkpoint: %v", err)
+	}
+	if err := reopened.RebuildTotals(); err != nil || reopened.Totals().Requests != 7 {
+		t.Fatalf("want 7 requests after a full rescan, got %+v (%v)", reopened.Totals(), err)
+	}
+}
+
+func TestJSONStore_GzipRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	store := NewJSONStore(filepath.Join(dir, "usage.json"), WithPeriodicFlush(false))
+	defer store.Close()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 3 {
+		event := UsageEvent{Timestamp: base.Add(time.Duration(i) * time.Minute), Model: "m", RequestID: fmt.Sprint(i), TotalTokens: int64(i + 1)}
+		if err := store.Write(event); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	want, err := store.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	// Compress the file under a name without .gz: detection uses the magic
+	raw, err := os.ReadFile(store.path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(raw); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	backup := filepath.Join(dir, "backup")
+	if err := os.WriteFile(backup, compressed.Bytes(), 0o600); err != nil {
+		t.Fatalf("write backup: %v", err)
+	}
+
+	readOnly := NewReadOnlyStore(backup)
+	defer readOnly.Close()
+	got, err := readOnly.Load()
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("want the events back from the gzipped file, got %+v (err %v)", got, err)
+	}
+
+	restored := NewJSONStore(filepath.Join(dir, "restored.json"), WithPeriodicFlush(false))
+	defer restored.Close()
+	result, err := restored.Import(bytes.NewReader(compressed.Bytes()), false)
+	if err != nil || result.Imported != 3 || result.Skipped != 0 {
+		t.Fatalf("want 3 events imported from gzip, got %+v (err %v)", result, err)
+	}
+	if got = iteratedEvents(t, restored); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want the restored events to match, got %+v", got)
+	}
+
+	if _, err := restored.Import(bytes.NewReader(gzipMagic), false); err == nil {
+		t.Fatal("want an error for a truncated gzip stream")
+	}
+}
+
+func TestJSONStore_DrainRemovesRecentEvents(t *testing.T) {
+	store := NewJSONStore(filepath.Join(t.TempDir(), "usage.json"), WithPeriodicFlush(false), WithRecentCapacity(2))
+	defer func() { _ = store.Close() }()
+
+	now := time.Now()
+	write := func(id string) {
+		if err := store.Write(UsageEvent{Timestamp: now, Model: "m", RequestID: id}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	write("a")
+	write("b")
+	if err := store.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	write("c")
+	drained, err := store.Drain()
+	if err != nil || len(drained) != 1 || drained[0].RequestID != "c" {
+		t.Fatalf("want c drained, got %+v %v", drained, err)
+	}
+	if recent := store.Recent(10); len(recent) != 1 || recent[0].RequestID != "b" {
+		t.Fatalf("want only the stored b among the recent events, got %+v", recent)
+	}
+
+	write("d")
+	if recent := store.Recent(10); len(recent) != 2 || recent[0].RequestID != "b" || recent[1].RequestID != "d" {
+		t.Fatalf("want b and d, got %+v", recent)
+	}
+}
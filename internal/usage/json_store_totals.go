@@ -0,0 +1,313 @@
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// ModelTotals holds all-time counters for a single model.
+type ModelTotals struct {
+	Requests int64 `json:"requests"`
+	Tokens   int64 `json:"tokens"`
+	// PromptTokens, CompletionTokens and CachedTokens are only tracked by rollups.
+	PromptTokens     int64 `json:"prompt_tokens,omitempty"`
+	CompletionTokens int64 `json:"completion_tokens,omitempty"`
+	CachedTokens     int64 `json:"cached_tokens,omitempty"`
+}
+
+// RunningTotals are all-time counters maintained incrementally by the store,
+// giving O(1) totals without scanning the file.
+type RunningTotals struct {
+	Requests int64                  `json:"requests"`
+	Tokens   int64                  `json:"tokens"`
+	Failed   int64                  `json:"failed"`
+	ByModel  map[string]ModelTotals `json:"by_model"`
+	// Since is when ResetTotals last zeroed the counters; zero means they
+	// cover all recorded history.
+	Since time.Time `json:"since,omitempty"`
+}
+
+// totalsCheckpoint is the persisted form of RunningTotals. Offset is the size
+// of the store file the totals account for, so startup only needs to scan
+// events appended after it.
+type totalsCheckpoint struct {
+	Totals RunningTotals `json:"totals"`
+	// Span covers the events up to Offset; nil in checkpoints written
+	// before it was tracked, which makes startup rescan the file for it.
+	Span *EventSpan `json:"span,omitempty"`
+	// Segment names the newest rotated segment when the checkpoint was
+	// written, "" when there was none, so startup can tell whether the file
+	// Offset points into has been rotated since. nil in older checkpoints.
+	Segment   *string   `json:"segment,omitempty"`
+	Offset    int64     `json:"offset"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newRunningTotals() RunningTotals {
+	return RunningTotals{ByModel: make(map[string]ModelTotals)}
+}
+
+// add counts an event (or removes it when sign is -1). Markers are skipped.
+func (t *RunningTotals) add(event UsageEvent, sign int64) {
+	if event.IsMarker() {
+		return
+	}
+	t.Requests += sign
+	t.Tokens += sign * event.TotalTokens
+	if event.Status >= httpStatusBadRequest {
+		t.Failed += sign
+	}
+	m := t.ByModel[event.Model]
+	m.Requests += sign
+	m.Tokens += sign * event.TotalTokens
+	if m.Requests <= 0 {
+		delete(t.ByModel, event.Model)
+		return
+	}
+	t.ByModel[event.Model] = m
+}
+
+func (t RunningTotals) clone() RunningTotals {
+	out := t
+	out.ByModel = make(map[string]ModelTotals, len(t.ByModel))
+	for k, v := range t.ByModel {
+		out.ByModel[k] = v
+	}
+	return out
+}
+
+// Totals returns a snapshot of the all-time counters, covering every recorded
+// event on disk and in the buffer. Call RebuildTotals on startup to include
+// history written by earlier runs.
+func (s *JSONStore) Totals() RunningTotals {
+	if s == nil {
+		return newRunningTotals()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.totals.clone()
+}
+
+// RebuildTotals recomputes the running counters and the event span from
+// disk. The checkpoint written at each flush records the counters as of a
+// byte offset into the live file and the newest rotated segment at the time,
+// so startup only replays events appended after it: the rest of the
+// checkpointed file, which may have been rotated into a segment since, and
+// any newer segments and the live file. Without a usable checkpoint every
+// segment and the live file are read once.
+//
+// Returns:
+//   - error: An error if a store file cannot be read
+func (s *JSONStore) RebuildTotals() error {
+	if s == nil {
+		return fmt.Errorf("json store is nil")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.Segments()
+	if err != nil {
+		return err
+	}
+	// Checkpoints place themselves among the rotated segments; the totals
+	// of a checkpoint taken after an import include its events, so import
+	// segments are only read by a full rescan
+	segments := rotatedSegments(slices.Clone(all))
+	imported := len(all) - len(segments)
+	s.latestSegment, s.latestSegmentKnown = "", true
+	if len(segments) > 0 {
+		s.latestSegment = filepath.Base(segments[len(segments)-1])
+	}
+	files := append(slices.DeleteFunc(all, func(path string) bool { return !isImportSegment(path) }), segments...)
+	files = append(files, s.path)
+	liveIndex := len(files) - 1
+
+	totals := newRunningTotals()
+	var since time.Time
+	var first int
+	var offset int64
+	cp, resumed := s.readCheckpointLocked()
+	if resumed {
+		since = cp.Totals.Since
+		if first, resumed = checkpointedFile(cp, segments); resumed {
+			first += imported
+			totals, offset = cp.Totals, cp.Offset
+			if totals.ByModel == nil {
+				totals.ByModel = make(map[string]ModelTotals)
+			}
+		}
+	}
+	totals.Since = since
+
+	var live TailResult
+	for i := first; i < len(files); i++ {
+		page, err := s.readFileFromLocked(files[i], offset, 0)
+		if err != nil {
+			return err
+		}
+		offset = 0
+		if page.Reset && resumed {
+			// The checkpointed file no longer matches, rescan every file after the baseline
+			totals, resumed, first = newRunningTotals(), false, 0
+			totals.Since = since
+			i = -1
+			continue
+		}
+		for _, event := range page.Events {
+			if !resumed && event.Timestamp.Before(since) {
+				continue
+			}
+			totals.add(event, 1)
+		}
+		live = page
+	}
+
+	// The span covers the live file only; it continues the checkpoint's when
+	// the live file was resumed from it
+	var span EventSpan
+	if resumed && first == liveIndex {
+		if cp.Span == nil {
+			// The checkpoint predates span tracking; read the file once more for it
+			full, err := s.readFromLocked(0, 0)
+			if err != nil {
+				return err
+			}
+			live.Events = full.Events
+		} else {
+			span = *cp.Span
+		}
+	}
+	span.add(live.Events...)
+	s.span = span
+	// Keep anything already buffered by this process
+	for _, event := range s.buffer {
+		totals.add(event, 1)
+	}
+	s.totals = totals
+	s.totalsRebuilt = true
+
+	if len(s.buffer) == 0 {
+		s.saveCheckpointLocked(live.Offset)
+	}
+	return nil
+}
+
+// checkpointedFile returns the index in segments, or len(segments) for the
+// live file, of the file the checkpoint's offset points into: the live file
+// unless it has been rotated since. It returns false when that file can no
+// longer be identified, e.g. because its segment was deleted.
+func checkpointedFile(cp totalsCheckpoint, segments []string) (int, bool) {
+	if cp.Segment == nil {
+		// Written before segments were recorded; assume no rotation since
+		return len(segments), true
+	}
+	if *cp.Segment == "" {
+		// No segment existed, so the checkpointed file is the oldest one
+		return 0, true
+	}
+	for i, segment := range segments {
+		if filepath.Base(segment) == *cp.Segment {
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// ResetTotals zeroes the running counters and records now as their baseline,
+// without touching the store file or any other sidecar. Buffered events are
+// flushed first so they fall before the baseline. The reset is checkpointed
+// and survives restarts.
+//
+// Returns:
+//   - time.Time: The new baseline
+//   - error: An error if buffered events cannot be flushed
+func (s *JSONStore) ResetTotals() (time.Time, error) {
+	if s == nil {
+		return time.Time{}, fmt.Errorf("json store is nil")
+	}
+	if s.readOnly {
+		return time.Time{}, ErrReadOnly
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.flushLocked(); err != nil {
+		return time.Time{}, err
+	}
+	var size int64
+	if info, err := os.Stat(s.path); err == nil {
+		size = info.Size()
+	}
+	s.totals = newRunningTotals()
+	s.totals.Since = s.now().UTC()
+	s.totalsRebuilt = true
+	s.saveCheckpointLocked(size)
+	return s.totals.Since, nil
+}
+
+func (s *JSONStore) checkpointPath() string {
+	return s.path + ".totals"
+}
+
+// readCheckpointLocked loads the persisted totals checkpoint, if any.
+// Must be called with s.mu held.
+func (s *JSONStore) readCheckpointLocked() (totalsCheckpoint, bool) {
+	var cp totalsCheckpoint
+	data, err := os.ReadFile(s.checkpointPath())
+	if err != nil {
+		return cp, false
+	}
+	if err := json.Unmarshal(data, &cp); err != nil || cp.Offset < 0 {
+		return cp, false
+	}
+	return cp, true
+}
+
+// saveCheckpointLocked persists the current totals for the given file size.
+// Nothing is written before the store file exists, so a store that never
+// receives events leaves no files behind. Failures are logged and otherwise
+// ignored; the next startup then rescans.
+// Must be called with s.mu held and an empty buffer.
+func (s *JSONStore) saveCheckpointLocked(offset int64) {
+	if s.readOnly || !s.fileExistsLocked() {
+		return
+	}
+	span := s.span
+	segment := s.latestSegmentLocked()
+	data, err := json.Marshal(totalsCheckpoint{Totals: s.totals, Span: &span, Segment: &segment, Offset: offset, UpdatedAt: time.Now()})
+	if err != nil {
+		return
+	}
+	tmp := s.checkpointPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to write usage totals checkpoint: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmp, s.checkpointPath()); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to write usage totals checkpoint: %v\n", err)
+	}
+}
+
+// latestSegmentLocked returns the base name of the newest rotated segment, or
+// "" when there is none, listing the segments only the first time.
+// Must be called with s.mu held.
+func (s *JSONStore) latestSegmentLocked() string {
+	if !s.latestSegmentKnown {
+		s.latestSegment = ""
+		if segments, err := s.Segments(); err == nil {
+			if segments = rotatedSegments(segments); len(segments) > 0 {
+				s.latestSegment = filepath.Base(segments[len(segments)-1])
+			}
+		}
+		s.latestSegmentKnown = true
+	}
+	return s.latestSegment
+}
@@ -0,0 +1,103 @@
+package usage
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxReportedCorruptLines caps how many corrupt lines a ValidationReport lists.
+const maxReportedCorruptLines = 100
+
+// CorruptLine describes a store line that could not be parsed.
+type CorruptLine struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ValidationReport summarises the integrity of a store file.
+type ValidationReport struct {
+	Path       string `json:"path"`
+	Exists     bool   `json:"exists"`
+	SizeBytes  int64  `json:"size_bytes"`
+	TotalLines int    `json:"total_lines"`
+	Events     int    `json:"events"`
+	// SchemaVersion is the version declared by the schema line, 0 for legacy files.
+	SchemaVersion SchemaVersion `json:"schema_version"`
+	// Skipped counts empty lines, the schema line and the store header.
+	Skipped int `json:"skipped"`
+	// Corrupt counts lines that failed to parse; CorruptLines lists the first
+	// maxReportedCorruptLines of them.
+	Corrupt      int           `json:"corrupt"`
+	CorruptLines []CorruptLine `json:"corrupt_lines"`
+	Earliest     *time.Time    `json:"earliest,omitempty"`
+	Latest       *time.Time    `json:"latest,omitempty"`
+	// Monotonic is true when every event's timestamp is at or after the previous one.
+	Monotonic  bool `json:"monotonic"`
+	OutOfOrder int  `json:"out_of_order"`
+}
+
+// Validate scans the store file and reports line counts, corrupt lines,
+// the covered time span and whether timestamps are in order. It reads the
+// same way Load does but collects diagnostics instead of events: the lock is
+// only held to snapshot the file size, so the periodic self-check never
+// stalls Write for a whole read, and lines flushed meanwhile are not checked.
+//
+// Returns:
+//   - ValidationReport: The diagnostics for the store file
+//   - error: An error if the file cannot be read
+func (s *JSONStore) Validate() (ValidationReport, error) {
+	if s == nil {
+		return ValidationReport{}, fmt.Errorf("json store is nil")
+	}
+
+	report := ValidationReport{Path: s.path, Monotonic: true, CorruptLines: []CorruptLine{}}
+	s.mu.Lock()
+	snapshot, err := s.openSnapshotLocked()
+	s.mu.Unlock()
+	if err != nil {
+		return report, err
+	}
+	if snapshot.file == nil {
+		return report, nil
+	}
+	defer snapshot.close()
+	report.Exists = true
+	report.SizeBytes = snapshot.size
+	report.SchemaVersion = readSchemaVersion(s.path)
+
+	var earliest, latest, previous time.Time
+	lines, err := s.scanSnapshotLines(snapshot, func(lineNum int, event UsageEvent, errParse error) bool {
+		if errParse != nil {
+			report.Corrupt++
+			if len(report.CorruptLines) < maxReportedCorruptLines {
+				report.CorruptLines = append(report.CorruptLines, CorruptLine{Line: lineNum, Error: errParse.Error()})
+			}
+			return true
+		}
+		report.Events++
+		ts := event.Timestamp
+		if earliest.IsZero() || ts.Before(earliest) {
+			earliest = ts
+		}
+		if ts.After(latest) {
+			latest = ts
+		}
+		if !previous.IsZero() && ts.Before(previous) {
+			report.Monotonic = false
+			report.OutOfOrder++
+		}
+		previous = ts
+		return true
+	})
+	report.TotalLines = lines
+	report.Skipped = lines - report.Events - report.Corrupt
+	if report.Events > 0 {
+		report.Earliest = &earliest
+		report.Latest = &latest
+	}
+	if err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
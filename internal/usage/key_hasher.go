@@ -0,0 +1,75 @@
+package usage
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// KeyHasher maps a raw API key to the value stored in UsageEvent.APIKeyHash.
+type KeyHasher func(apiKey string) string
+
+// WithKeyHasher replaces the default SHA-256 hex hash applied to API keys
+// before they are recorded. Filtering by api_key_hash compares stored values
+// verbatim, so a file written with more than one hasher cannot be filtered
+// consistently across the change.
+func WithKeyHasher(hasher KeyHasher) StoreOption {
+	return func(s *JSONStore) {
+		s.keyHasher = hasher
+	}
+}
+
+// HashKey hashes an API key with the store's hasher, falling back to SHA-256
+// hex when none is set or the store is nil. Empty keys stay empty.
+func (s *JSONStore) HashKey(apiKey string) string {
+	if apiKey == "" {
+		return ""
+	}
+	if s == nil || s.keyHasher == nil {
+		return hashString(apiKey)
+	}
+	return s.keyHasher(apiKey)
+}
+
+// NewKeyHasher builds a hasher from a config name ("sha256" or "sha512", empty
+// meaning sha256) that keeps only the first length hex characters when length > 0.
+//
+// Returns:
+//   - KeyHasher: The configured hasher
+//   - error: An error if the algorithm is unknown
+func NewKeyHasher(algorithm string, length int) (KeyHasher, error) {
+	var hasher KeyHasher
+	switch strings.ToLower(strings.TrimSpace(algorithm)) {
+	case "", "sha256":
+		hasher = hashString
+	case "sha512":
+		hasher = func(apiKey string) string {
+			sum := sha512.Sum512([]byte(apiKey))
+			return hex.EncodeToString(sum[:])
+		}
+	default:
+		return nil, fmt.Errorf("unknown key hash algorithm %q", algorithm)
+	}
+	if length <= 0 {
+		return hasher, nil
+	}
+	return func(apiKey string) string {
+		hashed := hasher(apiKey)
+		if len(hashed) > length {
+			hashed = hashed[:length]
+		}
+		return hashed
+	}, nil
+}
+
+// hashString creates a SHA256 hash of the input string.
+// Returns empty string if input is empty.
+func hashString(s string) string {
+	if s == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
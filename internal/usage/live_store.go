@@ -0,0 +1,165 @@
+package usage
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultLiveRetention = time.Hour
+	defaultLiveMaxEvents = 100_000
+)
+
+// LiveStore keeps usage in memory only, for dashboards without persistence.
+// It holds the events of the last retention window (capped at maxEvents) and
+// running totals since process start; everything is lost on restart.
+type LiveStore struct {
+	mu        sync.Mutex
+	retention time.Duration
+	maxEvents int
+	events    []UsageEvent
+	totals    RunningTotals
+	startedAt time.Time
+	// trimmed is the newest timestamp of an event dropped so far; once
+	// maxEvents is reached that can lie inside the retention window.
+	trimmed time.Time
+}
+
+// NewLiveStore creates an in-memory store.
+//
+// Parameters:
+//   - retention: How long events are kept (<= 0 uses one hour)
+//   - maxEvents: Maximum number of events kept (<= 0 uses 100000)
+//
+// Returns:
+//   - *LiveStore: A new in-memory store
+func NewLiveStore(retention time.Duration, maxEvents int) *LiveStore {
+	if retention <= 0 {
+		retention = defaultLiveRetention
+	}
+	if maxEvents <= 0 {
+		maxEvents = defaultLiveMaxEvents
+	}
+	return &LiveStore{
+		retention: retention,
+		maxEvents: maxEvents,
+		totals:    newRunningTotals(),
+		startedAt: time.Now(),
+	}
+}
+
+// Add records an event and drops events that fell out of the retention window.
+func (l *LiveStore) Add(event UsageEvent) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.totals.add(event, 1)
+	l.events = append(l.events, event)
+	l.trimLocked(time.Now())
+}
+
+// Since returns a copy of the retained events at or after from, oldest first.
+func (l *LiveStore) Since(from time.Time) []UsageEvent {
+	if l == nil {
+		return []UsageEvent{}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.trimLocked(time.Now())
+	out := make([]UsageEvent, 0, len(l.events))
+	for _, event := range l.events {
+		if !event.Timestamp.Before(from) {
+			out = append(out, event)
+		}
+	}
+	return out
+}
+
+// Totals returns the running totals since the store was created.
+func (l *LiveStore) Totals() RunningTotals {
+	if l == nil {
+		return newRunningTotals()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.totals.clone()
+}
+
+// Span returns the span of the retained events.
+func (l *LiveStore) Span() EventSpan {
+	if l == nil {
+		return EventSpan{}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.trimLocked(time.Now())
+	var span EventSpan
+	span.add(l.events...)
+	return span
+}
+
+// Retention returns how long events are kept.
+func (l *LiveStore) Retention() time.Duration {
+	if l == nil {
+		return 0
+	}
+	return l.retention
+}
+
+// CoveredSince returns the earliest time from which every event is retained:
+// the latest of process start, the retention cutoff and the newest event
+// dropped because maxEvents was reached.
+func (l *LiveStore) CoveredSince() time.Time {
+	if l == nil {
+		return time.Time{}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.trimLocked(now)
+	covered := now.Add(-l.retention)
+	if covered.Before(l.startedAt) {
+		covered = l.startedAt
+	}
+	if covered.Before(l.trimmed) {
+		covered = l.trimmed
+	}
+	return covered
+}
+
+// trimLocked drops events older than the retention window and beyond maxEvents.
+// Events arrive roughly in order, so trimming stops at the first retained one.
+// Must be called with l.mu held.
+func (l *LiveStore) trimLocked(now time.Time) {
+	cutoff := now.Add(-l.retention)
+	drop := 0
+	for drop < len(l.events) && l.events[drop].Timestamp.Before(cutoff) {
+		drop++
+	}
+	drop = max(drop, len(l.events)-l.maxEvents)
+	if drop == 0 {
+		return
+	}
+	for _, event := range l.events[:drop] {
+		if event.Timestamp.After(l.trimmed) {
+			l.trimmed = event.Timestamp
+		}
+	}
+	// Copy down once more than half the backing array is dead
+	l.events = l.events[drop:]
+	if cap(l.events) > 2*len(l.events)+64 {
+		l.events = append([]UsageEvent(nil), l.events...)
+	}
+}
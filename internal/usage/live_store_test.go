@@ -0,0 +1,26 @@
+package usage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLiveStore_CoveredSinceAfterMaxEventsTrim(t *testing.T) {
+	live := NewLiveStore(time.Hour, 2)
+	now := time.Now()
+	live.startedAt = now.Add(-30 * time.Minute)
+	if covered := live.CoveredSince(); !covered.Equal(live.startedAt) {
+		t.Fatalf("want coverage from process start, got %v", covered)
+	}
+
+	for i := 3; i > 0; i-- {
+		live.Add(UsageEvent{Timestamp: now.Add(-time.Duration(i) * time.Minute), Model: "m"})
+	}
+	if events := live.Since(time.Time{}); len(events) != 2 {
+		t.Fatalf("want 2 retained events, got %d", len(events))
+	}
+	// The oldest event fell to the cap, not to the retention window
+	if covered := live.CoveredSince(); !covered.Equal(now.Add(-3 * time.Minute)) {
+		t.Fatalf("want coverage from the trimmed event at %v, got %v", now.Add(-3*time.Minute), covered)
+	}
+}
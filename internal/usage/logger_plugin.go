@@ -5,13 +5,15 @@ package usage
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	coreusage "github.com/router-for-me/CLIProxyAPI/v6/sdk/cliproxy/usage"
@@ -20,6 +22,29 @@ import (
 var statisticsEnabled atomic.Bool
 var jsonStore *JSONStore
 var jsonStoreMu sync.RWMutex
+var otelExporter *OTELExporter
+var storeManager *StoreManager
+var liveStore *LiveStore
+var replaySink *ReplaySink
+var tenantHeader string
+var enrichers []func(*UsageEvent)
+
+// suspiciousTokenCap is the per-request token count above which recorded events
+// are treated as bad data; 0 disables the check. When clampSuspicious is set the
+// token counts are clamped to the cap, otherwise the event is only flagged.
+var suspiciousTokenCap atomic.Int64
+var clampSuspicious atomic.Bool
+
+// dropZeroTokenFailures discards failed events that report no tokens.
+// clampedEventCount and droppedZeroTokenCount count the events changed or
+// discarded by normalizeTokens.
+var dropZeroTokenFailures atomic.Bool
+var clampedEventCount atomic.Int64
+var droppedZeroTokenCount atomic.Int64
+
+// maxFieldLength is the longest model name or label value recorded, in
+// characters; 0 means DefaultMaxFieldLength and a negative value no limit.
+var maxFieldLength atomic.Int64
 
 func init() {
 	statisticsEnabled.Store(true)
@@ -61,14 +86,23 @@ func SetStatisticsEnabled(enabled bool) { statisticsEnabled.Store(enabled) }
 func StatisticsEnabled() bool { return statisticsEnabled.Load() }
 
 // SetJSONStore sets the global JSON store for usage persistence.
-// This should be called once during server initialization.
+// A previously set store is closed (flushed and its goroutines stopped) once
+// it has been swapped out; writers that fetched it just before the swap still
+// persist their events, since a closed store writes through to disk.
 //
 // Parameters:
-//   - store: The JSON store instance to use for persistence
+//   - store: The JSON store instance to use for persistence, or nil to disable it
 func SetJSONStore(store *JSONStore) {
 	jsonStoreMu.Lock()
-	defer jsonStoreMu.Unlock()
+	previous := jsonStore
 	jsonStore = store
+	jsonStoreMu.Unlock()
+
+	if previous != nil && previous != store {
+		if err := previous.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to close replaced usage store: %v\n", err)
+		}
+	}
 }
 
 // GetJSONStore returns the current JSON store instance.
@@ -79,6 +113,131 @@ func GetJSONStore() *JSONStore {
 	return jsonStore
 }
 
+// SetTokenSanityCheck configures the per-request token cap applied when
+// persisting usage events. Events above the cap are logged and flagged as
+// suspicious; with clamp set their token counts are also reduced to the cap.
+// A cap of 0 disables the check.
+func SetTokenSanityCheck(cap int64, clamp bool) {
+	if cap < 0 {
+		cap = 0
+	}
+	suspiciousTokenCap.Store(cap)
+	clampSuspicious.Store(clamp)
+}
+
+// SetDropZeroTokenFailures makes the recording path discard non-2xx events
+// that report no tokens, such as requests rejected before reaching a model.
+// Negative token counts are always clamped to zero.
+func SetDropZeroTokenFailures(drop bool) {
+	dropZeroTokenFailures.Store(drop)
+}
+
+// DefaultMaxFieldLength is the default limit applied by SetMaxFieldLength.
+const DefaultMaxFieldLength = 128
+
+// fieldTruncationMarker ends a value shortened to the field length limit.
+const fieldTruncationMarker = "…"
+
+// SetMaxFieldLength caps the length, in characters, of the model name and
+// label values of recorded events, so a malformed upstream cannot bloat the
+// store or break dashboards. Longer values are cut and end in "…", and a
+// warning is logged. 0 restores DefaultMaxFieldLength; a negative limit
+// disables truncation.
+func SetMaxFieldLength(limit int) {
+	maxFieldLength.Store(int64(limit))
+}
+
+// TokenAdjustments counts the events the recording path changed or discarded
+// because of their token counts since the process started.
+type TokenAdjustments struct {
+	// ClampedNegative counts events with a negative token count clamped to zero.
+	ClampedNegative int64 `json:"clamped_negative"`
+	// DroppedZeroToken counts zero-token failed events discarded when enabled.
+	DroppedZeroToken int64 `json:"dropped_zero_token"`
+}
+
+// CurrentTokenAdjustments returns the token adjustment counters.
+func CurrentTokenAdjustments() TokenAdjustments {
+	return TokenAdjustments{
+		ClampedNegative:  clampedEventCount.Load(),
+		DroppedZeroToken: droppedZeroTokenCount.Load(),
+	}
+}
+
+// SetStoreManager sets the per-tenant store manager. Every recorded event is
+// additionally written to the store of its tenant, identified by the value of
+// header (when non-empty and present on the request) or else the API key hash.
+// Pass nil to disable per-tenant stores.
+func SetStoreManager(manager *StoreManager, header string) {
+	jsonStoreMu.Lock()
+	defer jsonStoreMu.Unlock()
+	storeManager = manager
+	tenantHeader = header
+}
+
+// GetStoreManager returns the per-tenant store manager, or nil if none is configured.
+func GetStoreManager() *StoreManager {
+	jsonStoreMu.RLock()
+	defer jsonStoreMu.RUnlock()
+	return storeManager
+}
+
+// SetLiveStore sets the in-memory store used when persistence is disabled.
+// Recorded events are added to it alongside any JSON store. Pass nil to disable it.
+func SetLiveStore(store *LiveStore) {
+	jsonStoreMu.Lock()
+	defer jsonStoreMu.Unlock()
+	liveStore = store
+}
+
+// GetLiveStore returns the in-memory store, or nil if none is configured.
+func GetLiveStore() *LiveStore {
+	jsonStoreMu.RLock()
+	defer jsonStoreMu.RUnlock()
+	return liveStore
+}
+
+// SetOTELExporter sets the global OTLP exporter that receives every recorded
+// usage event alongside the JSON store. Pass nil to disable exporting.
+func SetOTELExporter(exporter *OTELExporter) {
+	jsonStoreMu.Lock()
+	defer jsonStoreMu.Unlock()
+	otelExporter = exporter
+}
+
+// GetOTELExporter returns the current OTLP exporter, or nil if none is configured.
+func GetOTELExporter() *OTELExporter {
+	jsonStoreMu.RLock()
+	defer jsonStoreMu.RUnlock()
+	return otelExporter
+}
+
+// SetReplaySink sets the secondary sink that the management replay endpoint
+// writes to. Pass nil to disable replay.
+func SetReplaySink(sink *ReplaySink) {
+	jsonStoreMu.Lock()
+	defer jsonStoreMu.Unlock()
+	replaySink = sink
+}
+
+// SetEnrichers sets the functions that stamp metadata such as labels onto
+// every recorded event, in order, before it is handed to the live store, the
+// shared and tenant stores and the OTLP exporter, so all of them see the same
+// labels. Like WithEnricher they must be safe for concurrent use and cheap.
+// Call without arguments to disable enrichment.
+func SetEnrichers(fns ...func(*UsageEvent)) {
+	jsonStoreMu.Lock()
+	defer jsonStoreMu.Unlock()
+	enrichers = slices.DeleteFunc(slices.Clone(fns), func(fn func(*UsageEvent)) bool { return fn == nil })
+}
+
+// GetReplaySink returns the replay sink, or nil if none is configured.
+func GetReplaySink() *ReplaySink {
+	jsonStoreMu.RLock()
+	defer jsonStoreMu.RUnlock()
+	return replaySink
+}
+
 // RequestStatistics maintains aggregated request metrics in memory.
 type RequestStatistics struct {
 	mu sync.RWMutex
@@ -232,8 +391,14 @@ func (s *RequestStatistics) Record(ctx context.Context, record coreusage.Record)
 	s.tokensByDay[dayKey] += totalTokens
 	s.tokensByHour[hourKey] += totalTokens
 
+	completedAt := time.Now()
+	var latencyMs int64
+	if !record.RequestedAt.IsZero() {
+		latencyMs = completedAt.Sub(record.RequestedAt).Milliseconds()
+	}
+
 	// Persist to JSON store if configured (non-blocking)
-	persistToJSONStore(timestamp, modelName, detail, statsKey, success)
+	persistToJSONStore(timestamp, completedAt, modelName, record.Provider, record.UpstreamRequestID, detail, statsKey, success, latencyMs, resolveTenantHeader(ctx))
 }
 
 func (s *RequestStatistics) updateAPIStats(stats *apiStats, model string, detail RequestDetail) {
@@ -249,46 +414,204 @@ func (s *RequestStatistics) updateAPIStats(stats *apiStats, model string, detail
 	modelStatsValue.Details = append(modelStatsValue.Details, detail)
 }
 
-// persistToJSONStore writes a usage event to the JSON store if configured.
+// persistToJSONStore writes a usage event to the JSON store and OTLP exporter if configured.
 // This function runs asynchronously to avoid blocking the request processing.
-func persistToJSONStore(timestamp time.Time, model string, tokens TokenStats, apiKeyHash string, success bool) {
+func persistToJSONStore(timestamp, completedAt time.Time, model, provider, upstreamRequestID string, tokens TokenStats, apiKeyHash string, success bool, latencyMs int64, tenant string) {
 	// Quick check without lock
 	jsonStoreMu.RLock()
 	store := jsonStore
+	exporter := otelExporter
+	manager := storeManager
+	live := liveStore
+	enrich := enrichers
 	jsonStoreMu.RUnlock()
 
-	if store == nil {
+	if store == nil && exporter == nil && manager == nil && live == nil {
 		return
 	}
 
 	// Build the usage event
 	event := UsageEvent{
-		Timestamp:        timestamp,
-		Model:            model,
-		PromptTokens:     tokens.InputTokens,
-		CompletionTokens: tokens.OutputTokens,
-		TotalTokens:      tokens.TotalTokens,
-		Status:           statusFromSuccess(success),
-		APIKeyHash:       hashString(apiKeyHash),
+		Timestamp:         timestamp,
+		Model:             model,
+		Provider:          provider,
+		UpstreamRequestID: upstreamRequestID,
+		PromptTokens:      tokens.InputTokens,
+		CompletionTokens:  tokens.OutputTokens,
+		TotalTokens:       tokens.TotalTokens,
+		CachedTokens:      tokens.CachedTokens,
+		Status:            statusFromSuccess(success),
+		APIKeyHash:        store.HashKey(apiKeyHash),
+		LatencyMs:         latencyMs,
+		CompletedAt:       completedAt,
+	}
+	if !normalizeTokens(&event) {
+		return
+	}
+	checkTokenSanity(&event)
+	event = applyEnrichers(event, enrich)
+	truncateLongFields(&event)
+
+	exporter.Export(event)
+	dispatchEvent(event, store, manager, live, tenant)
+}
+
+// RecordMarker records a marker event of the given kind, e.g. EventKindPing
+// for a health check or keepalive, so traffic volume can be counted apart
+// from token workload. Markers carry no tokens or model and are not exported
+// over OTLP; the metrics endpoints count them under markers unless a kind
+// filter selects them. An empty kind or EventKindRequest is ignored.
+//
+// Parameters:
+//   - kind: The marker kind
+//   - apiKeyHash: The caller's API key, hashed like request events; may be empty
+func RecordMarker(kind, apiKeyHash string) {
+	if kind == "" || kind == EventKindRequest || !statisticsEnabled.Load() {
+		return
+	}
+	jsonStoreMu.RLock()
+	store := jsonStore
+	manager := storeManager
+	live := liveStore
+	enrich := enrichers
+	jsonStoreMu.RUnlock()
+
+	event := applyEnrichers(UsageEvent{
+		Timestamp:  time.Now(),
+		Kind:       kind,
+		Status:     statusFromSuccess(true),
+		APIKeyHash: store.HashKey(apiKeyHash),
+	}, enrich)
+	truncateLongFields(&event)
+	dispatchEvent(event, store, manager, live, "")
+}
+
+// dispatchEvent adds an event to the live store and writes it to the shared
+// and tenant stores, asynchronously to avoid blocking the request. An empty
+// tenant falls back to the event's key hash.
+func dispatchEvent(event UsageEvent, store *JSONStore, manager *StoreManager, live *LiveStore, tenant string) {
+	live.Add(event)
+	if store == nil && manager == nil {
+		return
+	}
+	if tenant == "" {
+		tenant = event.APIKeyHash
 	}
 
 	// Write asynchronously to avoid blocking
 	go func() {
-		if err := store.Write(event); err != nil {
-			// Log error but don't fail the request
-			fmt.Fprintf(os.Stderr, "warning: failed to persist usage event: %v\n", err)
+		if store != nil {
+			if err := store.Write(event); err != nil {
+				// Log error but don't fail the request
+				fmt.Fprintf(os.Stderr, "warning: failed to persist usage event: %v\n", err)
+			}
+		}
+		if manager != nil && tenant != "" {
+			if err := manager.Write(tenant, event); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: failed to persist usage event to tenant store: %v\n", err)
+			}
 		}
 	}()
 }
 
-// hashString creates a SHA256 hash of the input string.
-// Returns empty string if input is empty.
-func hashString(s string) string {
-	if s == "" {
+// resolveTenantHeader returns the configured tenant header value from the request, if any.
+func resolveTenantHeader(ctx context.Context) string {
+	jsonStoreMu.RLock()
+	header := tenantHeader
+	jsonStoreMu.RUnlock()
+	if header == "" || ctx == nil {
 		return ""
 	}
-	sum := sha256.Sum256([]byte(s))
-	return hex.EncodeToString(sum[:])
+	ginCtx, ok := ctx.Value("gin").(*gin.Context)
+	if !ok || ginCtx == nil || ginCtx.Request == nil {
+		return ""
+	}
+	return strings.TrimSpace(ginCtx.GetHeader(header))
+}
+
+// normalizeTokens clamps negative token counts, which some upstreams report
+// for streaming increments, to zero. It returns false when the event should
+// not be recorded: a failed request without tokens while
+// SetDropZeroTokenFailures is enabled.
+func normalizeTokens(event *UsageEvent) bool {
+	clamped := false
+	for _, count := range []*int64{&event.PromptTokens, &event.CompletionTokens, &event.TotalTokens, &event.CachedTokens} {
+		if *count < 0 {
+			*count = 0
+			clamped = true
+		}
+	}
+	if clamped {
+		clampedEventCount.Add(1)
+	}
+	if event.TotalTokens == 0 && (event.Status < 200 || event.Status > 299) && dropZeroTokenFailures.Load() {
+		droppedZeroTokenCount.Add(1)
+		return false
+	}
+	return true
+}
+
+// truncateLongFields shortens the model name and label values of an event
+// to the configured field length limit. Labels are copied before changing
+// them, since the map may be shared with the caller.
+func truncateLongFields(event *UsageEvent) {
+	limit := int(maxFieldLength.Load())
+	if limit == 0 {
+		limit = DefaultMaxFieldLength
+	}
+	if limit < 0 {
+		return
+	}
+	if model, ok := truncateField(event.Model, limit); ok {
+		fmt.Fprintf(os.Stderr, "warning: usage event model name of %d bytes truncated to %d characters: %s\n", len(event.Model), limit, model)
+		event.Model = model
+	}
+	copied := false
+	for key, value := range event.Labels {
+		truncated, ok := truncateField(value, limit)
+		if !ok {
+			continue
+		}
+		if !copied {
+			event.Labels = maps.Clone(event.Labels)
+			copied = true
+		}
+		fmt.Fprintf(os.Stderr, "warning: usage event label %q value of %d bytes truncated to %d characters\n", key, len(value), limit)
+		event.Labels[key] = truncated
+	}
+}
+
+// truncateField cuts value to limit characters, the last being
+// fieldTruncationMarker, and reports whether it had to.
+func truncateField(value string, limit int) (string, bool) {
+	if len(value) <= limit || utf8.RuneCountInString(value) <= limit {
+		return value, false
+	}
+	runes := 0
+	for i := range value {
+		if runes == limit-1 {
+			return value[:i] + fieldTruncationMarker, true
+		}
+		runes++
+	}
+	return value, false
+}
+
+// checkTokenSanity flags, and optionally clamps, events above the configured token cap.
+func checkTokenSanity(event *UsageEvent) {
+	limit := suspiciousTokenCap.Load()
+	if limit <= 0 || event.TotalTokens <= limit {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "warning: usage event for model %s reported %d tokens, above the cap of %d\n", event.Model, event.TotalTokens, limit)
+	event.Suspicious = true
+	if clampSuspicious.Load() {
+		event.TotalTokens = limit
+		event.PromptTokens = min(event.PromptTokens, limit)
+		event.CompletionTokens = min(event.CompletionTokens, limit-event.PromptTokens)
+		// Cached tokens are part of the prompt, so the cache hit ratio stays at most 1
+		event.CachedTokens = min(event.CachedTokens, event.PromptTokens)
+	}
 }
 
 // statusFromSuccess converts a success boolean to an HTTP-like status code.
@@ -0,0 +1,100 @@
+package usage
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestNormalizeTokens_ClampsNegativesAndDropsZeroTokenFailures(t *testing.T) {
+	defer SetDropZeroTokenFailures(false)
+	before := CurrentTokenAdjustments()
+
+	event := UsageEvent{Status: 200, PromptTokens: 10, CompletionTokens: -4, TotalTokens: -1}
+	if !normalizeTokens(&event) {
+		t.Fatal("want a successful event kept")
+	}
+	if event.CompletionTokens != 0 || event.TotalTokens != 0 || event.PromptTokens != 10 {
+		t.Fatalf("want negatives clamped to zero, got %+v", event)
+	}
+
+	failed := UsageEvent{Status: 500}
+	if !normalizeTokens(&failed) {
+		t.Fatal("want zero-token failures kept by default")
+	}
+	SetDropZeroTokenFailures(true)
+	if normalizeTokens(&failed) {
+		t.Fatal("want zero-token failures dropped when enabled")
+	}
+	if kept := (UsageEvent{Status: 500, TotalTokens: 5}); !normalizeTokens(&kept) {
+		t.Fatal("want failures with tokens kept")
+	}
+
+	after := CurrentTokenAdjustments()
+	if after.ClampedNegative-before.ClampedNegative != 1 || after.DroppedZeroToken-before.DroppedZeroToken != 1 {
+		t.Fatalf("want one clamped and one dropped event, got %+v then %+v", before, after)
+	}
+}
+
+func TestTruncateLongFields_CutsModelAndLabelsWithMarker(t *testing.T) {
+	defer SetMaxFieldLength(0)
+
+	labels := map[string]string{"region": strings.Repeat("é", 200), "env": "prod"}
+	event := UsageEvent{Model: strings.Repeat("m", 5000), Labels: labels}
+	truncateLongFields(&event)
+	if utf8.RuneCountInString(event.Model) != DefaultMaxFieldLength || !strings.HasSuffix(event.Model, fieldTruncationMarker) {
+		t.Fatalf("want the model cut to %d characters ending in the marker, got %d", DefaultMaxFieldLength, utf8.RuneCountInString(event.Model))
+	}
+	if region := event.Labels["region"]; utf8.RuneCountInString(region) != DefaultMaxFieldLength || !utf8.ValidString(region) {
+		t.Fatalf("want the label cut on a character boundary, got %q", region)
+	}
+	if event.Labels["env"] != "prod" || len(labels["region"]) != 400 {
+		t.Fatalf("want short labels kept and the caller's map untouched, got %v", event.Labels)
+	}
+
+	SetMaxFieldLength(-1)
+	long := UsageEvent{Model: strings.Repeat("m", 5000)}
+	truncateLongFields(&long)
+	if len(long.Model) != 5000 {
+		t.Fatal("want no truncation with a negative limit")
+	}
+}
+
+func TestSetEnrichers_LabelsEventsForTheLiveStore(t *testing.T) {
+	live := NewLiveStore(time.Hour, 0)
+	SetLiveStore(live)
+	SetEnrichers(StaticLabels(map[string]string{"region": "eu"}))
+	defer func() {
+		SetLiveStore(nil)
+		SetEnrichers()
+	}()
+
+	now := time.Now()
+	persistToJSONStore(now, now, "m", "openai", "", TokenStats{InputTokens: 1, TotalTokens: 1}, "key", true, 10, "")
+	RecordMarker(EventKindPing, "")
+
+	events := live.Since(time.Time{})
+	if len(events) != 2 {
+		t.Fatalf("want 2 events, got %d", len(events))
+	}
+	for _, event := range events {
+		if event.Labels["region"] != "eu" {
+			t.Fatalf("want the region label without a JSON store, got %+v", event)
+		}
+	}
+}
+
+func TestCheckTokenSanity_ClampsCachedTokensToPrompt(t *testing.T) {
+	SetTokenSanityCheck(100, true)
+	defer SetTokenSanityCheck(0, false)
+
+	event := UsageEvent{Model: "m", PromptTokens: 500, CompletionTokens: 50, TotalTokens: 550, CachedTokens: 400}
+	checkTokenSanity(&event)
+	if !event.Suspicious || event.TotalTokens != 100 || event.PromptTokens != 100 || event.CompletionTokens != 0 {
+		t.Fatalf("want the counts clamped to the cap, got %+v", event)
+	}
+	if event.CachedTokens != 100 {
+		t.Fatalf("want cached tokens clamped to the prompt, got %d", event.CachedTokens)
+	}
+}
@@ -0,0 +1,493 @@
+// Package usage provides usage tracking and logging functionality for the CLI Proxy API server.
+package usage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	otelQueueSize      = 4096
+	otelBatchSize      = 512
+	otelFlushInterval  = 10 * time.Second
+	otelRequestTimeout = 10 * time.Second
+	otelMaxAttempts    = 5
+	otelInitialBackoff = 500 * time.Millisecond
+	otelMaxBackoff     = 15 * time.Second
+	otelScopeName      = "github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+// Histogram bucket boundaries for the exported token and latency histograms.
+var (
+	otelTokenBounds   = []float64{100, 500, 1000, 2000, 5000, 10000, 20000, 50000, 100000, 200000}
+	otelLatencyBounds = []float64{50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000, 120000}
+)
+
+// OTELExporterConfig describes where and how usage events are exported over OTLP/HTTP.
+type OTELExporterConfig struct {
+	// Endpoint is the collector base URL, e.g. http://localhost:4318.
+	// Metrics are sent to <Endpoint>/v1/metrics and spans to <Endpoint>/v1/traces.
+	Endpoint string
+	// Headers are added to every export request (e.g. authentication).
+	Headers map[string]string
+	// ServiceName is reported as the service.name resource attribute.
+	ServiceName string
+}
+
+// OTELExporter emits usage events as OTLP metrics and spans using the JSON
+// encoding of OTLP/HTTP. Events are queued without blocking the caller and
+// exported in batches; when the collector is unreachable batches are retried
+// with exponential backoff and eventually dropped, and new events are dropped
+// while the queue is full.
+type OTELExporter struct {
+	cfg    OTELExporterConfig
+	client *http.Client
+	queue  chan UsageEvent
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	batchSize     int
+	flushInterval time.Duration
+
+	closeOnce sync.Once
+	dropped   int64
+	droppedMu sync.Mutex
+}
+
+// NewOTELExporter creates an exporter and starts its background batching goroutine.
+//
+// Parameters:
+//   - cfg: The collector endpoint and resource settings
+//
+// Returns:
+//   - *OTELExporter: A new exporter instance
+func NewOTELExporter(cfg OTELExporterConfig) *OTELExporter {
+	return newOTELExporter(cfg, otelBatchSize, otelFlushInterval)
+}
+
+// newOTELExporter is NewOTELExporter with the given batch size and flush
+// interval, so tests need not wait for the defaults.
+func newOTELExporter(cfg OTELExporterConfig, batchSize int, flushInterval time.Duration) *OTELExporter {
+	cfg.Endpoint = strings.TrimRight(strings.TrimSpace(cfg.Endpoint), "/")
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "cli-proxy-api"
+	}
+	e := &OTELExporter{
+		cfg:    cfg,
+		client: &http.Client{Timeout: otelRequestTimeout},
+		queue:  make(chan UsageEvent, otelQueueSize),
+		done:   make(chan struct{}),
+
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+	}
+	e.wg.Add(1)
+	go e.run()
+	return e
+}
+
+// Export queues an event for export. It never blocks; if the queue is full
+// the event is dropped and counted.
+func (e *OTELExporter) Export(event UsageEvent) {
+	if e == nil {
+		return
+	}
+	select {
+	case <-e.done:
+		return
+	default:
+	}
+	select {
+	case e.queue <- event:
+	default:
+		e.droppedMu.Lock()
+		e.dropped++
+		e.droppedMu.Unlock()
+		droppedEventCount.Add(1)
+	}
+}
+
+// ExportWait queues an event for export, waiting for room in the queue
+// instead of dropping it. It is meant for replays, which may produce events
+// faster than they can be sent.
+func (e *OTELExporter) ExportWait(event UsageEvent) error {
+	if e == nil {
+		return fmt.Errorf("otel exporter is nil")
+	}
+	// Checked first: once closed, a free slot would strand the event
+	select {
+	case <-e.done:
+		return fmt.Errorf("otel exporter is closed")
+	default:
+	}
+	select {
+	case e.queue <- event:
+		return nil
+	case <-e.done:
+		return fmt.Errorf("otel exporter is closed")
+	}
+}
+
+// Close stops the exporter after sending any queued events.
+func (e *OTELExporter) Close() {
+	if e == nil {
+		return
+	}
+	e.closeOnce.Do(func() {
+		close(e.done)
+		e.wg.Wait()
+	})
+}
+
+// run batches queued events and exports them on size or interval.
+func (e *OTELExporter) run() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]UsageEvent, 0, e.batchSize)
+	windowStart := time.Now()
+	flush := func() {
+		if len(batch) > 0 {
+			e.exportBatch(batch, windowStart, time.Now())
+			batch = batch[:0]
+		}
+		windowStart = time.Now()
+		e.reportDropped()
+	}
+
+	for {
+		select {
+		case event := <-e.queue:
+			batch = append(batch, event)
+			if len(batch) >= e.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.done:
+			for {
+				select {
+				case event := <-e.queue:
+					batch = append(batch, event)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// reportDropped logs and resets the count of events dropped due to a full queue.
+func (e *OTELExporter) reportDropped() {
+	e.droppedMu.Lock()
+	dropped := e.dropped
+	e.dropped = 0
+	e.droppedMu.Unlock()
+	if dropped > 0 {
+		fmt.Fprintf(os.Stderr, "warning: otel exporter queue full, dropped %d usage events\n", dropped)
+	}
+}
+
+// exportBatch sends one batch as both metrics and spans.
+func (e *OTELExporter) exportBatch(batch []UsageEvent, start, end time.Time) {
+	resource := e.resource()
+	metrics := map[string]any{
+		"resourceMetrics": []any{map[string]any{
+			"resource": resource,
+			"scopeMetrics": []any{map[string]any{
+				"scope":   map[string]any{"name": otelScopeName},
+				"metrics": buildOTELMetrics(batch, start, end),
+			}},
+		}},
+	}
+	if err := e.post("/v1/metrics", metrics); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to export usage metrics to otel collector: %v\n", err)
+	}
+
+	traces := map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": resource,
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": otelScopeName},
+				"spans": buildOTELSpans(batch),
+			}},
+		}},
+	}
+	if err := e.post("/v1/traces", traces); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to export usage spans to otel collector: %v\n", err)
+	}
+}
+
+func (e *OTELExporter) resource() map[string]any {
+	return map[string]any{
+		"attributes": []any{otelStringAttr("service.name", e.cfg.ServiceName)},
+	}
+}
+
+// post sends an OTLP JSON payload, retrying transient failures with
+// exponential backoff as recommended by the OTLP/HTTP specification.
+func (e *OTELExporter) post(path string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %w", err)
+	}
+
+	backoff := otelInitialBackoff
+	var lastErr error
+	for attempt := 1; attempt <= otelMaxAttempts; attempt++ {
+		retryAfter, err := e.send(path, body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if retryAfter < 0 {
+			// Permanent failure, retrying would not help
+			return err
+		}
+		if attempt == otelMaxAttempts {
+			break
+		}
+		wait := backoff
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		select {
+		case <-time.After(wait):
+		case <-e.done:
+			// Shutting down: give up on retries
+			return lastErr
+		}
+		backoff *= 2
+		if backoff > otelMaxBackoff {
+			backoff = otelMaxBackoff
+		}
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", otelMaxAttempts, lastErr)
+}
+
+// send performs a single export request. The returned duration is the
+// server-requested retry delay (0 to use backoff, negative if not retryable).
+func (e *OTELExporter) send(path string, body []byte) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, e.cfg.Endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return 0, nil
+	}
+	err = fmt.Errorf("collector returned status %d", resp.StatusCode)
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		if seconds, errParse := strconv.Atoi(resp.Header.Get("Retry-After")); errParse == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second, err
+		}
+		return 0, err
+	default:
+		return -1, err
+	}
+}
+
+// otelSeriesKey identifies a metric data point by its attributes.
+type otelSeriesKey struct {
+	model  string
+	status int
+}
+
+// otelHistogram accumulates values into explicit buckets.
+type otelHistogram struct {
+	count  int64
+	sum    float64
+	counts []int64
+}
+
+func (h *otelHistogram) observe(bounds []float64, v float64) {
+	if h.counts == nil {
+		h.counts = make([]int64, len(bounds)+1)
+	}
+	h.count++
+	h.sum += v
+	idx := len(bounds)
+	for i, b := range bounds {
+		if v <= b {
+			idx = i
+			break
+		}
+	}
+	h.counts[idx]++
+}
+
+// buildOTELMetrics aggregates a batch into a delta request counter and
+// token and latency histograms, split by model and status.
+func buildOTELMetrics(batch []UsageEvent, start, end time.Time) []any {
+	requests := make(map[otelSeriesKey]int64)
+	tokens := make(map[otelSeriesKey]*otelHistogram)
+	latency := make(map[otelSeriesKey]*otelHistogram)
+	for _, event := range batch {
+		key := otelSeriesKey{model: event.Model, status: event.Status}
+		requests[key]++
+		if tokens[key] == nil {
+			tokens[key] = &otelHistogram{}
+		}
+		tokens[key].observe(otelTokenBounds, float64(event.TotalTokens))
+		if event.LatencyMs > 0 {
+			if latency[key] == nil {
+				latency[key] = &otelHistogram{}
+			}
+			latency[key].observe(otelLatencyBounds, float64(event.LatencyMs))
+		}
+	}
+
+	startNano := strconv.FormatInt(start.UnixNano(), 10)
+	endNano := strconv.FormatInt(end.UnixNano(), 10)
+
+	requestPoints := make([]any, 0, len(requests))
+	for key, n := range requests {
+		requestPoints = append(requestPoints, map[string]any{
+			"attributes":        otelSeriesAttrs(key),
+			"startTimeUnixNano": startNano,
+			"timeUnixNano":      endNano,
+			"asInt":             strconv.FormatInt(n, 10),
+		})
+	}
+
+	histogramPoints := func(series map[otelSeriesKey]*otelHistogram, bounds []float64) []any {
+		points := make([]any, 0, len(series))
+		for key, h := range series {
+			bucketCounts := make([]string, len(h.counts))
+			for i, c := range h.counts {
+				bucketCounts[i] = strconv.FormatInt(c, 10)
+			}
+			points = append(points, map[string]any{
+				"attributes":        otelSeriesAttrs(key),
+				"startTimeUnixNano": startNano,
+				"timeUnixNano":      endNano,
+				"count":             strconv.FormatInt(h.count, 10),
+				"sum":               h.sum,
+				"bucketCounts":      bucketCounts,
+				"explicitBounds":    bounds,
+			})
+		}
+		return points
+	}
+
+	// aggregationTemporality 1 is DELTA: each batch reports only its own window
+	metrics := []any{
+		map[string]any{
+			"name":        "cliproxy.usage.requests",
+			"description": "Number of proxied requests",
+			"unit":        "{request}",
+			"sum": map[string]any{
+				"dataPoints":             requestPoints,
+				"aggregationTemporality": 1,
+				"isMonotonic":            true,
+			},
+		},
+		map[string]any{
+			"name":        "cliproxy.usage.tokens",
+			"description": "Total tokens per request",
+			"unit":        "{token}",
+			"histogram": map[string]any{
+				"dataPoints":             histogramPoints(tokens, otelTokenBounds),
+				"aggregationTemporality": 1,
+			},
+		},
+	}
+	if len(latency) > 0 {
+		metrics = append(metrics, map[string]any{
+			"name":        "cliproxy.usage.latency",
+			"description": "Request latency",
+			"unit":        "ms",
+			"histogram": map[string]any{
+				"dataPoints":             histogramPoints(latency, otelLatencyBounds),
+				"aggregationTemporality": 1,
+			},
+		})
+	}
+	return metrics
+}
+
+// buildOTELSpans converts each event into a standalone span.
+func buildOTELSpans(batch []UsageEvent) []any {
+	spans := make([]any, 0, len(batch))
+	for _, event := range batch {
+		end := event.Timestamp.Add(time.Duration(event.LatencyMs) * time.Millisecond)
+		attrs := []any{
+			otelStringAttr("gen_ai.request.model", event.Model),
+			otelIntAttr("http.response.status_code", int64(event.Status)),
+			otelIntAttr("gen_ai.usage.input_tokens", event.PromptTokens),
+			otelIntAttr("gen_ai.usage.output_tokens", event.CompletionTokens),
+			otelIntAttr("cliproxy.usage.total_tokens", event.TotalTokens),
+		}
+		if event.RequestID != "" {
+			attrs = append(attrs, otelStringAttr("cliproxy.request_id", event.RequestID))
+		}
+		for _, key := range slices.Sorted(maps.Keys(event.Labels)) {
+			attrs = append(attrs, otelStringAttr("cliproxy.label."+key, event.Labels[key]))
+		}
+		// status code 2 is ERROR, 0 is UNSET
+		statusCode := 0
+		if event.Status >= 400 {
+			statusCode = 2
+		}
+		spans = append(spans, map[string]any{
+			"traceId":           otelRandomID(16),
+			"spanId":            otelRandomID(8),
+			"name":              "usage.request",
+			"kind":              3, // SPAN_KIND_CLIENT
+			"startTimeUnixNano": strconv.FormatInt(event.Timestamp.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(end.UnixNano(), 10),
+			"attributes":        attrs,
+			"status":            map[string]any{"code": statusCode},
+		})
+	}
+	return spans
+}
+
+func otelSeriesAttrs(key otelSeriesKey) []any {
+	return []any{
+		otelStringAttr("gen_ai.request.model", key.model),
+		otelIntAttr("http.response.status_code", int64(key.status)),
+	}
+}
+
+func otelStringAttr(key, value string) map[string]any {
+	return map[string]any{"key": key, "value": map[string]any{"stringValue": value}}
+}
+
+func otelIntAttr(key string, value int64) map[string]any {
+	return map[string]any{"key": key, "value": map[string]any{"intValue": strconv.FormatInt(value, 10)}}
+}
+
+// otelRandomID returns a hex-encoded random identifier of n bytes.
+func otelRandomID(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,221 @@
+package usage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// otelTestCollector records the OTLP requests an exporter sends.
+type otelTestCollector struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// metrics holds the arrival time of every /v1/metrics request, and spans
+	// the span count of every accepted /v1/traces request.
+	metrics []time.Time
+	spans   []int
+	// reply, when set, answers the n-th /v1/metrics request (from 1) instead
+	// of accepting it.
+	reply func(w http.ResponseWriter, n int) bool
+}
+
+func newOTELTestCollector(t *testing.T) *otelTestCollector {
+	t.Helper()
+	c := &otelTestCollector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		switch r.URL.Path {
+		case "/v1/metrics":
+			c.metrics = append(c.metrics, time.Now())
+			if c.reply != nil && c.reply(w, len(c.metrics)) {
+				return
+			}
+		case "/v1/traces":
+			var payload struct {
+				ResourceSpans []struct {
+					ScopeSpans []struct {
+						Spans []json.RawMessage `json:"spans"`
+					} `json:"scopeSpans"`
+				} `json:"resourceSpans"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				t.Errorf("decode traces: %v", err)
+			}
+			c.spans = append(c.spans, len(payload.ResourceSpans[0].ScopeSpans[0].Spans))
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+// batches returns the span counts of the batches received so far.
+func (c *otelTestCollector) batches() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int(nil), c.spans...)
+}
+
+// waitBatches waits up to a few seconds for n batches.
+func (c *otelTestCollector) waitBatches(t *testing.T, n int) []int {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(c.batches()) < n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	batches := c.batches()
+	if len(batches) < n {
+		t.Fatalf("want %d batches, got %v", n, batches)
+	}
+	return batches
+}
+
+func TestOTELExporter_BatchesBySizeAndInterval(t *testing.T) {
+	collector := newOTELTestCollector(t)
+
+	// A full batch is sent at once, long before the flush interval
+	bySize := newOTELExporter(OTELExporterConfig{Endpoint: collector.URL}, 3, time.Hour)
+	defer bySize.Close()
+	for i := 0; i < 4; i++ {
+		bySize.Export(UsageEvent{Timestamp: time.Now(), Model: "m", TotalTokens: 10, Status: 200})
+	}
+	if batches := collector.waitBatches(t, 1); batches[0] != 3 {
+		t.Fatalf("want a batch of 3, got %v", batches)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if batches := collector.batches(); len(batches) != 1 {
+		t.Fatalf("want the fourth event held for the interval, got %v", batches)
+	}
+
+	// A partial batch waits for the interval
+	byInterval := newOTELExporter(OTELExporterConfig{Endpoint: collector.URL}, 100, 100*time.Millisecond)
+	defer byInterval.Close()
+	byInterval.Export(UsageEvent{Timestamp: time.Now(), Model: "m", Status: 200})
+	byInterval.Export(UsageEvent{Timestamp: time.Now(), Model: "m", Status: 200})
+	if batches := collector.waitBatches(t, 2); batches[1] != 2 {
+		t.Fatalf("want a batch of 2 after the interval, got %v", batches)
+	}
+}
+
+func TestOTELExporter_RetriesTransientFailures(t *testing.T) {
+	collector := newOTELTestCollector(t)
+	collector.reply = func(w http.ResponseWriter, n int) bool {
+		switch n {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			// Retry-After overrides the doubled backoff of 1s
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			return false
+		}
+		return true
+	}
+
+	exporter := newOTELExporter(OTELExporterConfig{Endpoint: collector.URL}, 1, time.Hour)
+	defer exporter.Close()
+	exporter.Export(UsageEvent{Timestamp: time.Now(), Model: "m", Status: 200})
+	collector.waitBatches(t, 1)
+
+	collector.mu.Lock()
+	attempts := append([]time.Time(nil), collector.metrics...)
+	collector.mu.Unlock()
+	if len(attempts) != 3 {
+		t.Fatalf("want 3 metrics attempts, got %d", len(attempts))
+	}
+	if wait := attempts[1].Sub(attempts[0]); wait < otelInitialBackoff || wait >= time.Second {
+		t.Fatalf("want the initial backoff without Retry-After, retried after %v", wait)
+	}
+	if wait := attempts[2].Sub(attempts[1]); wait < 2*time.Second {
+		t.Fatalf("want Retry-After respected, retried after %v", wait)
+	}
+}
+
+func TestOTELExporter_DropsWhenQueueFullWithoutBlocking(t *testing.T) {
+	release := make(chan struct{})
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	collector := newOTELTestCollector(t)
+	collector.reply = func(w http.ResponseWriter, n int) bool {
+		// Hold the first export so the queue fills up behind it
+		if n == 1 {
+			collector.mu.Unlock()
+			<-release
+			collector.mu.Lock()
+		}
+		return false
+	}
+	defer unblock()
+
+	exporter := newOTELExporter(OTELExporterConfig{Endpoint: collector.URL}, 1, time.Hour)
+	defer exporter.Close()
+	exporter.Export(UsageEvent{Timestamp: time.Now(), Model: "m", Status: 200})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		collector.mu.Lock()
+		started := len(collector.metrics) > 0
+		collector.mu.Unlock()
+		if started || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	start := time.Now()
+	for i := 0; i < otelQueueSize+100; i++ {
+		exporter.Export(UsageEvent{Timestamp: time.Now(), Model: "m", Status: 200})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Export blocked for %v with the collector stalled", elapsed)
+	}
+	// The stalled export holds off reportDropped, which resets the count
+	exporter.droppedMu.Lock()
+	dropped := exporter.dropped
+	exporter.droppedMu.Unlock()
+	if dropped != 100 {
+		t.Fatalf("want 100 events dropped, got %d", dropped)
+	}
+	unblock()
+}
+
+func TestOTELExporter_CloseFlushesPendingBatch(t *testing.T) {
+	collector := newOTELTestCollector(t)
+
+	exporter := newOTELExporter(OTELExporterConfig{Endpoint: collector.URL}, 100, time.Hour)
+	for i := 0; i < 5; i++ {
+		exporter.Export(UsageEvent{Timestamp: time.Now(), Model: "m", Status: 200})
+	}
+	exporter.Close()
+	if batches := collector.batches(); len(batches) != 1 || batches[0] != 5 {
+		t.Fatalf("want the 5 pending events sent on close, got %v", batches)
+	}
+
+	// Events after Close are ignored rather than blocking
+	exporter.Export(UsageEvent{Timestamp: time.Now(), Model: "m"})
+	if err := exporter.ExportWait(UsageEvent{Timestamp: time.Now(), Model: "m"}); err == nil {
+		t.Fatal("want ExportWait to fail once closed")
+	}
+}
+
+func TestOTELExporter_CloseDoesNotWaitForUnreachableCollector(t *testing.T) {
+	collector := newOTELTestCollector(t)
+	endpoint := collector.URL
+	collector.Close()
+
+	exporter := newOTELExporter(OTELExporterConfig{Endpoint: endpoint}, 1, time.Hour)
+	exporter.Export(UsageEvent{Timestamp: time.Now(), Model: "m", Status: 200})
+	time.Sleep(100 * time.Millisecond)
+
+	// Close abandons the backoff rather than waiting out every attempt
+	start := time.Now()
+	exporter.Close()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Close took %v with the collector down", elapsed)
+	}
+}
@@ -0,0 +1,74 @@
+package usage
+
+import "time"
+
+// defaultRecentCapacity is the number of recent events kept in memory when
+// WithRecentCapacity is not given.
+const defaultRecentCapacity = 1000
+
+// recentRing is a fixed-size ring buffer of the most recently recorded events.
+// It is not safe for concurrent use; JSONStore guards it with its mutex.
+type recentRing struct {
+	events []UsageEvent
+	next   int
+	count  int
+	// coveredSince is the point from which the ring holds every recorded event:
+	// the store creation time until the first eviction, then the timestamp of
+	// the most recently evicted event.
+	coveredSince time.Time
+}
+
+func newRecentRing(capacity int, createdAt time.Time) *recentRing {
+	if capacity <= 0 {
+		return nil
+	}
+	return &recentRing{events: make([]UsageEvent, capacity), coveredSince: createdAt}
+}
+
+func (r *recentRing) push(event UsageEvent) {
+	if r == nil {
+		return
+	}
+	if r.count == len(r.events) {
+		if evicted := r.events[r.next].Timestamp; evicted.After(r.coveredSince) {
+			r.coveredSince = evicted
+		}
+	} else {
+		r.count++
+	}
+	r.events[r.next] = event
+	r.next = (r.next + 1) % len(r.events)
+}
+
+// dropNewest removes up to n of the newest events, e.g. events drained from
+// the buffer before they were stored.
+func (r *recentRing) dropNewest(n int) {
+	if r == nil || n <= 0 {
+		return
+	}
+	n = min(n, r.count)
+	for i := 0; i < n; i++ {
+		r.next = (r.next - 1 + len(r.events)) % len(r.events)
+		r.events[r.next] = UsageEvent{}
+	}
+	r.count -= n
+}
+
+// last returns up to n of the newest events, oldest first.
+func (r *recentRing) last(n int) []UsageEvent {
+	if r == nil || n <= 0 {
+		return []UsageEvent{}
+	}
+	if n > r.count {
+		n = r.count
+	}
+	out := make([]UsageEvent, n)
+	start := r.next - n
+	if start < 0 {
+		start += len(r.events)
+	}
+	for i := 0; i < n; i++ {
+		out[i] = r.events[(start+i)%len(r.events)]
+	}
+	return out
+}
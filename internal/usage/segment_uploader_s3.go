@@ -0,0 +1,88 @@
+package usage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3UploadPartSize bounds the memory used per upload: segments are streamed
+// with an unknown size, so they are sent as multipart uploads of this size.
+const s3UploadPartSize = 16 << 20
+
+// S3UploaderConfig describes the S3-compatible bucket receiving segments.
+type S3UploaderConfig struct {
+	// Endpoint is the host[:port] of the service, without a scheme.
+	Endpoint  string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Region    string
+	// Prefix is prepended to object names, e.g. "usage/prod".
+	Prefix    string
+	UseSSL    bool
+	PathStyle bool
+}
+
+// s3SegmentUploader uploads segments to an S3-compatible bucket.
+type s3SegmentUploader struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3SegmentUploader creates a SegmentUploader for an S3-compatible bucket,
+// e.g. AWS S3, MinIO or Cloudflare R2. The bucket must already exist.
+//
+// Parameters:
+//   - cfg: The endpoint, bucket and credentials
+//
+// Returns:
+//   - SegmentUploader: The uploader
+//   - error: An error if the configuration is incomplete
+func NewS3SegmentUploader(cfg S3UploaderConfig) (SegmentUploader, error) {
+	cfg.Endpoint = strings.TrimSpace(cfg.Endpoint)
+	cfg.Bucket = strings.TrimSpace(cfg.Bucket)
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("s3 uploader: endpoint is required")
+	}
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 uploader: bucket is required")
+	}
+
+	options := &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	}
+	if cfg.PathStyle {
+		options.BucketLookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(cfg.Endpoint, options)
+	if err != nil {
+		return nil, fmt.Errorf("s3 uploader: create client: %w", err)
+	}
+	return &s3SegmentUploader{client: client, bucket: cfg.Bucket, prefix: strings.Trim(cfg.Prefix, "/")}, nil
+}
+
+// Upload implements SegmentUploader.
+func (u *s3SegmentUploader) Upload(ctx context.Context, name string, body io.Reader) error {
+	contentType := "application/octet-stream"
+	if strings.HasSuffix(name, ".gz") {
+		contentType = "application/gzip"
+	}
+	key := path.Join(u.prefix, name)
+	_, err := u.client.PutObject(ctx, u.bucket, key, body, -1, minio.PutObjectOptions{
+		ContentType: contentType,
+		PartSize:    s3UploadPartSize,
+	})
+	if err != nil {
+		return fmt.Errorf("put object %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package usage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxOpenStores    = 64
+	defaultStoreIdleTimeout = 10 * time.Minute
+	tenantFilePrefix        = "usage-"
+	tenantFileSuffix        = ".json"
+)
+
+// tenantKeyPattern matches tenant keys that are safe to use verbatim in file names.
+var tenantKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// StoreManager keeps a separate JSONStore per tenant under a common directory,
+// creating stores lazily on first use. At most maxOpen stores are kept open;
+// the least recently used one is closed (and flushed) to make room, and a
+// background sweep closes stores that have been idle longer than idleTimeout.
+type StoreManager struct {
+	dir         string
+	maxOpen     int
+	idleTimeout time.Duration
+	opts        []StoreOption
+
+	mu     sync.Mutex
+	stores map[string]*managedStore
+	done   chan struct{}
+	closed bool
+}
+
+// managedStore tracks a tenant store and when it was last used.
+type managedStore struct {
+	store    *JSONStore
+	lastUsed time.Time
+}
+
+// NewStoreManager creates a manager that stores one file per tenant in dir.
+//
+// Parameters:
+//   - dir: Directory holding the per-tenant files
+//   - maxOpen: Maximum number of stores kept open at once (<= 0 uses 64)
+//   - idleTimeout: Idle time after which a store is closed (<= 0 uses 10 minutes)
+//   - opts: Options applied to every tenant store
+//
+// Returns:
+//   - *StoreManager: A new store manager
+func NewStoreManager(dir string, maxOpen int, idleTimeout time.Duration, opts ...StoreOption) *StoreManager {
+	if maxOpen <= 0 {
+		maxOpen = defaultMaxOpenStores
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = defaultStoreIdleTimeout
+	}
+	m := &StoreManager{
+		dir:         dir,
+		maxOpen:     maxOpen,
+		idleTimeout: idleTimeout,
+		opts:        opts,
+		stores:      make(map[string]*managedStore),
+		done:        make(chan struct{}),
+	}
+	go m.sweepIdle()
+	return m
+}
+
+// Store returns the store for a tenant, opening it if necessary.
+//
+// Parameters:
+//   - tenant: The tenant key, e.g. an API key hash
+//
+// Returns:
+//   - *JSONStore: The tenant's store
+//   - error: An error if the tenant key is empty or the manager is closed
+func (m *StoreManager) Store(tenant string) (*JSONStore, error) {
+	if m == nil {
+		return nil, fmt.Errorf("store manager is nil")
+	}
+	if tenant == "" {
+		return nil, fmt.Errorf("tenant key is empty")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.storeLocked(tenant)
+}
+
+// storeLocked implements Store. Must be called with m.mu held.
+func (m *StoreManager) storeLocked(tenant string) (*JSONStore, error) {
+	if m.closed {
+		return nil, fmt.Errorf("store manager is closed")
+	}
+	if entry, ok := m.stores[tenant]; ok {
+		entry.lastUsed = time.Now()
+		return entry.store, nil
+	}
+
+	if len(m.stores) >= m.maxOpen {
+		m.evictLRULocked()
+	}
+	store := NewJSONStore(m.tenantPath(tenant), m.opts...)
+	if err := store.RebuildTotals(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to load usage totals for tenant store: %v\n", err)
+	}
+	m.stores[tenant] = &managedStore{store: store, lastUsed: time.Now()}
+	return store, nil
+}
+
+// Write routes an event to the tenant's store. The manager lock is held for
+// the write so the store cannot be evicted and closed underneath it.
+func (m *StoreManager) Write(tenant string, event UsageEvent) error {
+	if m == nil {
+		return fmt.Errorf("store manager is nil")
+	}
+	if tenant == "" {
+		return fmt.Errorf("tenant key is empty")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	store, err := m.storeLocked(tenant)
+	if err != nil {
+		return err
+	}
+	return store.Write(event)
+}
+
+// Tenants lists the tenant keys that have a store file on disk.
+// Keys that had to be hashed for use as file names are returned in hashed form.
+func (m *StoreManager) Tenants() ([]string, error) {
+	if m == nil {
+		return nil, fmt.Errorf("store manager is nil")
+	}
+	entries, err := os.ReadDir(m.dir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenant stores: %w", err)
+	}
+	tenants := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, tenantFilePrefix) || !strings.HasSuffix(name, tenantFileSuffix) {
+			continue
+		}
+		tenants = append(tenants, strings.TrimSuffix(strings.TrimPrefix(name, tenantFilePrefix), tenantFileSuffix))
+	}
+	sort.Strings(tenants)
+	return tenants, nil
+}
+
+// Close flushes and closes every open tenant store and stops the idle sweep.
+func (m *StoreManager) Close() error {
+	if m == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	close(m.done)
+
+	var firstErr error
+	for tenant, entry := range m.stores {
+		if err := entry.store.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(m.stores, tenant)
+	}
+	return firstErr
+}
+
+// tenantPath maps a tenant key to its file, hashing keys that are not file-name safe.
+func (m *StoreManager) tenantPath(tenant string) string {
+	name := tenant
+	if !tenantKeyPattern.MatchString(tenant) {
+		sum := sha256.Sum256([]byte(tenant))
+		name = hex.EncodeToString(sum[:])
+	}
+	return filepath.Join(m.dir, tenantFilePrefix+name+tenantFileSuffix)
+}
+
+// evictLRULocked closes the least recently used store. Must be called with m.mu held.
+func (m *StoreManager) evictLRULocked() {
+	var (
+		oldestKey  string
+		oldestUsed time.Time
+	)
+	for tenant, entry := range m.stores {
+		if oldestKey == "" || entry.lastUsed.Before(oldestUsed) {
+			oldestKey, oldestUsed = tenant, entry.lastUsed
+		}
+	}
+	if oldestKey == "" {
+		return
+	}
+	if err := m.stores[oldestKey].store.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to close tenant store: %v\n", err)
+	}
+	delete(m.stores, oldestKey)
+}
+
+// sweepIdle periodically closes stores that have not been used within idleTimeout.
+func (m *StoreManager) sweepIdle() {
+	interval := m.idleTimeout / 2
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.mu.Lock()
+			cutoff := time.Now().Add(-m.idleTimeout)
+			for tenant, entry := range m.stores {
+				if entry.lastUsed.Before(cutoff) {
+					if err := entry.store.Close(); err != nil {
+						fmt.Fprintf(os.Stderr, "warning: failed to close idle tenant store: %v\n", err)
+					}
+					delete(m.stores, tenant)
+				}
+			}
+			m.mu.Unlock()
+		case <-m.done:
+			return
+		}
+	}
+}
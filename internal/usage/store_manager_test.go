@@ -0,0 +1,149 @@
+package usage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func storeClosed(s *JSONStore) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func TestStoreManager_EvictsLeastRecentlyUsed(t *testing.T) {
+	m := NewStoreManager(t.TempDir(), 2, time.Hour, WithPeriodicFlush(false))
+	defer m.Close()
+
+	event := UsageEvent{Timestamp: time.Now(), Model: "m", TotalTokens: 42}
+	if err := m.Write("b", event); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	a, _ := m.Store("a")
+	b, _ := m.Store("b")
+	// a was used last, so b goes when c needs room
+	m.mu.Lock()
+	m.stores["b"].lastUsed = time.Now().Add(-time.Minute)
+	m.mu.Unlock()
+	if _, err := m.Store("c"); err != nil {
+		t.Fatalf("store c: %v", err)
+	}
+
+	m.mu.Lock()
+	_, hasA := m.stores["a"]
+	_, hasB := m.stores["b"]
+	open := len(m.stores)
+	m.mu.Unlock()
+	if open != 2 || !hasA || hasB {
+		t.Fatalf("want a and c open, got a=%v b=%v (%d open)", hasA, hasB, open)
+	}
+	if storeClosed(a) || !storeClosed(b) {
+		t.Fatal("want only the evicted store closed")
+	}
+
+	// The evicted store was flushed, so reopening it finds its events
+	reopened, err := m.Store("b")
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if reopened == b {
+		t.Fatal("want a new store for an evicted tenant")
+	}
+	events, err := reopened.Load()
+	if err != nil || len(events) != 1 || events[0].TotalTokens != 42 {
+		t.Fatalf("want the evicted tenant's event back (%v), got %+v", err, events)
+	}
+	if totals := reopened.Totals(); totals.Tokens != 42 {
+		t.Fatalf("want totals rebuilt on reopen, got %+v", totals)
+	}
+}
+
+func TestStoreManager_SweepClosesIdleStores(t *testing.T) {
+	m := NewStoreManager(t.TempDir(), 0, 20*time.Millisecond, WithPeriodicFlush(false))
+	defer m.Close()
+
+	store, err := m.Store("idle")
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		m.mu.Lock()
+		open := len(m.stores)
+		m.mu.Unlock()
+		if open == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("want the idle store swept")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !storeClosed(store) {
+		t.Fatal("want the swept store closed")
+	}
+}
+
+func TestStoreManager_TenantPathHashesUnsafeKeys(t *testing.T) {
+	dir := t.TempDir()
+	m := NewStoreManager(dir, 0, time.Hour, WithPeriodicFlush(false))
+	defer m.Close()
+
+	if got, want := m.tenantPath("team-a_1"), filepath.Join(dir, "usage-team-a_1.json"); got != want {
+		t.Fatalf("want safe keys used verbatim, got %s", got)
+	}
+	for _, key := range []string{"../x", "a/b", "key with spaces", ""} {
+		path := m.tenantPath(key)
+		name := filepath.Base(path)
+		if filepath.Dir(path) != dir || len(name) != len("usage-.json")+64 {
+			t.Fatalf("%q: want a hashed file in %s, got %s", key, dir, path)
+		}
+	}
+	if m.tenantPath("../x") == m.tenantPath("../y") {
+		t.Fatal("want distinct keys hashed apart")
+	}
+
+	if err := m.Write("../x", UsageEvent{Timestamp: time.Now(), Model: "m"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	tenants, err := m.Tenants()
+	hashed := filepath.Base(m.tenantPath("../x"))
+	if err != nil || len(tenants) != 1 || "usage-"+tenants[0]+".json" != hashed {
+		t.Fatalf("want the hashed tenant listed (%v), got %v", err, tenants)
+	}
+}
+
+func TestStoreManager_CloseClosesEveryStore(t *testing.T) {
+	m := NewStoreManager(t.TempDir(), 0, time.Hour, WithPeriodicFlush(false))
+
+	var stores []*JSONStore
+	for _, tenant := range []string{"a", "b", "c"} {
+		if err := m.Write(tenant, UsageEvent{Timestamp: time.Now(), Model: "m"}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		store, _ := m.Store(tenant)
+		stores = append(stores, store)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	for i, store := range stores {
+		if !storeClosed(store) {
+			t.Fatalf("store %d left open", i)
+		}
+		if events, err := NewReadOnlyStore(store.path).Load(); err != nil || len(events) != 1 {
+			t.Fatalf("store %d: want its event flushed (%v), got %d", i, err, len(events))
+		}
+	}
+	if _, err := m.Store("a"); err == nil {
+		t.Fatal("want Store to fail after Close")
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("second close: %v", err)
+	}
+}
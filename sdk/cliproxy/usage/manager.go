@@ -19,6 +19,8 @@ type Record struct {
 	RequestedAt time.Time
 	Failed      bool
 	Detail      Detail
+	// UpstreamRequestID is the request ID returned by the provider, if any.
+	UpstreamRequestID string
 }
 
 // Detail holds the token usage breakdown.
@@ -0,0 +1,379 @@
+// Package qsclient is a typed Go client for the QuantumSpring usage metrics
+// endpoints under /v0/management/qs. It sends the management key, encodes
+// query parameters and decodes responses into the server's own types.
+package qsclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/api/handlers/management"
+	"github.com/router-for-me/CLIProxyAPI/v6/internal/usage"
+)
+
+// MetricsResponse re-exports the aggregated metrics response of /qs/metrics.
+type MetricsResponse = management.MetricsResponse
+
+// MetricsTotals re-exports the totals of a metrics response.
+type MetricsTotals = management.MetricsTotals
+
+// GroupMetrics re-exports the group_by pivot rows of a metrics response.
+type GroupMetrics = management.GroupMetrics
+
+// ModelMetrics re-exports the per-model entries of a metrics response.
+type ModelMetrics = management.ModelMetrics
+
+// TimeseriesBucket re-exports the timeseries entries of a metrics response.
+type TimeseriesBucket = management.TimeseriesBucket
+
+// MetricsQueryRequest re-exports the JSON filter body of POST /qs/metrics.
+type MetricsQueryRequest = management.MetricsQueryRequest
+
+// SelfCheckResult re-exports the background integrity check reported by /qs/health.
+type SelfCheckResult = usage.SelfCheckResult
+
+// TokenAdjustments re-exports the token adjustment counters reported by /qs/health.
+type TokenAdjustments = usage.TokenAdjustments
+
+// CombinedMetricsRequest re-exports the JSON body of POST /qs/metrics/combined.
+type CombinedMetricsRequest = management.CombinedMetricsRequest
+
+// ImportResult re-exports the counts returned by /qs/import.
+type ImportResult = usage.ImportResult
+
+// HealthResponse is the body of /qs/health.
+type HealthResponse struct {
+	OK            bool             `json:"ok"`
+	TotalRequests int64            `json:"total_requests"`
+	TotalTokens   int64            `json:"total_tokens"`
+	DiskBytes     int64            `json:"disk_bytes"`
+	SelfCheck     *SelfCheckResult `json:"self_check,omitempty"`
+	// TokenAdjustments counts events recorded with clamped or dropped token counts.
+	TokenAdjustments TokenAdjustments `json:"token_adjustments"`
+}
+
+// MetricsQuery holds the parameters of GetMetrics. Zero values are omitted,
+// leaving the server defaults (the last 24 hours, hourly buckets) in place.
+type MetricsQuery struct {
+	From time.Time
+	To   time.Time
+	// Window asks for a range ending now, e.g. "24h" or "7d", instead of
+	// From and To; warmed windows are served from memory.
+	Window            string
+	Model             string
+	Sparklines        bool
+	ExcludeSuspicious bool
+	// Buckets asks for roughly this many timeseries buckets.
+	Buckets int
+	// RequestIDPrefix only counts events whose request ID starts with it.
+	RequestIDPrefix string
+	// GroupBy adds a pivot over model, family, provider, status, api_key_hash
+	// and/or label:<name> dimensions.
+	GroupBy []string
+	// Sort orders by_model by "tokens" (the default), "cost" or "requests";
+	// Order is "desc" (the default) or "asc".
+	Sort  string
+	Order string
+	// ActiveSince lists only models with an event since then in by_model.
+	ActiveSince time.Time
+	// IncludeDelta adds each timeseries bucket's change from the previous one.
+	IncludeDelta bool
+	// CostPerRequest adds each timeseries bucket's estimated cost per request.
+	CostPerRequest bool
+	// FillGaps zero-fills timeseries buckets without activity (nonzero_only=false).
+	FillGaps bool
+	// Top and MinShare merge the models outside the Top largest, or below
+	// MinShare of all tokens, into one "(other)" entry of by_model.
+	Top      int
+	MinShare float64
+	// Kind aggregates "all" events or one marker kind such as "ping" instead
+	// of proxied requests.
+	Kind string
+	// BucketBy is "started" (the default) or "completed".
+	BucketBy string
+	// View is "full" (the default) or "totals", which skips every breakdown.
+	View string
+	// Fields limits the response to totals, by_model, timeseries, groups
+	// and/or markers; sections left out are zero.
+	Fields []string
+	// Tenant reads the metrics of a tenant's own store.
+	Tenant string
+}
+
+// ExportQuery holds the parameters of Export.
+type ExportQuery struct {
+	From  time.Time
+	To    time.Time
+	Model string
+	// Format is "csv" (the default) or "ndjson".
+	Format string
+	// Columns selects and orders the CSV columns; empty uses the default set.
+	Columns []string
+}
+
+// APIError is returned for non-2xx responses.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("qsclient: status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("qsclient: status %d: %s", e.StatusCode, e.Message)
+}
+
+// Client calls the metrics endpoints of one CLIProxyAPI server.
+type Client struct {
+	baseURL       string
+	managementKey string
+	httpClient    *http.Client
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests; the default is http.DefaultClient.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		if httpClient != nil {
+			c.httpClient = httpClient
+		}
+	}
+}
+
+// New returns a client for the server at baseURL (e.g. http://localhost:8317)
+// authenticating with managementKey.
+func New(baseURL, managementKey string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		managementKey: managementKey,
+		httpClient:    http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// GetMetrics returns aggregated usage metrics from /qs/metrics.
+func (c *Client) GetMetrics(ctx context.Context, query MetricsQuery) (*MetricsResponse, error) {
+	params := url.Values{}
+	setTimeRange(params, query.From, query.To)
+	setIfNotEmpty(params, "window", query.Window)
+	setIfNotEmpty(params, "model", query.Model)
+	setIfNotEmpty(params, "tenant", query.Tenant)
+	setIfNotEmpty(params, "request_id_prefix", query.RequestIDPrefix)
+	setIfNotEmpty(params, "sort", query.Sort)
+	setIfNotEmpty(params, "order", query.Order)
+	setIfNotEmpty(params, "view", query.View)
+	setIfNotEmpty(params, "kind", query.Kind)
+	setIfNotEmpty(params, "bucket_by", query.BucketBy)
+	if !query.ActiveSince.IsZero() {
+		params.Set("active_since", query.ActiveSince.UTC().Format(time.RFC3339Nano))
+	}
+	if query.Sparklines {
+		params.Set("sparklines", "true")
+	}
+	if query.ExcludeSuspicious {
+		params.Set("exclude_suspicious", "true")
+	}
+	if query.IncludeDelta {
+		params.Set("include_delta", "true")
+	}
+	if query.CostPerRequest {
+		params.Set("cost_per_request", "true")
+	}
+	if query.FillGaps {
+		params.Set("nonzero_only", "false")
+	}
+	if query.Buckets > 0 {
+		params.Set("buckets", strconv.Itoa(query.Buckets))
+	}
+	if query.Top > 0 {
+		params.Set("top", strconv.Itoa(query.Top))
+	}
+	if query.MinShare > 0 {
+		params.Set("min_share", strconv.FormatFloat(query.MinShare, 'f', -1, 64))
+	}
+	if len(query.GroupBy) > 0 {
+		params.Set("group_by", strings.Join(query.GroupBy, ","))
+	}
+	if len(query.Fields) > 0 {
+		params.Set("fields", strings.Join(query.Fields, ","))
+	}
+
+	var response MetricsResponse
+	if err := c.getJSON(ctx, "/qs/metrics", params, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// QueryMetrics returns aggregated usage metrics for the filters in request,
+// sent as the JSON body of POST /qs/metrics. tenant may be empty.
+func (c *Client) QueryMetrics(ctx context.Context, request MetricsQueryRequest, tenant string) (*MetricsResponse, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("qsclient: encode request: %w", err)
+	}
+	params := url.Values{}
+	setIfNotEmpty(params, "tenant", tenant)
+
+	resp, err := c.do(ctx, http.MethodPost, "/qs/metrics", params, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var response MetricsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("qsclient: decode /qs/metrics response: %w", err)
+	}
+	return &response, nil
+}
+
+// CombinedMetrics returns one metrics aggregation over the stores listed in
+// request, sent as the JSON body of POST /qs/metrics/combined.
+func (c *Client) CombinedMetrics(ctx context.Context, request CombinedMetricsRequest) (*MetricsResponse, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("qsclient: encode request: %w", err)
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/qs/metrics/combined", nil, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var response MetricsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("qsclient: decode /qs/metrics/combined response: %w", err)
+	}
+	return &response, nil
+}
+
+// Health returns the store's health and all-time totals from /qs/health.
+func (c *Client) Health(ctx context.Context) (*HealthResponse, error) {
+	var response HealthResponse
+	if err := c.getJSON(ctx, "/qs/health", nil, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Export streams raw events from /qs/events/export into w and returns the
+// number of bytes written.
+func (c *Client) Export(ctx context.Context, query ExportQuery, w io.Writer) (int64, error) {
+	params := url.Values{}
+	setTimeRange(params, query.From, query.To)
+	setIfNotEmpty(params, "model", query.Model)
+	setIfNotEmpty(params, "format", query.Format)
+	if len(query.Columns) > 0 {
+		params.Set("columns", strings.Join(query.Columns, ","))
+	}
+
+	resp, err := c.get(ctx, "/qs/events/export", params)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	return io.Copy(w, resp.Body)
+}
+
+// Import sends the JSON lines read from r, e.g. an NDJSON Export of another
+// node, to /qs/import. With dedupe, events the store already holds are left
+// out. tenant may be empty.
+func (c *Client) Import(ctx context.Context, r io.Reader, dedupe bool, tenant string) (*ImportResult, error) {
+	params := url.Values{}
+	if dedupe {
+		params.Set("dedupe", "true")
+	}
+	setIfNotEmpty(params, "tenant", tenant)
+
+	resp, err := c.do(ctx, http.MethodPost, "/qs/import", params, r)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var result ImportResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("qsclient: decode /qs/import response: %w", err)
+	}
+	return &result, nil
+}
+
+// getJSON performs a GET request and decodes the JSON body into out.
+func (c *Client) getJSON(ctx context.Context, path string, params url.Values, out any) error {
+	resp, err := c.get(ctx, path, params)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("qsclient: decode %s response: %w", path, err)
+	}
+	return nil
+}
+
+// get performs an authenticated GET request; see do.
+func (c *Client) get(ctx context.Context, path string, params url.Values) (*http.Response, error) {
+	return c.do(ctx, http.MethodGet, path, params, nil)
+}
+
+// do performs an authenticated request, sending body as JSON when it is not
+// nil and turning non-2xx responses into an *APIError. The caller closes the
+// returned body.
+func (c *Client) do(ctx context.Context, method, path string, params url.Values, body io.Reader) (*http.Response, error) {
+	endpoint := c.baseURL + "/v0/management" + path
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, fmt.Errorf("qsclient: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.managementKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("qsclient: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer func() { _ = resp.Body.Close() }()
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		var body struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&body); err == nil {
+			apiErr.Message = body.Error
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+func setTimeRange(params url.Values, from, to time.Time) {
+	if !from.IsZero() {
+		params.Set("from", from.UTC().Format(time.RFC3339Nano))
+	}
+	if !to.IsZero() {
+		params.Set("to", to.UTC().Format(time.RFC3339Nano))
+	}
+}
+
+func setIfNotEmpty(params url.Values, key, value string) {
+	if value != "" {
+		params.Set(key, value)
+	}
+}
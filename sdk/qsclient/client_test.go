@@ -0,0 +1,50 @@
+package qsclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClient_GetMetricsEncodesQueryAndKey(t *testing.T) {
+	from := time.Date(2025, 11, 25, 0, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v0/management/qs/metrics" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("unexpected authorization %q", got)
+		}
+		q := r.URL.Query()
+		if q.Get("from") != "2025-11-25T00:00:00Z" || q.Get("model") != "gpt-4" || q.Get("buckets") != "12" || q.Has("to") {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		_, _ = w.Write([]byte(`{"totals":{"tokens":30,"requests":2},"by_model":[{"model":"gpt-4","tokens":30,"requests":2}],"timeseries":[],"bucket_seconds":3600}`))
+	}))
+	defer server.Close()
+
+	response, err := New(server.URL+"/", "secret").GetMetrics(context.Background(), MetricsQuery{From: from, Model: "gpt-4", Buckets: 12})
+	if err != nil {
+		t.Fatalf("get metrics: %v", err)
+	}
+	if response.Totals.Tokens != 30 || len(response.ByModel) != 1 || response.BucketSeconds != 3600 {
+		t.Fatalf("unexpected response %+v", response)
+	}
+}
+
+func TestClient_ReturnsAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error":"invalid management key"}`))
+	}))
+	defer server.Close()
+
+	_, err := New(server.URL, "wrong").Health(context.Background())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || apiErr.Message != "invalid management key" {
+		t.Fatalf("want APIError 401, got %v", err)
+	}
+}